@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/config"
 	"github.com/gorilla/mux"
 
@@ -23,6 +25,13 @@ import (
 // own limit BEFORE reading the request body `r.Body = http.MaxBytesReader(w, r.Body, MaxRequestSize)`
 const MaxRequestSize = 5 * 1024 * 1024 // 5MB
 
+// MaxPacketUploadRequestSize is the size limit for support packet uploads, the largest packet
+// that will be opened and room for the other form fields.
+const MaxPacketUploadRequestSize = app.MaxPacketArchiveSize + 1024*1024
+
+// packetUploadPath is the only endpoint accepting requests larger than MaxRequestSize.
+const packetUploadPath = "/api/v0/customers/packets"
+
 // Handler Root API handler.
 type Handler struct {
 	*ErrorHandler
@@ -56,7 +65,11 @@ func NewHandler(pluginAPI *pluginapi.Client, config config.Service) *Handler {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestSize)
+	maxSize := int64(MaxRequestSize)
+	if r.Method == http.MethodPost && r.URL.Path == packetUploadPath {
+		maxSize = MaxPacketUploadRequestSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
 	h.root.ServeHTTP(w, r)
 }
 
@@ -113,3 +126,13 @@ func MattermostAuthorizationRequired(next http.Handler) http.Handler {
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
 	})
 }
+
+// checkSystemAdmin returns an error if the user making the request isn't a system admin, for use with PermissionsCheck.
+func checkSystemAdmin(r *http.Request, pluginAPI *pluginapi.Client) error {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if !app.IsSystemAdmin(userID, pluginAPI) {
+		return errors.Errorf("user '%s' is not a system admin", userID)
+	}
+
+	return nil
+}
@@ -3,10 +3,13 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -17,6 +20,10 @@ import (
 	pluginapi "github.com/mattermost/mattermost/server/public/pluginapi"
 )
 
+// packetUploadMemory is how much of an uploaded packet is held in memory, the rest is written to
+// temporary files while it's processed.
+const packetUploadMemory = 32 * 1024 * 1024
+
 // CusotmerHandler is the API handler.
 type CustomerHandler struct {
 	*ErrorHandler
@@ -36,23 +43,51 @@ func NewCustomerHandler(router *mux.Router, customerService app.CustomerService,
 
 	customersRouter := router.PathPrefix("/customers").Subrouter()
 
-	// customerRouter.HandleFunc("", withContext(handler.createCustomer)).Methods(http.MethodPost)
+	customersRouter.HandleFunc("", withContext(handler.createCustomer)).Methods(http.MethodPost)
 	customersRouter.HandleFunc("", withContext(handler.getCustomers)).Methods(http.MethodGet)
+	customersRouter.HandleFunc("/packets", withContext(handler.uploadPacket)).Methods(http.MethodPost)
 
 	//
 	customerRouter := customersRouter.PathPrefix("/{id:[A-Za-z0-9]+}").Subrouter()
 	customerRouter.HandleFunc("", withContext(handler.getCustomer)).Methods(http.MethodGet)
 	customerRouter.HandleFunc("", withContext(handler.updateCustomer)).Methods(http.MethodPut)
+	customerRouter.HandleFunc("", withContext(handler.deleteCustomer)).Methods(http.MethodDelete)
+	customerRouter.HandleFunc("/archive", withContext(handler.archiveCustomer)).Methods(http.MethodPost)
+	customerRouter.HandleFunc("/restore", withContext(handler.restoreCustomer)).Methods(http.MethodPost)
+	customerRouter.HandleFunc("/merge", withContext(handler.mergeCustomer)).Methods(http.MethodPost)
 
 	configRouter := customerRouter.PathPrefix("/config").Subrouter()
 	configRouter.HandleFunc("", withContext(handler.updateCustomerConfig)).Methods(http.MethodPut)
 
 	packetRouter := customerRouter.PathPrefix("/packet").Subrouter()
 	packetRouter.HandleFunc("", withContext(handler.updateCustomerPacket)).Methods(http.MethodPut)
+	packetRouter.HandleFunc("/fields", withContext(handler.getPacketFields)).Methods(http.MethodGet)
+	packetRouter.HandleFunc("/fields/{field:[A-Za-z]+}", withContext(handler.pinPacketField)).Methods(http.MethodPut)
+	packetRouter.HandleFunc("/fields/{field:[A-Za-z]+}", withContext(handler.unpinPacketField)).Methods(http.MethodDelete)
+
+	packetsRouter := customerRouter.PathPrefix("/packets").Subrouter()
+	packetsRouter.HandleFunc("/{packetID:[A-Za-z0-9]+}/raw", withContext(handler.getRawPacket)).Methods(http.MethodGet)
 
 	pluginRouter := customerRouter.PathPrefix("/plugins").Subrouter()
 	pluginRouter.HandleFunc("", withContext(handler.updateCustomerPlugins)).Methods(http.MethodPut)
 
+	customerRouter.HandleFunc("/findings", withContext(handler.runCustomerFindings)).Methods(http.MethodGet)
+
+	identifiersRouter := customerRouter.PathPrefix("/identifiers").Subrouter()
+	identifiersRouter.HandleFunc("", withContext(handler.getCustomerIdentifiers)).Methods(http.MethodGet)
+	identifiersRouter.HandleFunc("", withContext(handler.addCustomerIdentifier)).Methods(http.MethodPost)
+	identifiersRouter.HandleFunc("", withContext(handler.removeCustomerIdentifier)).Methods(http.MethodDelete)
+
+	environmentsRouter := customerRouter.PathPrefix("/environments").Subrouter()
+	environmentsRouter.HandleFunc("", withContext(handler.getEnvironments)).Methods(http.MethodGet)
+	environmentsRouter.HandleFunc("", withContext(handler.createEnvironment)).Methods(http.MethodPost)
+	environmentsRouter.HandleFunc("/{environmentID:[A-Za-z0-9]+}", withContext(handler.updateEnvironment)).Methods(http.MethodPut)
+
+	snapshotsRouter := customerRouter.PathPrefix("/snapshots").Subrouter()
+	snapshotsRouter.HandleFunc("", withContext(handler.getCustomerSnapshots)).Methods(http.MethodGet)
+	snapshotsRouter.HandleFunc("/{snapshotID:[A-Za-z0-9]+}/logs", withContext(handler.getSnapshotLogs)).Methods(http.MethodGet)
+	snapshotsRouter.HandleFunc("/{snapshotID:[A-Za-z0-9]+}/findings", withContext(handler.getSnapshotFindings)).Methods(http.MethodGet)
+
 	return handler
 }
 
@@ -89,6 +124,110 @@ func (h *CustomerHandler) getCustomer(c *Context, w http.ResponseWriter, r *http
 	ReturnJSON(w, &customer, http.StatusOK)
 }
 
+// createCustomer creates a customer entered by a user, before any packet was uploaded for it.
+func (h *CustomerHandler) createCustomer(c *Context, w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	var customer app.Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode customer", err)
+		return
+	}
+
+	fullCustomer, err := h.customerService.CreateCustomer(userID, customer)
+	if err != nil {
+		if errors.Is(err, app.ErrInvalidCustomer) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if errors.Is(err, app.ErrDuplicateEntry) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusConflict, err.Error(), nil)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, &fullCustomer, http.StatusCreated)
+}
+
+// archiveCustomer hides the customer from the list, keeping its history.
+func (h *CustomerHandler) archiveCustomer(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	if err := h.customerService.ArchiveCustomer(vars["id"], userID); err != nil {
+		h.handleCustomerNotFound(c, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreCustomer lists an archived customer again.
+func (h *CustomerHandler) restoreCustomer(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.customerService.RestoreCustomer(vars["id"]); err != nil {
+		h.handleCustomerNotFound(c, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteCustomer permanently deletes the customer and everything stored for it.
+func (h *CustomerHandler) deleteCustomer(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if err := h.customerService.DeleteCustomer(vars["id"]); err != nil {
+		h.handleCustomerNotFound(c, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mergeCustomer merges the customer given as sourceID into this one, deleting the source, or
+// previews the merge when dryRun is set.
+func (h *CustomerHandler) mergeCustomer(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var opts app.MergeOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode merge options", err)
+		return
+	}
+
+	result, err := h.customerService.MergeCustomers(vars["id"], userID, opts)
+	if err != nil {
+		if errors.Is(err, app.ErrInvalidMerge) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		h.handleCustomerNotFound(c, w, err)
+		return
+	}
+
+	ReturnJSON(w, &result, http.StatusOK)
+}
+
+func (h *CustomerHandler) handleCustomerNotFound(c *Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, app.ErrNotFound) {
+		h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No customer found for this ID", err)
+		return
+	}
+	h.HandleError(w, c.logger, err)
+}
+
 func (h *CustomerHandler) updateCustomer(c *Context, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	// userID := r.Header.Get("Mattermost-User-ID")
@@ -145,19 +284,30 @@ func (h *CustomerHandler) updateCustomerConfig(c *Context, w http.ResponseWriter
 	ReturnJSON(w, &fullCustomer, http.StatusOK)
 }
 
+// updateCustomerPacket pins the packet fields in the body that differ from the current values, so
+// later packets don't replace them. The pins expire at the optional expiresAt query parameter.
 func (h *CustomerHandler) updateCustomerPacket(c *Context, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := r.Header.Get("Mattermost-User-ID")
-	var packet app.CustomerPacketValues
-	if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
 		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode customer packet info", err)
 		return
 	}
 
+	var expiresAt int64
+	if expiresAtParam := r.URL.Query().Get("expiresAt"); expiresAtParam != "" {
+		var err error
+		expiresAt, err = strconv.ParseInt(expiresAtParam, 10, 64)
+		if err != nil {
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "expiresAt must be a timestamp in milliseconds", err)
+			return
+		}
+	}
+
 	customerID := vars["id"]
-	err := h.customerService.UpdateCustomerData(customerID, userID, &packet, nil, nil)
-	if err != nil {
-		h.HandleError(w, c.logger, err)
+	if err := h.customerService.UpdatePacketFields(customerID, userID, fields, expiresAt); err != nil {
+		h.handlePacketFieldError(c, w, err)
 		return
 	}
 
@@ -170,6 +320,123 @@ func (h *CustomerHandler) updateCustomerPacket(c *Context, w http.ResponseWriter
 	ReturnJSON(w, &fullCustomer, http.StatusOK)
 }
 
+// getPacketFields returns the effective value of every packet field along with the packet value.
+func (h *CustomerHandler) getPacketFields(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	fields, err := h.customerService.GetPacketFields(vars["id"])
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, fields, http.StatusOK)
+}
+
+// pinPacketField pins the value of a packet field, even if it's the current one.
+func (h *CustomerHandler) pinPacketField(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request struct {
+		Value     json.RawMessage `json:"value"`
+		ExpiresAt int64           `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode packet field pin", err)
+		return
+	}
+
+	err := h.customerService.PinPacketField(vars["id"], userID, vars["field"], request.Value, request.ExpiresAt)
+	if err != nil {
+		h.handlePacketFieldError(c, w, err)
+		return
+	}
+
+	fields, err := h.customerService.GetPacketFields(vars["id"])
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, fields, http.StatusOK)
+}
+
+// unpinPacketField removes the pin of a packet field, the packet value is used again.
+func (h *CustomerHandler) unpinPacketField(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	if err := h.customerService.UnpinPacketField(vars["id"], userID, vars["field"]); err != nil {
+		h.handlePacketFieldError(c, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CustomerHandler) handlePacketFieldError(c *Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, app.ErrInvalidPacketField) {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if errors.Is(err, app.ErrNotFound) {
+		h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	h.HandleError(w, c.logger, err)
+}
+
+// uploadPacket ingests the support packet zip in the "file" form field, for the customer in the
+// optional "customer_id" field or the one matching the packet. A "ticket" can be given as well.
+func (h *CustomerHandler) uploadPacket(c *Context, w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	if err := r.ParseMultipartForm(packetUploadMemory); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to parse multipart form, the packet may be too large", err)
+		return
+	}
+	defer func() {
+		_ = r.MultipartForm.RemoveAll()
+	}()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "missing support packet in the 'file' form field", err)
+		return
+	}
+	defer file.Close()
+
+	opts := app.PacketUploadOptions{
+		CustomerID:    r.FormValue("customer_id"),
+		EnvironmentID: r.FormValue("environment_id"),
+		TicketRef:     r.FormValue("ticket"),
+	}
+
+	result, err := h.customerService.UploadSupportPacket(userID, header.Filename, io.NewSectionReader(file, 0, header.Size), opts)
+	if err != nil {
+		var ingestionErr *app.IngestionError
+		switch {
+		case errors.As(err, &ingestionErr) && !ingestionErr.Retryable:
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, fmt.Sprintf("unable to ingest support packet: %s. %s", ingestionErr.Reason, ingestionErr.Fix), err)
+		case errors.Is(err, app.ErrNotFound):
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, err.Error(), nil)
+		case errors.Is(err, app.ErrAmbiguousCustomer):
+			h.HandleErrorWithCode(w, c.logger, http.StatusConflict, err.Error()+", choose one with 'customer_id'", nil)
+		default:
+			h.HandleError(w, c.logger, err)
+		}
+		return
+	}
+
+	if result.Duplicate {
+		ReturnJSON(w, &result, http.StatusOK)
+		return
+	}
+
+	ReturnJSON(w, &result, http.StatusCreated)
+}
+
 func (h *CustomerHandler) updateCustomerPlugins(c *Context, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := r.Header.Get("Mattermost-User-ID")
@@ -195,6 +462,227 @@ func (h *CustomerHandler) updateCustomerPlugins(c *Context, w http.ResponseWrite
 	ReturnJSON(w, &fullCustomer, http.StatusOK)
 }
 
+// getRawPacket downloads the original zip of an uploaded packet, if it is still archived.
+func (h *CustomerHandler) getRawPacket(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	archive, snapshot, err := h.customerService.GetPacketArchive(vars["id"], vars["packetID"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No archived packet found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+	defer archive.Close()
+
+	fileName := snapshot.FileName
+	if fileName == "" {
+		fileName = snapshot.ID + ".zip"
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	http.ServeContent(w, r, fileName, time.UnixMilli(snapshot.CreatedAt), archive)
+}
+
+func (h *CustomerHandler) getCustomerSnapshots(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	snapshots, err := h.customerService.GetSnapshots(vars["id"])
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, snapshots, http.StatusOK)
+}
+
+func (h *CustomerHandler) getSnapshotLogs(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	entries, err := h.customerService.GetLogEntries(vars["id"], vars["snapshotID"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No snapshot found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, entries, http.StatusOK)
+}
+
+func (h *CustomerHandler) getSnapshotFindings(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	findings, err := h.customerService.GetFindings(vars["id"], vars["snapshotID"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No snapshot found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, findings, http.StatusOK)
+}
+
+// runCustomerFindings evaluates the findings rules against the customer's current values.
+func (h *CustomerHandler) runCustomerFindings(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	findings, err := h.customerService.RunFindings(vars["id"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No customer found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, findings, http.StatusOK)
+}
+
+func (h *CustomerHandler) getCustomerIdentifiers(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	identifiers, err := h.customerService.GetCustomerIdentifiers(vars["id"])
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, identifiers, http.StatusOK)
+}
+
+// addCustomerIdentifier adds an identifier that packets are matched to the customer by.
+func (h *CustomerHandler) addCustomerIdentifier(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request struct {
+		Type  app.IdentifierType `json:"type"`
+		Value string             `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode identifier", err)
+		return
+	}
+
+	identifier, err := h.customerService.AddCustomerIdentifier(vars["id"], userID, request.Type, request.Value)
+	if err != nil {
+		if errors.Is(err, app.ErrInvalidIdentifier) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No customer found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, &identifier, http.StatusCreated)
+}
+
+// removeCustomerIdentifier removes the identifier given by the type and value query parameters.
+func (h *CustomerHandler) removeCustomerIdentifier(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	params := r.URL.Query()
+
+	err := h.customerService.RemoveCustomerIdentifier(vars["id"], app.IdentifierType(params.Get("type")), params.Get("value"))
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No identifier found for this customer", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CustomerHandler) getEnvironments(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	environments, err := h.customerService.GetEnvironments(vars["id"])
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, environments, http.StatusOK)
+}
+
+// createEnvironment adds a server of the customer entered by a user, before any packet was
+// uploaded for it.
+func (h *CustomerHandler) createEnvironment(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var environment app.Environment
+	if err := json.NewDecoder(r.Body).Decode(&environment); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode environment", err)
+		return
+	}
+
+	created, err := h.customerService.CreateEnvironment(vars["id"], userID, environment)
+	if err != nil {
+		h.handleEnvironmentError(c, w, err)
+		return
+	}
+
+	ReturnJSON(w, &created, http.StatusCreated)
+}
+
+// updateEnvironment renames, retypes or moves the environment, or makes it the primary one.
+func (h *CustomerHandler) updateEnvironment(c *Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var environment app.Environment
+	if err := json.NewDecoder(r.Body).Decode(&environment); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode environment", err)
+		return
+	}
+
+	environment.ID = vars["environmentID"]
+	updated, err := h.customerService.UpdateEnvironment(vars["id"], environment)
+	if err != nil {
+		h.handleEnvironmentError(c, w, err)
+		return
+	}
+
+	ReturnJSON(w, &updated, http.StatusOK)
+}
+
+func (h *CustomerHandler) handleEnvironmentError(c *Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, app.ErrInvalidEnvironment):
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+	case errors.Is(err, app.ErrDuplicateEntry):
+		h.HandleErrorWithCode(w, c.logger, http.StatusConflict, err.Error(), nil)
+	case errors.Is(err, app.ErrNotFound):
+		h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, err.Error(), nil)
+	default:
+		h.HandleError(w, c.logger, err)
+	}
+}
+
 func parseGetCustomerOptions(u *url.URL) (app.CustomerFilterOptions, error) {
 	params := u.Query()
 
@@ -262,11 +750,20 @@ func parseGetCustomerOptions(u *url.URL) (app.CustomerFilterOptions, error) {
 		return app.CustomerFilterOptions{}, errors.Errorf("bad parameter 'per_page': it should be a positive number")
 	}
 
+	var includeArchived bool
+	if param = params.Get("includeArchived"); param != "" {
+		includeArchived, err = strconv.ParseBool(param)
+		if err != nil {
+			return app.CustomerFilterOptions{}, errors.Wrapf(err, "bad parameter 'includeArchived': it should be true or false")
+		}
+	}
+
 	return app.CustomerFilterOptions{
-		Sort:       sortField,
-		Direction:  sortDirection,
-		SearchTerm: searchTerm,
-		Page:       page,
-		PerPage:    perPage,
+		Sort:            sortField,
+		Direction:       sortDirection,
+		SearchTerm:      searchTerm,
+		IncludeArchived: includeArchived,
+		Page:            page,
+		PerPage:         perPage,
 	}, nil
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/config"
+	"github.com/gorilla/mux"
+	pluginapi "github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// FindingsHandler is the API handler for the custom findings rules.
+type FindingsHandler struct {
+	*ErrorHandler
+	customerService app.CustomerService
+	pluginAPI       *pluginapi.Client
+	config          config.Service
+}
+
+// NewFindingsHandler returns a new findings api handler
+func NewFindingsHandler(router *mux.Router, customerService app.CustomerService, api *pluginapi.Client, configService config.Service) *FindingsHandler {
+	handler := &FindingsHandler{
+		ErrorHandler:    &ErrorHandler{},
+		customerService: customerService,
+		pluginAPI:       api,
+		config:          configService,
+	}
+
+	rulesRouter := router.PathPrefix("/findings/rules").Subrouter()
+	rulesRouter.HandleFunc("", withContext(handler.getRules)).Methods(http.MethodGet)
+	rulesRouter.HandleFunc("", withContext(handler.uploadRules)).Methods(http.MethodPost)
+
+	return handler
+}
+
+func (h *FindingsHandler) getRules(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	ruleSets, err := h.customerService.GetFindingRuleSets()
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, ruleSets, http.StatusOK)
+}
+
+// uploadRules stores the YAML or JSON rule file in the request body as the next version.
+func (h *FindingsHandler) uploadRules(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to read findings rules", err)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-ID")
+	ruleSet, err := h.customerService.UploadFindingRules(userID, data)
+	if err != nil {
+		if errors.Is(err, app.ErrInvalidFindingRules) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, &ruleSet, http.StatusCreated)
+}
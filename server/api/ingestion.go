@@ -0,0 +1,330 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/config"
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
+	pluginapi "github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// IngestionHandler is the API handler for the ingestion queue.
+type IngestionHandler struct {
+	*ErrorHandler
+	customerService app.CustomerService
+	pluginAPI       *pluginapi.Client
+	config          config.Service
+}
+
+// NewIngestionHandler returns a new ingestion api handler
+func NewIngestionHandler(router *mux.Router, customerService app.CustomerService, api *pluginapi.Client, configService config.Service) *IngestionHandler {
+	handler := &IngestionHandler{
+		ErrorHandler:    &ErrorHandler{},
+		customerService: customerService,
+		pluginAPI:       api,
+		config:          configService,
+	}
+
+	jobsRouter := router.PathPrefix("/ingestion/jobs").Subrouter()
+	jobsRouter.HandleFunc("", withContext(handler.getJobs)).Methods(http.MethodGet)
+	jobsRouter.HandleFunc("", withContext(handler.purgeJobs)).Methods(http.MethodDelete)
+	jobsRouter.HandleFunc("/{id:[A-Za-z0-9]+}/retry", withContext(handler.retryJob)).Methods(http.MethodPost)
+
+	backfillsRouter := router.PathPrefix("/ingestion/backfills").Subrouter()
+	backfillsRouter.HandleFunc("", withContext(handler.getBackfills)).Methods(http.MethodGet)
+	backfillsRouter.HandleFunc("", withContext(handler.startBackfill)).Methods(http.MethodPost)
+	backfillsRouter.HandleFunc("/{id:[A-Za-z0-9]+}", withContext(handler.getBackfill)).Methods(http.MethodGet)
+	backfillsRouter.HandleFunc("/{id:[A-Za-z0-9]+}/items", withContext(handler.getBackfillItems)).Methods(http.MethodGet)
+	backfillsRouter.HandleFunc("/{id:[A-Za-z0-9]+}/cancel", withContext(handler.cancelBackfill)).Methods(http.MethodPost)
+
+	pendingRouter := router.PathPrefix("/ingestion/pending").Subrouter()
+	pendingRouter.HandleFunc("/{id:[A-Za-z0-9]+}/resolve", withContext(handler.resolvePending)).Methods(http.MethodPost)
+	pendingRouter.HandleFunc("/{id:[A-Za-z0-9]+}/dialog", withContext(handler.openPendingDialog)).Methods(http.MethodPost)
+	pendingRouter.HandleFunc("/{id:[A-Za-z0-9]+}/submit", withContext(handler.submitPendingDialog)).Methods(http.MethodPost)
+
+	return handler
+}
+
+func parseIngestionJobStatus(param string) (app.IngestionJobStatus, error) {
+	status := app.IngestionJobStatus(param)
+	switch status {
+	case "", app.IngestionPending, app.IngestionProcessing, app.IngestionCompleted, app.IngestionSkipped, app.IngestionDead:
+		return status, nil
+	}
+
+	return "", errors.Errorf("bad parameter 'status' (%s): it should be empty or one of 'pending', 'processing', 'completed', 'skipped' or 'dead'", param)
+}
+
+func (h *IngestionHandler) getJobs(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	status, err := parseIngestionJobStatus(r.URL.Query().Get("status"))
+	if err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	jobs, err := h.customerService.GetIngestionJobs(status)
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, jobs, http.StatusOK)
+}
+
+func (h *IngestionHandler) retryJob(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := h.customerService.RetryIngestionJob(vars["id"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No ingestion job found for this ID that isn't processing", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeJobs deletes the jobs with the status given in the query. Jobs being processed can't be purged.
+func (h *IngestionHandler) purgeJobs(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	status, err := parseIngestionJobStatus(r.URL.Query().Get("status"))
+	if err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if status == "" || status == app.IngestionProcessing {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "bad parameter 'status': it should be one of 'pending', 'completed', 'skipped' or 'dead'", nil)
+		return
+	}
+
+	purged, err := h.customerService.PurgeIngestionJobs(status)
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, map[string]int64{"purged": purged}, http.StatusOK)
+}
+
+func (h *IngestionHandler) getBackfills(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	jobs, err := h.customerService.GetBackfillJobs()
+	if err != nil {
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, jobs, http.StatusOK)
+}
+
+// startBackfill queues a backfill of the channels and time range in the body, a BackfillOptions.
+func (h *IngestionHandler) startBackfill(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var opts app.BackfillOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode backfill options", err)
+		return
+	}
+
+	job, err := h.customerService.StartBackfill(userID, opts)
+	if err != nil {
+		if errors.Is(err, app.ErrInvalidBackfill) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, job, http.StatusCreated)
+}
+
+func (h *IngestionHandler) getBackfill(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	job, err := h.customerService.GetBackfillJob(vars["id"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No backfill job found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, job, http.StatusOK)
+}
+
+// getBackfillItems lists the attachments found by a backfill, filtered by the status in the query.
+func (h *IngestionHandler) getBackfillItems(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	status, err := parseIngestionJobStatus(r.URL.Query().Get("status"))
+	if err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	vars := mux.Vars(r)
+	items, err := h.customerService.GetBackfillItems(vars["id"], status)
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No backfill job found for this ID", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, items, http.StatusOK)
+}
+
+func (h *IngestionHandler) cancelBackfill(c *Context, w http.ResponseWriter, r *http.Request) {
+	if !h.PermissionsCheck(w, c.logger, checkSystemAdmin(r, h.pluginAPI)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := h.customerService.CancelBackfill(vars["id"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			h.HandleErrorWithCode(w, c.logger, http.StatusNotFound, "No backfill job found for this ID that is still running", err)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolvePending is the post action of the prompt asking which customer a pending upload is
+// for. Anyone who can read the channel the prompt was posted in can answer it.
+func (h *IngestionHandler) resolvePending(c *Context, w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode post action request", err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	noLongerPending := &model.PostActionIntegrationResponse{
+		EphemeralText: "This support packet is no longer waiting for a customer to be chosen.",
+	}
+
+	// the channel of the request is up to the client, the prompt's is the one that counts
+	channelID, err := h.customerService.GetPendingUploadChannel(vars["id"])
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			ReturnJSON(w, noLongerPending, http.StatusOK)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	if !h.pluginAPI.User.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel) {
+		h.HandleErrorWithCode(w, c.logger, http.StatusForbidden, "Not authorized", errors.Errorf("user '%s' can't read channel '%s'", userID, channelID))
+		return
+	}
+
+	customerID, _ := request.Context["customer_id"].(string)
+
+	err = h.customerService.ResolvePendingUpload(vars["id"], userID, customerID)
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			ReturnJSON(w, noLongerPending, http.StatusOK)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, &model.PostActionIntegrationResponse{}, http.StatusOK)
+}
+
+func (h *IngestionHandler) openPendingDialog(c *Context, w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode post action request", err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := h.customerService.OpenPendingUploadDialog(vars["id"], userID, request.TriggerId)
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			ReturnJSON(w, &model.PostActionIntegrationResponse{
+				EphemeralText: "This support packet is no longer waiting for a customer to be chosen.",
+			}, http.StatusOK)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	ReturnJSON(w, &model.PostActionIntegrationResponse{}, http.StatusOK)
+}
+
+func (h *IngestionHandler) submitPendingDialog(c *Context, w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.HandleErrorWithCode(w, c.logger, http.StatusBadRequest, "unable to decode dialog submission", err)
+		return
+	}
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := h.customerService.SubmitPendingUploadDialog(vars["id"], userID, app.ParsePendingUploadSubmission(request.Submission))
+	if err != nil {
+		if errors.Is(err, app.ErrNotFound) {
+			ReturnJSON(w, &model.SubmitDialogResponse{
+				Error: "This support packet is no longer waiting for a customer, or the customer was removed.",
+			}, http.StatusOK)
+			return
+		}
+		h.HandleError(w, c.logger, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,405 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// backfillPollInterval is how often the worker checks for backfill jobs to run.
+	backfillPollInterval = 10 * time.Second
+
+	// backfillRunDuration is how long a backfill job is worked on before it is released, so a
+	// long backfill doesn't hold up stopping the plugin and can move to another server.
+	backfillRunDuration = time.Minute
+
+	// backfillJobLease is how long a claimed backfill job is held before another server may take
+	// it over, longer than a run so it only happens when the server went away.
+	backfillJobLease = 15 * time.Minute
+
+	// backfillPageSize is how many posts are read at a time while scanning a channel.
+	backfillPageSize = 200
+
+	// backfillItemBatchSize is how many attachments are ingested between checks for cancellation.
+	backfillItemBatchSize = 10
+)
+
+// BackfillStatus is where a backfill job is. Jobs first scan every channel for attachments, then
+// ingest them oldest post first.
+type BackfillStatus string
+
+const (
+	// BackfillScanning jobs are looking for attachments in the posts of the channels.
+	BackfillScanning BackfillStatus = "scanning"
+	// BackfillIngesting jobs have scanned every channel and are ingesting the attachments found.
+	BackfillIngesting BackfillStatus = "ingesting"
+	// BackfillCompleted jobs went through every attachment found.
+	BackfillCompleted BackfillStatus = "completed"
+	// BackfillCancelled jobs were stopped by an admin, the attachments ingested so far are kept.
+	BackfillCancelled BackfillStatus = "cancelled"
+)
+
+// BackfillOptions are the channels and time range a backfill job scans for support packets.
+type BackfillOptions struct {
+	ChannelIDs []string `json:"channelIDs"`
+
+	// Since and Until bound when the posts were created, Until is zero to scan up to now.
+	Since int64 `json:"since"`
+	Until int64 `json:"until"`
+}
+
+// BackfillJob ingests the support packets posted in channels before the plugin was watching them.
+type BackfillJob struct {
+	ID         string         `json:"id"`
+	CreatedBy  string         `json:"createdBy"`
+	ChannelIDs []string       `json:"channelIDs" db:"-"`
+	Since      int64          `json:"since"`
+	Until      int64          `json:"until"`
+	Status     BackfillStatus `json:"status"`
+	LastError  string         `json:"lastError"`
+	CreateAt   int64          `json:"createAt"`
+	UpdateAt   int64          `json:"updateAt"`
+
+	// ScanChannel is the index of the channel being scanned, ScanCursor the oldest post scanned in
+	// it so far. Scanning resumes from there.
+	ScanChannel int    `json:"scanChannel"`
+	ScanCursor  string `json:"scanCursor"`
+
+	// PostsScanned counts the posts in the time range read so far, the others count the
+	// attachments found in them by the status they are in.
+	PostsScanned     int `json:"postsScanned"`
+	AttachmentsFound int `json:"attachmentsFound"`
+	Ingested         int `json:"ingested"`
+	Skipped          int `json:"skipped"`
+	Failed           int `json:"failed"`
+}
+
+// BackfillItem is an attachment found by a backfill job. Items go through the same statuses as
+// ingestion jobs, except processing.
+type BackfillItem struct {
+	ID           string             `json:"id"`
+	JobID        string             `json:"jobID"`
+	PostID       string             `json:"postID"`
+	FileID       string             `json:"fileID"`
+	ChannelID    string             `json:"channelID"`
+	PostCreateAt int64              `json:"postCreateAt"`
+	Status       IngestionJobStatus `json:"status"`
+	Attempts     int                `json:"attempts"`
+	LastError    string             `json:"lastError"`
+}
+
+// validateBackfillOptions checks the options and returns them with duplicate channels removed.
+func validateBackfillOptions(opts BackfillOptions) (BackfillOptions, error) {
+	var channelIDs []string
+	for _, channelID := range opts.ChannelIDs {
+		if !model.IsValidId(channelID) {
+			return BackfillOptions{}, errors.Wrapf(ErrInvalidBackfill, "'%s' is not a channel ID", channelID)
+		}
+		if !containsString(channelIDs, channelID) {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		return BackfillOptions{}, errors.Wrap(ErrInvalidBackfill, "at least one channel is required")
+	}
+
+	if opts.Since < 0 || opts.Until < 0 {
+		return BackfillOptions{}, errors.Wrap(ErrInvalidBackfill, "since and until can't be negative")
+	}
+	if opts.Until != 0 && opts.Until <= opts.Since {
+		return BackfillOptions{}, errors.Wrap(ErrInvalidBackfill, "until must be after since")
+	}
+
+	opts.ChannelIDs = channelIDs
+	return opts, nil
+}
+
+// StartBackfill queues a backfill job for the channels, which must be public or private channels.
+func (s *customerService) StartBackfill(userID string, opts BackfillOptions) (BackfillJob, error) {
+	opts, err := validateBackfillOptions(opts)
+	if err != nil {
+		return BackfillJob{}, err
+	}
+
+	for _, channelID := range opts.ChannelIDs {
+		channel, err := s.api.Channel.Get(channelID)
+		if err != nil {
+			return BackfillJob{}, errors.Wrapf(ErrInvalidBackfill, "channel '%s' couldn't be found: %s", channelID, err.Error())
+		}
+		// packets sent to the bot need their uploader to pick the customer, they can't be backfilled
+		if channel.IsGroupOrDirect() {
+			return BackfillJob{}, errors.Wrapf(ErrInvalidBackfill, "channel '%s' is a direct or group message", channelID)
+		}
+	}
+
+	job := BackfillJob{
+		CreatedBy:  userID,
+		ChannelIDs: opts.ChannelIDs,
+		Since:      opts.Since,
+		Until:      opts.Until,
+		Status:     BackfillScanning,
+	}
+	job.ID, err = s.jobs.CreateBackfillJob(job)
+	if err != nil {
+		return BackfillJob{}, err
+	}
+
+	return s.jobs.GetBackfillJob(job.ID)
+}
+
+func (s *customerService) GetBackfillJobs() ([]BackfillJob, error) {
+	return s.jobs.GetBackfillJobs()
+}
+
+func (s *customerService) GetBackfillJob(id string) (BackfillJob, error) {
+	return s.jobs.GetBackfillJob(id)
+}
+
+func (s *customerService) GetBackfillItems(jobID string, status IngestionJobStatus) ([]BackfillItem, error) {
+	if _, err := s.jobs.GetBackfillJob(jobID); err != nil {
+		return nil, err
+	}
+
+	return s.jobs.GetBackfillItems(jobID, status)
+}
+
+func (s *customerService) CancelBackfill(id string) error {
+	return s.jobs.CancelBackfillJob(id)
+}
+
+// backfill runs the oldest active backfill job for a while, picking up where it was left off.
+func (w *ingestionWorker) backfill() {
+	job, err := w.service.jobs.ClaimBackfillJob(backfillJobLease)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to claim backfill job")
+		return
+	}
+	if job == nil {
+		return
+	}
+	logger := logrus.WithField("backfill_id", job.ID)
+
+	deadline := time.Now().Add(backfillRunDuration)
+	var lastError string
+	var retryAt int64
+	status, err := runBackfill(w.service, job, func() bool {
+		select {
+		case <-w.stop:
+			return false
+		default:
+			return time.Now().Before(deadline)
+		}
+	})
+	if err != nil {
+		// the job is resumed from where it failed once the backoff is over
+		logger.WithError(err).Warn("Backfill job failed, it will be resumed")
+		lastError = err.Error()
+		retryAt = model.GetMillis() + ingestionBackoff(1).Milliseconds()
+	}
+
+	if err = w.service.jobs.ReleaseBackfillJob(job.ID, status, lastError, retryAt); err != nil {
+		logger.WithError(err).Error("Failed to release backfill job")
+		return
+	}
+
+	if status == BackfillCompleted {
+		notifyBackfillCompleted(w.service, job.ID)
+	}
+}
+
+// runBackfill scans the channels of the job and ingests the attachments found, for as long as
+// proceed returns true. The status the job is left in is returned.
+func runBackfill(s *customerService, job *BackfillJob, proceed func() bool) (BackfillStatus, error) {
+	for proceed() {
+		// cancelling only changes the status, the run has to notice it
+		current, err := s.jobs.GetBackfillJob(job.ID)
+		if err != nil {
+			return job.Status, err
+		}
+		if current.Status == BackfillCancelled {
+			return BackfillCancelled, nil
+		}
+
+		if job.Status == BackfillScanning {
+			if err = scanBackfillPage(s, job); err != nil {
+				return job.Status, err
+			}
+			continue
+		}
+
+		items, err := s.jobs.GetPendingBackfillItems(job.ID, backfillItemBatchSize)
+		if err != nil {
+			return job.Status, err
+		}
+		if len(items) == 0 {
+			return BackfillCompleted, nil
+		}
+
+		for _, item := range items {
+			if !proceed() {
+				return job.Status, nil
+			}
+			// later packets wait for this one, so the history stays in the order they were posted
+			if err = ingestBackfillItem(s, item); err != nil {
+				return job.Status, err
+			}
+		}
+	}
+
+	return job.Status, nil
+}
+
+// scanBackfillPage reads the next page of posts of the channel being scanned and saves the
+// attachments found in them with the new position of the scan.
+func scanBackfillPage(s *customerService, job *BackfillJob) error {
+	channelID := job.ChannelIDs[job.ScanChannel]
+
+	var posts *model.PostList
+	var err error
+	if job.ScanCursor == "" {
+		posts, err = s.api.Post.GetPostsForChannel(channelID, 0, backfillPageSize)
+	} else {
+		posts, err = s.api.Post.GetPostsBefore(channelID, job.ScanCursor, 0, backfillPageSize)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get posts of channel '%s'", channelID)
+	}
+
+	items, scanned, done := backfillPageItems(job, posts, s.poster.IsFromPoster)
+	job.PostsScanned += scanned
+	if done {
+		job.ScanChannel++
+		job.ScanCursor = ""
+	} else {
+		job.ScanCursor = posts.Order[len(posts.Order)-1]
+	}
+	if job.ScanChannel >= len(job.ChannelIDs) {
+		job.Status = BackfillIngesting
+	}
+
+	return s.jobs.SaveBackfillScan(*job, items)
+}
+
+// backfillPageItems returns the attachments of the top level posts in the page that the job's
+// time range covers, the number of posts in that range and whether the channel has been scanned
+// completely. Pages are newest post first, so the scan goes back in time.
+func backfillPageItems(job *BackfillJob, posts *model.PostList, isFromBot func(post *model.Post) bool) ([]BackfillItem, int, bool) {
+	if posts == nil || len(posts.Order) == 0 {
+		return nil, 0, true
+	}
+
+	var items []BackfillItem
+	var scanned int
+	for _, postID := range posts.Order {
+		post, ok := posts.Posts[postID]
+		if !ok {
+			continue
+		}
+		if post.CreateAt < job.Since {
+			return items, scanned, true
+		}
+		if job.Until != 0 && post.CreateAt > job.Until {
+			continue
+		}
+
+		scanned++
+		// the same posts are ignored as when they are posted
+		if post.RootId != "" || post.DeleteAt != 0 || len(post.FileIds) == 0 || isFromBot(post) {
+			continue
+		}
+		for _, fileID := range post.FileIds {
+			items = append(items, BackfillItem{
+				JobID:        job.ID,
+				PostID:       post.Id,
+				FileID:       fileID,
+				ChannelID:    post.ChannelId,
+				PostCreateAt: post.CreateAt,
+				Status:       IngestionPending,
+			})
+		}
+	}
+
+	return items, scanned, len(posts.Order) < backfillPageSize
+}
+
+// ingestBackfillItem ingests the attachment without replying to the post, recording the outcome.
+// An error is only returned when it should be tried again before moving on to the next one.
+func ingestBackfillItem(s *customerService, item BackfillItem) error {
+	logger := logrus.WithFields(logrus.Fields{
+		"backfill_id": item.JobID,
+		"post_id":     item.PostID,
+		"file_id":     item.FileID,
+	})
+
+	status, err := backfillAttachment(s, item)
+	if err == nil {
+		return s.jobs.FinishBackfillItem(item.ID, status, "")
+	}
+
+	ingestionErr := asIngestionError(StageStore, err)
+	if !ingestionErr.Retryable || item.Attempts+1 >= MaxIngestionAttempts {
+		logger.WithError(err).Warn("Failed to ingest backfilled attachment, moving on to the next one")
+		return s.jobs.FinishBackfillItem(item.ID, IngestionDead, err.Error())
+	}
+
+	if failErr := s.jobs.FailBackfillItem(item.ID, err.Error()); failErr != nil {
+		logger.WithError(failErr).Error("Failed to record backfill item failure")
+	}
+
+	return err
+}
+
+// backfillAttachment goes through the ingestion pipeline for an attachment found by a backfill.
+func backfillAttachment(s *customerService, item BackfillItem) (IngestionJobStatus, error) {
+	post, err := s.api.Post.GetPost(item.PostID)
+	if err != nil {
+		return "", newRetryableIngestionError(StageDownload, "the post couldn't be read", errors.Wrap(err, "failed to get post"))
+	}
+	if post.DeleteAt != 0 {
+		return IngestionSkipped, nil
+	}
+
+	packetFile, err := openSupportPacketFile(s, item.FileID)
+	if err != nil {
+		return "", err
+	}
+	if packetFile == nil {
+		return IngestionSkipped, nil
+	}
+
+	if _, err = processSupportPacket(s, packetFile, post, true); err != nil {
+		return "", err
+	}
+
+	return IngestionCompleted, nil
+}
+
+// notifyBackfillCompleted tells the admin who started the backfill that it's done.
+func notifyBackfillCompleted(s *customerService, jobID string) {
+	job, err := s.jobs.GetBackfillJob(jobID)
+	if err != nil {
+		logrus.WithError(err).WithField("backfill_id", jobID).Warn("Failed to get completed backfill job.")
+		return
+	}
+
+	err = s.poster.DM(job.CreatedBy, &model.Post{
+		Message: backfillCompletedMessage(job),
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("backfill_id", jobID).Warn("Failed to send backfill completion message.")
+	}
+}
+
+func backfillCompletedMessage(job BackfillJob) string {
+	message := fmt.Sprintf("The support packet backfill of %d channel(s) finished. It scanned %d posts and found %d attachments: %d ingested, %d skipped and %d failed.",
+		len(job.ChannelIDs), job.PostsScanned, job.AttachmentsFound, job.Ingested, job.Skipped, job.Failed)
+	if job.Failed > 0 {
+		message += fmt.Sprintf(" The failed ones are listed by `GET /api/v0/ingestion/backfills/%s/items?status=dead`.", job.ID)
+	}
+
+	return message
+}
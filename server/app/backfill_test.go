@@ -0,0 +1,89 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateBackfillOptions(t *testing.T) {
+	channelID := model.NewId()
+
+	opts, err := validateBackfillOptions(BackfillOptions{ChannelIDs: []string{channelID, channelID}, Since: 1000})
+	require.NoError(t, err)
+	require.Equal(t, []string{channelID}, opts.ChannelIDs)
+
+	_, err = validateBackfillOptions(BackfillOptions{})
+	require.True(t, errors.Is(err, ErrInvalidBackfill))
+
+	_, err = validateBackfillOptions(BackfillOptions{ChannelIDs: []string{"town-square"}})
+	require.True(t, errors.Is(err, ErrInvalidBackfill))
+
+	_, err = validateBackfillOptions(BackfillOptions{ChannelIDs: []string{channelID}, Since: 2000, Until: 1000})
+	require.True(t, errors.Is(err, ErrInvalidBackfill))
+}
+
+func TestBackfillPageItems(t *testing.T) {
+	job := &BackfillJob{ID: "job1", Since: 1000, Until: 5000}
+	isFromBot := func(post *model.Post) bool {
+		return post.UserId == "bot"
+	}
+
+	page := func(posts ...*model.Post) *model.PostList {
+		list := model.NewPostList()
+		for _, post := range posts {
+			list.AddPost(post)
+			list.AddOrder(post.Id)
+		}
+		return list
+	}
+
+	t.Run("keeps top level attachments in the time range", func(t *testing.T) {
+		items, scanned, done := backfillPageItems(job, page(
+			&model.Post{Id: "future", CreateAt: 6000, FileIds: []string{"file0"}},
+			&model.Post{Id: "packet", ChannelId: "channel1", CreateAt: 4000, FileIds: []string{"file1", "file2"}},
+			&model.Post{Id: "reply", RootId: "packet", CreateAt: 3500, FileIds: []string{"file3"}},
+			&model.Post{Id: "bot", UserId: "bot", CreateAt: 3000, FileIds: []string{"file4"}},
+			&model.Post{Id: "text", CreateAt: 2000},
+		), isFromBot)
+
+		require.Len(t, items, 2)
+		require.Equal(t, BackfillItem{JobID: "job1", PostID: "packet", FileID: "file1", ChannelID: "channel1", PostCreateAt: 4000, Status: IngestionPending}, items[0])
+		require.Equal(t, "file2", items[1].FileID)
+		require.Equal(t, 4, scanned)
+		// a partial page is the start of the channel
+		require.True(t, done)
+	})
+
+	t.Run("stops at posts older than the range", func(t *testing.T) {
+		posts := make([]*model.Post, 0, backfillPageSize)
+		for i := 0; i < backfillPageSize; i++ {
+			posts = append(posts, &model.Post{Id: model.NewId(), CreateAt: int64(5000 - i*10)})
+		}
+
+		_, scanned, done := backfillPageItems(job, page(posts...), isFromBot)
+		require.Equal(t, backfillPageSize, scanned)
+		require.False(t, done)
+
+		_, scanned, done = backfillPageItems(job, page(append(posts, &model.Post{Id: "old", CreateAt: 500})...), isFromBot)
+		require.Equal(t, backfillPageSize, scanned)
+		require.True(t, done)
+	})
+
+	t.Run("empty page", func(t *testing.T) {
+		items, scanned, done := backfillPageItems(job, model.NewPostList(), isFromBot)
+		require.Empty(t, items)
+		require.Zero(t, scanned)
+		require.True(t, done)
+	})
+}
+
+func TestBackfillCompletedMessage(t *testing.T) {
+	job := BackfillJob{ID: "job1", ChannelIDs: []string{"a", "b"}, PostsScanned: 120, AttachmentsFound: 4, Ingested: 2, Skipped: 1, Failed: 1}
+	require.Equal(t, "The support packet backfill of 2 channel(s) finished. It scanned 120 posts and found 4 attachments: 2 ingested, 1 skipped and 1 failed. The failed ones are listed by `GET /api/v0/ingestion/backfills/job1/items?status=dead`.", backfillCompletedMessage(job))
+
+	job.Failed = 0
+	require.NotContains(t, backfillCompletedMessage(job), "status=dead")
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/r3labs/diff"
+)
+
+// nodeSpecificConfigPaths are config settings that are expected to differ between cluster nodes.
+var nodeSpecificConfigPaths = []string{
+	"ClusterSettings.OverrideHostname",
+	"ClusterSettings.AdvertiseAddress",
+	"ClusterSettings.BindAddress",
+}
+
+// maxConfigMismatchPaths is how many differing config keys are listed per node in the summary.
+const maxConfigMismatchPaths = 10
+
+// supportPacketNode is the part of a support packet that came from a single directory in
+// the archive. Multi-node packets have one directory per cluster node.
+type supportPacketNode struct {
+	id       string
+	packet   *model.SupportPacket
+	config   *model.Config
+	plugins  *model.PluginsResponse
+	metadata *packetMetadata
+}
+
+// packetMetadata is the metadata.yaml newer servers add to the packet, only the identifiers
+// of the server are read from it.
+type packetMetadata struct {
+	ServerID  string `yaml:"server_id"`
+	LicenseID string `yaml:"license_id"`
+}
+
+// nodeValues converts the node's packet into the values stored for it.
+func (n *supportPacketNode) nodeValues() CustomerNodeValues {
+	return CustomerNodeValues{
+		NodeID:                n.id,
+		Version:               n.packet.ServerVersion,
+		BuildHash:             n.packet.BuildHash,
+		ServerOS:              n.packet.ServerOS,
+		ServerArch:            n.packet.ServerArchitecture,
+		DatabaseVersion:       n.packet.DatabaseVersion,
+		DatabaseSchemaVersion: n.packet.DatabaseSchemaVersion,
+		WebsocketConnections:  n.packet.WebsocketConnections,
+		MasterDBConnections:   n.packet.MasterDbConnections,
+		ReplicaDBConnections:  n.packet.ReplicaDbConnections,
+	}
+}
+
+// clusterMismatches compares the nodes against each other and returns a readable line for every
+// version or config difference found. The first node is used as the baseline for config.
+func clusterMismatches(nodes []*supportPacketNode) []string {
+	if len(nodes) < 2 {
+		return nil
+	}
+
+	var mismatches []string
+
+	versions := make(map[string][]string)
+	for _, node := range nodes {
+		version := node.packet.ServerVersion
+		if node.packet.BuildHash != "" {
+			version += " (" + node.packet.BuildHash + ")"
+		}
+		versions[version] = append(versions[version], node.id)
+	}
+
+	if len(versions) > 1 {
+		var parts []string
+		for version, ids := range versions {
+			parts = append(parts, fmt.Sprintf("`%s` on %s", version, strings.Join(ids, ", ")))
+		}
+		sort.Strings(parts)
+		mismatches = append(mismatches, "Nodes are running different versions: "+strings.Join(parts, "; "))
+	}
+
+	baseline := nodes[0]
+	for _, node := range nodes[1:] {
+		if baseline.config == nil || node.config == nil {
+			continue
+		}
+
+		paths := configDifferences(baseline.config, node.config)
+		if len(paths) == 0 {
+			continue
+		}
+
+		listed := paths
+		if len(listed) > maxConfigMismatchPaths {
+			listed = listed[:maxConfigMismatchPaths]
+		}
+		line := fmt.Sprintf("Config on `%s` differs from `%s`: `%s`", node.id, baseline.id, strings.Join(listed, "`, `"))
+		if len(paths) > len(listed) {
+			line += fmt.Sprintf(" and %d more", len(paths)-len(listed))
+		}
+		mismatches = append(mismatches, line)
+	}
+
+	return mismatches
+}
+
+// configDifferences returns the sorted config paths that differ between the two configs,
+// ignoring settings that are expected to be node specific.
+func configDifferences(a, b *model.Config) []string {
+	changelog, err := diff.Diff(a, b)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, change := range changelog {
+		changePath := strings.Join(change.Path, ".")
+		if isNodeSpecificConfigPath(changePath) || seen[changePath] {
+			continue
+		}
+		seen[changePath] = true
+		paths = append(paths, changePath)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func isNodeSpecificConfigPath(configPath string) bool {
+	for _, nodePath := range nodeSpecificConfigPaths {
+		if strings.HasPrefix(configPath, nodePath) {
+			return true
+		}
+	}
+
+	return false
+}
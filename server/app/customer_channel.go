@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// parseCustomerChannel reads a customer's channel setting, either a channel ID or a link to the
+// channel like https://example.com/team/channels/name. The team and channel names are returned
+// for links, the ID otherwise.
+func parseCustomerChannel(setting string) (channelID string, teamName string, channelName string, err error) {
+	setting = strings.TrimSpace(setting)
+	if model.IsValidId(setting) {
+		return setting, "", "", nil
+	}
+
+	link, err := url.Parse(setting)
+	if err != nil {
+		return "", "", "", errors.Wrapf(err, "customer channel '%s' is neither a channel ID nor a link", setting)
+	}
+
+	parts := strings.Split(strings.Trim(link.Path, "/"), "/")
+	for i := 1; i < len(parts)-1; i++ {
+		if parts[i] == "channels" {
+			return "", parts[i-1], parts[i+1], nil
+		}
+	}
+
+	return "", "", "", errors.Errorf("customer channel '%s' is neither a channel ID nor a link to a channel", setting)
+}
+
+// resolveCustomerChannel returns the channel a customer's channel setting refers to.
+func resolveCustomerChannel(s *customerService, setting string) (*model.Channel, error) {
+	channelID, teamName, channelName, err := parseCustomerChannel(setting)
+	if err != nil {
+		return nil, err
+	}
+
+	if channelID != "" {
+		return s.api.Channel.Get(channelID)
+	}
+
+	return s.api.Channel.GetByNameForTeamName(teamName, channelName, false)
+}
+
+// crossPostPacketSummary posts a condensed summary of the upload to the customer's channel if the
+// customer has opted in, or the uploader chose to share it. Failing to do so doesn't fail the
+// upload, it's only logged.
+func crossPostPacketSummary(s *customerService, customerID string, upload *SupportPacketUpload, changes string) {
+	logger := logrus.WithField("customer_id", customerID)
+
+	customer, err := s.store.GetCustomerByID(customerID)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get customer to cross-post the packet summary.")
+		return
+	}
+	share := customer.PostPacketSummaries
+	if upload.ShareSummary != nil {
+		share = *upload.ShareSummary
+	}
+	if !share || customer.CustomerChannel == "" {
+		return
+	}
+
+	channel, err := resolveCustomerChannel(s, customer.CustomerChannel)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to find the customer channel to cross-post the packet summary.")
+		return
+	}
+	// the full summary is already in the thread of the packet post
+	if channel.Id == upload.ChannelID {
+		return
+	}
+
+	err = s.poster.Post(&model.Post{
+		ChannelId: channel.Id,
+		Message:   customerChannelSummary(customer.Customer, upload, changes, postPermalink(s, upload.PostID)),
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to cross-post the packet summary to the customer channel.")
+	}
+}
+
+// customerChannelSummary is the condensed summary posted to the customer channel: the main
+// values, the findings and what changed since the last packet.
+func customerChannelSummary(customer Customer, upload *SupportPacketUpload, changes string, link string) string {
+	message := fmt.Sprintf("### New support packet for %s\n", customer.Name)
+	if link != "" {
+		message += fmt.Sprintf("Support packet `%s` was ingested, see the [original upload](%s).\n\n", upload.FileName, link)
+	} else {
+		message += fmt.Sprintf("Support packet `%s` was ingested.\n\n", upload.FileName)
+	}
+
+	if upload.Packet != nil {
+		message += fmt.Sprintf("**Server Version:** `%s` | **Active Users:** %d | **Database:** %s %s\n\n", upload.Packet.ServerVersion, upload.Packet.ActiveUsers, upload.Packet.DatabaseType, upload.Packet.DatabaseVersion)
+	}
+
+	return message + findingsMarkdown(upload.Findings) + changes
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCustomerChannel(t *testing.T) {
+	id := model.NewId()
+
+	channelID, teamName, channelName, err := parseCustomerChannel(" " + id + " ")
+	require.NoError(t, err)
+	require.Equal(t, id, channelID)
+	require.Empty(t, teamName)
+	require.Empty(t, channelName)
+
+	channelID, teamName, channelName, err = parseCustomerChannel("https://chat.example.com/support/channels/acme-corp")
+	require.NoError(t, err)
+	require.Empty(t, channelID)
+	require.Equal(t, "support", teamName)
+	require.Equal(t, "acme-corp", channelName)
+
+	// servers hosted under a subpath
+	_, teamName, channelName, err = parseCustomerChannel("https://example.com/chat/support/channels/acme-corp/")
+	require.NoError(t, err)
+	require.Equal(t, "support", teamName)
+	require.Equal(t, "acme-corp", channelName)
+
+	_, _, _, err = parseCustomerChannel("acme-corp")
+	require.Error(t, err)
+}
+
+func TestCustomerChannelSummary(t *testing.T) {
+	upload := &SupportPacketUpload{
+		FileName: "packet.zip",
+		Packet:   &model.SupportPacket{ServerVersion: "9.5.0", ActiveUsers: 120, DatabaseType: "postgres", DatabaseVersion: "14.2"},
+		Findings: []Finding{{Severity: SeverityWarning, Title: "Old version", Remediation: "Upgrade."}},
+	}
+
+	message := customerChannelSummary(Customer{Name: "Acme"}, upload, "## Since Last Packet on 2024-01-02 10:00 UTC\nNothing changed.\n\n", "https://example.com/_redirect/pl/post1")
+	require.Equal(t, "### New support packet for Acme\n"+
+		"Support packet `packet.zip` was ingested, see the [original upload](https://example.com/_redirect/pl/post1).\n\n"+
+		"**Server Version:** `9.5.0` | **Active Users:** 120 | **Database:** postgres 14.2\n\n"+
+		"## Findings\n- :warning: **Old version**: Upgrade.\n\n"+
+		"## Since Last Packet on 2024-01-02 10:00 UTC\nNothing changed.\n\n", message)
+
+	message = customerChannelSummary(Customer{Name: "Acme"}, &SupportPacketUpload{FileName: "packet.zip"}, "", "")
+	require.Equal(t, "### New support packet for Acme\nSupport packet `packet.zip` was ingested.\n\n## Findings\nNo issues found.\n\n", message)
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// licenseTypes are the license types a customer can be created with, besides none.
+var licenseTypes = []LicenseType{Cloud, Enterprise, Professional, Free, Trial, NonProfit, Other}
+
+// validateNewCustomer trims the identifying values of a customer entered by a user and checks
+// them.
+func validateNewCustomer(customer *Customer) error {
+	customer.Name = strings.TrimSpace(customer.Name)
+	customer.SiteURL = strings.TrimSpace(customer.SiteURL)
+	customer.LicensedTo = strings.TrimSpace(customer.LicensedTo)
+
+	if customer.Name == "" {
+		return errors.Wrap(ErrInvalidCustomer, "name cannot be empty")
+	}
+
+	if customer.SiteURL != "" && !isSiteURL(customer.SiteURL) {
+		return errors.Wrapf(ErrInvalidCustomer, "site URL '%s' must be an http or https URL", customer.SiteURL)
+	}
+
+	if customer.LicenseType != "" {
+		valid := false
+		for _, licenseType := range licenseTypes {
+			valid = valid || customer.LicenseType == licenseType
+		}
+		if !valid {
+			return errors.Wrapf(ErrInvalidCustomer, "unknown license type '%s'", customer.LicenseType)
+		}
+	}
+
+	return nil
+}
+
+func (s *customerService) CreateCustomer(userID string, customer Customer) (FullCustomerInfo, error) {
+	if err := validateNewCustomer(&customer); err != nil {
+		return FullCustomerInfo{}, err
+	}
+
+	// packets are matched by site URL, a second customer with it would make them ambiguous
+	if customer.SiteURL != "" {
+		matches, err := s.store.MatchCustomers(PacketIdentity{SiteURL: customer.SiteURL})
+		if err != nil {
+			return FullCustomerInfo{}, err
+		}
+		for _, match := range matches {
+			if strings.EqualFold(match.SiteURL, customer.SiteURL) {
+				return FullCustomerInfo{}, errors.Wrapf(ErrDuplicateEntry, "customer '%s' already has site URL '%s'", match.Name, customer.SiteURL)
+			}
+		}
+	}
+
+	customerID, err := s.store.CreateCustomer(customer)
+	if err != nil {
+		return FullCustomerInfo{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"customer_id": customerID,
+		"user_id":     userID,
+	}).Info("Customer created")
+
+	return s.store.GetCustomerByID(customerID)
+}
+
+func (s *customerService) ArchiveCustomer(id string, userID string) error {
+	return s.store.SetCustomerArchived(id, userID, model.GetMillis())
+}
+
+func (s *customerService) RestoreCustomer(id string) error {
+	return s.store.SetCustomerArchived(id, "", 0)
+}
+
+func (s *customerService) DeleteCustomer(id string) error {
+	snapshots, err := s.store.GetSnapshots(id)
+	if err != nil {
+		return err
+	}
+
+	if err = s.store.DeleteCustomer(id); err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, snapshot := range snapshots {
+		if snapshot.ArchivePath != "" {
+			paths = append(paths, snapshot.ArchivePath)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// the rows are gone either way, a zip left behind is only wasted space
+	backend, err := s.fileBackend()
+	if err != nil {
+		logrus.WithError(err).WithField("customer_id", id).Warn("Failed to get file backend to delete the packet archives of a deleted customer.")
+		return nil
+	}
+	for _, path := range paths {
+		if err := backend.RemoveFile(path); err != nil {
+			logrus.WithError(err).WithField("path", path).Warn("Failed to delete packet archive of deleted customer.")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNewCustomer(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		customer := Customer{Name: "  Acme ", SiteURL: "https://chat.acme.com ", LicenseType: Enterprise}
+		require.NoError(t, validateNewCustomer(&customer))
+		require.Equal(t, "Acme", customer.Name)
+		require.Equal(t, "https://chat.acme.com", customer.SiteURL)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, customer := range map[string]Customer{
+			"no name":         {Name: " "},
+			"no scheme":       {Name: "Acme", SiteURL: "chat.acme.com"},
+			"other scheme":    {Name: "Acme", SiteURL: "ftp://chat.acme.com"},
+			"unknown license": {Name: "Acme", LicenseType: "platinum"},
+			"no host":         {Name: "Acme", SiteURL: "https://"},
+		} {
+			customer := customer
+			require.ErrorIs(t, validateNewCustomer(&customer), ErrInvalidCustomer, name)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package app
+
+// stableIdentifiers are generated by the server, so unlike the site URL and license holder they
+// are enough on their own to tell which of several customers a packet is for.
+var stableIdentifiers = []IdentifierType{IdentifierTelemetryID, IdentifierLicenseID, IdentifierClusterID}
+
+// ResolveCustomerMatch picks the customer a packet belongs to out of the matches, which must be
+// sorted by SortCustomerMatches. A single match is used as is. Out of several, the most confident
+// one only wins if it matched a stable identifier and no other customer matches just as well.
+// Archived customers are never picked. Returns false when there is no clear match and someone has
+// to choose.
+func ResolveCustomerMatch(matches []CustomerMatch) (Customer, bool) {
+	if len(matches) == 0 || matches[0].ArchivedAt != 0 {
+		return Customer{}, false
+	}
+
+	if len(matches) == 1 {
+		return matches[0].Customer, true
+	}
+
+	if matchedStableIdentifier(matches[0]) && matches[0].Confidence > matches[1].Confidence {
+		return matches[0].Customer, true
+	}
+
+	return Customer{}, false
+}
+
+func matchedStableIdentifier(match CustomerMatch) bool {
+	for _, identifierType := range match.Matched {
+		for _, stable := range stableIdentifiers {
+			if identifierType == stable {
+				return true
+			}
+		}
+	}
+
+	return false
+}
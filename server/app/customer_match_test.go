@@ -0,0 +1,123 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scoreAll scores and sorts the customers against the packet like the store does.
+func scoreAll(customers []Customer, known map[string][]CustomerIdentifier, identity PacketIdentity) []CustomerMatch {
+	matches := make([]CustomerMatch, 0, len(customers))
+	for _, customer := range customers {
+		matches = append(matches, ScoreCustomerMatch(customer, known[customer.ID], identity))
+	}
+	SortCustomerMatches(matches)
+
+	return matches
+}
+
+func TestResolveCustomerMatch(t *testing.T) {
+	resolve := func(customers []Customer, known map[string][]CustomerIdentifier, identity PacketIdentity) (string, bool) {
+		customer, ok := ResolveCustomerMatch(scoreAll(customers, known, identity))
+		return customer.ID, ok
+	}
+
+	t.Run("single candidate", func(t *testing.T) {
+		id, ok := resolve([]Customer{{ID: "1", SiteURL: "old.com", LicensedTo: "a"}}, nil, PacketIdentity{SiteURL: "new.com", LicensedTo: "a"})
+		require.True(t, ok)
+		require.Equal(t, "1", id)
+	})
+
+	t.Run("one candidate matches both weak identifiers", func(t *testing.T) {
+		_, ok := resolve([]Customer{
+			{ID: "1", SiteURL: "a.com", LicensedTo: "a"},
+			{ID: "2", SiteURL: "b.com", LicensedTo: "a"},
+		}, nil, PacketIdentity{SiteURL: "b.com", LicensedTo: "a"})
+		require.False(t, ok, "the site URL and license holder alone aren't enough to pick one")
+	})
+
+	t.Run("one by the site URL, another by the license holder", func(t *testing.T) {
+		_, ok := resolve([]Customer{
+			{ID: "1", SiteURL: "a.com", LicensedTo: "b"},
+			{ID: "2", SiteURL: "c.com", LicensedTo: "a"},
+		}, nil, PacketIdentity{SiteURL: "a.com", LicensedTo: "a"})
+		require.False(t, ok, "the license holder is more confident, but doesn't settle it")
+	})
+
+	t.Run("several match both", func(t *testing.T) {
+		_, ok := resolve([]Customer{
+			{ID: "1", SiteURL: "a.com", LicensedTo: "a"},
+			{ID: "2", SiteURL: "a.com", LicensedTo: "a"},
+		}, nil, PacketIdentity{SiteURL: "a.com", LicensedTo: "a"})
+		require.False(t, ok)
+	})
+
+	t.Run("several share the license", func(t *testing.T) {
+		_, ok := resolve([]Customer{
+			{ID: "1", SiteURL: "a.com", LicensedTo: "a"},
+			{ID: "2", SiteURL: "b.com", LicensedTo: "a"},
+		}, nil, PacketIdentity{SiteURL: "c.com", LicensedTo: "a"})
+		require.False(t, ok)
+	})
+
+	t.Run("telemetry id settles a shared license", func(t *testing.T) {
+		id, ok := resolve([]Customer{
+			{ID: "1", SiteURL: "a.com", LicensedTo: "a"},
+			{ID: "2", SiteURL: "b.com", LicensedTo: "a"},
+		}, map[string][]CustomerIdentifier{
+			"2": {{CustomerID: "2", Type: IdentifierTelemetryID, Value: "server2"}},
+		}, PacketIdentity{SiteURL: "new.com", LicensedTo: "a", TelemetryID: "server2"})
+		require.True(t, ok)
+		require.Equal(t, "2", id)
+	})
+
+	t.Run("archived customers are left for someone to choose", func(t *testing.T) {
+		_, ok := resolve([]Customer{{ID: "1", SiteURL: "a.com", ArchivedAt: 1}}, nil, PacketIdentity{SiteURL: "a.com"})
+		require.False(t, ok)
+
+		_, ok = resolve([]Customer{
+			{ID: "1", SiteURL: "a.com", LicensedTo: "a", ArchivedAt: 1},
+			{ID: "2", SiteURL: "b.com", LicensedTo: "a"},
+		}, map[string][]CustomerIdentifier{
+			"1": {{CustomerID: "1", Type: IdentifierTelemetryID, Value: "server1"}},
+		}, PacketIdentity{SiteURL: "a.com", LicensedTo: "a", TelemetryID: "server1"})
+		require.False(t, ok)
+	})
+
+	t.Run("no candidates", func(t *testing.T) {
+		_, ok := ResolveCustomerMatch(nil)
+		require.False(t, ok)
+	})
+}
+
+func TestScoreCustomerMatch(t *testing.T) {
+	customer := Customer{ID: "1", SiteURL: "old.com", LicensedTo: "Old Name"}
+	known := []CustomerIdentifier{
+		{CustomerID: "1", Type: IdentifierLicenseID, Value: "license1"},
+		{CustomerID: "1", Type: IdentifierSiteURL, Value: "new.com"},
+	}
+
+	t.Run("renamed and moved customer still matches", func(t *testing.T) {
+		match := ScoreCustomerMatch(customer, known, PacketIdentity{SiteURL: "new.com", LicensedTo: "New Name", LicenseID: "license1"})
+		require.Equal(t, []IdentifierType{IdentifierLicenseID, IdentifierSiteURL}, match.Matched)
+		require.InDelta(t, 0.95, match.Confidence, 0.0001)
+	})
+
+	t.Run("nothing matches", func(t *testing.T) {
+		match := ScoreCustomerMatch(customer, known, PacketIdentity{SiteURL: "other.com"})
+		require.Empty(t, match.Matched)
+		require.Zero(t, match.Confidence)
+	})
+
+	t.Run("empty values never match", func(t *testing.T) {
+		match := ScoreCustomerMatch(Customer{ID: "2"}, nil, PacketIdentity{TelemetryID: "server1"})
+		require.Empty(t, match.Matched)
+	})
+}
+
+func TestValidateCustomerIdentifier(t *testing.T) {
+	require.NoError(t, ValidateCustomerIdentifier(IdentifierTelemetryID, "abc"))
+	require.ErrorIs(t, ValidateCustomerIdentifier("serial", "abc"), ErrInvalidIdentifier)
+	require.ErrorIs(t, ValidateCustomerIdentifier(IdentifierSiteURL, " "), ErrInvalidIdentifier)
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MergeStrategy decides whose value is kept when the merged customers have different values for
+// the same profile field.
+type MergeStrategy string
+
+const (
+	// MergeKeepTarget keeps the values of the customer merged into.
+	MergeKeepTarget MergeStrategy = "target"
+	// MergeKeepSource keeps the values of the customer merged from.
+	MergeKeepSource MergeStrategy = "source"
+	// MergeKeepNewest keeps the values of the most recently updated customer.
+	MergeKeepNewest MergeStrategy = "newest"
+)
+
+// MergeOptions are how a source customer is merged into a target one.
+type MergeOptions struct {
+	SourceID string `json:"sourceID"`
+
+	// Strategy resolves the conflicting profile fields, keeping the target's values when empty.
+	Strategy MergeStrategy `json:"strategy"`
+
+	// Fields overrides the strategy for single profile fields, by JSON name.
+	Fields map[string]MergeStrategy `json:"fields"`
+
+	// DryRun previews the merge without changing anything.
+	DryRun bool `json:"dryRun"`
+}
+
+// MergeConflict is a profile field both customers have a different value for.
+type MergeConflict struct {
+	Field       string        `json:"field"`
+	TargetValue interface{}   `json:"targetValue"`
+	SourceValue interface{}   `json:"sourceValue"`
+	Kept        MergeStrategy `json:"kept"`
+}
+
+// MergeResult is the merged customer profile with the conflicts resolved to get it.
+type MergeResult struct {
+	DryRun    bool            `json:"dryRun"`
+	Customer  Customer        `json:"customer"`
+	Conflicts []MergeConflict `json:"conflicts"`
+
+	// MovedRows counts the source rows moved to the target by table, or that would be moved for
+	// dry runs.
+	MovedRows map[string]int64 `json:"movedRows"`
+}
+
+// mergeSkippedFields aren't profile fields, they're the target's or worked out by the store.
+var mergeSkippedFields = map[string]bool{
+	"id":          true,
+	"lastUpdated": true,
+	"archivedAt":  true,
+	"archivedBy":  true,
+}
+
+// validateMergeOptions checks the options, defaulting to keeping the target's values.
+func validateMergeOptions(targetID string, opts *MergeOptions) error {
+	if opts.SourceID == "" {
+		return errors.Wrap(ErrInvalidMerge, "sourceID cannot be empty")
+	}
+	if opts.SourceID == targetID {
+		return errors.Wrap(ErrInvalidMerge, "a customer cannot be merged into itself")
+	}
+
+	if opts.Strategy == "" {
+		opts.Strategy = MergeKeepTarget
+	}
+	if !isMergeStrategy(opts.Strategy) {
+		return errors.Wrapf(ErrInvalidMerge, "strategy '%s' must be one of '%s', '%s' or '%s'", opts.Strategy, MergeKeepTarget, MergeKeepSource, MergeKeepNewest)
+	}
+
+	customerType := reflect.TypeOf(Customer{})
+	for field, strategy := range opts.Fields {
+		if _, ok := customerFieldIndex(customerType, field); !ok || mergeSkippedFields[field] {
+			return errors.Wrapf(ErrInvalidMerge, "unknown profile field '%s'", field)
+		}
+		if !isMergeStrategy(strategy) {
+			return errors.Wrapf(ErrInvalidMerge, "strategy '%s' of field '%s' must be one of '%s', '%s' or '%s'", strategy, field, MergeKeepTarget, MergeKeepSource, MergeKeepNewest)
+		}
+	}
+
+	return nil
+}
+
+func isMergeStrategy(strategy MergeStrategy) bool {
+	return strategy == MergeKeepTarget || strategy == MergeKeepSource || strategy == MergeKeepNewest
+}
+
+func customerFieldIndex(customerType reflect.Type, field string) (int, bool) {
+	for i := 0; i < customerType.NumField(); i++ {
+		if strings.Split(customerType.Field(i).Tag.Get("json"), ",")[0] == field {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// resolveMerge returns the target's profile with the source's values for the fields only the
+// source has, and the conflicting fields resolved by the options.
+func resolveMerge(target Customer, source Customer, opts MergeOptions) (Customer, []MergeConflict) {
+	merged := target
+	if source.LastUpdated > merged.LastUpdated {
+		merged.LastUpdated = source.LastUpdated
+	}
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	sourceValue := reflect.ValueOf(source)
+	customerType := mergedValue.Type()
+
+	conflicts := []MergeConflict{}
+	for i := 0; i < customerType.NumField(); i++ {
+		field := strings.Split(customerType.Field(i).Tag.Get("json"), ",")[0]
+		if mergeSkippedFields[field] {
+			continue
+		}
+
+		targetField := mergedValue.Field(i)
+		sourceField := sourceValue.Field(i)
+		if sourceField.IsZero() || reflect.DeepEqual(targetField.Interface(), sourceField.Interface()) {
+			continue
+		}
+		if targetField.IsZero() {
+			targetField.Set(sourceField)
+			continue
+		}
+
+		strategy := opts.Strategy
+		if override, ok := opts.Fields[field]; ok {
+			strategy = override
+		}
+		if strategy == MergeKeepNewest {
+			strategy = MergeKeepTarget
+			if source.LastUpdated > target.LastUpdated {
+				strategy = MergeKeepSource
+			}
+		}
+
+		conflicts = append(conflicts, MergeConflict{
+			Field:       field,
+			TargetValue: targetField.Interface(),
+			SourceValue: sourceField.Interface(),
+			Kept:        strategy,
+		})
+		if strategy == MergeKeepSource {
+			targetField.Set(sourceField)
+		}
+	}
+
+	return merged, conflicts
+}
+
+// MergeCustomers merges the source customer of the options into the target one, moving all of
+// its history and identifiers, then deletes the source.
+func (s *customerService) MergeCustomers(targetID string, userID string, opts MergeOptions) (MergeResult, error) {
+	if err := validateMergeOptions(targetID, &opts); err != nil {
+		return MergeResult{}, err
+	}
+
+	target, err := s.store.GetCustomerByID(targetID)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	source, err := s.store.GetCustomerByID(opts.SourceID)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	merged, conflicts := resolveMerge(target.Customer, source.Customer, opts)
+	result := MergeResult{
+		DryRun:    opts.DryRun,
+		Customer:  merged,
+		Conflicts: conflicts,
+	}
+
+	if opts.DryRun {
+		result.MovedRows, err = s.store.CountCustomerRows(source.ID)
+		if err != nil {
+			return MergeResult{}, err
+		}
+		return result, nil
+	}
+
+	result.MovedRows, err = s.store.MergeCustomers(merged, source.Customer, userID)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"target_id": targetID,
+		"source_id": source.ID,
+		"user_id":   userID,
+	}).Info("Customers merged")
+
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveMerge(t *testing.T) {
+	target := Customer{
+		ID:          "target",
+		Name:        "Acme",
+		LastUpdated: 1000,
+		SiteURL:     "https://chat.acme.com",
+		Region:      "amer",
+	}
+	source := Customer{
+		ID:                     "source",
+		Name:                   "Acme Corp",
+		LastUpdated:            2000,
+		SiteURL:                "https://chat.acme.com",
+		Region:                 "emea",
+		CustomerSuccessManager: "csm",
+	}
+
+	t.Run("keep target", func(t *testing.T) {
+		merged, conflicts := resolveMerge(target, source, MergeOptions{Strategy: MergeKeepTarget})
+		require.Equal(t, "target", merged.ID)
+		require.Equal(t, "Acme", merged.Name)
+		require.Equal(t, "amer", merged.Region)
+		require.Equal(t, "csm", merged.CustomerSuccessManager)
+		require.Equal(t, int64(2000), merged.LastUpdated)
+
+		require.Len(t, conflicts, 2)
+		require.Equal(t, MergeConflict{Field: "name", TargetValue: "Acme", SourceValue: "Acme Corp", Kept: MergeKeepTarget}, conflicts[0])
+	})
+
+	t.Run("newest with override", func(t *testing.T) {
+		merged, conflicts := resolveMerge(target, source, MergeOptions{
+			Strategy: MergeKeepNewest,
+			Fields:   map[string]MergeStrategy{"region": MergeKeepTarget},
+		})
+		require.Equal(t, "Acme Corp", merged.Name)
+		require.Equal(t, "amer", merged.Region)
+		require.Equal(t, MergeKeepSource, conflicts[0].Kept)
+		require.Equal(t, MergeKeepTarget, conflicts[1].Kept)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		opts := MergeOptions{SourceID: "source"}
+		require.NoError(t, validateMergeOptions("target", &opts))
+		require.Equal(t, MergeKeepTarget, opts.Strategy)
+
+		for name, opts := range map[string]MergeOptions{
+			"no source":      {},
+			"itself":         {SourceID: "target"},
+			"strategy":       {SourceID: "source", Strategy: "oldest"},
+			"unknown field":  {SourceID: "source", Fields: map[string]MergeStrategy{"nickname": MergeKeepSource}},
+			"skipped field":  {SourceID: "source", Fields: map[string]MergeStrategy{"id": MergeKeepSource}},
+			"field strategy": {SourceID: "source", Fields: map[string]MergeStrategy{"name": "oldest"}},
+		} {
+			opts := opts
+			require.ErrorIs(t, validateMergeOptions("target", &opts), ErrInvalidMerge, name)
+		}
+	})
+}
@@ -1,6 +1,11 @@
 package app
 
-import "github.com/mattermost/mattermost/server/public/model"
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
 
 type LicenseType string
 
@@ -35,6 +40,16 @@ type Customer struct {
 	Status                  string      `json:"status"`      // gold standard, onboarding, stable
 	CompanyType             string      `json:"companyType"` // enterprise, federal, midmarket, smb,
 	CodeWord                string      `json:"codeWord"`
+
+	// PostPacketSummaries cross-posts a summary of every packet ingested for the customer to the
+	// CustomerChannel.
+	PostPacketSummaries bool `json:"postPacketSummaries"`
+
+	// ArchivedAt is when the customer was archived, zero if it isn't. Archived customers are left
+	// out of GetCustomers but keep their history. New packets matching them are only stored for
+	// them if someone picks them when asked which customer the packet is for.
+	ArchivedAt int64  `json:"archivedAt"`
+	ArchivedBy string `json:"archivedBy"`
 }
 
 // todo - modify the licnesedTo to match mattermost with licenseto
@@ -75,11 +90,99 @@ type CustomerPluginValues struct {
 	HomePageURL string `json:"homePageURL"`
 }
 
+// CustomerNodeValues are the packet values of a single node in a multi-node cluster.
+type CustomerNodeValues struct {
+	NodeID                string `json:"nodeID"`
+	Version               string `json:"version"`
+	BuildHash             string `json:"buildHash"`
+	ServerOS              string `json:"serverOS"`
+	ServerArch            string `json:"serverArch"`
+	DatabaseVersion       string `json:"databaseVersion"`
+	DatabaseSchemaVersion string `json:"databaseSchemaVersion"`
+	WebsocketConnections  int    `json:"websocketConnections"`
+	MasterDBConnections   int    `json:"masterDBConnections"`
+	ReplicaDBConnections  int    `json:"replicaDBConnections"`
+}
+
 type FullCustomerInfo struct {
 	Customer
 	PacketValues CustomerPacketValues   `json:"packet"`
+	PacketFields []PacketField          `json:"packetFields"`
 	Plugins      []CustomerPluginValues `json:"plugins"`
 	Config       model.Config           `json:"config"`
+	Nodes        []CustomerNodeValues   `json:"nodes"`
+
+	// Environments are every server of the customer with its current values, the primary one
+	// first. The values above are the primary environment's.
+	Environments []EnvironmentInfo `json:"environments"`
+}
+
+// SupportPacketUpload holds the parts of an uploaded support packet to be stored. Packet, Config
+// and Plugins are the cluster level values, Nodes is only set for multi-node packets.
+type SupportPacketUpload struct {
+	FileName string
+
+	// UserID, PostID and ChannelID are who uploaded the packet and where, TicketRef is the
+	// support ticket mentioned with it, if any.
+	UserID    string
+	PostID    string
+	ChannelID string
+	TicketRef string
+
+	// UploadedAt is when the packet was posted, the history is ordered by it. Zero means now.
+	UploadedAt int64
+
+	// Backfilled uploads were found in past posts by a backfill job, no summaries are posted for them.
+	Backfilled bool
+
+	// ShareSummary overrides the customer's PostPacketSummaries setting for this upload, when set.
+	ShareSummary *bool
+
+	// ArchiveHash and ContentHash identify re-uploads of the same packet, see GetSnapshotByHash.
+	ArchiveHash string
+	ContentHash string
+
+	// Identity is what the customer is matched by, its identifiers are added to the customer's set.
+	Identity PacketIdentity
+
+	// EnvironmentID is the customer's environment the packet is stored for, resolved from the
+	// Identity when it's empty.
+	EnvironmentID string
+
+	// ArchivePath is where the raw zip was copied to in the file store, empty if it wasn't.
+	ArchivePath string
+	ArchiveSize int64
+
+	Packet   *model.SupportPacket
+	Config   *model.Config
+	Plugins  *model.PluginsResponse
+	Nodes    []CustomerNodeValues
+	Logs     *LogAnalysis
+	Findings []Finding
+}
+
+// PacketSnapshot is a single uploaded support packet for a customer, linking the audit rows of
+// the parts that were stored from it.
+type PacketSnapshot struct {
+	ID             string `json:"id"`
+	CustomerID     string `json:"customerID"`
+	EnvironmentID  string `json:"environmentID"`
+	CreatedAt      int64  `json:"createdAt"`
+	FileName       string `json:"fileName"`
+	PacketAuditID  string `json:"packetAuditID"`
+	ConfigAuditID  string `json:"configAuditID"`
+	PluginsAuditID string `json:"pluginsAuditID"`
+	UserID         string `json:"userID"`
+	PostID         string `json:"postID"`
+	ChannelID      string `json:"channelID"`
+	TicketRef      string `json:"ticketRef"`
+	ArchiveHash    string `json:"archiveHash"`
+	ContentHash    string `json:"contentHash"`
+	ArchivePath    string `json:"-"`
+	ArchiveSize    int64  `json:"archiveSize"`
+
+	// Permalink links back to the post the packet was uploaded in, it isn't stored.
+	Permalink string `json:"permalink" db:"-"`
 }
 
 type CustomerService interface {
@@ -90,17 +193,140 @@ type CustomerService interface {
 	// Get retrieves a customer based on id
 	GetCustomerByID(id string) (FullCustomerInfo, error)
 
+	// CreateCustomer validates and creates a customer entered by a user.
+	CreateCustomer(userID string, customer Customer) (FullCustomerInfo, error)
+
+	// ArchiveCustomer hides the customer from GetCustomers, keeping everything stored for it.
+	ArchiveCustomer(id string, userID string) error
+
+	// RestoreCustomer lists an archived customer again.
+	RestoreCustomer(id string) error
+
+	// DeleteCustomer permanently deletes the customer, its history and archived packets.
+	DeleteCustomer(id string) error
+
+	// MergeCustomers merges a duplicate customer into the target, or previews the merge for dry runs.
+	MergeCustomers(targetID string, userID string, opts MergeOptions) (MergeResult, error)
+
+	// GetEnvironments returns the customer's environments, the primary one first.
+	GetEnvironments(customerID string) ([]Environment, error)
+
+	// CreateEnvironment validates and adds an environment entered by a user to the customer.
+	CreateEnvironment(customerID string, userID string, environment Environment) (Environment, error)
+
+	// UpdateEnvironment validates and updates the customer's environment, making it the primary
+	// one if asked to.
+	UpdateEnvironment(customerID string, environment Environment) (Environment, error)
+
 	// Checks to see if a customer exists based on the siteURL and licensedTo
 	GetCustomerID(siteURL string, licensedTo string) (id string, err error)
 
-	// This monitors the posts for a support packet and triggers actions based on that
+	// This monitors the posts for a support packet and queues them for ingestion
 	MessageHasBeenPosted(post *model.Post)
 
+	// StartIngestionWorker starts processing the ingestion queue in the background.
+	StartIngestionWorker()
+
+	// StopIngestionWorker stops the ingestion worker, waiting for the jobs in progress to finish.
+	StopIngestionWorker()
+
+	// GetIngestionJobs returns the ingestion jobs with the given status, or all of them if it's empty.
+	GetIngestionJobs(status IngestionJobStatus) ([]IngestionJob, error)
+
+	// RetryIngestionJob queues a job to be processed again right away.
+	RetryIngestionJob(id string) error
+
+	// PurgeIngestionJobs deletes the ingestion jobs with the given status.
+	PurgeIngestionJobs(status IngestionJobStatus) (int64, error)
+
+	// StartBackfill queues a job ingesting the support packets posted in the channels during the
+	// time range, oldest first.
+	StartBackfill(userID string, opts BackfillOptions) (BackfillJob, error)
+
+	// GetBackfillJobs returns the backfill jobs with their progress, newest first.
+	GetBackfillJobs() ([]BackfillJob, error)
+
+	// GetBackfillJob returns the backfill job with its progress.
+	GetBackfillJob(id string) (BackfillJob, error)
+
+	// GetBackfillItems returns the attachments found by the backfill job with the given status, or all of them if it's empty.
+	GetBackfillItems(jobID string, status IngestionJobStatus) ([]BackfillItem, error)
+
+	// CancelBackfill stops a backfill job, the packets ingested so far are kept.
+	CancelBackfill(id string) error
+
 	GetPacket(customerID string) (CustomerPacketValues, error)
 	// StorePacket(updateId string, packet CustomerPacketValues) error
 
+	// GetPacketFields returns every packet field with its effective value, the value stored from
+	// packets and the manual pin in effect, if any.
+	GetPacketFields(customerID string) ([]PacketField, error)
+
+	// UpdatePacketFields pins the edited fields, the ones that differ from the current values,
+	// until expiresAt, or for good if it's zero.
+	UpdatePacketFields(customerID string, userID string, fields map[string]json.RawMessage, expiresAt int64) error
+
+	// PinPacketField pins the value of a field until expiresAt, or for good if it's zero.
+	PinPacketField(customerID string, userID string, field string, value json.RawMessage, expiresAt int64) error
+
+	// UnpinPacketField removes the pin of a field, the value stored from packets is used again.
+	UnpinPacketField(customerID string, userID string, field string) error
+
 	GetConfig(customerID string) (model.Config, error)
 	GetPlugins(customerID string) ([]CustomerPluginValues, error)
+	GetNodes(customerID string) ([]CustomerNodeValues, error)
+
+	// GetSnapshots returns the packets uploaded for the customer, newest first, with permalinks
+	// back to the posts they were uploaded in.
+	GetSnapshots(customerID string) ([]PacketSnapshot, error)
+
+	// GetLogEntries returns the aggregated log entries stored with a snapshot of the customer,
+	// most frequent first.
+	GetLogEntries(customerID string, snapshotID string) ([]LogEntrySummary, error)
+
+	// GetFindings returns the findings stored with a snapshot of the customer.
+	GetFindings(customerID string, snapshotID string) ([]Finding, error)
+
+	// GetFindingRuleSets returns every uploaded version of the custom findings rules, newest first.
+	GetFindingRuleSets() ([]FindingRuleSet, error)
+
+	// UploadFindingRules validates a YAML or JSON rule file and stores it as the next version.
+	UploadFindingRules(userID string, data []byte) (FindingRuleSet, error)
+
+	// GetPacketArchive opens the raw zip archived for a snapshot of the customer.
+	GetPacketArchive(customerID string, snapshotID string) (io.ReadSeekCloser, PacketSnapshot, error)
+
+	// PurgeExpiredPacketArchives deletes the archived zips that fall outside of the retention settings.
+	PurgeExpiredPacketArchives()
+
+	// RunFindings evaluates the built in and latest custom rules against the customer's stored values.
+	RunFindings(customerID string) ([]Finding, error)
+
+	// GetCustomerIdentifiers returns the identifiers the customer is known by.
+	GetCustomerIdentifiers(customerID string) ([]CustomerIdentifier, error)
+
+	// AddCustomerIdentifier validates and adds an identifier to the customer's set.
+	AddCustomerIdentifier(customerID string, userID string, identifierType IdentifierType, value string) (CustomerIdentifier, error)
+
+	// RemoveCustomerIdentifier removes an identifier from the customer's set.
+	RemoveCustomerIdentifier(customerID string, identifierType IdentifierType, value string) error
+
+	// GetPendingUploadChannel returns the channel the prompt asking for a pending upload's customer
+	// was posted in, or ErrNotFound if the upload is no longer pending.
+	GetPendingUploadChannel(pendingID string) (string, error)
+
+	// ResolvePendingUpload stores a pending upload for the chosen customer, or a new customer if customerID is empty.
+	ResolvePendingUpload(pendingID string, userID string, customerID string) error
+
+	// UploadSupportPacket ingests a support packet zip uploaded through the API for the given
+	// customer, or the matching one if no customer ID is given.
+	UploadSupportPacket(userID string, fileName string, data io.Reader, opts PacketUploadOptions) (PacketUploadResult, error)
+
+	// OpenPendingUploadDialog opens the customer picker for a pending upload sent to the bot, only for the uploader.
+	OpenPendingUploadDialog(pendingID string, userID string, triggerID string) error
+
+	// SubmitPendingUploadDialog stores a pending upload for the customer the uploader picked in the dialog.
+	SubmitPendingUploadDialog(pendingID string, userID string, submission PendingUploadSubmission) error
 
 	UpdateCustomer(customer Customer) error
 	UpdateCustomerData(customerID string, userID string, packet *CustomerPacketValues, config *model.Config, plugins []CustomerPluginValues) error
@@ -112,19 +338,153 @@ type CustomerStore interface {
 	// GetCustomers returns filtered customers and the total count before paging.
 	GetCustomerByID(id string) (FullCustomerInfo, error)
 
-	// Checks to see if a customer exists based on the siteURL and licensedTo
+	// Checks to see if a customer exists based on the siteURL and licensedTo, returning
+	// ErrAmbiguousCustomer when several match and none clearly.
 	GetCustomerID(siteURL string, licensedTo string) (id string, err error)
 
+	// MatchCustomers returns the customers sharing any identifier with the packet, scored and
+	// sorted by SortCustomerMatches.
+	MatchCustomers(identity PacketIdentity) ([]CustomerMatch, error)
+
+	// GetCustomerIdentifiers returns the identifiers the customer is known by.
+	GetCustomerIdentifiers(customerID string) ([]CustomerIdentifier, error)
+
+	// GetEnvironments returns the customer's environments, the primary one first.
+	GetEnvironments(customerID string) ([]Environment, error)
+
+	// GetEnvironment returns the environment, or ErrNotFound.
+	GetEnvironment(id string) (Environment, error)
+
+	// CreateEnvironment adds the environment to its customer, as the primary one if the customer
+	// has none yet, returning its ID.
+	CreateEnvironment(environment Environment) (string, error)
+
+	// UpdateEnvironment updates the environment's name, type and site URL. Making it primary
+	// makes the customer's other environments secondary.
+	UpdateEnvironment(environment Environment) error
+
+	// ResolveEnvironment returns the customer's environment the packet matches by
+	// MatchEnvironment, learning its telemetry ID, or creates one by NewPacketEnvironment.
+	ResolveEnvironment(customerID string, userID string, identity PacketIdentity) (Environment, error)
+
+	// GetEnvironmentInfo returns the environment with its current values as stored from packets.
+	GetEnvironmentInfo(environmentID string) (EnvironmentInfo, error)
+
+	// AddCustomerIdentifier adds the identifier to the customer's set, doing nothing if it's already in it.
+	AddCustomerIdentifier(identifier CustomerIdentifier) error
+
+	// RemoveCustomerIdentifier removes the identifier from the customer's set, or returns ErrNotFound.
+	RemoveCustomerIdentifier(customerID string, identifierType IdentifierType, value string) error
+
+	// CreateCustomer creates the customer, named after its license holder or site URL when it has
+	// no name, along with its primary environment, returning its ID.
+	CreateCustomer(customer Customer) (string, error)
+
+	// SetCustomerArchived archives the customer at archivedAt, or restores it if it's zero. It
+	// returns ErrNotFound if the customer doesn't exist.
+	SetCustomerArchived(id string, userID string, archivedAt int64) error
+
+	// DeleteCustomer permanently deletes the customer with everything stored for it, or returns
+	// ErrNotFound.
+	DeleteCustomer(id string) error
+
+	// CountCustomerRows counts the rows stored for the customer by table.
+	CountCustomerRows(id string) (map[string]int64, error)
+
+	// MergeCustomers moves everything stored for the source customer to the merged one, which
+	// takes its profile, adds the source's site URL and license holder to the merged customer's
+	// identifiers and deletes the source. The source's environments are merged into the ones of
+	// the same servers, the others become secondary environments. It returns the rows moved by table.
+	MergeCustomers(merged Customer, source Customer, userID string) (map[string]int64, error)
+
+	// CreatePendingUpload stores an upload waiting for a customer to be chosen, returning its ID.
+	CreatePendingUpload(pending PendingUpload) (string, error)
+
+	// SetPendingUploadPrompt records the post asking which customer the pending upload is for.
+	SetPendingUploadPrompt(id string, promptPostID string) error
+
+	// GetPendingUpload returns the pending upload, or ErrNotFound.
+	GetPendingUpload(id string) (PendingUpload, error)
+
+	// GetPendingUploadForPacket returns the pending upload of the zip with the archive hash
+	// attached to the post, or ErrNotFound.
+	GetPendingUploadForPacket(postID string, archiveHash string) (PendingUpload, error)
+
+	// ClaimPendingUpload marks the pending upload as being stored by the user and returns it, or
+	// ErrNotFound if it doesn't exist or someone else claimed it already.
+	ClaimPendingUpload(id string, userID string) (PendingUpload, error)
+
+	// ReleasePendingUpload clears the claim on the pending upload so it can be claimed again.
+	ReleasePendingUpload(id string) error
+
+	// DeletePendingUpload deletes the pending upload once it's been stored.
+	DeletePendingUpload(id string) error
+
+	// GetPacket returns the current packet values of the customer's primary environment, with the
+	// pinned ones in place of the values stored from packets.
 	GetPacket(customerID string) (CustomerPacketValues, error)
 	// StorePacket(updateId string, packet CustomerPacketValues) error
 
+	// GetStoredPacket returns the current packet values of the customer's primary environment as
+	// stored from packets, without the pins, along with their sources.
+	GetStoredPacket(customerID string) (CustomerPacketValues, PacketSources, error)
+
+	// GetPacketFields returns every packet field with its effective and stored value.
+	GetPacketFields(customerID string) ([]PacketField, error)
+
+	// PinPacketFields pins the values of the fields for the customer, replacing the existing pins
+	// of those fields.
+	PinPacketFields(customerID string, userID string, fields map[string]json.RawMessage, expiresAt int64) error
+
+	// UnpinPacketField removes the pin of the field, or returns ErrNotFound.
+	UnpinPacketField(customerID string, userID string, field string) error
+
+	// GetConfig, GetPlugins and GetNodes return the current values of the customer's primary environment.
 	GetConfig(customerID string) (model.Config, error)
 	GetPlugins(customerID string) ([]CustomerPluginValues, error)
+	GetNodes(customerID string) ([]CustomerNodeValues, error)
+
+	// GetSnapshots returns the packets uploaded for the customer, of every environment, newest first.
+	GetSnapshots(customerID string) ([]PacketSnapshot, error)
+
+	// GetSnapshotByHash returns the first snapshot stored with either hash, or ErrNotFound.
+	GetSnapshotByHash(archiveHash string, contentHash string) (PacketSnapshot, error)
+
+	// GetSnapshot returns the snapshot, or ErrNotFound.
+	GetSnapshot(snapshotID string) (PacketSnapshot, error)
+
+	// GetArchivedSnapshots returns the snapshots whose raw zip is still in the file store,
+	// grouped by customer with the newest first.
+	GetArchivedSnapshots() ([]PacketSnapshot, error)
+
+	// ClearSnapshotArchive unlinks the raw zip from the snapshot once it has been deleted.
+	ClearSnapshotArchive(snapshotID string) error
+
+	// GetLogEntries returns the aggregated log entries stored with a snapshot, most frequent first.
+	GetLogEntries(snapshotID string) ([]LogEntrySummary, error)
+
+	// GetFindings returns the findings stored with a snapshot.
+	GetFindings(snapshotID string) ([]Finding, error)
+
+	// GetFindingRuleSets returns every version of the custom findings rules, newest first.
+	GetFindingRuleSets() ([]FindingRuleSet, error)
+
+	// GetLatestFindingRuleSet returns the newest version of the custom findings rules, or
+	// ErrNotFound if none have been uploaded.
+	GetLatestFindingRuleSet() (FindingRuleSet, error)
+
+	// CreateFindingRuleSet stores the rules as the next version.
+	CreateFindingRuleSet(userID string, rules []FindingRuleDefinition) (FindingRuleSet, error)
 
 	UpdateCustomer(customer Customer) error
+
+	// UpdateCustomerData stores the values edited by a user for the primary environment. The packet
+	// values that differ from the current ones are pinned, so they aren't replaced by the next packet.
 	UpdateCustomerData(customerID string, userID string, packet *CustomerPacketValues, config *model.Config, plugins []CustomerPluginValues) error
 
-	UpdateCustomerThroughUpload(customerID string, packet *model.SupportPacket, config *model.Config, plugins *model.PluginsResponse) error
+	// UpdateCustomerThroughUpload stores the uploaded packet as the current values of its
+	// environment, resolving it if the upload has none, returning the ID of the snapshot created.
+	UpdateCustomerThroughUpload(customerID string, upload *SupportPacketUpload) (string, error)
 }
 
 type GetCustomersResult struct {
@@ -139,6 +499,9 @@ type CustomerFilterOptions struct {
 	Direction  SortDirection
 	SearchTerm string
 
+	// IncludeArchived lists the archived customers along with the others.
+	IncludeArchived bool
+
 	// Pagination options.
 	Page    int
 	PerPage int
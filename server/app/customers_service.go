@@ -1,23 +1,34 @@
 package app
 
 import (
+	"strings"
+
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/bot"
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/config"
 	"github.com/mattermost/mattermost/server/public/model"
 	pluginapi "github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
 )
 
 type customerService struct {
 	store  CustomerStore
+	jobs   IngestionJobStore
 	poster bot.Poster
 	api    *pluginapi.Client
+	config config.Service
+
+	// worker is set while the ingestion worker is running.
+	worker *ingestionWorker
 }
 
 // NewCustomerService returns a new customer service
-func NewCustomerService(store CustomerStore, poster bot.Poster, api *pluginapi.Client) CustomerService {
+func NewCustomerService(store CustomerStore, jobs IngestionJobStore, poster bot.Poster, api *pluginapi.Client, configService config.Service) CustomerService {
 	return &customerService{
 		store:  store,
+		jobs:   jobs,
 		poster: poster,
 		api:    api,
+		config: configService,
 	}
 }
 
@@ -33,8 +44,8 @@ func (s *customerService) UpdateCustomerData(customerID string, userID string, p
 	return s.store.UpdateCustomerData(customerID, userID, packet, config, plugins)
 }
 
-func (s *customerService) UpdateCustomerThroughUpload(customerID string, packet *model.SupportPacket, config *model.Config, plugins *model.PluginsResponse) error {
-	return s.store.UpdateCustomerThroughUpload(customerID, packet, config, plugins)
+func (s *customerService) UpdateCustomerThroughUpload(customerID string, upload *SupportPacketUpload) (string, error) {
+	return s.store.UpdateCustomerThroughUpload(customerID, upload)
 }
 
 func (s *customerService) GetCustomerByID(id string) (FullCustomerInfo, error) {
@@ -56,3 +67,121 @@ func (s *customerService) GetConfig(customerID string) (model.Config, error) {
 func (s *customerService) GetPlugins(customerID string) ([]CustomerPluginValues, error) {
 	return s.store.GetPlugins(customerID)
 }
+
+func (s *customerService) GetNodes(customerID string) ([]CustomerNodeValues, error) {
+	return s.store.GetNodes(customerID)
+}
+
+func (s *customerService) GetSnapshots(customerID string) ([]PacketSnapshot, error) {
+	snapshots, err := s.store.GetSnapshots(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return withPermalinks(s, snapshots), nil
+}
+
+func (s *customerService) GetLogEntries(customerID string, snapshotID string) ([]LogEntrySummary, error) {
+	if _, err := getCustomerSnapshot(s, customerID, snapshotID); err != nil {
+		return nil, err
+	}
+
+	return s.store.GetLogEntries(snapshotID)
+}
+
+// getCustomerSnapshot returns the snapshot, or ErrNotFound if it belongs to another customer.
+func getCustomerSnapshot(s *customerService, customerID string, snapshotID string) (PacketSnapshot, error) {
+	snapshot, err := s.store.GetSnapshot(snapshotID)
+	if err != nil {
+		return PacketSnapshot{}, err
+	}
+
+	if snapshot.CustomerID != customerID {
+		return PacketSnapshot{}, errors.Wrapf(ErrNotFound, "snapshot '%s' does not belong to customer '%s'", snapshotID, customerID)
+	}
+
+	return snapshot, nil
+}
+
+func (s *customerService) GetFindings(customerID string, snapshotID string) ([]Finding, error) {
+	if _, err := getCustomerSnapshot(s, customerID, snapshotID); err != nil {
+		return nil, err
+	}
+
+	return s.store.GetFindings(snapshotID)
+}
+
+func (s *customerService) GetFindingRuleSets() ([]FindingRuleSet, error) {
+	return s.store.GetFindingRuleSets()
+}
+
+func (s *customerService) UploadFindingRules(userID string, data []byte) (FindingRuleSet, error) {
+	rules, err := ParseFindingRules(data)
+	if err != nil {
+		return FindingRuleSet{}, err
+	}
+
+	return s.store.CreateFindingRuleSet(userID, rules)
+}
+
+func (s *customerService) RunFindings(customerID string) ([]Finding, error) {
+	customer, err := s.store.GetCustomerByID(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	config := customer.Config
+	packet := customer.PacketValues
+	input := &FindingInput{
+		Packet:  &packet,
+		Config:  &config,
+		Plugins: customer.Plugins,
+		Nodes:   customer.Nodes,
+	}
+
+	return EvaluateFindings(input, currentFindingRules(s)), nil
+}
+
+func (s *customerService) GetIngestionJobs(status IngestionJobStatus) ([]IngestionJob, error) {
+	return s.jobs.GetIngestionJobs(status)
+}
+
+func (s *customerService) RetryIngestionJob(id string) error {
+	return s.jobs.RetryIngestionJob(id)
+}
+
+func (s *customerService) PurgeIngestionJobs(status IngestionJobStatus) (int64, error) {
+	return s.jobs.PurgeIngestionJobs(status)
+}
+
+func (s *customerService) GetCustomerIdentifiers(customerID string) ([]CustomerIdentifier, error) {
+	return s.store.GetCustomerIdentifiers(customerID)
+}
+
+func (s *customerService) AddCustomerIdentifier(customerID string, userID string, identifierType IdentifierType, value string) (CustomerIdentifier, error) {
+	if err := ValidateCustomerIdentifier(identifierType, value); err != nil {
+		return CustomerIdentifier{}, err
+	}
+
+	if _, err := s.store.GetCustomerByID(customerID); err != nil {
+		return CustomerIdentifier{}, err
+	}
+
+	identifier := CustomerIdentifier{
+		CustomerID: customerID,
+		Type:       identifierType,
+		Value:      strings.TrimSpace(value),
+		Source:     IdentifierSourceAdmin,
+		CreatedAt:  model.GetMillis(),
+		CreatedBy:  userID,
+	}
+	if err := s.store.AddCustomerIdentifier(identifier); err != nil {
+		return CustomerIdentifier{}, err
+	}
+
+	return identifier, nil
+}
+
+func (s *customerService) RemoveCustomerIdentifier(customerID string, identifierType IdentifierType, value string) error {
+	return s.store.RemoveCustomerIdentifier(customerID, identifierType, value)
+}
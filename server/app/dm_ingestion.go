@@ -0,0 +1,251 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxDialogCustomers caps the customers listed in the picker, the candidates always are.
+	// Dynamic selects aren't supported by the server version the plugin targets, so the dialog
+	// says when the list was cut short.
+	maxDialogCustomers = 250
+
+	// newCustomerOption is the picker value creating a new customer, dialog selects can't be empty.
+	newCustomerOption = "new"
+
+	dialogCustomerField     = "customer_id"
+	dialogTicketField       = "ticket"
+	dialogShareSummaryField = "share_summary"
+)
+
+// PendingUploadSubmission is what the uploader chose in the customer picker dialog.
+type PendingUploadSubmission struct {
+	// CustomerID is empty to create a new customer.
+	CustomerID   string
+	TicketRef    string
+	ShareSummary bool
+}
+
+// ParsePendingUploadSubmission reads the values submitted with the customer picker dialog.
+func ParsePendingUploadSubmission(submission map[string]interface{}) PendingUploadSubmission {
+	var parsed PendingUploadSubmission
+
+	parsed.CustomerID, _ = submission[dialogCustomerField].(string)
+	if parsed.CustomerID == newCustomerOption {
+		parsed.CustomerID = ""
+	}
+	parsed.TicketRef, _ = submission[dialogTicketField].(string)
+	parsed.ShareSummary, _ = submission[dialogShareSummaryField].(bool)
+
+	return parsed
+}
+
+// isBotDirectMessage returns true if the channel is a direct message between someone and the bot.
+func isBotDirectMessage(s *customerService, channelID string) bool {
+	channel, err := s.api.Channel.Get(channelID)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_id", channelID).Warn("Failed to get channel of the support packet post.")
+		return false
+	}
+
+	return isDirectMessageWith(channel, s.config.GetConfiguration().BotUserID)
+}
+
+// queueDirectMessageUpload stores an upload sent to the bot in a direct message and asks the
+// uploader to choose the customer in a dialog, instead of matching one automatically.
+func queueDirectMessageUpload(s *customerService, packetFile *supportPacketFile, upload *SupportPacketUpload, summary string, candidates []CustomerMatch) error {
+	if len(candidates) > maxPendingCandidates {
+		candidates = candidates[:maxPendingCandidates]
+	}
+
+	pending, err := storePendingUpload(s, packetFile, upload, summary, candidates)
+	if err != nil {
+		return err
+	}
+	if pending.PromptPostID != "" {
+		// an earlier attempt already asked
+		return nil
+	}
+
+	prompt := &model.Post{}
+	model.ParseSlackAttachment(prompt, []*model.SlackAttachment{{
+		Title: "Which customer is this support packet for?",
+		Text:  fmt.Sprintf("Support packet `%s` has site URL `%s` and is licensed to `%s`. Choose the customer it belongs to, it's only stored once you do.", upload.FileName, upload.Identity.SiteURL, upload.Identity.LicensedTo),
+		Actions: []*model.PostAction{{
+			Type:  model.PostActionTypeButton,
+			Name:  "Choose customer",
+			Style: "primary",
+			Integration: &model.PostActionIntegration{
+				URL: fmt.Sprintf("/plugins/%s/api/v0/ingestion/pending/%s/dialog", s.config.GetManifest().Id, pending.ID),
+			},
+		}},
+	}})
+
+	if err = s.poster.DM(upload.UserID, prompt); err != nil {
+		return errors.Wrap(err, "failed to send customer prompt")
+	}
+
+	if err = s.store.SetPendingUploadPrompt(pending.ID, prompt.Id); err != nil {
+		logrus.WithError(err).WithField("pending_id", pending.ID).Warn("Failed to save customer prompt post.")
+	}
+
+	return nil
+}
+
+// getUploaderPendingUpload returns the pending upload, or ErrNotFound if someone other than the
+// uploader asks for it.
+func getUploaderPendingUpload(s *customerService, pendingID string, userID string) (PendingUpload, error) {
+	pending, err := s.store.GetPendingUpload(pendingID)
+	if err != nil {
+		return PendingUpload{}, err
+	}
+	if pending.Upload.UserID != userID {
+		return PendingUpload{}, errors.Wrapf(ErrNotFound, "user '%s' did not upload pending upload '%s'", userID, pendingID)
+	}
+
+	return pending, nil
+}
+
+// OpenPendingUploadDialog opens the customer picker for a pending upload on the uploader's client.
+func (s *customerService) OpenPendingUploadDialog(pendingID string, userID string, triggerID string) error {
+	pending, err := getUploaderPendingUpload(s, pendingID, userID)
+	if err != nil {
+		return err
+	}
+
+	options, best, truncated, err := pendingCustomerOptions(s, pending.CandidateIDs)
+	if err != nil {
+		return err
+	}
+
+	dialog := pendingUploadDialog(pending, options, best, truncated)
+	err = s.api.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       fmt.Sprintf("/plugins/%s/api/v0/ingestion/pending/%s/submit", s.config.GetManifest().Id, pendingID),
+		Dialog:    dialog,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to open customer dialog")
+	}
+
+	return nil
+}
+
+// SubmitPendingUploadDialog stores the pending upload for the customer chosen in the dialog.
+func (s *customerService) SubmitPendingUploadDialog(pendingID string, userID string, submission PendingUploadSubmission) error {
+	if _, err := getUploaderPendingUpload(s, pendingID, userID); err != nil {
+		return err
+	}
+
+	if submission.CustomerID != "" {
+		if _, err := s.store.GetCustomerByID(submission.CustomerID); err != nil {
+			return err
+		}
+	}
+
+	return applyPendingUpload(s, pendingID, userID, submission.CustomerID, func(upload *SupportPacketUpload) {
+		upload.TicketRef = submission.TicketRef
+		upload.ShareSummary = model.NewBool(submission.ShareSummary)
+	})
+}
+
+// pendingCustomerOptions lists the customers to pick from, the candidates first, then the rest by
+// name. The most confident candidate is returned as well, or nil if there is none, and whether
+// there were more customers than could be listed.
+func pendingCustomerOptions(s *customerService, candidateIDs []string) ([]*model.PostActionOptions, *Customer, bool, error) {
+	result, err := s.store.GetCustomers(CustomerFilterOptions{
+		Sort:      SortByName,
+		Direction: DirectionAsc,
+		PerPage:   maxDialogCustomers,
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	listed := make(map[string]Customer)
+	for _, customer := range result.Customers {
+		listed[customer.ID] = customer
+	}
+
+	var options []*model.PostActionOptions
+	var best *Customer
+	for _, candidateID := range candidateIDs {
+		candidate, ok := listed[candidateID]
+		if !ok {
+			fullCandidate, err := s.store.GetCustomerByID(candidateID)
+			if err != nil {
+				logrus.WithError(err).WithField("customer_id", candidateID).Warn("Failed to get candidate customer.")
+				continue
+			}
+			candidate = fullCandidate.Customer
+		}
+		if best == nil {
+			best = &candidate
+		}
+		options = append(options, &model.PostActionOptions{Text: candidate.Name + " (suggested)", Value: candidate.ID})
+	}
+
+	for _, customer := range result.Customers {
+		if !containsString(candidateIDs, customer.ID) {
+			options = append(options, &model.PostActionOptions{Text: customer.Name, Value: customer.ID})
+		}
+	}
+	options = append(options, &model.PostActionOptions{Text: "Create a new customer", Value: newCustomerOption})
+
+	return options, best, result.TotalCount > len(result.Customers), nil
+}
+
+// pendingUploadDialog is the customer picker, preselecting the most confident candidate. When not
+// every customer could be listed, it says so and how to add the packet to one that's missing.
+func pendingUploadDialog(pending PendingUpload, options []*model.PostActionOptions, best *Customer, truncated bool) model.Dialog {
+	customerDefault := newCustomerOption
+	shareDefault := "false"
+	if best != nil {
+		customerDefault = best.ID
+		shareDefault = fmt.Sprintf("%t", best.PostPacketSummaries)
+	}
+
+	customerHelp := "Suggested customers share identifiers with the packet."
+	if truncated {
+		customerHelp += fmt.Sprintf(" Only the first %d customers by name are listed, if yours isn't create a new customer and merge it into yours afterwards.", maxDialogCustomers)
+	}
+
+	return model.Dialog{
+		CallbackId:       pending.ID,
+		Title:            "Add Support Packet",
+		IntroductionText: fmt.Sprintf("Support packet `%s` has site URL `%s` and is licensed to `%s`.", pending.Upload.FileName, pending.Upload.Identity.SiteURL, pending.Upload.Identity.LicensedTo),
+		SubmitLabel:      "Add",
+		Elements: []model.DialogElement{
+			{
+				DisplayName: "Customer",
+				Name:        dialogCustomerField,
+				Type:        "select",
+				Default:     customerDefault,
+				Placeholder: "Search for a customer",
+				HelpText:    customerHelp,
+				Options:     options,
+			},
+			{
+				DisplayName: "Ticket",
+				Name:        dialogTicketField,
+				Type:        "text",
+				Default:     pending.Upload.TicketRef,
+				Placeholder: "Ticket number",
+				Optional:    true,
+				MaxLength:   100,
+			},
+			{
+				DisplayName: "Share to customer channel",
+				Name:        dialogShareSummaryField,
+				Type:        "bool",
+				Default:     shareDefault,
+				Placeholder: "Post a summary with the findings and changes to the customer channel",
+				Optional:    true,
+			},
+		},
+	}
+}
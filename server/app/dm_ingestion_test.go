@@ -0,0 +1,47 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePendingUploadSubmission(t *testing.T) {
+	require.Equal(t, PendingUploadSubmission{CustomerID: "customer1", TicketRef: "12345", ShareSummary: true}, ParsePendingUploadSubmission(map[string]interface{}{
+		dialogCustomerField:     "customer1",
+		dialogTicketField:       "12345",
+		dialogShareSummaryField: true,
+	}))
+
+	// optional fields left out aren't submitted
+	require.Equal(t, PendingUploadSubmission{}, ParsePendingUploadSubmission(map[string]interface{}{
+		dialogCustomerField: newCustomerOption,
+	}))
+}
+
+func TestPendingUploadDialog(t *testing.T) {
+	pending := PendingUpload{
+		ID: "pending1",
+		Upload: &SupportPacketUpload{
+			FileName:  "packet.zip",
+			TicketRef: "12345",
+			Identity:  PacketIdentity{SiteURL: "https://a.test", LicensedTo: "Acme"},
+		},
+	}
+	options := []*model.PostActionOptions{{Text: "Acme (suggested)", Value: "customer1"}, {Text: "Create a new customer", Value: newCustomerOption}}
+
+	dialog := pendingUploadDialog(pending, options, &Customer{ID: "customer1", PostPacketSummaries: true}, false)
+	require.Equal(t, "pending1", dialog.CallbackId)
+	require.Len(t, dialog.Elements, 3)
+	require.Equal(t, "customer1", dialog.Elements[0].Default)
+	require.Equal(t, options, dialog.Elements[0].Options)
+	require.Equal(t, "12345", dialog.Elements[1].Default)
+	require.Equal(t, "true", dialog.Elements[2].Default)
+	require.NotContains(t, dialog.Elements[0].HelpText, "Only the first")
+
+	dialog = pendingUploadDialog(pending, options, nil, true)
+	require.Equal(t, newCustomerOption, dialog.Elements[0].Default)
+	require.Equal(t, "false", dialog.Elements[2].Default)
+	require.Contains(t, dialog.Elements[0].HelpText, "Only the first 250 customers")
+}
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// EnvironmentType is what a customer runs a server for.
+type EnvironmentType string
+
+const (
+	EnvironmentProduction  EnvironmentType = "production"
+	EnvironmentStaging     EnvironmentType = "staging"
+	EnvironmentDevelopment EnvironmentType = "development"
+	// EnvironmentDisasterRecovery servers stand by to take over from production.
+	EnvironmentDisasterRecovery EnvironmentType = "dr"
+	EnvironmentOther            EnvironmentType = "other"
+)
+
+var environmentTypes = []EnvironmentType{EnvironmentProduction, EnvironmentStaging, EnvironmentDevelopment, EnvironmentDisasterRecovery, EnvironmentOther}
+
+// environmentHostHints are the words in a site URL's host that give away what a server created
+// from a packet is for, checked in order.
+var environmentHostHints = []struct {
+	words           []string
+	environmentType EnvironmentType
+}{
+	{[]string{"staging", "stage", "stg", "uat", "qa", "test", "preprod"}, EnvironmentStaging},
+	{[]string{"dev", "sandbox", "lab"}, EnvironmentDevelopment},
+	{[]string{"dr", "failover", "backup", "standby"}, EnvironmentDisasterRecovery},
+}
+
+// Environment is one of a customer's servers, like their production, staging or DR ones. Every
+// packet is stored for an environment, and each one has its own current values.
+type Environment struct {
+	ID         string          `json:"id"`
+	CustomerID string          `json:"customerID"`
+	Name       string          `json:"name"`
+	Type       EnvironmentType `json:"type"`
+	SiteURL    string          `json:"siteURL"`
+
+	// TelemetryID is the server_id of the environment's packets, it keeps matching them when the
+	// site URL changes.
+	TelemetryID string `json:"telemetryID"`
+
+	// Primary is the environment the customer's own values come from, like its site URL and
+	// packet fields. Every customer has exactly one.
+	Primary bool `json:"primary" db:"isprimary"`
+
+	CreatedAt int64  `json:"createdAt"`
+	CreatedBy string `json:"createdBy"`
+}
+
+// EnvironmentInfo is an environment with its current values, as stored from its latest packets.
+type EnvironmentInfo struct {
+	Environment
+	PacketValues CustomerPacketValues   `json:"packet"`
+	Plugins      []CustomerPluginValues `json:"plugins"`
+	Config       model.Config           `json:"config"`
+	Nodes        []CustomerNodeValues   `json:"nodes"`
+}
+
+// isSiteURL returns whether the value is an http or https URL with a host.
+func isSiteURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// sameSiteURL compares site URLs the way servers report them, ignoring case and a trailing slash.
+func sameSiteURL(a string, b string) bool {
+	return a != "" && strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
+
+// validateEnvironment trims the values of an environment entered by a user and checks them,
+// defaulting to a production environment.
+func validateEnvironment(environment *Environment) error {
+	environment.Name = strings.TrimSpace(environment.Name)
+	environment.SiteURL = strings.TrimSpace(environment.SiteURL)
+
+	if environment.Name == "" {
+		return errors.Wrap(ErrInvalidEnvironment, "name cannot be empty")
+	}
+
+	if environment.SiteURL != "" && !isSiteURL(environment.SiteURL) {
+		return errors.Wrapf(ErrInvalidEnvironment, "site URL '%s' must be an http or https URL", environment.SiteURL)
+	}
+
+	if environment.Type == "" {
+		environment.Type = EnvironmentProduction
+	}
+	for _, environmentType := range environmentTypes {
+		if environment.Type == environmentType {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrInvalidEnvironment, "type '%s' must be one of '%s', '%s', '%s', '%s' or '%s'", environment.Type, EnvironmentProduction, EnvironmentStaging, EnvironmentDevelopment, EnvironmentDisasterRecovery, EnvironmentOther)
+}
+
+// MatchEnvironment picks the customer's environment a packet was generated on out of the
+// environments, which must have the primary one first: the one with its telemetry ID, then the
+// one with its site URL. Failing both, an environment nothing is known about yet, like the one
+// created with a customer that had no site URL, takes the packet. Packets with neither go to the
+// primary environment. Returns false when a new environment is needed.
+func MatchEnvironment(environments []Environment, identity PacketIdentity) (Environment, bool) {
+	if len(environments) == 0 {
+		return Environment{}, false
+	}
+	if identity.TelemetryID == "" && identity.SiteURL == "" {
+		return environments[0], true
+	}
+
+	if identity.TelemetryID != "" {
+		for _, environment := range environments {
+			if environment.TelemetryID == identity.TelemetryID {
+				return environment, true
+			}
+		}
+	}
+
+	for _, environment := range environments {
+		if sameSiteURL(environment.SiteURL, identity.SiteURL) {
+			return environment, true
+		}
+	}
+
+	for _, environment := range environments {
+		if environment.SiteURL == "" && environment.TelemetryID == "" {
+			return environment, true
+		}
+	}
+
+	return Environment{}, false
+}
+
+// NewPacketEnvironment returns the environment to create for a packet no environment of the
+// customer matches, named after its site URL's host and typed by the words in it. The customer's
+// first environment is its primary one.
+func NewPacketEnvironment(customerID string, environments []Environment, identity PacketIdentity) Environment {
+	environment := Environment{
+		CustomerID:  customerID,
+		Name:        "Unnamed server",
+		Type:        EnvironmentProduction,
+		SiteURL:     identity.SiteURL,
+		TelemetryID: identity.TelemetryID,
+		Primary:     len(environments) == 0,
+	}
+
+	parsed, err := url.Parse(identity.SiteURL)
+	if err != nil || parsed.Hostname() == "" {
+		return environment
+	}
+	host := strings.ToLower(parsed.Hostname())
+	environment.Name = host
+
+	// the words of the host, so "dr" doesn't match "drive"
+	words := strings.FieldsFunc(host, func(r rune) bool {
+		return r == '.' || r == '-' || r == '_'
+	})
+	for _, hint := range environmentHostHints {
+		for _, word := range words {
+			if containsString(hint.words, word) {
+				environment.Type = hint.environmentType
+				return environment
+			}
+		}
+	}
+
+	// a second production server is more likely something nobody told us about
+	for _, existing := range environments {
+		if existing.Type == EnvironmentProduction {
+			environment.Type = EnvironmentOther
+		}
+	}
+
+	return environment
+}
+
+// resolveUploadEnvironment sets the environment the upload is stored for, unless it was chosen
+// already, creating one if the customer has none matching the packet.
+func resolveUploadEnvironment(s *customerService, customerID string, upload *SupportPacketUpload) error {
+	if upload.EnvironmentID != "" {
+		return nil
+	}
+
+	environment, err := s.store.ResolveEnvironment(customerID, upload.UserID, upload.Identity)
+	if err != nil {
+		return err
+	}
+	upload.EnvironmentID = environment.ID
+
+	return nil
+}
+
+// customerEnvironment returns the environment if it's one of the customer's, or ErrNotFound.
+func (s *customerService) customerEnvironment(customerID string, environmentID string) (Environment, error) {
+	environment, err := s.store.GetEnvironment(environmentID)
+	if err != nil {
+		return Environment{}, err
+	}
+	if environment.CustomerID != customerID {
+		return Environment{}, errors.Wrapf(ErrNotFound, "customer '%s' has no environment '%s'", customerID, environmentID)
+	}
+
+	return environment, nil
+}
+
+func (s *customerService) GetEnvironments(customerID string) ([]Environment, error) {
+	return s.store.GetEnvironments(customerID)
+}
+
+func (s *customerService) CreateEnvironment(customerID string, userID string, environment Environment) (Environment, error) {
+	if _, err := s.store.GetCustomerByID(customerID); err != nil {
+		return Environment{}, err
+	}
+	if err := validateEnvironment(&environment); err != nil {
+		return Environment{}, err
+	}
+
+	environments, err := s.store.GetEnvironments(customerID)
+	if err != nil {
+		return Environment{}, err
+	}
+	for _, existing := range environments {
+		if sameSiteURL(existing.SiteURL, environment.SiteURL) {
+			return Environment{}, errors.Wrapf(ErrDuplicateEntry, "environment '%s' already has site URL '%s'", existing.Name, environment.SiteURL)
+		}
+	}
+
+	environment.CustomerID = customerID
+	environment.TelemetryID = ""
+	environment.CreatedBy = userID
+	environment.ID, err = s.store.CreateEnvironment(environment)
+	if err != nil {
+		return Environment{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"customer_id":    customerID,
+		"environment_id": environment.ID,
+		"user_id":        userID,
+	}).Info("Environment created")
+
+	return s.store.GetEnvironment(environment.ID)
+}
+
+func (s *customerService) UpdateEnvironment(customerID string, environment Environment) (Environment, error) {
+	existing, err := s.customerEnvironment(customerID, environment.ID)
+	if err != nil {
+		return Environment{}, err
+	}
+	if err = validateEnvironment(&environment); err != nil {
+		return Environment{}, err
+	}
+	if existing.Primary && !environment.Primary {
+		return Environment{}, errors.Wrap(ErrInvalidEnvironment, "make another environment primary instead")
+	}
+
+	environments, err := s.store.GetEnvironments(customerID)
+	if err != nil {
+		return Environment{}, err
+	}
+	for _, other := range environments {
+		if other.ID != environment.ID && sameSiteURL(other.SiteURL, environment.SiteURL) {
+			return Environment{}, errors.Wrapf(ErrDuplicateEntry, "environment '%s' already has site URL '%s'", other.Name, environment.SiteURL)
+		}
+	}
+
+	// only learned from packets
+	environment.TelemetryID = existing.TelemetryID
+	environment.CustomerID = customerID
+	if err = s.store.UpdateEnvironment(environment); err != nil {
+		return Environment{}, err
+	}
+
+	return s.store.GetEnvironment(environment.ID)
+}
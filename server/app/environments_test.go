@@ -0,0 +1,93 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchEnvironment(t *testing.T) {
+	environments := []Environment{
+		{ID: "production", SiteURL: "https://chat.acme.com", TelemetryID: "server1", Primary: true},
+		{ID: "staging", SiteURL: "https://staging.acme.com"},
+	}
+	match := func(environments []Environment, identity PacketIdentity) (string, bool) {
+		environment, ok := MatchEnvironment(environments, identity)
+		return environment.ID, ok
+	}
+
+	t.Run("telemetry ID wins over the site URL", func(t *testing.T) {
+		id, ok := match(environments, PacketIdentity{SiteURL: "https://staging.acme.com", TelemetryID: "server1"})
+		require.True(t, ok)
+		require.Equal(t, "production", id)
+	})
+
+	t.Run("site URL", func(t *testing.T) {
+		id, ok := match(environments, PacketIdentity{SiteURL: "https://Staging.acme.com/", TelemetryID: "server2"})
+		require.True(t, ok)
+		require.Equal(t, "staging", id)
+	})
+
+	t.Run("no identifiers go to the primary environment", func(t *testing.T) {
+		id, ok := match(environments, PacketIdentity{LicensedTo: "Acme"})
+		require.True(t, ok)
+		require.Equal(t, "production", id)
+	})
+
+	t.Run("unknown server", func(t *testing.T) {
+		_, ok := match(environments, PacketIdentity{SiteURL: "https://dr.acme.com"})
+		require.False(t, ok)
+
+		_, ok = match(nil, PacketIdentity{SiteURL: "https://dr.acme.com"})
+		require.False(t, ok)
+	})
+
+	t.Run("environment without identifiers takes the packet", func(t *testing.T) {
+		id, ok := match(append(environments, Environment{ID: "blank"}), PacketIdentity{SiteURL: "https://dr.acme.com"})
+		require.True(t, ok)
+		require.Equal(t, "blank", id)
+	})
+}
+
+func TestNewPacketEnvironment(t *testing.T) {
+	production := []Environment{{ID: "production", Type: EnvironmentProduction, Primary: true}}
+
+	for _, tc := range []struct {
+		siteURL      string
+		existing     []Environment
+		expectedName string
+		expectedType EnvironmentType
+	}{
+		{"https://chat.acme.com", nil, "chat.acme.com", EnvironmentProduction},
+		{"https://chat-staging.acme.com", production, "chat-staging.acme.com", EnvironmentStaging},
+		{"https://dev.acme.com", production, "dev.acme.com", EnvironmentDevelopment},
+		{"https://chat.dr.acme.com", production, "chat.dr.acme.com", EnvironmentDisasterRecovery},
+		{"https://drive.acme.com", production, "drive.acme.com", EnvironmentOther},
+		{"", production, "Unnamed server", EnvironmentProduction},
+	} {
+		t.Run(tc.siteURL, func(t *testing.T) {
+			environment := NewPacketEnvironment("customer1", tc.existing, PacketIdentity{SiteURL: tc.siteURL, TelemetryID: "server1"})
+			require.Equal(t, tc.expectedName, environment.Name)
+			require.Equal(t, tc.expectedType, environment.Type)
+			require.Equal(t, "customer1", environment.CustomerID)
+			require.Equal(t, "server1", environment.TelemetryID)
+			require.Equal(t, len(tc.existing) == 0, environment.Primary)
+		})
+	}
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	environment := Environment{Name: " Staging ", SiteURL: " https://staging.acme.com "}
+	require.NoError(t, validateEnvironment(&environment))
+	require.Equal(t, "Staging", environment.Name)
+	require.Equal(t, "https://staging.acme.com", environment.SiteURL)
+	require.Equal(t, EnvironmentProduction, environment.Type)
+
+	for _, invalid := range []Environment{
+		{Name: " "},
+		{Name: "Staging", SiteURL: "staging.acme.com"},
+		{Name: "Staging", Type: "preprod"},
+	} {
+		require.ErrorIs(t, validateEnvironment(&invalid), ErrInvalidEnvironment)
+	}
+}
@@ -7,3 +7,33 @@ var ErrNotFound = errors.New("not found")
 
 // ErrDuplicateEntry occurs when failing to insert because the entry already existed.
 var ErrDuplicateEntry = errors.New("duplicate entry")
+
+// ErrPacketTooLarge occurs when a support packet exceeds the size or compression limits for extraction.
+var ErrPacketTooLarge = errors.New("support packet too large")
+
+// ErrPacketUnsafe occurs when a support packet contains entries that could escape the extraction path.
+var ErrPacketUnsafe = errors.New("support packet contains unsafe file names")
+
+// ErrInvalidFindingRules occurs when an uploaded findings rule file can't be parsed or fails validation.
+var ErrInvalidFindingRules = errors.New("invalid findings rules")
+
+// ErrAmbiguousCustomer occurs when a support packet matches more than one customer and none of them clearly.
+var ErrAmbiguousCustomer = errors.New("ambiguous customer match")
+
+// ErrInvalidIdentifier occurs when a customer identifier has an unknown type or no value.
+var ErrInvalidIdentifier = errors.New("invalid customer identifier")
+
+// ErrInvalidBackfill occurs when a backfill job is requested for channels or a time range it can't scan.
+var ErrInvalidBackfill = errors.New("invalid backfill")
+
+// ErrInvalidPacketField occurs when a packet field pin names an unknown field, has a value of the wrong type or has already expired.
+var ErrInvalidPacketField = errors.New("invalid packet field")
+
+// ErrInvalidCustomer occurs when a customer entered by a user is missing a name or has invalid values.
+var ErrInvalidCustomer = errors.New("invalid customer")
+
+// ErrInvalidMerge occurs when a customer merge has no source, the target as its source or an unknown strategy.
+var ErrInvalidMerge = errors.New("invalid merge")
+
+// ErrInvalidEnvironment occurs when an environment entered by a user is missing a name, has an unknown type or an invalid site URL.
+var ErrInvalidEnvironment = errors.New("invalid environment")
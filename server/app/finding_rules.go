@@ -0,0 +1,299 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// RuleOperator is how a custom rule compares the value at its field path.
+type RuleOperator string
+
+const (
+	OperatorEquals   RuleOperator = "eq"
+	OperatorLessThan RuleOperator = "lt"
+	OperatorSemver   RuleOperator = "semver"
+	OperatorRegex    RuleOperator = "regex"
+	OperatorExists   RuleOperator = "exists"
+)
+
+// findingRuleRoots are the first part of a custom rule's field path.
+var findingRuleRoots = []string{"packet", "config", "plugins"}
+
+// FindingRuleDefinition is an admin authored rule. The finding is reported when the value at
+// Field matches the operator and value, e.g. `config.SqlSettings.DriverName` `eq` `mysql`.
+//
+// Field paths start with `packet`, `config` or `plugins` followed by the JSON keys of the stored
+// values, matched case insensitively. Plugins are keyed by their ID, e.g. `plugins.playbooks.version`.
+type FindingRuleDefinition struct {
+	ID          string          `json:"id" yaml:"id"`
+	Field       string          `json:"field" yaml:"field"`
+	Operator    RuleOperator    `json:"operator" yaml:"operator"`
+	Value       string          `json:"value" yaml:"value"`
+	Severity    FindingSeverity `json:"severity" yaml:"severity"`
+	Message     string          `json:"message" yaml:"message"`
+	Remediation string          `json:"remediation" yaml:"remediation"`
+}
+
+// FindingRuleSet is an uploaded version of the custom rules. Only the latest version is evaluated.
+type FindingRuleSet struct {
+	ID        string                  `json:"id"`
+	Version   int                     `json:"version"`
+	CreatedAt int64                   `json:"createdAt"`
+	CreatedBy string                  `json:"createdBy"`
+	Rules     []FindingRuleDefinition `json:"rules"`
+}
+
+// findingRuleFile is the layout of an uploaded rule file.
+type findingRuleFile struct {
+	Rules []FindingRuleDefinition `json:"rules" yaml:"rules"`
+}
+
+// ParseFindingRules reads a YAML or JSON rule file and validates every rule in it.
+func ParseFindingRules(data []byte) ([]FindingRuleDefinition, error) {
+	var file findingRuleFile
+
+	// JSON is valid YAML, so one parser handles both formats.
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrapf(ErrInvalidFindingRules, "unable to parse rule file: %s", err.Error())
+	}
+
+	if err := ValidateFindingRules(file.Rules); err != nil {
+		return nil, err
+	}
+
+	return file.Rules, nil
+}
+
+// ValidateFindingRules checks the rules can be evaluated, returning an ErrInvalidFindingRules
+// error describing the first problem found.
+func ValidateFindingRules(rules []FindingRuleDefinition) error {
+	seen := make(map[string]bool)
+	for _, rule := range builtinFindingRules {
+		seen[rule.id] = true
+	}
+
+	for i, rule := range rules {
+		if rule.ID == "" {
+			return errors.Wrapf(ErrInvalidFindingRules, "rule %d has no id", i+1)
+		}
+		if seen[rule.ID] {
+			return errors.Wrapf(ErrInvalidFindingRules, "rule id '%s' is used more than once or by a built in rule", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		if err := validateFindingRule(rule); err != nil {
+			return errors.Wrapf(ErrInvalidFindingRules, "rule '%s': %s", rule.ID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func validateFindingRule(rule FindingRuleDefinition) error {
+	root := strings.ToLower(strings.SplitN(rule.Field, ".", 2)[0])
+	validRoot := false
+	for _, r := range findingRuleRoots {
+		if root == r && strings.Contains(rule.Field, ".") {
+			validRoot = true
+		}
+	}
+	if !validRoot {
+		return errors.Errorf("field '%s' must start with one of '%s' followed by a path", rule.Field, strings.Join(findingRuleRoots, "', '"))
+	}
+
+	switch rule.Severity {
+	case SeverityCritical, SeverityWarning, SeverityInfo:
+	default:
+		return errors.Errorf("severity '%s' must be one of '%s', '%s' or '%s'", rule.Severity, SeverityCritical, SeverityWarning, SeverityInfo)
+	}
+
+	if rule.Message == "" {
+		return errors.New("message cannot be empty")
+	}
+
+	switch rule.Operator {
+	case OperatorEquals:
+	case OperatorLessThan:
+		if _, err := strconv.ParseFloat(rule.Value, 64); err != nil {
+			return errors.Errorf("value '%s' must be a number for 'lt'", rule.Value)
+		}
+	case OperatorSemver:
+		if _, err := semver.ParseRange(rule.Value); err != nil {
+			return errors.Wrapf(err, "value '%s' must be a semver range", rule.Value)
+		}
+	case OperatorRegex:
+		if _, err := regexp.Compile(rule.Value); err != nil {
+			return errors.Wrapf(err, "value '%s' must be a regular expression", rule.Value)
+		}
+	case OperatorExists:
+		if rule.Value != "" && rule.Value != "true" && rule.Value != "false" {
+			return errors.Errorf("value '%s' must be empty, 'true' or 'false' for 'exists'", rule.Value)
+		}
+	default:
+		return errors.Errorf("operator '%s' must be one of '%s', '%s', '%s', '%s' or '%s'", rule.Operator, OperatorEquals, OperatorLessThan, OperatorSemver, OperatorRegex, OperatorExists)
+	}
+
+	return nil
+}
+
+// compileFindingRules turns validated definitions into rules evaluated like the built in ones.
+// Definitions that fail to compile are skipped, they are validated before being stored.
+func compileFindingRules(definitions []FindingRuleDefinition) []findingRule {
+	rules := make([]findingRule, 0, len(definitions))
+	for _, definition := range definitions {
+		match, err := compileFindingRuleMatcher(definition)
+		if err != nil {
+			continue
+		}
+
+		definition := definition
+		rules = append(rules, findingRule{
+			id: definition.ID,
+			evaluate: func(input *FindingInput) *Finding {
+				value, found := input.fieldValue(definition.Field)
+				if !match(value, found) {
+					return nil
+				}
+				return &Finding{
+					Severity:    definition.Severity,
+					Title:       definition.Message,
+					Remediation: definition.Remediation,
+				}
+			},
+		})
+	}
+
+	return rules
+}
+
+// compileFindingRuleMatcher returns a function reporting whether the value at the rule's field
+// matches. Fields that are missing only ever match the exists operator.
+func compileFindingRuleMatcher(rule FindingRuleDefinition) (func(value interface{}, found bool) bool, error) {
+	switch rule.Operator {
+	case OperatorEquals:
+		return func(value interface{}, found bool) bool {
+			return found && fieldString(value) == rule.Value
+		}, nil
+	case OperatorLessThan:
+		limit, err := strconv.ParseFloat(rule.Value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, found bool) bool {
+			number, err := strconv.ParseFloat(fieldString(value), 64)
+			return found && err == nil && number < limit
+		}, nil
+	case OperatorSemver:
+		versionRange, err := semver.ParseRange(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, found bool) bool {
+			version, err := semver.ParseTolerant(fieldString(value))
+			return found && err == nil && versionRange(version)
+		}, nil
+	case OperatorRegex:
+		pattern, err := regexp.Compile(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, found bool) bool {
+			return found && pattern.MatchString(fieldString(value))
+		}, nil
+	case OperatorExists:
+		want := rule.Value != "false"
+		return func(value interface{}, found bool) bool {
+			return (found && fieldString(value) != "") == want
+		}, nil
+	}
+
+	return nil, errors.Errorf("unknown operator '%s'", rule.Operator)
+}
+
+// fieldValue returns the value at a rule's field path, and false if any part of it is missing.
+func (i *FindingInput) fieldValue(fieldPath string) (interface{}, bool) {
+	if i.document == nil {
+		i.document = i.buildDocument()
+	}
+
+	var current interface{} = i.document
+	for _, key := range strings.Split(fieldPath, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		next, ok := object[key]
+		if !ok {
+			for objectKey, objectValue := range object {
+				if strings.EqualFold(objectKey, key) {
+					next, ok = objectValue, true
+					break
+				}
+			}
+		}
+		if !ok || next == nil {
+			return nil, false
+		}
+		current = next
+	}
+
+	return current, true
+}
+
+// buildDocument converts the input into generic JSON values so rules can address any field.
+func (i *FindingInput) buildDocument() map[string]interface{} {
+	document := make(map[string]interface{})
+
+	if i.Packet != nil {
+		document["packet"] = toJSONValue(i.Packet)
+	}
+	if i.Config != nil {
+		document["config"] = toJSONValue(i.Config)
+	}
+	if i.Plugins != nil {
+		plugins := make(map[string]interface{})
+		for _, plugin := range i.Plugins {
+			plugins[plugin.PluginID] = toJSONValue(plugin)
+		}
+		document["plugins"] = plugins
+	}
+
+	return document
+}
+
+func toJSONValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+
+	return generic
+}
+
+// fieldString formats a value for comparison. JSON numbers are floats, so whole numbers are
+// printed without a decimal point to compare equal to how they're written in a rule.
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
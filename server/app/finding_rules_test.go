@@ -0,0 +1,104 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFindingRules(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		rules, err := ParseFindingRules([]byte(`
+rules:
+  - id: mysql
+    field: config.SqlSettings.DriverName
+    operator: eq
+    value: mysql
+    severity: info
+    message: Customer is on MySQL
+  - id: few-teams
+    field: packet.totalTeams
+    operator: lt
+    value: 2
+    severity: info
+    message: Only one team
+`))
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+		require.Equal(t, "2", rules[1].Value)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rules, err := ParseFindingRules([]byte(`{"rules": [{"id": "old-server", "field": "packet.version", "operator": "semver", "value": "<9.0.0", "severity": "warning", "message": "Unsupported version"}]}`))
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		require.Equal(t, OperatorSemver, rules[0].Operator)
+	})
+
+	testCases := []struct {
+		name  string
+		rules string
+	}{
+		{"not yaml", "rules: [}"},
+		{"unknown key", `{"rules": [{"id": "a", "fields": "packet.version"}]}`},
+		{"missing id", `{"rules": [{"field": "packet.version", "operator": "exists", "severity": "info", "message": "m"}]}`},
+		{"duplicate id", `{"rules": [{"id": "a", "field": "packet.version", "operator": "exists", "severity": "info", "message": "m"}, {"id": "a", "field": "packet.version", "operator": "exists", "severity": "info", "message": "m"}]}`},
+		{"built in id", `{"rules": [{"id": "ha-single-node", "field": "packet.version", "operator": "exists", "severity": "info", "message": "m"}]}`},
+		{"unknown root", `{"rules": [{"id": "a", "field": "customer.name", "operator": "exists", "severity": "info", "message": "m"}]}`},
+		{"bad severity", `{"rules": [{"id": "a", "field": "packet.version", "operator": "exists", "severity": "urgent", "message": "m"}]}`},
+		{"missing message", `{"rules": [{"id": "a", "field": "packet.version", "operator": "exists", "severity": "info"}]}`},
+		{"unknown operator", `{"rules": [{"id": "a", "field": "packet.version", "operator": "gt", "value": "1", "severity": "info", "message": "m"}]}`},
+		{"lt without a number", `{"rules": [{"id": "a", "field": "packet.activeUsers", "operator": "lt", "value": "many", "severity": "info", "message": "m"}]}`},
+		{"bad semver range", `{"rules": [{"id": "a", "field": "packet.version", "operator": "semver", "value": "nine", "severity": "info", "message": "m"}]}`},
+		{"bad regex", `{"rules": [{"id": "a", "field": "packet.version", "operator": "regex", "value": "(", "severity": "info", "message": "m"}]}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseFindingRules([]byte(tc.rules))
+			require.ErrorIs(t, err, ErrInvalidFindingRules)
+		})
+	}
+}
+
+func TestEvaluateCustomFindingRules(t *testing.T) {
+	config := &model.Config{}
+	config.SetDefaults()
+	config.SqlSettings.DriverName = model.NewString("mysql")
+
+	input := &FindingInput{
+		Packet:  &CustomerPacketValues{Version: "8.1.4", ActiveUsers: 50, LDAPProvider: "OpenLDAP"},
+		Config:  config,
+		Plugins: []CustomerPluginValues{{PluginID: "playbooks", Version: "1.38.1"}},
+	}
+
+	rule := func(id, field string, operator RuleOperator, value string) FindingRuleDefinition {
+		return FindingRuleDefinition{ID: id, Field: field, Operator: operator, Value: value, Severity: SeverityInfo, Message: id}
+	}
+
+	rules := []FindingRuleDefinition{
+		rule("eq-match", "config.SqlSettings.DriverName", OperatorEquals, "mysql"),
+		rule("eq-miss", "config.SqlSettings.DriverName", OperatorEquals, "postgres"),
+		rule("eq-bool", "config.ServiceSettings.EnableDeveloper", OperatorEquals, "false"),
+		rule("lt-match", "packet.activeUsers", OperatorLessThan, "100"),
+		rule("lt-miss", "packet.ActiveUsers", OperatorLessThan, "10"),
+		rule("semver-match", "packet.version", OperatorSemver, "<9.0.0"),
+		rule("semver-plugin", "plugins.playbooks.version", OperatorSemver, ">=2.0.0"),
+		rule("regex-match", "packet.ldapProvider", OperatorRegex, "(?i)^openldap$"),
+		rule("exists-match", "plugins.playbooks", OperatorExists, ""),
+		rule("exists-miss", "plugins.calls", OperatorExists, ""),
+		rule("not-exists", "plugins.calls", OperatorExists, "false"),
+		rule("missing-field", "config.NoSuchSettings.Value", OperatorEquals, ""),
+	}
+
+	findings := EvaluateFindings(input, rules)
+
+	var custom []string
+	for _, finding := range findings {
+		if finding.Title == finding.RuleID {
+			custom = append(custom, finding.RuleID)
+		}
+	}
+	require.Equal(t, []string{"eq-match", "eq-bool", "lt-match", "semver-match", "regex-match", "exists-match", "not-exists"}, custom)
+}
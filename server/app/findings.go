@@ -0,0 +1,180 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// FindingSeverity is how urgently a finding should be addressed.
+type FindingSeverity string
+
+const (
+	SeverityCritical FindingSeverity = "critical"
+	SeverityWarning  FindingSeverity = "warning"
+	SeverityInfo     FindingSeverity = "info"
+)
+
+const (
+	// recommendedMaxOpenConns is the Mattermost default for SqlSettings.MaxOpenConns.
+	recommendedMaxOpenConns = 300
+
+	// recommendedMaxIdleConns is the Mattermost default for SqlSettings.MaxIdleConns.
+	recommendedMaxIdleConns = 20
+)
+
+// Finding is a potential problem with a customer's environment found in a support packet.
+type Finding struct {
+	RuleID      string          `json:"ruleID"`
+	Severity    FindingSeverity `json:"severity"`
+	Title       string          `json:"title"`
+	Remediation string          `json:"remediation"`
+}
+
+// FindingInput is what the rules are evaluated against. Any of the parts may be missing when
+// the packet was partial, rules skip themselves when what they need isn't there.
+type FindingInput struct {
+	Packet  *CustomerPacketValues
+	Config  *model.Config
+	Plugins []CustomerPluginValues
+	Nodes   []CustomerNodeValues
+
+	// document is the input as generic JSON values, built when a custom rule first needs it.
+	document map[string]interface{}
+}
+
+// findingRule is a single check. Evaluate returns nil when the rule doesn't apply.
+type findingRule struct {
+	id       string
+	evaluate func(input *FindingInput) *Finding
+}
+
+// builtinFindingRules are evaluated against every ingested packet.
+var builtinFindingRules = []findingRule{
+	{
+		id: "elasticsearch-unreachable",
+		evaluate: func(input *FindingInput) *Finding {
+			if input.Config == nil || input.Packet == nil {
+				return nil
+			}
+			if !isTrue(input.Config.ElasticsearchSettings.EnableIndexing) || input.Packet.ElasticServerVersion != "" {
+				return nil
+			}
+			return &Finding{
+				Severity:    SeverityWarning,
+				Title:       "Elasticsearch indexing is enabled but no Elasticsearch server version was reported",
+				Remediation: "The server could not reach Elasticsearch when the packet was generated. Check `ElasticsearchSettings.ConnectionURL` and the credentials, then test the connection from the System Console.",
+			}
+		},
+	},
+	{
+		id: "active-users-over-license",
+		evaluate: func(input *FindingInput) *Finding {
+			if input.Packet == nil || input.Packet.LicenseSupportedUsers == 0 {
+				return nil
+			}
+			if input.Packet.ActiveUsers <= input.Packet.LicenseSupportedUsers {
+				return nil
+			}
+			return &Finding{
+				Severity:    SeverityCritical,
+				Title:       fmt.Sprintf("%d active users exceed the %d licensed users", input.Packet.ActiveUsers, input.Packet.LicenseSupportedUsers),
+				Remediation: "Work with the account team on a true-up, or deactivate users who no longer need access.",
+			}
+		},
+	},
+	{
+		id: "ha-single-node",
+		evaluate: func(input *FindingInput) *Finding {
+			if input.Config == nil || !isTrue(input.Config.ClusterSettings.Enable) {
+				return nil
+			}
+			if len(input.Nodes) > 1 {
+				return nil
+			}
+			return &Finding{
+				Severity:    SeverityWarning,
+				Title:       "High availability is enabled but the packet only contains a single node",
+				Remediation: "Confirm the other nodes are healthy and joined to the cluster, or disable `ClusterSettings.Enable` if the deployment runs on one server.",
+			}
+		},
+	},
+	{
+		id: "sql-max-open-conns-low",
+		evaluate: func(input *FindingInput) *Finding {
+			if input.Config == nil || input.Config.SqlSettings.MaxOpenConns == nil {
+				return nil
+			}
+			if *input.Config.SqlSettings.MaxOpenConns >= recommendedMaxOpenConns {
+				return nil
+			}
+			return &Finding{
+				Severity:    SeverityWarning,
+				Title:       fmt.Sprintf("`SqlSettings.MaxOpenConns` is %d, below the recommended %d", *input.Config.SqlSettings.MaxOpenConns, recommendedMaxOpenConns),
+				Remediation: fmt.Sprintf("Raise `SqlSettings.MaxOpenConns` to at least %d, making sure the database `max_connections` allows for it across all nodes.", recommendedMaxOpenConns),
+			}
+		},
+	},
+	{
+		id: "sql-max-idle-conns-low",
+		evaluate: func(input *FindingInput) *Finding {
+			if input.Config == nil || input.Config.SqlSettings.MaxIdleConns == nil {
+				return nil
+			}
+			if *input.Config.SqlSettings.MaxIdleConns >= recommendedMaxIdleConns {
+				return nil
+			}
+			return &Finding{
+				Severity:    SeverityInfo,
+				Title:       fmt.Sprintf("`SqlSettings.MaxIdleConns` is %d, below the recommended %d", *input.Config.SqlSettings.MaxIdleConns, recommendedMaxIdleConns),
+				Remediation: fmt.Sprintf("Raise `SqlSettings.MaxIdleConns` to at least %d to avoid reopening database connections under load.", recommendedMaxIdleConns),
+			}
+		},
+	},
+}
+
+// EvaluateFindings runs the built in rules, followed by the given custom rules, against the input.
+func EvaluateFindings(input *FindingInput, custom []FindingRuleDefinition) []Finding {
+	rules := append(append([]findingRule{}, builtinFindingRules...), compileFindingRules(custom)...)
+
+	var findings []Finding
+	for _, rule := range rules {
+		finding := rule.evaluate(input)
+		if finding == nil {
+			continue
+		}
+		finding.RuleID = rule.id
+		findings = append(findings, *finding)
+	}
+
+	return findings
+}
+
+func isTrue(value *bool) bool {
+	return value != nil && *value
+}
+
+// findingsMarkdown renders the findings for the thread reply.
+func findingsMarkdown(findings []Finding) string {
+	mdTable := "## Findings\n"
+	if len(findings) == 0 {
+		return mdTable + "No issues found.\n\n"
+	}
+
+	for _, finding := range findings {
+		mdTable += fmt.Sprintf("- %s **%s**: %s\n", severityEmoji(finding.Severity), finding.Title, finding.Remediation)
+	}
+
+	return mdTable + "\n"
+}
+
+func severityEmoji(severity FindingSeverity) string {
+	switch severity {
+	case SeverityCritical:
+		return ":red_circle:"
+	case SeverityWarning:
+		return ":warning:"
+	default:
+		return ":information_source:"
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func findingRuleIDs(findings []Finding) []string {
+	ids := []string{}
+	for _, finding := range findings {
+		ids = append(ids, finding.RuleID)
+	}
+	return ids
+}
+
+func TestEvaluateFindings(t *testing.T) {
+	t.Run("empty input", func(t *testing.T) {
+		require.Empty(t, EvaluateFindings(&FindingInput{}, nil))
+	})
+
+	t.Run("healthy packet", func(t *testing.T) {
+		config := &model.Config{}
+		config.SetDefaults()
+
+		findings := EvaluateFindings(&FindingInput{
+			Packet: &CustomerPacketValues{ActiveUsers: 10, LicenseSupportedUsers: 100},
+			Config: config,
+		}, nil)
+		require.Empty(t, findings)
+	})
+
+	t.Run("problems are reported", func(t *testing.T) {
+		config := &model.Config{}
+		config.SetDefaults()
+		config.ElasticsearchSettings.EnableIndexing = model.NewBool(true)
+		config.ClusterSettings.Enable = model.NewBool(true)
+		config.SqlSettings.MaxOpenConns = model.NewInt(50)
+		config.SqlSettings.MaxIdleConns = model.NewInt(5)
+
+		findings := EvaluateFindings(&FindingInput{
+			Packet: &CustomerPacketValues{ActiveUsers: 200, LicenseSupportedUsers: 100},
+			Config: config,
+			Nodes:  []CustomerNodeValues{{NodeID: "node1"}},
+		}, nil)
+		require.Equal(t, []string{
+			"elasticsearch-unreachable",
+			"active-users-over-license",
+			"ha-single-node",
+			"sql-max-open-conns-low",
+			"sql-max-idle-conns-low",
+		}, findingRuleIDs(findings))
+		require.Equal(t, SeverityCritical, findings[1].Severity)
+	})
+}
+
+func TestFindingsMarkdown(t *testing.T) {
+	require.Contains(t, findingsMarkdown(nil), "No issues found.")
+
+	md := findingsMarkdown([]Finding{{RuleID: "a", Severity: SeverityCritical, Title: "Broken", Remediation: "Fix it."}})
+	require.Contains(t, md, ":red_circle: **Broken**: Fix it.")
+}
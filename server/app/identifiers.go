@@ -0,0 +1,160 @@
+package app
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IdentifierType is the kind of value a customer is recognized by.
+type IdentifierType string
+
+const (
+	// IdentifierTelemetryID is the server's diagnostic ID, the server_id in the packet metadata.
+	IdentifierTelemetryID IdentifierType = "telemetry_id"
+	// IdentifierLicenseID is the ID of the license the server runs with.
+	IdentifierLicenseID IdentifierType = "license_id"
+	// IdentifierClusterID is the ID shared by the nodes of a high availability cluster.
+	IdentifierClusterID IdentifierType = "cluster_id"
+	// IdentifierSiteURL is the server's site URL.
+	IdentifierSiteURL IdentifierType = "site_url"
+	// IdentifierLicensedTo is the license holder.
+	IdentifierLicensedTo IdentifierType = "licensed_to"
+)
+
+// identifierConfidence is how sure a match on each type of identifier makes us that a packet
+// belongs to the customer. The IDs are generated by the server so they're rarely shared, the
+// license holder and site URL change when customers rename or move domains. The license holder
+// outweighs the site URL so it's offered first when only one of them matches each customer.
+var identifierConfidence = map[IdentifierType]float64{
+	IdentifierTelemetryID: 0.95,
+	IdentifierLicenseID:   0.9,
+	IdentifierClusterID:   0.8,
+	IdentifierLicensedTo:  0.6,
+	IdentifierSiteURL:     0.5,
+}
+
+// identifierTypes lists the types from the most to the least confident. Scores are always
+// combined in this order so customers matching the same types score exactly the same.
+var identifierTypes = []IdentifierType{
+	IdentifierTelemetryID,
+	IdentifierLicenseID,
+	IdentifierClusterID,
+	IdentifierLicensedTo,
+	IdentifierSiteURL,
+}
+
+// IdentifierSource is where a customer identifier was learned from.
+type IdentifierSource string
+
+const (
+	IdentifierSourcePacket IdentifierSource = "packet"
+	IdentifierSourceAdmin  IdentifierSource = "admin"
+
+	// IdentifierSourceMerge identifiers were the site URL and license holder of a customer merged
+	// into another one.
+	IdentifierSourceMerge IdentifierSource = "merge"
+)
+
+// CustomerIdentifier is one of the values a customer is recognized by when matching packets.
+type CustomerIdentifier struct {
+	CustomerID string           `json:"customerID"`
+	Type       IdentifierType   `json:"type"`
+	Value      string           `json:"value"`
+	Source     IdentifierSource `json:"source"`
+	CreatedAt  int64            `json:"createdAt"`
+	CreatedBy  string           `json:"createdBy"`
+}
+
+// PacketIdentity holds the identifiers a support packet carries. Any of them may be empty,
+// older servers don't include the IDs.
+type PacketIdentity struct {
+	SiteURL     string `json:"siteURL"`
+	LicensedTo  string `json:"licensedTo"`
+	TelemetryID string `json:"telemetryID"`
+	LicenseID   string `json:"licenseID"`
+	ClusterID   string `json:"clusterID"`
+}
+
+// Identifiers returns the identifiers that were set, keyed by type.
+func (p PacketIdentity) Identifiers() map[IdentifierType]string {
+	identifiers := make(map[IdentifierType]string)
+	for identifierType, value := range map[IdentifierType]string{
+		IdentifierTelemetryID: p.TelemetryID,
+		IdentifierLicenseID:   p.LicenseID,
+		IdentifierClusterID:   p.ClusterID,
+		IdentifierSiteURL:     p.SiteURL,
+		IdentifierLicensedTo:  p.LicensedTo,
+	} {
+		if value != "" {
+			identifiers[identifierType] = value
+		}
+	}
+
+	return identifiers
+}
+
+// CustomerMatch is a customer sharing at least one identifier with a packet.
+type CustomerMatch struct {
+	Customer
+
+	// Confidence is between 0 and 1, combining the confidence of every matched identifier type.
+	Confidence float64          `json:"confidence"`
+	Matched    []IdentifierType `json:"matched"`
+}
+
+// ValidateCustomerIdentifier checks an identifier added by an admin.
+func ValidateCustomerIdentifier(identifierType IdentifierType, value string) error {
+	if _, ok := identifierConfidence[identifierType]; !ok {
+		return errors.Wrapf(ErrInvalidIdentifier, "type '%s' must be one of '%s', '%s', '%s', '%s' or '%s'", identifierType, IdentifierTelemetryID, IdentifierLicenseID, IdentifierClusterID, IdentifierSiteURL, IdentifierLicensedTo)
+	}
+	if strings.TrimSpace(value) == "" {
+		return errors.Wrap(ErrInvalidIdentifier, "value cannot be empty")
+	}
+
+	return nil
+}
+
+// ScoreCustomerMatch works out which of the packet's identifiers the customer is known by and
+// how confident that makes the match. The customer's own site URL and license holder count as
+// known identifiers even if they aren't in the set.
+func ScoreCustomerMatch(customer Customer, known []CustomerIdentifier, identity PacketIdentity) CustomerMatch {
+	knownValues := make(map[IdentifierType]map[string]bool)
+	addKnown := func(identifierType IdentifierType, value string) {
+		if knownValues[identifierType] == nil {
+			knownValues[identifierType] = make(map[string]bool)
+		}
+		knownValues[identifierType][value] = true
+	}
+
+	addKnown(IdentifierSiteURL, customer.SiteURL)
+	addKnown(IdentifierLicensedTo, customer.LicensedTo)
+	for _, identifier := range known {
+		addKnown(identifier.Type, identifier.Value)
+	}
+
+	identifiers := identity.Identifiers()
+	match := CustomerMatch{Customer: customer}
+	unlikely := 1.0
+	for _, identifierType := range identifierTypes {
+		value, ok := identifiers[identifierType]
+		if ok && knownValues[identifierType][value] {
+			match.Matched = append(match.Matched, identifierType)
+			unlikely *= 1 - identifierConfidence[identifierType]
+		}
+	}
+	match.Confidence = 1 - unlikely
+
+	return match
+}
+
+// SortCustomerMatches orders the matches by confidence, then by the most recently updated.
+func SortCustomerMatches(matches []CustomerMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Confidence != matches[j].Confidence {
+			return matches[i].Confidence > matches[j].Confidence
+		}
+		return matches[i].LastUpdated > matches[j].LastUpdated
+	})
+}
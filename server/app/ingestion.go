@@ -0,0 +1,337 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/scheduler"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ingestionPollInterval is how often the worker checks the queue for jobs.
+	ingestionPollInterval = 5 * time.Second
+
+	// maxConcurrentIngestionJobs is how many jobs a single server processes at the same time.
+	// Packets are held in memory while processed, so this bounds the memory used as well.
+	maxConcurrentIngestionJobs = 2
+
+	// ingestionJobLease is how long a claimed job is held before another worker may take it over,
+	// in case the server processing it went away.
+	ingestionJobLease = 15 * time.Minute
+
+	// MaxIngestionAttempts is how many times a job is tried before it is moved to the dead letter state.
+	MaxIngestionAttempts = 5
+
+	// ingestionBaseBackoff is the wait before the first retry, doubled for every attempt after it.
+	ingestionBaseBackoff = 30 * time.Second
+
+	// ingestionMaxBackoff caps the wait between retries.
+	ingestionMaxBackoff = time.Hour
+)
+
+// IngestionJobStatus is where a job is in the ingestion queue.
+type IngestionJobStatus string
+
+const (
+	// IngestionPending jobs are waiting to be picked up, including ones waiting for a retry.
+	IngestionPending IngestionJobStatus = "pending"
+	// IngestionProcessing jobs have been claimed by a worker.
+	IngestionProcessing IngestionJobStatus = "processing"
+	// IngestionCompleted jobs were ingested.
+	IngestionCompleted IngestionJobStatus = "completed"
+	// IngestionSkipped jobs were for attachments that turned out not to be support packets.
+	IngestionSkipped IngestionJobStatus = "skipped"
+	// IngestionDead jobs failed every attempt and won't be retried unless an admin asks for it.
+	IngestionDead IngestionJobStatus = "dead"
+)
+
+// IngestionJob is a single post attachment waiting to be, or that has been, ingested.
+type IngestionJob struct {
+	ID            string             `json:"id"`
+	PostID        string             `json:"postID"`
+	FileID        string             `json:"fileID"`
+	ChannelID     string             `json:"channelID"`
+	Status        IngestionJobStatus `json:"status"`
+	Attempts      int                `json:"attempts"`
+	LastError     string             `json:"lastError"`
+	CreateAt      int64              `json:"createAt"`
+	UpdateAt      int64              `json:"updateAt"`
+	NextAttemptAt int64              `json:"nextAttemptAt"`
+	LockedUntil   int64              `json:"lockedUntil"`
+
+	// StatusPostID is the bot reply showing the progress of the job, edited as it changes.
+	StatusPostID string `json:"statusPostID"`
+}
+
+// IngestionJobStore is the durable queue of ingestion jobs.
+type IngestionJobStore interface {
+	// EnqueueIngestionJob adds a pending job for the attachment, doing nothing if one already exists.
+	EnqueueIngestionJob(postID, fileID, channelID string) error
+
+	// ClaimIngestionJobs marks up to limit jobs that are due as processing until the lease runs
+	// out and returns them. Jobs claimed by another server are skipped.
+	ClaimIngestionJobs(limit int, lease time.Duration) ([]IngestionJob, error)
+
+	// FinishIngestionJob sets the final status of a claimed job. lockedUntil is the lease of the
+	// claim, ErrNotFound is returned if it ran out and the job was claimed again since.
+	FinishIngestionJob(id string, lockedUntil int64, status IngestionJobStatus) error
+
+	// FailIngestionJob records a failed attempt, scheduling the next one or moving the job to
+	// dead. Like FinishIngestionJob, it returns ErrNotFound if the lease of the claim ran out.
+	FailIngestionJob(id string, lockedUntil int64, lastError string, nextAttemptAt int64, dead bool) error
+
+	// SetIngestionJobStatusPost saves the post showing the progress of the job.
+	SetIngestionJobStatusPost(id string, postID string) error
+
+	// GetIngestionJobs returns the jobs with the given status, or all jobs if it's empty, newest first.
+	GetIngestionJobs(status IngestionJobStatus) ([]IngestionJob, error)
+
+	// RetryIngestionJob resets a job that isn't being processed so it is picked up again right away.
+	RetryIngestionJob(id string) error
+
+	// PurgeIngestionJobs deletes the jobs with the given status, returning how many were removed.
+	PurgeIngestionJobs(status IngestionJobStatus) (int64, error)
+
+	// CreateBackfillJob stores a new backfill job, returning its ID.
+	CreateBackfillJob(job BackfillJob) (string, error)
+
+	// GetBackfillJobs returns the backfill jobs with their progress, newest first.
+	GetBackfillJobs() ([]BackfillJob, error)
+
+	// GetBackfillJob returns the backfill job with its progress, or ErrNotFound.
+	GetBackfillJob(id string) (BackfillJob, error)
+
+	// GetBackfillItems returns the attachments found by the backfill job with the given status, or
+	// all of them if it's empty, oldest post first.
+	GetBackfillItems(jobID string, status IngestionJobStatus) ([]BackfillItem, error)
+
+	// ClaimBackfillJob holds the oldest backfill job that is scanning or ingesting, and isn't held
+	// by another server, until the lease runs out. Nil is returned if there is none.
+	ClaimBackfillJob(lease time.Duration) (*BackfillJob, error)
+
+	// SaveBackfillScan adds the attachments found and saves the position of the scan and the
+	// status of the job together, so a resumed scan neither misses nor repeats posts.
+	SaveBackfillScan(job BackfillJob, items []BackfillItem) error
+
+	// GetPendingBackfillItems returns up to limit attachments waiting to be ingested, oldest post first.
+	GetPendingBackfillItems(jobID string, limit int) ([]BackfillItem, error)
+
+	// FinishBackfillItem sets the final status of an attachment, with the error it failed with, if any.
+	FinishBackfillItem(id string, status IngestionJobStatus, lastError string) error
+
+	// FailBackfillItem records a failed attempt at ingesting an attachment, leaving it pending.
+	FailBackfillItem(id string, lastError string) error
+
+	// ReleaseBackfillJob lets go of a claimed job, saving its status unless it was cancelled in the
+	// meantime. It isn't claimed again before retryAt.
+	ReleaseBackfillJob(id string, status BackfillStatus, lastError string, retryAt int64) error
+
+	// CancelBackfillJob stops a backfill job that is scanning or ingesting, or returns ErrNotFound.
+	CancelBackfillJob(id string) error
+}
+
+// ingestionBackoff returns how long to wait before the next attempt after the given number of attempts.
+func ingestionBackoff(attempts int) time.Duration {
+	backoff := ingestionBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= ingestionMaxBackoff {
+			return ingestionMaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// ingestionWorker polls the queue and processes the jobs. It runs on every server in the cluster,
+// the store makes sure each job is only claimed by one of them at a time.
+type ingestionWorker struct {
+	service *customerService
+
+	task    *scheduler.ScheduledTask
+	running chan struct{}
+	wg      sync.WaitGroup
+
+	// backfillTask runs the backfill jobs, one at a time. stop is closed to cut a run short.
+	backfillTask *scheduler.ScheduledTask
+	stop         chan struct{}
+}
+
+func (s *customerService) StartIngestionWorker() {
+	if s.worker != nil {
+		return
+	}
+
+	worker := &ingestionWorker{
+		service: s,
+		running: make(chan struct{}, maxConcurrentIngestionJobs),
+		stop:    make(chan struct{}),
+	}
+	worker.task = scheduler.CreateRecurringTask("CRM_IngestionWorker", worker.poll, ingestionPollInterval)
+	worker.backfillTask = scheduler.CreateRecurringTask("CRM_BackfillWorker", worker.backfill, backfillPollInterval)
+	s.worker = worker
+}
+
+func (s *customerService) StopIngestionWorker() {
+	if s.worker == nil {
+		return
+	}
+
+	close(s.worker.stop)
+	s.worker.task.Cancel()
+	s.worker.backfillTask.Cancel()
+	s.worker.wg.Wait()
+	s.worker = nil
+}
+
+// poll claims as many jobs as there are free slots and processes each in its own goroutine.
+func (w *ingestionWorker) poll() {
+	free := cap(w.running) - len(w.running)
+	if free == 0 {
+		return
+	}
+
+	jobs, err := w.service.jobs.ClaimIngestionJobs(free, ingestionJobLease)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to claim ingestion jobs")
+		return
+	}
+
+	for _, job := range jobs {
+		w.running <- struct{}{}
+		w.wg.Add(1)
+		go func(job IngestionJob) {
+			defer func() {
+				<-w.running
+				w.wg.Done()
+			}()
+			w.process(job)
+		}(job)
+	}
+}
+
+func (w *ingestionWorker) process(job IngestionJob) {
+	logger := logrus.WithFields(logrus.Fields{
+		"job_id":  job.ID,
+		"post_id": job.PostID,
+		"file_id": job.FileID,
+	})
+
+	status, err := ingestAttachment(w.service, &job)
+	if err == nil {
+		if err = w.service.jobs.FinishIngestionJob(job.ID, job.LockedUntil, status); err != nil {
+			logIngestionJobUpdateError(logger, err, "Failed to finish ingestion job")
+		}
+		return
+	}
+
+	ingestionErr := asIngestionError(StageStore, err)
+	logger = logger.WithField("stage", ingestionErr.Stage)
+
+	attempts := job.Attempts + 1
+	dead := isFinalIngestionAttempt(job, ingestionErr)
+	nextAttemptAt := model.GetMillis() + ingestionBackoff(attempts).Milliseconds()
+	switch {
+	case !ingestionErr.Retryable:
+		logger.WithError(err).Warn("Ingestion job failed on a problem with the packet, moving it to dead")
+	case dead:
+		logger.WithError(err).Error("Ingestion job failed its last attempt, moving it to dead")
+	default:
+		logger.WithError(err).Warn("Ingestion job failed, it will be retried")
+	}
+
+	if err = w.service.jobs.FailIngestionJob(job.ID, job.LockedUntil, err.Error(), nextAttemptAt, dead); err != nil {
+		logIngestionJobUpdateError(logger, err, "Failed to record ingestion job failure")
+	}
+}
+
+// logIngestionJobUpdateError logs a failure to update a processed job. A job whose lease ran out
+// belongs to the worker that claimed it again, which records its own outcome.
+func logIngestionJobUpdateError(logger logrus.FieldLogger, err error, message string) {
+	if errors.Is(err, ErrNotFound) {
+		logger.WithError(err).Warn("Ingestion job took longer than its lease and was claimed again")
+		return
+	}
+
+	logger.WithError(err).Error(message)
+}
+
+// ingestAttachment ingests the attachment of a job, keeping a status post in the thread up to
+// date as it goes. Returned errors are IngestionErrors, retried if they are retryable.
+func ingestAttachment(s *customerService, job *IngestionJob) (IngestionJobStatus, error) {
+	post, err := s.api.Post.GetPost(job.PostID)
+	if err != nil {
+		return "", newRetryableIngestionError(StageDownload, "the post couldn't be read", errors.Wrap(err, "failed to get post"))
+	}
+	if post.DeleteAt != 0 {
+		return IngestionSkipped, nil
+	}
+
+	packetFile, err := openSupportPacketFile(s, job.FileID)
+	if err != nil {
+		// without the file there is nothing to show a status for until it's given up on
+		ingestionErr := asIngestionError(StageDownload, err)
+		if isFinalIngestionAttempt(*job, ingestionErr) {
+			replyToPacketPost(s, post, ingestionFailureMessage("", ingestionErr))
+		}
+		return "", ingestionErr
+	}
+	if packetFile == nil {
+		return IngestionSkipped, nil
+	}
+	fileName := packetFile.info.Name
+
+	setIngestionStatus(s, job, post, "Uploading support packet for "+fileName)
+
+	message, err := processSupportPacket(s, packetFile, post, false)
+	if err != nil {
+		ingestionErr := asIngestionError(StageStore, err)
+		if isFinalIngestionAttempt(*job, ingestionErr) {
+			setIngestionStatus(s, job, post, ingestionFailureMessage(fileName, ingestionErr))
+		} else {
+			setIngestionStatus(s, job, post, ingestionRetryMessage(fileName, ingestionErr, job.Attempts+1))
+		}
+		return "", ingestionErr
+	}
+
+	setIngestionStatus(s, job, post, message)
+
+	return IngestionCompleted, nil
+}
+
+// setIngestionStatus edits the job's status post to show the message, posting it in the thread
+// the first time. The status post is kept across attempts so retries don't add more of them.
+func setIngestionStatus(s *customerService, job *IngestionJob, post *model.Post, message string) {
+	logger := logrus.WithFields(logrus.Fields{
+		"job_id":  job.ID,
+		"post_id": post.Id,
+	})
+
+	if job.StatusPostID != "" {
+		statusPost, err := s.api.Post.GetPost(job.StatusPostID)
+		if err == nil && statusPost.DeleteAt == 0 {
+			statusPost.Message = message
+			if err = s.api.Post.UpdatePost(statusPost); err != nil {
+				logger.WithError(err).Warn("Failed to update ingestion status post")
+			}
+			return
+		}
+	}
+
+	statusPost := &model.Post{
+		ChannelId: post.ChannelId,
+		Message:   message,
+	}
+	if err := s.poster.PostMessageToThread(post.Id, statusPost); err != nil {
+		logger.WithError(err).Error("Failed in sending reply")
+		return
+	}
+
+	job.StatusPostID = statusPost.Id
+	if err := s.jobs.SetIngestionJobStatusPost(job.ID, statusPost.Id); err != nil {
+		logger.WithError(err).Warn("Failed to save ingestion status post")
+	}
+}
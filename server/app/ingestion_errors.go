@@ -0,0 +1,124 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IngestionStage is the step of ingesting a packet that failed.
+type IngestionStage string
+
+const (
+	StageDownload IngestionStage = "download"
+	StageUnzip    IngestionStage = "unzip"
+	StageParse    IngestionStage = "parse"
+	StageMatch    IngestionStage = "match"
+	StageStore    IngestionStage = "store"
+	StageReply    IngestionStage = "reply"
+)
+
+// IngestionError explains why a packet couldn't be ingested, in words the uploader can act on.
+type IngestionError struct {
+	Stage IngestionStage
+
+	// Reason is what went wrong and Fix what the uploader can do about it, both shown in the thread.
+	Reason string
+	Fix    string
+
+	// Retryable errors are likely to go away on their own, like the database being unavailable,
+	// so the job is attempted again. Anything else is down to the packet itself.
+	Retryable bool
+
+	Err error
+}
+
+func (e *IngestionError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Stage, e.Reason)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", e.Stage, e.Reason, e.Err.Error())
+}
+
+func (e *IngestionError) Unwrap() error {
+	return e.Err
+}
+
+// newRetryableIngestionError wraps an error the server ran into, as opposed to a problem with the packet.
+func newRetryableIngestionError(stage IngestionStage, reason string, err error) *IngestionError {
+	return &IngestionError{
+		Stage:     stage,
+		Reason:    reason,
+		Fix:       "Nothing, it will be tried again automatically. If it keeps failing, ask a system admin to check the server logs.",
+		Retryable: true,
+		Err:       err,
+	}
+}
+
+// asIngestionError returns the error as an IngestionError, treating unknown errors as retryable.
+func asIngestionError(stage IngestionStage, err error) *IngestionError {
+	var ingestionErr *IngestionError
+	if errors.As(err, &ingestionErr) {
+		return ingestionErr
+	}
+
+	return newRetryableIngestionError(stage, "an unexpected error occurred", err)
+}
+
+// extractionError explains why the packet couldn't be unzipped.
+func extractionError(err error) *IngestionError {
+	switch {
+	case errors.Is(err, ErrPacketTooLarge):
+		return &IngestionError{
+			Stage:  StageUnzip,
+			Reason: "the packet is too large to process, " + strings.TrimSuffix(err.Error(), ": "+ErrPacketTooLarge.Error()),
+			Fix:    fmt.Sprintf("Generate a new support packet, or remove the extra files from the zip so the packet files stay under %d MB.", MaxPacketTotalSize/1024/1024),
+			Err:    err,
+		}
+	case errors.Is(err, ErrPacketUnsafe):
+		return &IngestionError{
+			Stage:  StageUnzip,
+			Reason: "the zip contains file names that point outside of it",
+			Fix:    "Generate a new support packet from the System Console and upload it without modifying it.",
+			Err:    err,
+		}
+	}
+
+	return &IngestionError{
+		Stage:  StageUnzip,
+		Reason: "the zip couldn't be read",
+		Fix:    "Check the zip opens on your computer, then upload it again.",
+		Err:    err,
+	}
+}
+
+// isFinalIngestionAttempt returns true if the job won't be attempted again after failing with the error.
+func isFinalIngestionAttempt(job IngestionJob, ingestionErr *IngestionError) bool {
+	return !ingestionErr.Retryable || job.Attempts+1 >= MaxIngestionAttempts
+}
+
+// ingestionFailureMessage is the final status of a packet that couldn't be ingested. The file
+// name is empty when the attachment couldn't be downloaded to find it out.
+func ingestionFailureMessage(fileName string, ingestionErr *IngestionError) string {
+	message := fmt.Sprintf(":x: Unable to ingest %s: %s.", packetDescription(fileName), ingestionErr.Reason)
+	if ingestionErr.Fix != "" {
+		message += "\n\n**How to fix it:** " + ingestionErr.Fix
+	}
+
+	return message
+}
+
+// ingestionRetryMessage is the status of a packet whose attempt failed but will be tried again.
+func ingestionRetryMessage(fileName string, ingestionErr *IngestionError, attempts int) string {
+	return fmt.Sprintf(":warning: Attempt %d of %d to ingest %s failed: %s. It will be tried again automatically.", attempts, MaxIngestionAttempts, packetDescription(fileName), ingestionErr.Reason)
+}
+
+func packetDescription(fileName string) string {
+	if fileName == "" {
+		return "the attached support packet"
+	}
+
+	return fmt.Sprintf("support packet `%s`", fileName)
+}
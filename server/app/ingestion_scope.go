@@ -0,0 +1,136 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/config"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ingestionScope is where packets are ingested automatically, parsed from the plugin settings.
+// Teams and channels are matched by name or ID.
+type ingestionScope struct {
+	teams           []string
+	channels        []string
+	blockedChannels []string
+	directMessages  bool
+	botUserID       string
+}
+
+func newIngestionScope(configuration *config.Configuration) ingestionScope {
+	return ingestionScope{
+		teams:           splitSettingList(configuration.IngestionTeams),
+		channels:        splitSettingList(configuration.IngestionChannels),
+		blockedChannels: splitSettingList(configuration.IngestionBlockedChannels),
+		directMessages:  configuration.IngestionDirectMessages,
+		botUserID:       configuration.BotUserID,
+	}
+}
+
+// allows returns true if packets posted in the channel are ingested. The team is nil for direct
+// and group messages, which are only ingested when sent to the bot and direct messages are allowed.
+func (scope ingestionScope) allows(channel *model.Channel, team *model.Team) bool {
+	if channel.IsGroupOrDirect() {
+		return scope.directMessages && isDirectMessageWith(channel, scope.botUserID)
+	}
+
+	if matchesSetting(scope.blockedChannels, channel.Id, channel.Name) {
+		return false
+	}
+	if len(scope.teams) > 0 && (team == nil || !matchesSetting(scope.teams, team.Id, team.Name)) {
+		return false
+	}
+	if len(scope.channels) > 0 && !matchesSetting(scope.channels, channel.Id, channel.Name) {
+		return false
+	}
+
+	return true
+}
+
+// isInIngestionScope returns true if the attachments posted in the channel should be ingested.
+func isInIngestionScope(s *customerService, channelID string) bool {
+	channel, err := s.api.Channel.Get(channelID)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_id", channelID).Error("Failed to get channel to check the ingestion scope")
+		return false
+	}
+
+	var team *model.Team
+	if channel.TeamId != "" {
+		team, err = s.api.Team.Get(channel.TeamId)
+		if err != nil {
+			logrus.WithError(err).WithField("team_id", channel.TeamId).Error("Failed to get team to check the ingestion scope")
+			return false
+		}
+	}
+
+	return newIngestionScope(s.config.GetConfiguration()).allows(channel, team)
+}
+
+// postPacketResult posts a result of ingesting the upload, like the summary, in the thread of the
+// packet post. When a results channel is configured it's posted there instead, linking back to
+// the packet post, so customer details aren't shared with everyone in the uploader's channel.
+func postPacketResult(s *customerService, upload *SupportPacketUpload, post *model.Post) error {
+	resultsChannelID := s.config.GetConfiguration().ResultsChannelID
+	if resultsChannelID == "" {
+		post.ChannelId = upload.ChannelID
+		return s.poster.PostMessageToThread(upload.PostID, post)
+	}
+
+	channel, err := s.api.Channel.Get(resultsChannelID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get results channel '%s'", resultsChannelID)
+	}
+	// falling back to the packet's channel could share the results with the wrong audience
+	if channel.Type != model.ChannelTypePrivate {
+		return errors.Errorf("results channel '%s' is not a private channel", resultsChannelID)
+	}
+
+	header := fmt.Sprintf("Support packet `%s`", upload.FileName)
+	if user, err := s.api.User.Get(upload.UserID); err == nil {
+		header += " uploaded by @" + user.Username
+	}
+	if link := postPermalink(s, upload.PostID); link != "" {
+		header += fmt.Sprintf(", see the [packet post](%s)", link)
+	}
+
+	post.ChannelId = resultsChannelID
+	post.Message = header + "\n\n" + post.Message
+
+	return s.poster.Post(post)
+}
+
+// isDirectMessageWith returns true if the channel is a direct message between the user and someone else.
+func isDirectMessageWith(channel *model.Channel, userID string) bool {
+	if channel.Type != model.ChannelTypeDirect || userID == "" {
+		return false
+	}
+
+	userIDs := strings.Split(channel.Name, "__")
+	return len(userIDs) == 2 && userIDs[0] != userIDs[1] && containsString(userIDs, userID)
+}
+
+// splitSettingList splits a comma separated setting, dropping empty values.
+func splitSettingList(setting string) []string {
+	var values []string
+	for _, value := range strings.Split(setting, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func matchesSetting(values []string, id string, name string) bool {
+	for _, value := range values {
+		if value == id || strings.EqualFold(value, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/config"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestionScope(t *testing.T) {
+	support := &model.Team{Id: "teamsupport", Name: "support"}
+	sales := &model.Team{Id: "teamsales", Name: "sales"}
+	packets := &model.Channel{Id: "channelpackets", Name: "packets", TeamId: support.Id, Type: model.ChannelTypeOpen}
+	offTopic := &model.Channel{Id: "channeloff", Name: "off-topic", TeamId: support.Id, Type: model.ChannelTypeOpen}
+	salesPackets := &model.Channel{Id: "channelsales", Name: "packets", TeamId: sales.Id, Type: model.ChannelTypePrivate}
+	botDM := &model.Channel{Id: "channeldm", Name: "bot__user", Type: model.ChannelTypeDirect}
+	userDM := &model.Channel{Id: "channeluserdm", Name: "user__other", Type: model.ChannelTypeDirect}
+	group := &model.Channel{Id: "channelgroup", Name: "group", Type: model.ChannelTypeGroup}
+
+	t.Run("everything but direct messages by default", func(t *testing.T) {
+		scope := newIngestionScope(&config.Configuration{BotUserID: "bot"})
+		require.True(t, scope.allows(packets, support))
+		require.True(t, scope.allows(salesPackets, sales))
+		require.False(t, scope.allows(botDM, nil))
+		require.False(t, scope.allows(group, nil))
+	})
+
+	t.Run("allowed teams and channels by name or ID", func(t *testing.T) {
+		scope := newIngestionScope(&config.Configuration{
+			IngestionTeams:    " Support ,",
+			IngestionChannels: "packets, channeloff",
+		})
+		require.True(t, scope.allows(packets, support))
+		require.True(t, scope.allows(offTopic, support))
+		require.False(t, scope.allows(salesPackets, sales))
+	})
+
+	t.Run("blocked channels win", func(t *testing.T) {
+		scope := newIngestionScope(&config.Configuration{
+			IngestionChannels:        "packets,off-topic",
+			IngestionBlockedChannels: "off-topic",
+		})
+		require.True(t, scope.allows(packets, support))
+		require.False(t, scope.allows(offTopic, support))
+	})
+
+	t.Run("direct messages with the bot only", func(t *testing.T) {
+		scope := newIngestionScope(&config.Configuration{
+			BotUserID:               "bot",
+			IngestionTeams:          "support",
+			IngestionDirectMessages: true,
+		})
+		require.True(t, scope.allows(botDM, nil))
+		require.False(t, scope.allows(userDM, nil))
+		require.False(t, scope.allows(group, nil))
+	})
+}
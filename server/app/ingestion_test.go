@@ -0,0 +1,52 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestionBackoff(t *testing.T) {
+	require.Equal(t, 30*time.Second, ingestionBackoff(1))
+	require.Equal(t, time.Minute, ingestionBackoff(2))
+	require.Equal(t, 4*time.Minute, ingestionBackoff(4))
+	require.Equal(t, time.Hour, ingestionBackoff(20))
+}
+
+func TestIngestionErrors(t *testing.T) {
+	t.Run("extraction errors are explained", func(t *testing.T) {
+		tooLarge := extractionError(errors.Wrap(ErrPacketTooLarge, "archive is larger than 1 bytes"))
+		require.Equal(t, StageUnzip, tooLarge.Stage)
+		require.False(t, tooLarge.Retryable)
+		require.Contains(t, tooLarge.Reason, "archive is larger than 1 bytes")
+		require.True(t, errors.Is(tooLarge, ErrPacketTooLarge))
+
+		unsafe := extractionError(errors.Wrap(ErrPacketUnsafe, "invalid file name '../a'"))
+		require.Equal(t, "the zip contains file names that point outside of it", unsafe.Reason)
+	})
+
+	t.Run("unknown errors are retried", func(t *testing.T) {
+		ingestionErr := asIngestionError(StageStore, errors.New("connection refused"))
+		require.True(t, ingestionErr.Retryable)
+		require.Equal(t, StageStore, ingestionErr.Stage)
+
+		wrapped := errors.Wrap(extractionError(errors.New("zip: not a valid zip file")), "failed")
+		require.False(t, asIngestionError(StageStore, wrapped).Retryable)
+	})
+
+	t.Run("final attempt", func(t *testing.T) {
+		retryable := newRetryableIngestionError(StageStore, "saving failed", nil)
+		require.False(t, isFinalIngestionAttempt(IngestionJob{Attempts: 0}, retryable))
+		require.True(t, isFinalIngestionAttempt(IngestionJob{Attempts: MaxIngestionAttempts - 1}, retryable))
+		require.True(t, isFinalIngestionAttempt(IngestionJob{}, &IngestionError{Stage: StageParse}))
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		ingestionErr := &IngestionError{Stage: StageMatch, Reason: "it has no site URL", Fix: "Set the Site URL."}
+		require.Equal(t, ":x: Unable to ingest support packet `packet.zip`: it has no site URL.\n\n**How to fix it:** Set the Site URL.", ingestionFailureMessage("packet.zip", ingestionErr))
+		require.Equal(t, ":x: Unable to ingest the attached support packet: it has no site URL.\n\n**How to fix it:** Set the Site URL.", ingestionFailureMessage("", ingestionErr))
+		require.Equal(t, ":warning: Attempt 2 of 5 to ingest support packet `packet.zip` failed: it has no site URL. It will be tried again automatically.", ingestionRetryMessage("packet.zip", ingestionErr, 2))
+	})
+}
@@ -0,0 +1,419 @@
+package app
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxLogLineSize is the longest log line that will be parsed, longer lines are skipped.
+	maxLogLineSize = 1024 * 1024 // 1MB
+
+	// maxLogEntryGroups caps the number of distinct groups tracked per packet so a log full of
+	// unique messages can't grow without bound.
+	maxLogEntryGroups = 1000
+
+	// logTimelineBucket is the resolution of the occurrence timeline of each group.
+	logTimelineBucket = time.Hour
+
+	// topLogEntriesCount is how many of the most frequent log entries are shown in the summary.
+	topLogEntriesCount = 5
+)
+
+// supportPacketLogNames are the log files in a support packet that are analyzed.
+var supportPacketLogNames = []string{
+	"mattermost.log",
+	"notifications.log",
+	"notification.log",
+}
+
+// logTimestampLayouts are the timestamp formats used by the Mattermost JSON logs.
+var logTimestampLayouts = []string{
+	"2006-01-02 15:04:05.000 Z07:00",
+	"2006-01-02 15:04:05.000 -07:00",
+	time.RFC3339Nano,
+}
+
+// logNormalizers replace the variable parts of a log message so the same error is grouped
+// together regardless of the IDs, numbers or values in it. Order matters, the more specific
+// patterns run first.
+var logNormalizers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`https?://[^\s"',]+`), "<url>"},
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`\b[a-z0-9]{26}\b`), "<id>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{16,}\b`), "<hex>"},
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<value>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ms|s|m|h)?\b`), "<n>"},
+}
+
+// LogLevel is the level of a log line.
+type LogLevel string
+
+const (
+	LogLevelError LogLevel = "error"
+	LogLevelWarn  LogLevel = "warn"
+)
+
+// LogEntrySummary is a group of log lines with the same level, normalized message and caller.
+type LogEntrySummary struct {
+	Level   LogLevel `json:"level"`
+	Source  string   `json:"source"`
+	Message string   `json:"message"`
+	Caller  string   `json:"caller"`
+	Example string   `json:"example"`
+	Count   int      `json:"count"`
+
+	// FirstSeen and LastSeen are in milliseconds, zero if no line had a readable timestamp.
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen  int64 `json:"lastSeen"`
+
+	// Timeline is the number of occurrences per hour, keyed by the start of the hour in milliseconds.
+	Timeline map[int64]int `json:"timeline"`
+}
+
+// LogAnalysis is the aggregated view of the logs in a support packet.
+type LogAnalysis struct {
+	LinesRead int               `json:"linesRead"`
+	Truncated bool              `json:"truncated"`
+	Entries   []LogEntrySummary `json:"entries"`
+}
+
+// TopEntries returns the most frequent entries of the given level.
+func (a *LogAnalysis) TopEntries(level LogLevel, count int) []LogEntrySummary {
+	var top []LogEntrySummary
+	for _, entry := range a.Entries {
+		if entry.Level != level {
+			continue
+		}
+		top = append(top, entry)
+		if len(top) == count {
+			break
+		}
+	}
+
+	return top
+}
+
+// logLine is the subset of a Mattermost JSON log line used for analysis.
+type logLine struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Msg       string `json:"msg"`
+	Caller    string `json:"caller"`
+}
+
+// logAnalyzer accumulates log lines into groups.
+type logAnalyzer struct {
+	analysis *LogAnalysis
+	groups   map[string]*LogEntrySummary
+}
+
+func newLogAnalyzer() *logAnalyzer {
+	return &logAnalyzer{
+		analysis: &LogAnalysis{},
+		groups:   make(map[string]*LogEntrySummary),
+	}
+}
+
+// isSupportPacketLog returns true for the log files in the archive that are analyzed.
+func isSupportPacketLog(name string) bool {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	for _, logName := range supportPacketLogNames {
+		if base == logName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// analyzeSupportPacketLogs streams the log files of the archive, grouping the errors and
+// warnings. Returns nil if the packet has no logs. The logs are held to the same limits as the
+// extracted packet files, each is read up to MaxPacketEntrySize and all of them together up to
+// MaxPacketTotalSize, anything past that is ignored.
+func analyzeSupportPacketLogs(archive *zip.Reader) (*LogAnalysis, error) {
+	analyzer := newLogAnalyzer()
+	found := false
+	var totalSize int64
+
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !isSafeEntryName(file.Name) || !isSupportPacketLog(file.Name) {
+			continue
+		}
+		found = true
+
+		if file.CompressedSize64 > 0 && file.UncompressedSize64/file.CompressedSize64 > MaxPacketCompressionRatio {
+			return nil, errors.Wrapf(ErrPacketTooLarge, "'%s' exceeds the maximum compression ratio of %d", file.Name, MaxPacketCompressionRatio)
+		}
+
+		limit := int64(MaxPacketEntrySize)
+		if remaining := MaxPacketTotalSize - totalSize; remaining < limit {
+			limit = remaining
+		}
+
+		read, err := analyzer.readLogFile(file, limit)
+		if err != nil {
+			return nil, err
+		}
+		totalSize += read
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return analyzer.result(), nil
+}
+
+// readLogFile reads at most limit bytes of the log file, returning how many were read.
+func (a *logAnalyzer) readLogFile(file *zip.File, limit int64) (int64, error) {
+	if limit <= 0 {
+		a.analysis.Truncated = true
+		return 0, nil
+	}
+
+	logFile, err := file.Open()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open '%s'", file.Name)
+	}
+	defer logFile.Close()
+
+	limited := &io.LimitedReader{R: logFile, N: limit}
+	if err := a.readLogLines(path.Base(file.Name), limited); err != nil {
+		return 0, errors.Wrapf(err, "failed to read '%s'", file.Name)
+	}
+
+	if limited.N <= 0 {
+		a.analysis.Truncated = true
+	}
+
+	return limit - limited.N, nil
+}
+
+// readLogLines parses every line of the reader, skipping lines that are too long or not JSON.
+func (a *logAnalyzer) readLogLines(source string, r io.Reader) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var line []byte
+	skipping := false
+
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if !skipping {
+			line = append(line, chunk...)
+			if len(line) > maxLogLineSize {
+				skipping = true
+				line = line[:0]
+			}
+		}
+
+		if isPrefix {
+			continue
+		}
+
+		if !skipping {
+			a.addLine(source, line)
+		}
+		line = line[:0]
+		skipping = false
+	}
+
+	return nil
+}
+
+func (a *logAnalyzer) addLine(source string, raw []byte) {
+	a.analysis.LinesRead++
+
+	var line logLine
+	if err := json.Unmarshal(raw, &line); err != nil {
+		return
+	}
+
+	level := normalizeLogLevel(line.Level)
+	if level == "" {
+		return
+	}
+
+	message := normalizeLogMessage(line.Msg)
+	key := string(level) + "|" + source + "|" + line.Caller + "|" + message
+
+	group, ok := a.groups[key]
+	if !ok {
+		if len(a.groups) >= maxLogEntryGroups {
+			return
+		}
+		group = &LogEntrySummary{
+			Level:    level,
+			Source:   source,
+			Message:  message,
+			Caller:   line.Caller,
+			Example:  line.Msg,
+			Timeline: make(map[int64]int),
+		}
+		a.groups[key] = group
+	}
+
+	group.Count++
+
+	timestamp, ok := parseLogTimestamp(line.Timestamp)
+	if !ok {
+		return
+	}
+
+	millis := timestamp.UnixMilli()
+	if group.FirstSeen == 0 || millis < group.FirstSeen {
+		group.FirstSeen = millis
+	}
+	if millis > group.LastSeen {
+		group.LastSeen = millis
+	}
+	group.Timeline[timestamp.Truncate(logTimelineBucket).UnixMilli()]++
+}
+
+// result returns the groups ordered by the most frequent first.
+func (a *logAnalyzer) result() *LogAnalysis {
+	entries := make([]LogEntrySummary, 0, len(a.groups))
+	for _, group := range a.groups {
+		entries = append(entries, *group)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Message < entries[j].Message
+	})
+
+	a.analysis.Entries = entries
+
+	return a.analysis
+}
+
+// normalizeLogLevel maps the level of a log line to the levels that are tracked, or returns an
+// empty string for levels that are ignored.
+func normalizeLogLevel(level string) LogLevel {
+	switch strings.ToLower(level) {
+	case "error", "critical", "fatal", "panic":
+		return LogLevelError
+	case "warn", "warning":
+		return LogLevelWarn
+	}
+
+	return ""
+}
+
+// normalizeLogMessage strips the variable parts of a message so similar lines group together.
+func normalizeLogMessage(message string) string {
+	for _, normalizer := range logNormalizers {
+		message = normalizer.pattern.ReplaceAllString(message, normalizer.replacement)
+	}
+
+	return strings.Join(strings.Fields(message), " ")
+}
+
+func parseLogTimestamp(timestamp string) (time.Time, bool) {
+	if timestamp == "" {
+		return time.Time{}, false
+	}
+
+	for _, layout := range logTimestampLayouts {
+		if parsed, err := time.Parse(layout, timestamp); err == nil {
+			return parsed.UTC(), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// logEntryKey identifies the same group across different snapshots.
+func logEntryKey(entry LogEntrySummary) string {
+	return string(entry.Level) + "|" + entry.Source + "|" + entry.Caller + "|" + entry.Message
+}
+
+// logSummaryMarkdown renders the most frequent errors and warnings. When previous entries are
+// given, each row shows how the count compares to the last uploaded packet.
+func logSummaryMarkdown(analysis *LogAnalysis, previous []LogEntrySummary) string {
+	if analysis == nil {
+		return ""
+	}
+
+	previousCounts := make(map[string]int)
+	for _, entry := range previous {
+		previousCounts[logEntryKey(entry)] = entry.Count
+	}
+
+	mdTable := "## Log Analysis\n"
+	mdTable += fmt.Sprintf("Read %d log lines", analysis.LinesRead)
+	if analysis.Truncated {
+		mdTable += fmt.Sprintf(", only the first %d MB of each log and %d MB in total were analyzed", MaxPacketEntrySize/1024/1024, MaxPacketTotalSize/1024/1024)
+	}
+	mdTable += ".\n\n"
+
+	for _, level := range []LogLevel{LogLevelError, LogLevelWarn} {
+		top := analysis.TopEntries(level, topLogEntriesCount)
+		if len(top) == 0 {
+			continue
+		}
+
+		title := "Errors"
+		if level == LogLevelWarn {
+			title = "Warnings"
+		}
+
+		mdTable += fmt.Sprintf("#### Top %s\n", title)
+		if previous == nil {
+			mdTable += "| Count | Message | Caller | Last Seen |\n| --- | --- | --- | --- |\n"
+		} else {
+			mdTable += "| Count | Message | Caller | Last Seen | Previous Packet |\n| --- | --- | --- | --- | --- |\n"
+		}
+
+		for _, entry := range top {
+			row := fmt.Sprintf("| %d | `%s` | %s | %s |", entry.Count, escapeMarkdownTableCell(entry.Message), escapeMarkdownTableCell(entry.Caller), formatLogTime(entry.LastSeen))
+			if previous != nil {
+				if count, ok := previousCounts[logEntryKey(entry)]; ok {
+					row += fmt.Sprintf(" %d |", count)
+				} else {
+					row += " new |"
+				}
+			}
+			mdTable += row + "\n"
+		}
+		mdTable += "\n"
+	}
+
+	return mdTable
+}
+
+func formatLogTime(millis int64) string {
+	if millis == 0 {
+		return "unknown"
+	}
+
+	return time.UnixMilli(millis).UTC().Format("2006-01-02 15:04 MST")
+}
+
+// escapeMarkdownTableCell keeps a value from breaking out of its table cell or code span.
+func escapeMarkdownTableCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	value = strings.ReplaceAll(value, "`", "'")
+	return strings.ReplaceAll(value, "\n", " ")
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLogMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"ids", "Unable to get channel id=8ohp3n1yx3dm5rmxbzd3nsibqo", "Unable to get channel id=<id>"},
+		{"numbers", "Request took 1532ms after 3 retries", "Request took <n> after <n> retries"},
+		{"ips", "dial tcp 10.0.0.12:5432: connection refused", "dial tcp <ip>: connection refused"},
+		{"urls", "Post https://push.mattermost.com/api/v1/send failed", "Post <url> failed"},
+		{"quoted", `failed to find user "bob"`, "failed to find user <value>"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, normalizeLogMessage(tc.message))
+		})
+	}
+}
+
+func TestAnalyzeSupportPacketLogs(t *testing.T) {
+	logLines := []string{
+		`{"timestamp":"2023-10-18 13:05:02.123 Z","level":"error","msg":"Unable to get channel id=8ohp3n1yx3dm5rmxbzd3nsibqo","caller":"app/channel.go:12"}`,
+		`{"timestamp":"2023-10-18 13:45:02.123 Z","level":"error","msg":"Unable to get channel id=a1hp3n1yx3dm5rmxbzd3nsibqo","caller":"app/channel.go:12"}`,
+		`{"timestamp":"2023-10-18 15:00:00.000 Z","level":"error","msg":"Unable to get channel id=b2hp3n1yx3dm5rmxbzd3nsibqo","caller":"app/channel.go:12"}`,
+		`{"timestamp":"2023-10-18 15:00:00.000 Z","level":"warn","msg":"Slow query took 2000ms","caller":"sqlstore/post.go:1"}`,
+		`{"timestamp":"2023-10-18 15:00:00.000 Z","level":"info","msg":"Server is starting","caller":"app/server.go:1"}`,
+		`not json at all`,
+	}
+
+	archive := makeZip(t, map[string]string{
+		"packet/support_packet.yaml": "",
+		"packet/mattermost.log":      strings.Join(logLines, "\n"),
+	})
+
+	analysis, err := analyzeSupportPacketLogs(archive)
+	require.NoError(t, err)
+	require.NotNil(t, analysis)
+	require.Equal(t, len(logLines), analysis.LinesRead)
+	require.Len(t, analysis.Entries, 2)
+
+	top := analysis.Entries[0]
+	require.Equal(t, LogLevelError, top.Level)
+	require.Equal(t, "Unable to get channel id=<id>", top.Message)
+	require.Equal(t, "app/channel.go:12", top.Caller)
+	require.Equal(t, "mattermost.log", top.Source)
+	require.Equal(t, 3, top.Count)
+	require.Len(t, top.Timeline, 2)
+	require.Less(t, top.FirstSeen, top.LastSeen)
+
+	require.Len(t, analysis.TopEntries(LogLevelWarn, 5), 1)
+
+	t.Run("summary compares with previous entries", func(t *testing.T) {
+		previous := []LogEntrySummary{top}
+		previous[0].Count = 10
+
+		summary := logSummaryMarkdown(analysis, previous)
+		require.Contains(t, summary, "#### Top Errors")
+		require.Contains(t, summary, "| 3 | `Unable to get channel id=<id>` | app/channel.go:12 | 2023-10-18 15:00 UTC | 10 |")
+		require.Contains(t, summary, "| new |")
+	})
+
+	t.Run("logs above the compression ratio are refused", func(t *testing.T) {
+		_, err := analyzeSupportPacketLogs(makeZip(t, map[string]string{
+			"mattermost.log": strings.Repeat("a", 10*1024*1024),
+		}))
+		require.ErrorIs(t, err, ErrPacketTooLarge)
+	})
+
+	t.Run("packet without logs", func(t *testing.T) {
+		analysis, err := analyzeSupportPacketLogs(makeZip(t, map[string]string{"support_packet.yaml": ""}))
+		require.NoError(t, err)
+		require.Nil(t, analysis)
+	})
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxPacketArchiveSize is the largest support packet zip that will be opened.
+	MaxPacketArchiveSize = 1024 * 1024 * 1024 // 1GB
+
+	// MaxPacketEntrySize is the largest uncompressed size allowed for a single extracted file.
+	MaxPacketEntrySize = 50 * 1024 * 1024 // 50MB
+
+	// MaxPacketTotalSize is the largest combined uncompressed size of the extracted files.
+	MaxPacketTotalSize = 100 * 1024 * 1024 // 100MB
+
+	// MaxPacketCompressionRatio is the highest uncompressed to compressed ratio allowed for an
+	// extracted file. Anything above it is treated as a zip bomb.
+	MaxPacketCompressionRatio = 100
+)
+
+// supportPacketMimeTypes are the MIME types a zip attachment can be reported as.
+var supportPacketMimeTypes = []string{
+	"application/zip",
+	"application/x-zip",
+	"application/x-zip-compressed",
+	"multipart/x-zip",
+}
+
+// supportPacketFile is a post attachment that has been confirmed to be a support packet.
+type supportPacketFile struct {
+	info    *model.FileInfo
+	archive *zip.Reader
+
+	// data is the whole archive and hash its SHA-256, used to archive and deduplicate the packet.
+	data sizedReaderAt
+	hash string
+}
+
+// isZipAttachment returns true if the file info looks like a zip archive by extension or MIME type.
+func isZipAttachment(info *model.FileInfo) bool {
+	if info == nil {
+		return false
+	}
+
+	if strings.EqualFold(strings.TrimPrefix(info.Extension, "."), "zip") {
+		return true
+	}
+
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(info.MimeType, ";")[0]))
+	for _, zipType := range supportPacketMimeTypes {
+		if mimeType == zipType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsSupportPacketFiles checks the central directory of the archive for the files
+// that make up a support packet. The support_packet.yaml is enough on its own, otherwise
+// both the config and plugin files need to be present to rule out unrelated zips that
+// happen to contain a plugins.json.
+func containsSupportPacketFiles(archive *zip.Reader) bool {
+	found := make(map[string]bool)
+	for _, file := range archive.File {
+		switch name := path.Base(file.Name); name {
+		case SupportPacketName, ConfigFileName, PluginFileName:
+			found[name] = true
+		}
+	}
+
+	if found[SupportPacketName] {
+		return true
+	}
+
+	return found[ConfigFileName] && found[PluginFileName]
+}
+
+// sizedReaderAt is satisfied by readers that can be used by zip without buffering, like bytes.Reader.
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// openZipArchive opens the archive from the given reader. Readers that already support random
+// access are used as is, anything else is buffered up to MaxPacketArchiveSize.
+func openZipArchive(r io.Reader) (*zip.Reader, error) {
+	readerAt, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(readerAt, readerAt.Size())
+}
+
+// readArchive returns a random access reader over the archive, buffering it if needed.
+func readArchive(r io.Reader) (sizedReaderAt, error) {
+	if readerAt, ok := r.(sizedReaderAt); ok {
+		if readerAt.Size() > MaxPacketArchiveSize {
+			return nil, errors.Wrapf(ErrPacketTooLarge, "archive is larger than %d bytes", MaxPacketArchiveSize)
+		}
+		return readerAt, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxPacketArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxPacketArchiveSize {
+		return nil, errors.Wrapf(ErrPacketTooLarge, "archive is larger than %d bytes", MaxPacketArchiveSize)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// hashArchive returns the hex encoded SHA-256 of the whole archive.
+func hashArchive(r sizedReaderAt) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(r, 0, r.Size())); err != nil {
+		return "", errors.Wrap(err, "failed to hash archive")
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashPacketContents returns the hex encoded SHA-256 of the extracted files. Unlike the archive
+// hash it is the same for a packet that was unzipped and zipped again.
+func hashPacketContents(files []*model.FileData) string {
+	sorted := make([]*model.FileData, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Filename < sorted[j].Filename
+	})
+
+	hash := sha256.New()
+	for _, file := range sorted {
+		fmt.Fprintf(hash, "%s\x00%d\x00", file.Filename, len(file.Body))
+		hash.Write(file.Body)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isSafeEntryName returns false for entry names that are absolute or traverse out of the archive root.
+func isSafeEntryName(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSupportPacketEntry returns true for the files in the archive that are parsed during ingestion.
+func isSupportPacketEntry(name string) bool {
+	switch path.Base(name) {
+	case SupportPacketName, ConfigFileName, PluginFileName, MetadataFileName:
+		return true
+	}
+
+	return false
+}
+
+// extractSupportPacket reads the files needed for ingestion out of the archive. Everything
+// else, like the server logs, is never decompressed. The sizes and compression ratio
+// reported in the central directory are checked before opening an entry, and the actual
+// number of bytes read is enforced as well in case the headers lie.
+func extractSupportPacket(archive *zip.Reader) ([]*model.FileData, error) {
+	var fileContents []*model.FileData
+	var totalSize int64
+
+	for _, file := range archive.File {
+		if !isSafeEntryName(file.Name) {
+			return nil, errors.Wrapf(ErrPacketUnsafe, "invalid file name '%s'", file.Name)
+		}
+
+		if file.FileInfo().IsDir() || !isSupportPacketEntry(file.Name) {
+			continue
+		}
+
+		if file.UncompressedSize64 > MaxPacketEntrySize {
+			return nil, errors.Wrapf(ErrPacketTooLarge, "'%s' is larger than %d bytes", file.Name, MaxPacketEntrySize)
+		}
+
+		if file.CompressedSize64 > 0 && file.UncompressedSize64/file.CompressedSize64 > MaxPacketCompressionRatio {
+			return nil, errors.Wrapf(ErrPacketTooLarge, "'%s' exceeds the maximum compression ratio of %d", file.Name, MaxPacketCompressionRatio)
+		}
+
+		remaining := MaxPacketTotalSize - totalSize
+		limit := int64(MaxPacketEntrySize)
+		if remaining < limit {
+			limit = remaining
+		}
+
+		contents, err := readZipEntry(file, limit)
+		if err != nil {
+			return nil, err
+		}
+		totalSize += int64(len(contents))
+
+		// the directory is kept so files from different cluster nodes don't overwrite each other
+		fileContents = append(fileContents, &model.FileData{
+			Filename: path.Clean(strings.ReplaceAll(file.Name, "\\", "/")),
+			Body:     contents,
+		})
+	}
+
+	return fileContents, nil
+}
+
+// readZipEntry reads at most limit bytes from the entry, erroring if there is more.
+func readZipEntry(file *zip.File, limit int64) ([]byte, error) {
+	zippedFile, err := file.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open '%s'", file.Name)
+	}
+	defer zippedFile.Close()
+
+	contents, err := io.ReadAll(io.LimitReader(zippedFile, limit+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read '%s'", file.Name)
+	}
+
+	if int64(len(contents)) > limit {
+		return nil, errors.Wrapf(ErrPacketTooLarge, "'%s' exceeds the extraction size limit", file.Name)
+	}
+
+	return contents, nil
+}
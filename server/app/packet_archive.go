@@ -0,0 +1,149 @@
+package app
+
+import (
+	"io"
+	"path"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// packetArchiveDirectory is where raw support packets are kept in the file store, under the
+// plugin's own directory.
+const packetArchiveDirectory = "packets"
+
+// fileBackend returns the server's file store. It's built on each use so changes to the file
+// settings are picked up without restarting the plugin.
+func (s *customerService) fileBackend() (filestore.FileBackend, error) {
+	config := s.api.Configuration.GetUnsanitizedConfig()
+	skipVerify := config.ServiceSettings.EnableInsecureOutgoingConnections != nil && *config.ServiceSettings.EnableInsecureOutgoingConnections
+
+	backend, err := filestore.NewFileBackend(filestore.NewFileBackendSettingsFromConfig(&config.FileSettings, false, skipVerify))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create file backend")
+	}
+
+	return backend, nil
+}
+
+// packetArchivePath is the file store path of a customer's packet. The archive hash is used as
+// the name since it's known before the snapshot is created, and duplicates aren't stored twice.
+func (s *customerService) packetArchivePath(customerID string, hash string) string {
+	return path.Join("plugins", s.config.GetManifest().Id, packetArchiveDirectory, customerID, hash+".zip")
+}
+
+// archivePacket copies the raw zip to the file store, returning the path and size written.
+func archivePacket(s *customerService, customerID string, packetFile *supportPacketFile) (string, int64, error) {
+	if packetFile.data == nil || packetFile.hash == "" {
+		return "", 0, errors.New("packet has no data to archive")
+	}
+
+	backend, err := s.fileBackend()
+	if err != nil {
+		return "", 0, err
+	}
+
+	archivePath := s.packetArchivePath(customerID, packetFile.hash)
+	written, err := backend.WriteFile(io.NewSectionReader(packetFile.data, 0, packetFile.data.Size()), archivePath)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to write packet to '%s'", archivePath)
+	}
+
+	return archivePath, written, nil
+}
+
+func (s *customerService) GetPacketArchive(customerID string, snapshotID string) (io.ReadSeekCloser, PacketSnapshot, error) {
+	snapshot, err := s.store.GetSnapshot(snapshotID)
+	if err != nil {
+		return nil, PacketSnapshot{}, err
+	}
+
+	if snapshot.CustomerID != customerID {
+		return nil, PacketSnapshot{}, errors.Wrapf(ErrNotFound, "snapshot '%s' does not belong to customer '%s'", snapshotID, customerID)
+	}
+	if snapshot.ArchivePath == "" {
+		return nil, PacketSnapshot{}, errors.Wrapf(ErrNotFound, "snapshot '%s' has no archived packet", snapshotID)
+	}
+
+	backend, err := s.fileBackend()
+	if err != nil {
+		return nil, PacketSnapshot{}, err
+	}
+
+	reader, err := backend.Reader(snapshot.ArchivePath)
+	if err != nil {
+		return nil, PacketSnapshot{}, errors.Wrapf(err, "failed to read archived packet '%s'", snapshot.ArchivePath)
+	}
+
+	return reader, snapshot, nil
+}
+
+func (s *customerService) PurgeExpiredPacketArchives() {
+	configuration := s.config.GetConfiguration()
+	if configuration.PacketRetentionDays <= 0 && configuration.PacketRetentionCount <= 0 {
+		return
+	}
+
+	snapshots, err := s.store.GetArchivedSnapshots()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get archived packets for retention")
+		return
+	}
+
+	expired := expiredPacketArchives(snapshots, model.GetMillis(), configuration.PacketRetentionDays, configuration.PacketRetentionCount)
+	if len(expired) == 0 {
+		return
+	}
+
+	backend, err := s.fileBackend()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get file backend for packet retention")
+		return
+	}
+
+	for _, snapshot := range expired {
+		logger := logrus.WithFields(logrus.Fields{
+			"snapshot_id": snapshot.ID,
+			"path":        snapshot.ArchivePath,
+		})
+
+		if err := backend.RemoveFile(snapshot.ArchivePath); err != nil {
+			exists, existsErr := backend.FileExists(snapshot.ArchivePath)
+			if existsErr != nil || exists {
+				logger.WithError(err).Warn("Failed to delete expired packet archive")
+				continue
+			}
+		}
+
+		if err := s.store.ClearSnapshotArchive(snapshot.ID); err != nil {
+			logger.WithError(err).Warn("Failed to unlink expired packet archive")
+		}
+	}
+
+	logrus.WithField("count", len(expired)).Info("Purged expired support packet archives")
+}
+
+// expiredPacketArchives returns the snapshots whose archives are older than the retention days,
+// or past the retention count for their customer. The snapshots must be grouped by customer with
+// the newest first, as returned by GetArchivedSnapshots. Zero disables either limit.
+func expiredPacketArchives(snapshots []PacketSnapshot, now int64, retentionDays int, retentionCount int) []PacketSnapshot {
+	var cutoff int64
+	if retentionDays > 0 {
+		cutoff = now - (time.Duration(retentionDays) * 24 * time.Hour).Milliseconds()
+	}
+
+	var expired []PacketSnapshot
+	kept := make(map[string]int)
+	for _, snapshot := range snapshots {
+		if (cutoff > 0 && snapshot.CreatedAt < cutoff) || (retentionCount > 0 && kept[snapshot.CustomerID] >= retentionCount) {
+			expired = append(expired, snapshot)
+			continue
+		}
+		kept[snapshot.CustomerID]++
+	}
+
+	return expired
+}
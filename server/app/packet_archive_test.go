@@ -0,0 +1,33 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiredPacketArchives(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	day := (24 * time.Hour).Milliseconds()
+
+	snapshots := []PacketSnapshot{
+		{ID: "a1", CustomerID: "a", CreatedAt: now - day},
+		{ID: "a2", CustomerID: "a", CreatedAt: now - 5*day},
+		{ID: "a3", CustomerID: "a", CreatedAt: now - 40*day},
+		{ID: "b1", CustomerID: "b", CreatedAt: now - 40*day},
+	}
+
+	ids := func(snapshots []PacketSnapshot) []string {
+		result := []string{}
+		for _, snapshot := range snapshots {
+			result = append(result, snapshot.ID)
+		}
+		return result
+	}
+
+	require.Empty(t, expiredPacketArchives(snapshots, now, 0, 0))
+	require.Equal(t, []string{"a3", "b1"}, ids(expiredPacketArchives(snapshots, now, 30, 0)))
+	require.Equal(t, []string{"a2", "a3"}, ids(expiredPacketArchives(snapshots, now, 0, 1)))
+	require.Equal(t, []string{"a3", "b1"}, ids(expiredPacketArchives(snapshots, now, 30, 2)))
+}
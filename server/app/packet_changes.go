@@ -0,0 +1,345 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/r3labs/diff"
+	"github.com/sirupsen/logrus"
+)
+
+// maxChangedConfigKeys caps the keys listed per config section in the reply.
+const maxChangedConfigKeys = 10
+
+// previousPacketValues are the customer's values as stored from the last packet, the baseline
+// the changes in a new upload are shown against. The parts are nil if the last packet didn't
+// include them.
+type previousPacketValues struct {
+	createdAt int64
+	packet    *CustomerPacketValues
+	config    *model.Config
+	plugins   []CustomerPluginValues
+}
+
+// UserCountChange is a user count that differs from the previous packet.
+type UserCountChange struct {
+	Label    string `json:"label"`
+	Previous int    `json:"previous"`
+	Current  int    `json:"current"`
+}
+
+// PluginVersionChange is a plugin running a different version than in the previous packet.
+type PluginVersionChange struct {
+	Plugin          CustomerPluginValues `json:"plugin"`
+	PreviousVersion string               `json:"previousVersion"`
+}
+
+// PacketChanges is what changed between the previous packet and a new upload.
+type PacketChanges struct {
+	// Since is when the previous packet was uploaded.
+	Since int64 `json:"since"`
+
+	// PreviousVersion and CurrentVersion are only set when the server version changed.
+	PreviousVersion string            `json:"previousVersion"`
+	CurrentVersion  string            `json:"currentVersion"`
+	UserCounts      []UserCountChange `json:"userCounts"`
+
+	PluginsAdded      []CustomerPluginValues `json:"pluginsAdded"`
+	PluginsRemoved    []CustomerPluginValues `json:"pluginsRemoved"`
+	PluginsUpgraded   []PluginVersionChange  `json:"pluginsUpgraded"`
+	PluginsDowngraded []PluginVersionChange  `json:"pluginsDowngraded"`
+
+	// ConfigKeys are the changed config keys, keyed by config section.
+	ConfigKeys map[string][]string `json:"configKeys"`
+}
+
+// loadPreviousPacketValues returns the environment's values from before the upload, or nil if no
+// packet has been uploaded for it yet.
+func loadPreviousPacketValues(s *customerService, customerID string, environmentID string) *previousPacketValues {
+	latest, ok := latestEnvironmentSnapshot(s, customerID, environmentID)
+	if !ok {
+		return nil
+	}
+
+	// compared against what the packets said, not the pinned values
+	info, err := s.store.GetEnvironmentInfo(environmentID)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get previous environment values.")
+		return nil
+	}
+
+	previous := &previousPacketValues{createdAt: latest.CreatedAt}
+	if latest.PacketAuditID != "" {
+		previous.packet = &info.PacketValues
+	}
+	if latest.ConfigAuditID != "" {
+		previous.config = &info.Config
+	}
+	if latest.PluginsAuditID != "" {
+		previous.plugins = info.Plugins
+	}
+
+	return previous
+}
+
+// latestEnvironmentSnapshot returns the packet uploaded last for the customer's environment, or
+// false if there is none.
+func latestEnvironmentSnapshot(s *customerService, customerID string, environmentID string) (PacketSnapshot, bool) {
+	snapshots, err := s.store.GetSnapshots(customerID)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get previous snapshots.")
+		return PacketSnapshot{}, false
+	}
+
+	// newest first
+	for _, snapshot := range snapshots {
+		if snapshot.EnvironmentID == environmentID {
+			return snapshot, true
+		}
+	}
+
+	return PacketSnapshot{}, false
+}
+
+// diffPacketUpload compares the upload to the previous values, returning nil if there are none
+// because it's the customer's first packet. Only the parts present in both are compared.
+func diffPacketUpload(previous *previousPacketValues, upload *SupportPacketUpload) *PacketChanges {
+	if previous == nil {
+		return nil
+	}
+
+	changes := &PacketChanges{
+		Since:      previous.createdAt,
+		ConfigKeys: make(map[string][]string),
+	}
+
+	if previous.packet != nil && upload.Packet != nil {
+		current := PacketValuesFromSupportPacket(upload.Packet)
+		if previous.packet.Version != current.Version {
+			changes.PreviousVersion = previous.packet.Version
+			changes.CurrentVersion = current.Version
+		}
+
+		for _, count := range []UserCountChange{
+			{"Active Users", previous.packet.ActiveUsers, current.ActiveUsers},
+			{"Daily Active Users", previous.packet.DailyActiveUsers, current.DailyActiveUsers},
+			{"Monthly Active Users", previous.packet.MonthlyActiveUsers, current.MonthlyActiveUsers},
+			{"Inactive Users", previous.packet.InactiveUserCount, current.InactiveUserCount},
+		} {
+			if count.Previous != count.Current {
+				changes.UserCounts = append(changes.UserCounts, count)
+			}
+		}
+	}
+
+	if previous.plugins != nil && upload.Plugins != nil {
+		diffPlugins(changes, previous.plugins, PluginValuesFromPluginsResponse(upload.Plugins))
+	}
+
+	if previous.config != nil && upload.Config != nil {
+		for _, key := range changedConfigKeys(previous.config, upload.Config) {
+			parts := strings.SplitN(key, ".", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			changes.ConfigKeys[parts[0]] = append(changes.ConfigKeys[parts[0]], parts[1])
+		}
+	}
+
+	return changes
+}
+
+func diffPlugins(changes *PacketChanges, previous []CustomerPluginValues, current []CustomerPluginValues) {
+	previousByID := make(map[string]CustomerPluginValues)
+	for _, plugin := range previous {
+		previousByID[plugin.PluginID] = plugin
+	}
+
+	currentIDs := make(map[string]bool)
+	for _, plugin := range current {
+		currentIDs[plugin.PluginID] = true
+
+		old, ok := previousByID[plugin.PluginID]
+		switch {
+		case !ok:
+			changes.PluginsAdded = append(changes.PluginsAdded, plugin)
+		case old.Version != plugin.Version:
+			change := PluginVersionChange{Plugin: plugin, PreviousVersion: old.Version}
+			if isDowngrade(old.Version, plugin.Version) {
+				changes.PluginsDowngraded = append(changes.PluginsDowngraded, change)
+			} else {
+				changes.PluginsUpgraded = append(changes.PluginsUpgraded, change)
+			}
+		}
+	}
+
+	for _, plugin := range previous {
+		if !currentIDs[plugin.PluginID] {
+			changes.PluginsRemoved = append(changes.PluginsRemoved, plugin)
+		}
+	}
+}
+
+// isDowngrade returns true if the current version is lower. Versions that can't be compared
+// are treated as upgrades.
+func isDowngrade(previous string, current string) bool {
+	previousVersion, err := semver.ParseTolerant(previous)
+	if err != nil {
+		return false
+	}
+	currentVersion, err := semver.ParseTolerant(current)
+	if err != nil {
+		return false
+	}
+
+	return currentVersion.LT(previousVersion)
+}
+
+// changedConfigKeys returns the sorted config keys that differ between the two configs. A setting
+// missing on one side, because it was added in a newer server version or left out of an older
+// packet, isn't reported when the other side still has the default value.
+func changedConfigKeys(previous *model.Config, current *model.Config) []string {
+	changelog, err := diff.Diff(previous, current)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to diff config.")
+		return nil
+	}
+
+	defaults := &model.Config{}
+	defaults.SetDefaults()
+	previousNonDefault := configPathSet(defaults, previous)
+	currentNonDefault := configPathSet(defaults, current)
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, change := range changelog {
+		fullPath := strings.Join(change.Path, ".")
+		if change.From == nil && !currentNonDefault[fullPath] {
+			continue
+		}
+		if change.To == nil && !previousNonDefault[fullPath] {
+			continue
+		}
+
+		// changes inside a list are reported once for the setting holding it
+		changePath := change.Path
+		for len(changePath) > 2 {
+			if _, err := strconv.Atoi(changePath[len(changePath)-1]); err != nil {
+				break
+			}
+			changePath = changePath[:len(changePath)-1]
+		}
+
+		key := strings.Join(changePath, ".")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// configPathSet returns the paths that differ between the two configs.
+func configPathSet(a *model.Config, b *model.Config) map[string]bool {
+	paths := make(map[string]bool)
+
+	changelog, err := diff.Diff(a, b)
+	if err != nil {
+		return paths
+	}
+	for _, change := range changelog {
+		paths[strings.Join(change.Path, ".")] = true
+	}
+
+	return paths
+}
+
+func (c PacketChanges) isEmpty() bool {
+	return c.CurrentVersion == "" && len(c.UserCounts) == 0 && len(c.PluginsAdded) == 0 &&
+		len(c.PluginsRemoved) == 0 && len(c.PluginsUpgraded) == 0 && len(c.PluginsDowngraded) == 0 &&
+		len(c.ConfigKeys) == 0
+}
+
+// packetChangesMarkdown renders the changes since the previous packet, or nothing for the first packet.
+func packetChangesMarkdown(changes *PacketChanges) string {
+	if changes == nil {
+		return ""
+	}
+
+	mdTable := fmt.Sprintf("## Since Last Packet on %s\n", formatLogTime(changes.Since))
+	if changes.isEmpty() {
+		return mdTable + "Nothing changed.\n\n"
+	}
+
+	if changes.CurrentVersion != "" {
+		mdTable += fmt.Sprintf("**Server Version:** `%s` → `%s`\n\n", changes.PreviousVersion, changes.CurrentVersion)
+	}
+
+	if len(changes.UserCounts) > 0 {
+		mdTable += "| Users | Before | After | Change |\n| --- | --- | --- | --- |\n"
+		for _, count := range changes.UserCounts {
+			mdTable += fmt.Sprintf("| %s | %d | %d | %+d |\n", count.Label, count.Previous, count.Current, count.Current-count.Previous)
+		}
+		mdTable += "\n"
+	}
+
+	if len(changes.PluginsAdded) > 0 || len(changes.PluginsRemoved) > 0 || len(changes.PluginsUpgraded) > 0 || len(changes.PluginsDowngraded) > 0 {
+		mdTable += "| Plugin | Change | Version |\n| --- | --- | --- |\n"
+		for _, plugin := range changes.PluginsAdded {
+			mdTable += fmt.Sprintf("| %s | Added | %s |\n", pluginName(plugin), plugin.Version)
+		}
+		for _, plugin := range changes.PluginsRemoved {
+			mdTable += fmt.Sprintf("| %s | Removed | %s |\n", pluginName(plugin), plugin.Version)
+		}
+		for _, change := range changes.PluginsUpgraded {
+			mdTable += fmt.Sprintf("| %s | Upgraded | %s → %s |\n", pluginName(change.Plugin), change.PreviousVersion, change.Plugin.Version)
+		}
+		for _, change := range changes.PluginsDowngraded {
+			mdTable += fmt.Sprintf("| %s | Downgraded | %s → %s |\n", pluginName(change.Plugin), change.PreviousVersion, change.Plugin.Version)
+		}
+		mdTable += "\n"
+	}
+
+	if len(changes.ConfigKeys) > 0 {
+		sections := make([]string, 0, len(changes.ConfigKeys))
+		for section := range changes.ConfigKeys {
+			sections = append(sections, section)
+		}
+		sort.Strings(sections)
+
+		mdTable += "| Config Section | Changed Keys |\n| --- | --- |\n"
+		for _, section := range sections {
+			keys := changes.ConfigKeys[section]
+			var listed []string
+			for _, key := range keys {
+				if len(listed) == maxChangedConfigKeys {
+					break
+				}
+				listed = append(listed, escapeMarkdownTableCell(key))
+			}
+			cell := "`" + strings.Join(listed, "`, `") + "`"
+			if len(keys) > len(listed) {
+				cell += fmt.Sprintf(" and %d more", len(keys)-len(listed))
+			}
+			mdTable += fmt.Sprintf("| %s | %s |\n", section, cell)
+		}
+		mdTable += "\n"
+	}
+
+	return mdTable
+}
+
+func pluginName(plugin CustomerPluginValues) string {
+	if plugin.Name == "" {
+		return plugin.PluginID
+	}
+
+	return plugin.Name
+}
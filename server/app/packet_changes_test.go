@@ -0,0 +1,80 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketChangesMarkdown(t *testing.T) {
+	previousConfig := &model.Config{}
+	previousConfig.SetDefaults()
+	previousConfig.ServiceSettings.SiteURL = model.NewString("https://old.test")
+
+	// an older packet leaves out settings that newer servers have, they shouldn't show as changed
+	previousConfig.ServiceSettings.EnableLocalMode = nil
+
+	currentConfig := &model.Config{}
+	currentConfig.SetDefaults()
+	currentConfig.ServiceSettings.SiteURL = model.NewString("https://new.test")
+	currentConfig.SqlSettings.DataSourceReplicas = []string{"replica1"}
+
+	previous := &previousPacketValues{
+		createdAt: 1700000000000,
+		packet: &CustomerPacketValues{
+			Version:     "9.2.0",
+			ActiveUsers: 100,
+		},
+		config: previousConfig,
+		plugins: []CustomerPluginValues{
+			{PluginID: "playbooks", Name: "Playbooks", Version: "1.38.0"},
+			{PluginID: "calls", Name: "Calls", Version: "0.21.0"},
+			{PluginID: "jira", Name: "Jira", Version: "4.0.0"},
+		},
+	}
+
+	upload := &SupportPacketUpload{
+		Packet: &model.SupportPacket{ServerVersion: "9.3.0", ActiveUsers: 120},
+		Config: currentConfig,
+		Plugins: &model.PluginsResponse{
+			Active: []*model.PluginInfo{
+				{Manifest: model.Manifest{Id: "playbooks", Name: "Playbooks", Version: "1.39.0"}},
+				{Manifest: model.Manifest{Id: "calls", Name: "Calls", Version: "0.20.0"}},
+				{Manifest: model.Manifest{Id: "github", Name: "GitHub", Version: "2.0.0"}},
+			},
+		},
+	}
+
+	md := packetChangesMarkdown(diffPacketUpload(previous, upload))
+	require.Contains(t, md, "## Since Last Packet on 2023-11-14 22:13 UTC")
+	require.Contains(t, md, "**Server Version:** `9.2.0` → `9.3.0`")
+	require.Contains(t, md, "| Active Users | 100 | 120 | +20 |")
+	require.NotContains(t, md, "Daily Active Users")
+	require.Contains(t, md, "| GitHub | Added | 2.0.0 |")
+	require.Contains(t, md, "| Jira | Removed | 4.0.0 |")
+	require.Contains(t, md, "| Playbooks | Upgraded | 1.38.0 → 1.39.0 |")
+	require.Contains(t, md, "| Calls | Downgraded | 0.21.0 → 0.20.0 |")
+	require.Contains(t, md, "| ServiceSettings | `SiteURL` |")
+	require.Contains(t, md, "| SqlSettings | `DataSourceReplicas` |")
+	require.NotContains(t, md, "EnableLocalMode")
+
+	t.Run("first packet has no changes section", func(t *testing.T) {
+		require.Empty(t, packetChangesMarkdown(diffPacketUpload(nil, upload)))
+	})
+
+	t.Run("nothing changed", func(t *testing.T) {
+		same := &previousPacketValues{
+			createdAt: 1700000000000,
+			packet:    PacketValuesFromSupportPacket(upload.Packet),
+			config:    currentConfig,
+			plugins:   PluginValuesFromPluginsResponse(upload.Plugins),
+		}
+		require.Contains(t, packetChangesMarkdown(diffPacketUpload(same, upload)), "Nothing changed.")
+	})
+
+	t.Run("parts missing from either packet are skipped", func(t *testing.T) {
+		md := packetChangesMarkdown(diffPacketUpload(&previousPacketValues{createdAt: 1700000000000, config: previousConfig}, &SupportPacketUpload{Packet: upload.Packet}))
+		require.Contains(t, md, "Nothing changed.")
+	})
+}
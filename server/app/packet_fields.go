@@ -0,0 +1,237 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PacketFieldSource is where the value of a packet field came from.
+type PacketFieldSource string
+
+const (
+	// PacketSourcePacket values were read from the support packet itself.
+	PacketSourcePacket PacketFieldSource = "packet"
+
+	// PacketSourceInferred values were derived from the config and plugins uploaded with the packet.
+	PacketSourceInferred PacketFieldSource = "inferred"
+
+	// PacketSourceManual values were entered by someone and are pinned over the packet values.
+	PacketSourceManual PacketFieldSource = "manual"
+)
+
+// PacketSources are the sources of the stored packet values by JSON field name. Fields that are
+// missing came from the packet.
+type PacketSources map[string]PacketFieldSource
+
+// Source returns where the stored value of the field came from.
+func (sources PacketSources) Source(field string) PacketFieldSource {
+	if source, ok := sources[field]; ok {
+		return source
+	}
+
+	return PacketSourcePacket
+}
+
+// PacketFieldPin is a value entered manually for a packet field. It's used in place of the value
+// stored from packets, which keeps being updated underneath it, until it expires or is removed.
+type PacketFieldPin struct {
+	Field    string          `json:"field"`
+	Value    json.RawMessage `json:"value"`
+	PinnedBy string          `json:"pinnedBy"`
+	PinnedAt int64           `json:"pinnedAt"`
+
+	// ExpiresAt is when the packet value is used again, zero if the pin never expires.
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// PacketField is the effective value of a packet field along with the value stored from packets,
+// so a manual value that disagrees with what the packets say can be shown.
+type PacketField struct {
+	Field       string            `json:"field"`
+	Value       interface{}       `json:"value"`
+	PacketValue interface{}       `json:"packetValue"`
+	Source      PacketFieldSource `json:"source"`
+
+	// Pin is the manual value in effect, if any.
+	Pin *PacketFieldPin `json:"pin"`
+
+	// Conflict is set when the pinned value differs from the packet value.
+	Conflict bool `json:"conflict"`
+}
+
+// packetFieldNames are the JSON names of the CustomerPacketValues fields, in declaration order,
+// and packetFieldIndex their index in the struct.
+var packetFieldNames, packetFieldIndex = indexPacketFields()
+
+func indexPacketFields() ([]string, map[string]int) {
+	valuesType := reflect.TypeOf(CustomerPacketValues{})
+	names := make([]string, 0, valuesType.NumField())
+	index := make(map[string]int, valuesType.NumField())
+	for i := 0; i < valuesType.NumField(); i++ {
+		name := strings.Split(valuesType.Field(i).Tag.Get("json"), ",")[0]
+		names = append(names, name)
+		index[name] = i
+	}
+
+	return names, index
+}
+
+// setPacketField decodes the JSON value into the field, which has to be of the field's type.
+func setPacketField(values *CustomerPacketValues, field string, value json.RawMessage) error {
+	i, ok := packetFieldIndex[field]
+	if !ok {
+		return errors.Wrapf(ErrInvalidPacketField, "unknown packet field '%s'", field)
+	}
+
+	target := reflect.ValueOf(values).Elem().Field(i)
+	if len(bytes.TrimSpace(value)) == 0 || bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		return errors.Wrapf(ErrInvalidPacketField, "packet field '%s' must have a value", field)
+	}
+	if err := json.Unmarshal(value, target.Addr().Interface()); err != nil {
+		return errors.Wrapf(ErrInvalidPacketField, "packet field '%s' must be a %s", field, target.Kind())
+	}
+
+	return nil
+}
+
+func packetFieldValue(values CustomerPacketValues, field string) interface{} {
+	return reflect.ValueOf(values).Field(packetFieldIndex[field]).Interface()
+}
+
+// ApplyPacketPins returns the stored values with the pinned ones in place of them.
+func ApplyPacketPins(stored CustomerPacketValues, pins []PacketFieldPin) CustomerPacketValues {
+	effective := stored
+	for _, pin := range pins {
+		if err := setPacketField(&effective, pin.Field, pin.Value); err != nil {
+			// pins are validated when they're set, only a change to the fields could get here
+			logrus.WithError(err).WithField("field", pin.Field).Warn("Ignoring invalid packet field pin.")
+		}
+	}
+
+	return effective
+}
+
+// NewPacketFields lists every packet field with its effective and stored value.
+func NewPacketFields(stored CustomerPacketValues, sources PacketSources, pins []PacketFieldPin) []PacketField {
+	pinsByField := make(map[string]PacketFieldPin, len(pins))
+	for _, pin := range pins {
+		pinsByField[pin.Field] = pin
+	}
+	effective := ApplyPacketPins(stored, pins)
+
+	fields := make([]PacketField, 0, len(packetFieldNames))
+	for _, name := range packetFieldNames {
+		field := PacketField{
+			Field:       name,
+			Value:       packetFieldValue(effective, name),
+			PacketValue: packetFieldValue(stored, name),
+			Source:      sources.Source(name),
+		}
+		if pin, ok := pinsByField[name]; ok {
+			pin := pin
+			field.Pin = &pin
+			field.Source = PacketSourceManual
+			field.Conflict = !reflect.DeepEqual(field.Value, field.PacketValue)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// PacketValueFields encodes every packet field of the values, by JSON name.
+func PacketValueFields(values CustomerPacketValues) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage, len(packetFieldNames))
+	for _, name := range packetFieldNames {
+		// the fields are strings, ints and bools, which always encode
+		value, _ := json.Marshal(packetFieldValue(values, name))
+		fields[name] = value
+	}
+
+	return fields
+}
+
+// ChangedPacketFields validates the fields and returns the ones whose value differs from the
+// current one.
+func ChangedPacketFields(current CustomerPacketValues, fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	changed := make(map[string]json.RawMessage)
+	for field, value := range fields {
+		edited := current
+		if err := setPacketField(&edited, field, value); err != nil {
+			return nil, err
+		}
+		if packetFieldValue(edited, field) != packetFieldValue(current, field) {
+			changed[field] = value
+		}
+	}
+
+	return changed, nil
+}
+
+// validatePinExpiry makes sure a pin doesn't expire before it's set, zero never expires.
+func validatePinExpiry(expiresAt int64) error {
+	if expiresAt != 0 && expiresAt <= model.GetMillis() {
+		return errors.Wrap(ErrInvalidPacketField, "the pin must expire in the future")
+	}
+
+	return nil
+}
+
+// UpdatePacketFields pins the fields whose value differs from the customer's current one, the
+// ones that were edited.
+func (s *customerService) UpdatePacketFields(customerID string, userID string, fields map[string]json.RawMessage, expiresAt int64) error {
+	if err := validatePinExpiry(expiresAt); err != nil {
+		return err
+	}
+
+	customer, err := s.store.GetCustomerByID(customerID)
+	if err != nil {
+		return err
+	}
+
+	changed, err := ChangedPacketFields(customer.PacketValues, fields)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return s.store.PinPacketFields(customerID, userID, changed, expiresAt)
+}
+
+// PinPacketField pins the value of a single field, even if it's the current one, so later packets
+// don't change it.
+func (s *customerService) PinPacketField(customerID string, userID string, field string, value json.RawMessage, expiresAt int64) error {
+	if err := validatePinExpiry(expiresAt); err != nil {
+		return err
+	}
+
+	var scratch CustomerPacketValues
+	if err := setPacketField(&scratch, field, value); err != nil {
+		return err
+	}
+	if _, err := s.store.GetCustomerByID(customerID); err != nil {
+		return err
+	}
+
+	return s.store.PinPacketFields(customerID, userID, map[string]json.RawMessage{field: value}, expiresAt)
+}
+
+func (s *customerService) UnpinPacketField(customerID string, userID string, field string) error {
+	if _, ok := packetFieldIndex[field]; !ok {
+		return errors.Wrapf(ErrInvalidPacketField, "unknown packet field '%s'", field)
+	}
+
+	return s.store.UnpinPacketField(customerID, userID, field)
+}
+
+func (s *customerService) GetPacketFields(customerID string) ([]PacketField, error) {
+	return s.store.GetPacketFields(customerID)
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketFieldPins(t *testing.T) {
+	stored := CustomerPacketValues{
+		Version:        "9.0.0",
+		ActiveUsers:    10,
+		DeploymentType: "docker",
+		SAMLProvider:   "Okta",
+	}
+	pins := []PacketFieldPin{
+		{Field: "deploymentType", Value: json.RawMessage(`"kubernetes"`), PinnedBy: "user1"},
+		{Field: "samlProvider", Value: json.RawMessage(`"Okta"`), PinnedBy: "user1"},
+	}
+
+	t.Run("effective values", func(t *testing.T) {
+		effective := ApplyPacketPins(stored, pins)
+		require.Equal(t, "kubernetes", effective.DeploymentType)
+		require.Equal(t, "Okta", effective.SAMLProvider)
+		require.Equal(t, "9.0.0", effective.Version)
+		require.Equal(t, "docker", stored.DeploymentType)
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		fields := NewPacketFields(stored, PacketSources{"samlProvider": PacketSourceInferred}, pins)
+		require.Len(t, fields, len(packetFieldNames))
+
+		byName := make(map[string]PacketField)
+		for _, field := range fields {
+			byName[field.Field] = field
+		}
+
+		require.Equal(t, PacketSourcePacket, byName["version"].Source)
+		require.Nil(t, byName["version"].Pin)
+		require.Equal(t, 10, byName["activeUsers"].Value)
+
+		deployment := byName["deploymentType"]
+		require.Equal(t, PacketSourceManual, deployment.Source)
+		require.Equal(t, "kubernetes", deployment.Value)
+		require.Equal(t, "docker", deployment.PacketValue)
+		require.True(t, deployment.Conflict)
+		require.Equal(t, "user1", deployment.Pin.PinnedBy)
+
+		require.False(t, byName["samlProvider"].Conflict)
+	})
+
+	t.Run("changed fields", func(t *testing.T) {
+		changed, err := ChangedPacketFields(stored, map[string]json.RawMessage{
+			"version":        json.RawMessage(`"9.0.0"`),
+			"activeUsers":    json.RawMessage(`12`),
+			"deploymentType": json.RawMessage(`"kubernetes"`),
+		})
+		require.NoError(t, err)
+		require.Len(t, changed, 2)
+		require.Contains(t, changed, "activeUsers")
+		require.Contains(t, changed, "deploymentType")
+
+		changed, err = ChangedPacketFields(stored, PacketValueFields(stored))
+		require.NoError(t, err)
+		require.Empty(t, changed)
+	})
+
+	t.Run("invalid fields", func(t *testing.T) {
+		for _, fields := range []map[string]json.RawMessage{
+			{"unknown": json.RawMessage(`"value"`)},
+			{"activeUsers": json.RawMessage(`"ten"`)},
+			{"mobileApp": json.RawMessage(`null`)},
+		} {
+			_, err := ChangedPacketFields(stored, fields)
+			require.ErrorIs(t, err, ErrInvalidPacketField)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		require.NoError(t, validatePinExpiry(0))
+		require.NoError(t, validatePinExpiry(model.GetMillis()+60000))
+		require.ErrorIs(t, validatePinExpiry(model.GetMillis()-1), ErrInvalidPacketField)
+	})
+}
+
+func TestPacketSourcesFromUpload(t *testing.T) {
+	config := &model.Config{}
+	config.SetDefaults()
+	config.MetricsSettings.Enable = model.NewBool(true)
+
+	sources := PacketSourcesFromUpload(&SupportPacketUpload{Config: config}, PacketSources{"deploymentType": PacketSourceInferred})
+	require.Equal(t, PacketSourceInferred, sources.Source("metrics"))
+	require.Equal(t, PacketSourceInferred, sources.Source("deploymentType"))
+	require.Equal(t, PacketSourcePacket, sources.Source("version"))
+}
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// productPlugins maps the plugins that make up Mattermost products to the product names stored
+// in ProductsInUse.
+var productPlugins = map[string]string{
+	"com.mattermost.calls": "calls",
+	"playbooks":            "playbooks",
+	"focalboard":           "boards",
+	"mattermost-ai":        "copilot",
+}
+
+// metricsPluginID is the plugin collecting the performance metrics in the server itself.
+const metricsPluginID = "mattermost-plugin-metrics"
+
+// samlVendors are matched against the host and path of the IdP URLs, first match wins.
+var samlVendors = []struct {
+	pattern string
+	name    string
+}{
+	{"okta.com", "Okta"},
+	{"oktapreview.com", "Okta"},
+	{"login.microsoftonline.com", "Microsoft Entra ID"},
+	{"sts.windows.net", "Microsoft Entra ID"},
+	{"/adfs/", "ADFS"},
+	{"onelogin.com", "OneLogin"},
+	{"accounts.google.com", "Google Workspace"},
+	{"auth0.com", "Auth0"},
+	{"pingidentity.com", "Ping Identity"},
+	{"pingone.com", "Ping Identity"},
+	{"jumpcloud.com", "JumpCloud"},
+	{"duosecurity.com", "Duo"},
+	{"/realms/", "Keycloak"},
+	{"keycloak", "Keycloak"},
+}
+
+// s3Hosts are matched against the S3 endpoint to tell where the server is hosted.
+var s3Hosts = []struct {
+	suffix  string
+	hosting string
+}{
+	{"amazonaws.com", "aws"},
+	{"storage.googleapis.com", "gcp"},
+	{"digitaloceanspaces.com", "digitalocean"},
+}
+
+// cloudDomain is the domain Mattermost Cloud workspaces are hosted under.
+const cloudDomain = ".cloud.mattermost.com"
+
+// clusterDeploymentType is inferred for servers running in high availability mode. How the
+// nodes are deployed isn't in the config, the Cloud deployment type is more specific.
+const clusterDeploymentType = "cluster"
+
+// packetInference holds the values the support packet doesn't include, inferred from the config
+// and plugins uploaded with it. Nil values couldn't be inferred, the packet has no evidence
+// either way.
+type packetInference struct {
+	metrics        *bool
+	metricService  *string
+	hostingType    *string
+	deploymentType *string
+	mobileApp      *bool
+	productsInUse  *string
+	samlProvider   *string
+}
+
+// inferPacketValues derives what it can from the config and plugins, either may be nil.
+func inferPacketValues(config *model.Config, plugins *model.PluginsResponse) packetInference {
+	var inference packetInference
+
+	if plugins != nil {
+		inference.productsInUse = model.NewString(productsInUse(plugins))
+		if isPluginActive(plugins, metricsPluginID) {
+			inference.metricService = model.NewString("Metrics plugin")
+		}
+	}
+
+	if config == nil {
+		return inference
+	}
+
+	if config.MetricsSettings.Enable != nil {
+		inference.metrics = model.NewBool(*config.MetricsSettings.Enable)
+		// the server only exposes Prometheus metrics, the plugin is more specific
+		if *config.MetricsSettings.Enable && inference.metricService == nil {
+			inference.metricService = model.NewString("Prometheus")
+		}
+	}
+
+	// push notifications can be off with the mobile app still in use, only being on tells
+	if isTrue(config.EmailSettings.SendPushNotifications) && stringValue(config.EmailSettings.PushNotificationServer) != "" {
+		inference.mobileApp = model.NewBool(true)
+	}
+
+	if config.SamlSettings.Enable != nil {
+		if *config.SamlSettings.Enable {
+			if vendor := samlVendor(stringValue(config.SamlSettings.IdpURL), stringValue(config.SamlSettings.IdpMetadataURL)); vendor != "" {
+				inference.samlProvider = model.NewString(vendor)
+			}
+		} else {
+			inference.samlProvider = model.NewString("")
+		}
+	}
+
+	if host := urlHost(stringValue(config.ServiceSettings.SiteURL)); strings.HasSuffix(host, cloudDomain) {
+		inference.hostingType = model.NewString("cloud")
+		inference.deploymentType = model.NewString("cloud")
+	} else {
+		if stringValue(config.FileSettings.DriverName) == model.ImageDriverS3 {
+			if hosting := s3Hosting(stringValue(config.FileSettings.AmazonS3Endpoint)); hosting != "" {
+				inference.hostingType = model.NewString(hosting)
+			}
+		}
+		// a single node could be deployed any way, only clustering tells
+		if isTrue(config.ClusterSettings.Enable) {
+			inference.deploymentType = model.NewString(clusterDeploymentType)
+		}
+	}
+
+	return inference
+}
+
+// inferableFields are the JSON names of the packet fields that can be inferred, the ones kept
+// from the previous values when the upload has no evidence for them.
+var inferableFields = []string{"metrics", "metricService", "hostingType", "deploymentType", "mobileApp", "productsInUse", "samlProvider"}
+
+// fields returns the JSON names of the packet fields that were inferred.
+func (inference packetInference) fields() map[string]bool {
+	return map[string]bool{
+		"metrics":        inference.metrics != nil,
+		"metricService":  inference.metricService != nil,
+		"hostingType":    inference.hostingType != nil,
+		"deploymentType": inference.deploymentType != nil,
+		"mobileApp":      inference.mobileApp != nil,
+		"productsInUse":  inference.productsInUse != nil,
+		"samlProvider":   inference.samlProvider != nil,
+	}
+}
+
+// apply sets the inferred values, keeping the previous ones for those the packet has no evidence
+// for. Previous may be nil.
+func (inference packetInference) apply(values *CustomerPacketValues, previous *CustomerPacketValues) {
+	if previous != nil {
+		values.Metrics = previous.Metrics
+		values.MetricService = previous.MetricService
+		values.HostingType = previous.HostingType
+		values.DeploymentType = previous.DeploymentType
+		values.MobileApp = previous.MobileApp
+		values.ProductsInUse = previous.ProductsInUse
+		values.SAMLProvider = previous.SAMLProvider
+	}
+
+	if inference.metrics != nil {
+		values.Metrics = *inference.metrics
+	}
+	if inference.metricService != nil {
+		values.MetricService = *inference.metricService
+	}
+	if inference.hostingType != nil {
+		values.HostingType = *inference.hostingType
+	}
+	if inference.deploymentType != nil {
+		values.DeploymentType = *inference.deploymentType
+	}
+	if inference.mobileApp != nil {
+		values.MobileApp = *inference.mobileApp
+	}
+	if inference.productsInUse != nil {
+		values.ProductsInUse = *inference.productsInUse
+	}
+	if inference.samlProvider != nil {
+		values.SAMLProvider = *inference.samlProvider
+	}
+}
+
+// PacketValuesFromUpload converts the uploaded packet into the values stored for a customer,
+// inferring the ones the support packet doesn't include from the config and plugins uploaded
+// with it. Values the upload has no evidence for are taken from previous, the customer's values
+// before the upload, if it's set.
+func PacketValuesFromUpload(upload *SupportPacketUpload, previous *CustomerPacketValues) *CustomerPacketValues {
+	values := PacketValuesFromSupportPacket(upload.Packet)
+	inferPacketValues(upload.Config, upload.Plugins).apply(values, previous)
+
+	return values
+}
+
+// PacketSourcesFromUpload returns the sources of the values PacketValuesFromUpload stores. The
+// values kept from the previous ones keep their previous source.
+func PacketSourcesFromUpload(upload *SupportPacketUpload, previous PacketSources) PacketSources {
+	inferred := inferPacketValues(upload.Config, upload.Plugins).fields()
+
+	sources := PacketSources{}
+	for _, field := range inferableFields {
+		if inferred[field] {
+			sources[field] = PacketSourceInferred
+		} else if source, ok := previous[field]; ok {
+			sources[field] = source
+		}
+	}
+
+	return sources
+}
+
+// productsInUse lists the products whose plugins are active, comma separated.
+func productsInUse(plugins *model.PluginsResponse) string {
+	var products []string
+	for _, plugin := range plugins.Active {
+		if product, ok := productPlugins[plugin.Id]; ok && !containsString(products, product) {
+			products = append(products, product)
+		}
+	}
+	sort.Strings(products)
+
+	return strings.Join(products, ", ")
+}
+
+func isPluginActive(plugins *model.PluginsResponse, pluginID string) bool {
+	for _, plugin := range plugins.Active {
+		if plugin.Id == pluginID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// samlVendor guesses the identity provider from its URLs, empty if none is recognized.
+func samlVendor(urls ...string) string {
+	for _, vendor := range samlVendors {
+		for _, idpURL := range urls {
+			if idpURL != "" && strings.Contains(strings.ToLower(idpURL), vendor.pattern) {
+				return vendor.name
+			}
+		}
+	}
+
+	return ""
+}
+
+// s3Hosting returns where the S3 endpoint is hosted, empty for self hosted ones like MinIO.
+func s3Hosting(endpoint string) string {
+	host := urlHost(endpoint)
+	for _, s3Host := range s3Hosts {
+		if host == s3Host.suffix || strings.HasSuffix(host, "."+s3Host.suffix) {
+			return s3Host.hosting
+		}
+	}
+
+	return ""
+}
+
+// urlHost returns the lower case host of a URL, which may have no scheme like S3 endpoints.
+func urlHost(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
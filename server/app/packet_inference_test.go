@@ -0,0 +1,128 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferPacketValues(t *testing.T) {
+	newConfig := func() *model.Config {
+		config := &model.Config{}
+		config.SetDefaults()
+		return config
+	}
+
+	t.Run("nothing to infer from", func(t *testing.T) {
+		require.Equal(t, packetInference{}, inferPacketValues(nil, nil))
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		config := newConfig()
+		config.MetricsSettings.Enable = model.NewBool(true)
+
+		values := &CustomerPacketValues{}
+		inferPacketValues(config, nil).apply(values, nil)
+		require.True(t, values.Metrics)
+		require.Equal(t, "Prometheus", values.MetricService)
+
+		plugins := &model.PluginsResponse{Active: []*model.PluginInfo{{Manifest: model.Manifest{Id: metricsPluginID}}}}
+		values = &CustomerPacketValues{}
+		inferPacketValues(config, plugins).apply(values, nil)
+		require.Equal(t, "Metrics plugin", values.MetricService)
+	})
+
+	t.Run("saml vendor", func(t *testing.T) {
+		config := newConfig()
+		config.SamlSettings.Enable = model.NewBool(true)
+		config.SamlSettings.IdpURL = model.NewString("https://example.okta.com/app/mattermost/sso/saml")
+		require.Equal(t, "Okta", *inferPacketValues(config, nil).samlProvider)
+
+		config.SamlSettings.IdpURL = model.NewString("https://sso.example.com/adfs/ls/")
+		require.Equal(t, "ADFS", *inferPacketValues(config, nil).samlProvider)
+
+		// an unknown vendor isn't evidence against a manually entered one
+		config.SamlSettings.IdpURL = model.NewString("https://sso.example.com/saml")
+		require.Nil(t, inferPacketValues(config, nil).samlProvider)
+
+		config.SamlSettings.Enable = model.NewBool(false)
+		require.Equal(t, "", *inferPacketValues(config, nil).samlProvider)
+	})
+
+	t.Run("mobile app", func(t *testing.T) {
+		config := newConfig()
+		config.EmailSettings.SendPushNotifications = model.NewBool(true)
+		config.EmailSettings.PushNotificationServer = model.NewString(model.MHPNS)
+		require.True(t, *inferPacketValues(config, nil).mobileApp)
+
+		config.EmailSettings.SendPushNotifications = model.NewBool(false)
+		require.Nil(t, inferPacketValues(config, nil).mobileApp)
+	})
+
+	t.Run("products", func(t *testing.T) {
+		plugins := &model.PluginsResponse{
+			Active: []*model.PluginInfo{
+				{Manifest: model.Manifest{Id: "playbooks"}},
+				{Manifest: model.Manifest{Id: "com.mattermost.calls"}},
+				{Manifest: model.Manifest{Id: "com.github.plugin"}},
+			},
+			Inactive: []*model.PluginInfo{{Manifest: model.Manifest{Id: "focalboard"}}},
+		}
+		require.Equal(t, "calls, playbooks", *inferPacketValues(nil, plugins).productsInUse)
+	})
+
+	t.Run("hosting", func(t *testing.T) {
+		config := newConfig()
+		config.FileSettings.DriverName = model.NewString(model.ImageDriverS3)
+		config.FileSettings.AmazonS3Endpoint = model.NewString("s3.us-east-1.amazonaws.com")
+		require.Equal(t, "aws", *inferPacketValues(config, nil).hostingType)
+
+		config.FileSettings.AmazonS3Endpoint = model.NewString("minio.internal:9000")
+		require.Nil(t, inferPacketValues(config, nil).hostingType)
+
+		config.ServiceSettings.SiteURL = model.NewString("https://acme.cloud.mattermost.com")
+		inference := inferPacketValues(config, nil)
+		require.Equal(t, "cloud", *inference.hostingType)
+		require.Equal(t, "cloud", *inference.deploymentType)
+	})
+
+	t.Run("clustered deployment", func(t *testing.T) {
+		config := newConfig()
+		config.ClusterSettings.Enable = model.NewBool(false)
+		require.Nil(t, inferPacketValues(config, nil).deploymentType)
+
+		config.ClusterSettings.Enable = model.NewBool(true)
+		require.Equal(t, clusterDeploymentType, *inferPacketValues(config, nil).deploymentType)
+
+		config.ServiceSettings.SiteURL = model.NewString("https://acme.cloud.mattermost.com")
+		require.Equal(t, "cloud", *inferPacketValues(config, nil).deploymentType)
+	})
+
+	t.Run("keeps previous values without evidence", func(t *testing.T) {
+		previous := &CustomerPacketValues{
+			HostingType:    "azure",
+			DeploymentType: "kubernetes",
+			MobileApp:      true,
+			SAMLProvider:   "Shibboleth",
+			ProductsInUse:  "calls",
+		}
+
+		config := newConfig()
+		config.SamlSettings.Enable = model.NewBool(false)
+
+		values := PacketValuesFromUpload(&SupportPacketUpload{
+			Packet:  &model.SupportPacket{ServerVersion: "9.0.0"},
+			Config:  config,
+			Plugins: &model.PluginsResponse{},
+		}, previous)
+
+		require.Equal(t, "9.0.0", values.Version)
+		require.Equal(t, "azure", values.HostingType)
+		require.Equal(t, "kubernetes", values.DeploymentType)
+		require.True(t, values.MobileApp)
+		// contradicted by the packet
+		require.Equal(t, "", values.SAMLProvider)
+		require.Equal(t, "", values.ProductsInUse)
+	})
+}
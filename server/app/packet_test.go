@@ -0,0 +1,185 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func makeZip(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+
+	archive, err := openZipArchive(bytes.NewReader(makeZipData(t, files)))
+	require.NoError(t, err)
+
+	return archive
+}
+
+func makeZipData(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+	for name, contents := range files {
+		f, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
+
+func TestIsZipAttachment(t *testing.T) {
+	testCases := []struct {
+		name     string
+		info     *model.FileInfo
+		expected bool
+	}{
+		{"nil info", nil, false},
+		{"zip extension", &model.FileInfo{Extension: "zip"}, true},
+		{"upper case extension", &model.FileInfo{Extension: "ZIP"}, true},
+		{"zip mime type", &model.FileInfo{Extension: "bin", MimeType: "application/zip"}, true},
+		{"windows zip mime type", &model.FileInfo{MimeType: "application/x-zip-compressed"}, true},
+		{"screenshot", &model.FileInfo{Extension: "png", MimeType: "image/png"}, false},
+		{"pdf", &model.FileInfo{Extension: "pdf", MimeType: "application/pdf"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, isZipAttachment(tc.info))
+		})
+	}
+}
+
+func TestContainsSupportPacketFiles(t *testing.T) {
+	t.Run("full packet", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"mattermost_support_packet/support_packet.yaml":   "",
+			"mattermost_support_packet/sanitized_config.json": "{}",
+			"mattermost_support_packet/plugins.json":          "{}",
+		})
+		require.True(t, containsSupportPacketFiles(archive))
+	})
+
+	t.Run("only support_packet.yaml", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{"support_packet.yaml": ""})
+		require.True(t, containsSupportPacketFiles(archive))
+	})
+
+	t.Run("config and plugins without packet", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"sanitized_config.json": "{}",
+			"plugins.json":          "{}",
+		})
+		require.True(t, containsSupportPacketFiles(archive))
+	})
+
+	t.Run("unrelated zip with a plugins.json", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"plugin/plugins.json": "{}",
+			"plugin/server.go":    "package main",
+		})
+		require.False(t, containsSupportPacketFiles(archive))
+	})
+
+	t.Run("empty zip", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{})
+		require.False(t, containsSupportPacketFiles(archive))
+	})
+}
+
+func TestExtractSupportPacket(t *testing.T) {
+	t.Run("only extracts the packet files", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"packet/support_packet.yaml":   "server_version: 9.3.0",
+			"packet/sanitized_config.json": "{}",
+			"packet/plugins.json":          "{}",
+			"packet/mattermost.log":        "{}",
+		})
+
+		files, err := extractSupportPacket(archive)
+		require.NoError(t, err)
+		require.Len(t, files, 3)
+		for _, file := range files {
+			require.NotEqual(t, "mattermost.log", file.Filename)
+		}
+	})
+
+	t.Run("rejects path traversal", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"../support_packet.yaml": "",
+		})
+
+		_, err := extractSupportPacket(archive)
+		require.ErrorIs(t, err, ErrPacketUnsafe)
+	})
+
+	t.Run("rejects absolute paths", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"/etc/support_packet.yaml": "",
+		})
+
+		_, err := extractSupportPacket(archive)
+		require.ErrorIs(t, err, ErrPacketUnsafe)
+	})
+
+	t.Run("rejects highly compressed entries", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"support_packet.yaml": strings.Repeat("a", 10*1024*1024),
+		})
+
+		_, err := extractSupportPacket(archive)
+		require.ErrorIs(t, err, ErrPacketTooLarge)
+	})
+
+	t.Run("ignores large logs", func(t *testing.T) {
+		archive := makeZip(t, map[string]string{
+			"support_packet.yaml": "server_version: 9.3.0",
+			"mattermost.log":      strings.Repeat("a", 10*1024*1024),
+		})
+
+		files, err := extractSupportPacket(archive)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+	})
+}
+
+func TestIsSafeEntryName(t *testing.T) {
+	require.True(t, isSafeEntryName("packet/support_packet.yaml"))
+	require.True(t, isSafeEntryName("node..1/support_packet.yaml"))
+	require.False(t, isSafeEntryName("packet/../../support_packet.yaml"))
+	require.False(t, isSafeEntryName("..\\support_packet.yaml"))
+	require.False(t, isSafeEntryName("C:\\support_packet.yaml"))
+	require.False(t, isSafeEntryName(""))
+}
+
+func TestHashPacketContents(t *testing.T) {
+	files := []*model.FileData{
+		{Filename: "packet/support_packet.yaml", Body: []byte("server_version: 9.3.0")},
+		{Filename: "packet/plugins.json", Body: []byte("{}")},
+	}
+	reordered := []*model.FileData{files[1], files[0]}
+
+	require.Equal(t, hashPacketContents(files), hashPacketContents(reordered))
+
+	changed := []*model.FileData{
+		{Filename: "packet/support_packet.yaml", Body: []byte("server_version: 9.4.0")},
+		files[1],
+	}
+	require.NotEqual(t, hashPacketContents(files), hashPacketContents(changed))
+}
+
+func TestHashArchive(t *testing.T) {
+	first, err := hashArchive(bytes.NewReader([]byte("archive")))
+	require.NoError(t, err)
+	second, err := hashArchive(bytes.NewReader([]byte("archive")))
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+	require.Len(t, first, 64)
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PacketUploadOptions are the optional values of a support packet uploaded through the API.
+type PacketUploadOptions struct {
+	// CustomerID is who the packet is stored for, it's matched like posted packets when empty.
+	CustomerID string
+
+	// EnvironmentID is the environment the packet is stored for, implying its customer. It's
+	// resolved like posted packets when empty.
+	EnvironmentID string
+
+	TicketRef string
+}
+
+// PacketUploadResult is what was stored from a support packet uploaded through the API.
+type PacketUploadResult struct {
+	CustomerID string `json:"customerID"`
+
+	// Duplicate is true if the packet was already ingested, Snapshot is the original then and
+	// nothing else is set.
+	Duplicate bool           `json:"duplicate"`
+	Snapshot  PacketSnapshot `json:"snapshot"`
+
+	Packet  *CustomerPacketValues  `json:"packet"`
+	Plugins []CustomerPluginValues `json:"plugins"`
+	Nodes   []CustomerNodeValues   `json:"nodes"`
+
+	// Missing lists the packet files that were absent or could not be parsed.
+	Missing  []string  `json:"missing"`
+	Findings []Finding `json:"findings"`
+
+	// Changes are what changed since the customer's previous packet, nil for the first one.
+	Changes *PacketChanges `json:"changes"`
+}
+
+// openUploadedPacket reads a support packet zip uploaded through the API. Unlike attachments,
+// zips that aren't support packets are errors.
+func openUploadedPacket(fileName string, data io.Reader) (*supportPacketFile, error) {
+	readerAt, err := readArchive(data)
+	if err != nil {
+		return nil, extractionError(err)
+	}
+
+	archive, err := zip.NewReader(readerAt, readerAt.Size())
+	if err != nil {
+		return nil, extractionError(err)
+	}
+
+	if !containsSupportPacketFiles(archive) {
+		return nil, &IngestionError{
+			Stage:  StageParse,
+			Reason: fmt.Sprintf("none of `%s`, `%s` or `%s` are in the zip", SupportPacketName, ConfigFileName, PluginFileName),
+			Fix:    "Generate a support packet from the System Console and upload the zip it downloads.",
+		}
+	}
+
+	hash, err := hashArchive(readerAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &supportPacketFile{
+		info: &model.FileInfo{
+			Name:      fileName,
+			Extension: "zip",
+			Size:      readerAt.Size(),
+		},
+		archive: archive,
+		data:    readerAt,
+		hash:    hash,
+	}, nil
+}
+
+// UploadSupportPacket ingests a support packet uploaded through the API, going through the same
+// parsing, matching and storage as posted packets. No customer is created and no one is asked to
+// choose one, ErrAmbiguousCustomer is returned instead when no customer matches clearly.
+func (s *customerService) UploadSupportPacket(userID string, fileName string, data io.Reader, opts PacketUploadOptions) (PacketUploadResult, error) {
+	packetFile, err := openUploadedPacket(fileName, data)
+	if err != nil {
+		return PacketUploadResult{}, err
+	}
+
+	prepared, err := preparePacketUpload(s, packetFile, userID)
+	if err != nil {
+		return PacketUploadResult{}, err
+	}
+	if prepared.duplicate != nil {
+		return PacketUploadResult{
+			CustomerID: prepared.duplicate.CustomerID,
+			Duplicate:  true,
+			Snapshot:   *prepared.duplicate,
+		}, nil
+	}
+
+	upload := prepared.upload
+	upload.TicketRef = opts.TicketRef
+
+	customerID := opts.CustomerID
+	if opts.EnvironmentID != "" {
+		environment, err := s.store.GetEnvironment(opts.EnvironmentID)
+		if err != nil {
+			return PacketUploadResult{}, err
+		}
+		if customerID != "" && environment.CustomerID != customerID {
+			return PacketUploadResult{}, errors.Wrapf(ErrNotFound, "customer '%s' has no environment '%s'", customerID, opts.EnvironmentID)
+		}
+		customerID = environment.CustomerID
+		upload.EnvironmentID = environment.ID
+	}
+
+	if customerID != "" {
+		if _, err = s.store.GetCustomerByID(customerID); err != nil {
+			return PacketUploadResult{}, err
+		}
+	} else {
+		customerID, err = matchUploadedPacket(s, upload.Identity)
+		if err != nil {
+			return PacketUploadResult{}, err
+		}
+	}
+
+	upload.ArchivePath, upload.ArchiveSize, err = archivePacket(s, customerID, packetFile)
+	if err != nil {
+		// the parsed values are still worth keeping without the raw zip
+		logrus.WithError(err).WithField("file_name", fileName).Warn("Failed to archive support packet.")
+	}
+
+	snapshotID, changes, err := completeUpload(s, customerID, upload, prepared.summary)
+	if err != nil {
+		return PacketUploadResult{}, err
+	}
+
+	snapshot, err := s.store.GetSnapshot(snapshotID)
+	if err != nil {
+		return PacketUploadResult{}, err
+	}
+
+	result := PacketUploadResult{
+		CustomerID: customerID,
+		Snapshot:   snapshot,
+		Nodes:      upload.Nodes,
+		Missing:    prepared.missing,
+		Findings:   upload.Findings,
+		Changes:    changes,
+	}
+	if upload.Packet != nil {
+		result.Packet = PacketValuesFromUpload(upload, nil)
+	}
+	if upload.Plugins != nil {
+		result.Plugins = PluginValuesFromPluginsResponse(upload.Plugins)
+	}
+
+	return result, nil
+}
+
+// matchUploadedPacket returns the customer the packet clearly belongs to.
+func matchUploadedPacket(s *customerService, identity PacketIdentity) (string, error) {
+	if err := requireIdentity(identity); err != nil {
+		return "", err
+	}
+
+	candidates, err := s.store.MatchCustomers(identity)
+	if err != nil {
+		return "", err
+	}
+
+	customer, ok := ResolveCustomerMatch(candidates)
+	if !ok {
+		if len(candidates) == 0 {
+			return "", errors.Wrapf(ErrNotFound, "no customer matches site URL '%s' or license holder '%s'", identity.SiteURL, identity.LicensedTo)
+		}
+
+		names := make([]string, 0, len(candidates))
+		for _, candidate := range candidates {
+			names = append(names, fmt.Sprintf("%s (%s)", candidate.Name, candidate.ID))
+		}
+		return "", errors.Wrapf(ErrAmbiguousCustomer, "%d customers match the packet: %s", len(candidates), strings.Join(names, ", "))
+	}
+
+	return customer.ID, nil
+}
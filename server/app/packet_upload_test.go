@@ -0,0 +1,48 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenUploadedPacket(t *testing.T) {
+	t.Run("support packet", func(t *testing.T) {
+		data := makeZipData(t, map[string]string{SupportPacketName: "license_to: test"})
+
+		packetFile, err := openUploadedPacket("packet.zip", bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, "packet.zip", packetFile.info.Name)
+		require.Equal(t, int64(len(data)), packetFile.info.Size)
+		require.Len(t, packetFile.hash, 64)
+	})
+
+	t.Run("zip without packet files", func(t *testing.T) {
+		_, err := openUploadedPacket("other.zip", bytes.NewReader(makeZipData(t, map[string]string{"notes.txt": "hello"})))
+
+		var ingestionErr *IngestionError
+		require.True(t, errors.As(err, &ingestionErr))
+		require.Equal(t, StageParse, ingestionErr.Stage)
+		require.False(t, ingestionErr.Retryable)
+	})
+
+	t.Run("not a zip", func(t *testing.T) {
+		_, err := openUploadedPacket("packet.zip", strings.NewReader("not a zip"))
+
+		var ingestionErr *IngestionError
+		require.True(t, errors.As(err, &ingestionErr))
+		require.Equal(t, StageUnzip, ingestionErr.Stage)
+	})
+}
+
+func TestRequireIdentity(t *testing.T) {
+	require.NoError(t, requireIdentity(PacketIdentity{SiteURL: "https://a.test"}))
+	require.NoError(t, requireIdentity(PacketIdentity{LicensedTo: "Acme"}))
+
+	var ingestionErr *IngestionError
+	require.True(t, errors.As(requireIdentity(PacketIdentity{TelemetryID: "server1"}), &ingestionErr))
+	require.Equal(t, StageMatch, ingestionErr.Stage)
+}
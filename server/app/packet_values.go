@@ -0,0 +1,55 @@
+package app
+
+import "github.com/mattermost/mattermost/server/public/model"
+
+// PacketValuesFromSupportPacket converts the raw support packet into the values stored for a customer.
+func PacketValuesFromSupportPacket(rawPacket *model.SupportPacket) *CustomerPacketValues {
+	var packet CustomerPacketValues
+
+	packet.LicensedTo = rawPacket.LicenseTo
+	packet.Version = rawPacket.ServerVersion
+	packet.ServerOS = rawPacket.ServerOS
+	packet.ServerArch = rawPacket.ServerArchitecture
+	packet.DatabaseType = rawPacket.DatabaseType
+	packet.DatabaseVersion = rawPacket.DatabaseVersion
+	packet.DatabaseSchemaVersion = rawPacket.DatabaseSchemaVersion
+	packet.FileDriver = rawPacket.FileDriver
+	packet.ActiveUsers = rawPacket.ActiveUsers
+	packet.DailyActiveUsers = rawPacket.DailyActiveUsers
+	packet.MonthlyActiveUsers = rawPacket.MonthlyActiveUsers
+	packet.InactiveUserCount = rawPacket.InactiveUserCount
+	packet.LicenseSupportedUsers = rawPacket.LicenseSupportedUsers
+	packet.TotalPosts = rawPacket.TotalPosts
+	packet.TotalChannels = rawPacket.TotalChannels
+	packet.TotalTeams = rawPacket.TotalTeams
+	packet.LDAPProvider = rawPacket.LdapVendorName
+	packet.ElasticServerVersion = rawPacket.ElasticServerVersion
+	return &packet
+}
+
+// PluginValuesFromPluginsResponse converts the raw plugins list into the values stored for a customer.
+func PluginValuesFromPluginsResponse(rawPlugins *model.PluginsResponse) []CustomerPluginValues {
+	var parsedPlugins []CustomerPluginValues
+
+	for _, plugin := range rawPlugins.Active {
+		parsedPlugins = append(parsedPlugins, CustomerPluginValues{
+			PluginID:    plugin.Id,
+			Version:     plugin.Version,
+			IsActive:    true,
+			Name:        plugin.Name,
+			HomePageURL: plugin.HomepageURL,
+		})
+	}
+
+	for _, plugin := range rawPlugins.Inactive {
+		parsedPlugins = append(parsedPlugins, CustomerPluginValues{
+			PluginID:    plugin.Id,
+			Version:     plugin.Version,
+			IsActive:    false,
+			Name:        plugin.Name,
+			HomePageURL: plugin.HomepageURL,
+		})
+	}
+
+	return parsedPlugins
+}
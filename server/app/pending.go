@@ -0,0 +1,307 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pendingArchiveDirectory holds the raw zips of pending uploads, in place of the customer ID.
+	pendingArchiveDirectory = "pending"
+
+	// maxPendingCandidates caps the customers offered in the prompt, most confident first.
+	maxPendingCandidates = 5
+
+	// pendingCustomerContextKey is the post action context key holding the chosen customer ID.
+	// An empty ID creates a new customer.
+	pendingCustomerContextKey = "customer_id"
+)
+
+// PendingUpload is a parsed support packet waiting for someone to pick the customer it belongs
+// to, because it matched none or several of them.
+type PendingUpload struct {
+	ID        string
+	CreatedAt int64
+
+	// PromptPostID is the bot reply with the post actions to choose the customer.
+	PromptPostID string
+
+	// CandidateIDs are the customers offered in the prompt.
+	CandidateIDs []string
+
+	// Summary is the markdown reply posted once the upload has been stored.
+	Summary string
+
+	Upload *SupportPacketUpload
+}
+
+// queuePendingUpload stores the upload until a customer is chosen and asks for one in the thread
+// of the packet post, or the results channel.
+func queuePendingUpload(s *customerService, packetFile *supportPacketFile, upload *SupportPacketUpload, summary string, candidates []CustomerMatch) error {
+	if len(candidates) > maxPendingCandidates {
+		candidates = candidates[:maxPendingCandidates]
+	}
+
+	pending, err := storePendingUpload(s, packetFile, upload, summary, candidates)
+	if err != nil {
+		return err
+	}
+	if pending.PromptPostID != "" {
+		// an earlier attempt already asked
+		return nil
+	}
+
+	prompt := &model.Post{}
+	model.ParseSlackAttachment(prompt, []*model.SlackAttachment{pendingUploadAttachment(s, pending.ID, upload, candidates)})
+
+	if err = postPacketResult(s, upload, prompt); err != nil {
+		return errors.Wrap(err, "failed to post customer prompt")
+	}
+
+	if err = s.store.SetPendingUploadPrompt(pending.ID, prompt.Id); err != nil {
+		logrus.WithError(err).WithField("pending_id", pending.ID).Warn("Failed to save customer prompt post.")
+	}
+
+	return nil
+}
+
+// storePendingUpload archives the packet under the pending directory and stores the upload with
+// the candidates offered for it. When an earlier attempt already stored the same zip of the post,
+// that pending upload is returned instead, so retrying doesn't leave a second one behind.
+func storePendingUpload(s *customerService, packetFile *supportPacketFile, upload *SupportPacketUpload, summary string, candidates []CustomerMatch) (PendingUpload, error) {
+	existing, err := s.store.GetPendingUploadForPacket(upload.PostID, upload.ArchiveHash)
+	if err == nil {
+		return existing, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return PendingUpload{}, err
+	}
+
+	upload.ArchivePath, upload.ArchiveSize, err = archivePacket(s, pendingArchiveDirectory, packetFile)
+	if err != nil {
+		logrus.WithError(err).WithField("file_id", packetFile.info.Id).Warn("Failed to archive support packet.")
+	}
+
+	pending := PendingUpload{
+		Summary: summary,
+		Upload:  upload,
+	}
+	for _, candidate := range candidates {
+		pending.CandidateIDs = append(pending.CandidateIDs, candidate.ID)
+	}
+
+	pending.ID, err = s.store.CreatePendingUpload(pending)
+	if err != nil {
+		return PendingUpload{}, err
+	}
+
+	return pending, nil
+}
+
+// pendingUploadAttachment lists the candidate customers with a button for each, and one to create a new customer.
+func pendingUploadAttachment(s *customerService, pendingID string, upload *SupportPacketUpload, candidates []CustomerMatch) *model.SlackAttachment {
+	text := fmt.Sprintf("Support packet `%s` has site URL `%s` and is licensed to `%s`. ", upload.FileName, upload.Identity.SiteURL, upload.Identity.LicensedTo)
+	if len(candidates) == 0 {
+		text += "No customer matches it, should a new one be created?"
+	} else {
+		text += "It matches more than one customer, which one does it belong to?\n\n"
+		text += "| Customer | Site URL | Licensed To | Last Updated | Confidence | Matched On |\n| --- | --- | --- | --- | --- | --- |\n"
+		for _, candidate := range candidates {
+			name := candidate.Name
+			if candidate.ArchivedAt != 0 {
+				name += " (archived)"
+			}
+			text += fmt.Sprintf("| %s | %s | %s | %s | %.0f%% | %s |\n", name, candidate.SiteURL, candidate.LicensedTo, formatLogTime(candidate.LastUpdated), candidate.Confidence*100, identifierTypeList(candidate.Matched))
+		}
+	}
+
+	url := fmt.Sprintf("/plugins/%s/api/v0/ingestion/pending/%s/resolve", s.config.GetManifest().Id, pendingID)
+	action := func(name string, customerID string, style string) *model.PostAction {
+		return &model.PostAction{
+			Type:  model.PostActionTypeButton,
+			Name:  name,
+			Style: style,
+			Integration: &model.PostActionIntegration{
+				URL:     url,
+				Context: map[string]interface{}{pendingCustomerContextKey: customerID},
+			},
+		}
+	}
+
+	var actions []*model.PostAction
+	for _, candidate := range candidates {
+		actions = append(actions, action(candidate.Name, candidate.ID, "default"))
+	}
+	actions = append(actions, action("Create new customer", "", "primary"))
+
+	return &model.SlackAttachment{
+		Title:   "Which customer is this support packet for?",
+		Text:    text,
+		Actions: actions,
+	}
+}
+
+func (s *customerService) GetPendingUploadChannel(pendingID string) (string, error) {
+	pending, err := s.store.GetPendingUpload(pendingID)
+	if err != nil {
+		return "", err
+	}
+
+	// the prompt goes to the results channel when there is one, not the packet's
+	if pending.PromptPostID != "" {
+		prompt, err := s.api.Post.GetPost(pending.PromptPostID)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get prompt of pending upload '%s'", pendingID)
+		}
+		return prompt.ChannelId, nil
+	}
+
+	return pending.Upload.ChannelID, nil
+}
+
+// ResolvePendingUpload stores the pending upload for the chosen customer, creating a new one
+// when customerID is empty, and replaces the prompt with who made the choice.
+func (s *customerService) ResolvePendingUpload(pendingID string, userID string, customerID string) error {
+	pending, err := s.store.GetPendingUpload(pendingID)
+	if err != nil {
+		return err
+	}
+	if customerID != "" && !containsString(pending.CandidateIDs, customerID) {
+		return errors.Wrapf(ErrNotFound, "customer '%s' was not offered for pending upload '%s'", customerID, pendingID)
+	}
+
+	return applyPendingUpload(s, pendingID, userID, customerID, nil)
+}
+
+// applyPendingUpload claims the pending upload and stores it for the customer, creating a new one
+// when customerID is empty. The edit function, if any, can change the upload before it's stored.
+// The pending upload is only deleted once it's stored, if that fails it's left for another try.
+func applyPendingUpload(s *customerService, pendingID string, userID string, customerID string, edit func(upload *SupportPacketUpload)) error {
+	// claiming makes sure only the first choice is applied when several people click at once
+	pending, err := s.store.ClaimPendingUpload(pendingID, userID)
+	if err != nil {
+		return err
+	}
+	upload := pending.Upload
+	if edit != nil {
+		edit(upload)
+	}
+
+	customerID, err = storePendingChoice(s, pending, customerID)
+	if err != nil {
+		if releaseErr := s.store.ReleasePendingUpload(pendingID); releaseErr != nil {
+			logrus.WithError(releaseErr).WithField("pending_id", pendingID).Error("Failed to release pending upload.")
+		}
+		return err
+	}
+
+	if err = s.store.DeletePendingUpload(pendingID); err != nil {
+		// still claimed, so it can't be stored twice
+		logrus.WithError(err).WithField("pending_id", pendingID).Warn("Failed to delete stored pending upload.")
+	}
+
+	updatePendingPrompt(s, pending.PromptPostID, userID, customerID)
+
+	return nil
+}
+
+// storePendingChoice stores the claimed pending upload for the customer, creating a new one when
+// customerID is empty, and returns the customer's ID. When storing fails, the customer it created
+// is deleted and the zip moved back so the pending upload is as it was.
+func storePendingChoice(s *customerService, pending PendingUpload, customerID string) (string, error) {
+	upload := pending.Upload
+	created := false
+	if customerID == "" {
+		var err error
+		customerID, err = s.store.CreateCustomer(Customer{SiteURL: upload.Identity.SiteURL, LicensedTo: upload.Identity.LicensedTo})
+		if err != nil {
+			return "", err
+		}
+		created = true
+	}
+
+	pendingPath := upload.ArchivePath
+	if pendingPath != "" {
+		upload.ArchivePath = moveArchivedPacket(s, pendingPath, s.packetArchivePath(customerID, upload.ArchiveHash))
+	}
+
+	_, _, err := completeUpload(s, customerID, upload, pending.Summary)
+	if err != nil {
+		if upload.ArchivePath != "" {
+			moveArchivedPacket(s, upload.ArchivePath, pendingPath)
+		}
+		if created {
+			if deleteErr := s.store.DeleteCustomer(customerID); deleteErr != nil {
+				logrus.WithError(deleteErr).WithField("customer_id", customerID).Warn("Failed to delete customer created for pending upload.")
+			}
+		}
+		return "", err
+	}
+
+	return customerID, nil
+}
+
+// moveArchivedPacket moves a pending packet's zip to the customer's directory, returning its new
+// path. The snapshot is unlinked from the zip if it can't be moved.
+func moveArchivedPacket(s *customerService, oldPath string, newPath string) string {
+	backend, err := s.fileBackend()
+	if err == nil {
+		err = backend.MoveFile(oldPath, newPath)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("path", oldPath).Warn("Failed to move pending support packet archive.")
+		return ""
+	}
+
+	return newPath
+}
+
+// updatePendingPrompt removes the buttons from the prompt and says who picked which customer.
+func updatePendingPrompt(s *customerService, promptPostID string, userID string, customerID string) {
+	if promptPostID == "" {
+		return
+	}
+
+	prompt, err := s.api.Post.GetPost(promptPostID)
+	if err != nil {
+		logrus.WithError(err).WithField("post_id", promptPostID).Warn("Failed to get customer prompt post.")
+		return
+	}
+
+	customerName := customerID
+	if customer, err := s.store.GetCustomerByID(customerID); err == nil {
+		customerName = customer.Name
+	}
+	username := userID
+	if user, err := s.api.User.Get(userID); err == nil {
+		username = "@" + user.Username
+	}
+
+	prompt.DelProp("attachments")
+	prompt.Message = fmt.Sprintf("%s added this support packet to **%s**.", username, customerName)
+	if err := s.api.Post.UpdatePost(prompt); err != nil {
+		logrus.WithError(err).WithField("post_id", promptPostID).Warn("Failed to update customer prompt post.")
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func identifierTypeList(types []IdentifierType) string {
+	names := make([]string, 0, len(types))
+	for _, identifierType := range types {
+		names = append(names, string(identifierType))
+	}
+
+	return strings.Join(names, ", ")
+}
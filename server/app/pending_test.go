@@ -0,0 +1,173 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// pendingStore keeps a single pending upload in memory, storing it fails while failStore is set.
+// Calls to anything else panic.
+type pendingStore struct {
+	CustomerStore
+
+	pending   *PendingUpload
+	claimedBy string
+	failStore bool
+	stored    []string
+	created   []string
+	deleted   []string
+}
+
+func (p *pendingStore) GetPendingUpload(id string) (PendingUpload, error) {
+	if p.pending == nil || p.pending.ID != id {
+		return PendingUpload{}, errors.Wrapf(ErrNotFound, "pending upload does not exist for id '%s'", id)
+	}
+
+	return *p.pending, nil
+}
+
+func (p *pendingStore) GetPendingUploadForPacket(postID string, archiveHash string) (PendingUpload, error) {
+	if p.pending == nil || p.pending.Upload.PostID != postID || p.pending.Upload.ArchiveHash != archiveHash {
+		return PendingUpload{}, errors.Wrapf(ErrNotFound, "no pending upload of archive '%s' in post '%s'", archiveHash, postID)
+	}
+
+	return *p.pending, nil
+}
+
+func (p *pendingStore) ClaimPendingUpload(id string, userID string) (PendingUpload, error) {
+	if p.claimedBy != "" {
+		return PendingUpload{}, errors.Wrapf(ErrNotFound, "pending upload '%s' was already claimed", id)
+	}
+	pending, err := p.GetPendingUpload(id)
+	if err != nil {
+		return PendingUpload{}, err
+	}
+	p.claimedBy = userID
+
+	// a copy, like it's read back from the database
+	upload := *pending.Upload
+	pending.Upload = &upload
+	return pending, nil
+}
+
+func (p *pendingStore) ReleasePendingUpload(id string) error {
+	p.claimedBy = ""
+	return nil
+}
+
+func (p *pendingStore) DeletePendingUpload(id string) error {
+	p.pending = nil
+	return nil
+}
+
+func (p *pendingStore) CreateCustomer(customer Customer) (string, error) {
+	id := customer.LicensedTo + "-new"
+	p.created = append(p.created, id)
+	return id, nil
+}
+
+func (p *pendingStore) DeleteCustomer(id string) error {
+	p.deleted = append(p.deleted, id)
+	return nil
+}
+
+func (p *pendingStore) GetCustomerByID(id string) (FullCustomerInfo, error) {
+	return FullCustomerInfo{Customer: Customer{ID: id}}, nil
+}
+
+func (p *pendingStore) ResolveEnvironment(customerID string, userID string, identity PacketIdentity) (Environment, error) {
+	return Environment{ID: customerID + "-production", CustomerID: customerID, Primary: true}, nil
+}
+
+func (p *pendingStore) GetSnapshots(customerID string) ([]PacketSnapshot, error) {
+	return nil, nil
+}
+
+func (p *pendingStore) UpdateCustomerThroughUpload(customerID string, upload *SupportPacketUpload) (string, error) {
+	if p.failStore {
+		return "", errors.New("connection refused")
+	}
+	p.stored = append(p.stored, customerID)
+	return "snapshot1", nil
+}
+
+func TestApplyPendingUpload(t *testing.T) {
+	newStore := func() *pendingStore {
+		return &pendingStore{
+			pending: &PendingUpload{
+				ID:           "pending1",
+				CandidateIDs: []string{"customer1", "customer2"},
+				Upload:       &SupportPacketUpload{FileName: "packet.zip", Identity: PacketIdentity{LicensedTo: "Acme"}},
+			},
+			failStore: true,
+		}
+	}
+
+	t.Run("a failed store leaves the upload to be resolved again", func(t *testing.T) {
+		store := newStore()
+		s := &customerService{store: store}
+
+		err := s.ResolvePendingUpload("pending1", "user1", "customer1")
+		var ingestionErr *IngestionError
+		require.ErrorAs(t, err, &ingestionErr)
+		require.Equal(t, StageStore, ingestionErr.Stage)
+		require.NotNil(t, store.pending)
+		require.Empty(t, store.claimedBy)
+
+		store.failStore = false
+		require.NoError(t, s.ResolvePendingUpload("pending1", "user2", "customer2"))
+		require.Equal(t, []string{"customer2"}, store.stored)
+		require.Nil(t, store.pending)
+
+		err = s.ResolvePendingUpload("pending1", "user1", "customer1")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("the customer created for a failed store is deleted", func(t *testing.T) {
+		store := newStore()
+		s := &customerService{store: store}
+
+		require.Error(t, s.ResolvePendingUpload("pending1", "user1", ""))
+		require.Equal(t, []string{"Acme-new"}, store.created)
+		require.Equal(t, []string{"Acme-new"}, store.deleted)
+		require.NotNil(t, store.pending)
+
+		store.failStore = false
+		require.NoError(t, s.ResolvePendingUpload("pending1", "user1", ""))
+		require.Equal(t, []string{"Acme-new"}, store.stored)
+		require.Equal(t, []string{"Acme-new"}, store.deleted)
+	})
+
+	t.Run("a claimed upload can't be resolved twice", func(t *testing.T) {
+		store := newStore()
+		store.claimedBy = "user2"
+		s := &customerService{store: store}
+
+		err := s.ResolvePendingUpload("pending1", "user1", "customer1")
+		require.ErrorIs(t, err, ErrNotFound)
+		require.Empty(t, store.stored)
+		require.Equal(t, "user2", store.claimedBy)
+	})
+}
+
+func TestStorePendingUpload(t *testing.T) {
+	t.Run("a retried upload reuses the pending upload", func(t *testing.T) {
+		store := &pendingStore{
+			pending: &PendingUpload{
+				ID:           "pending1",
+				PromptPostID: "prompt1",
+				Upload:       &SupportPacketUpload{PostID: "post1", ArchiveHash: "hash1"},
+			},
+		}
+		s := &customerService{store: store}
+
+		upload := &SupportPacketUpload{PostID: "post1", ArchiveHash: "hash1"}
+		pending, err := storePendingUpload(s, &supportPacketFile{hash: "hash1"}, upload, "summary", nil)
+		require.NoError(t, err)
+		require.Equal(t, "pending1", pending.ID)
+		require.Equal(t, "prompt1", pending.PromptPostID)
+		require.Empty(t, upload.ArchivePath)
+	})
+}
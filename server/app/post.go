@@ -2,14 +2,14 @@ package app
 
 import (
 	"archive/zip"
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"path/filepath"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
@@ -18,128 +18,264 @@ const (
 	SupportPacketName = "support_packet.yaml"
 	PluginFileName    = "plugins.json"
 	ConfigFileName    = "sanitized_config.json"
+	MetadataFileName  = "metadata.yaml"
 )
 
+// MessageHasBeenPosted queues the zip attachments of the post for ingestion if it was posted in
+// a channel allowed by the plugin settings. Downloading and parsing them is left to the ingestion
+// worker so the hook returns right away.
 func (s *customerService) MessageHasBeenPosted(post *model.Post) {
 	if s.poster.IsFromPoster(post) || post.RootId != "" || len(post.FileIds) == 0 {
 		return
 	}
+	if !isInIngestionScope(s, post.ChannelId) {
+		return
+	}
 
-	supportPackets, names := postContainsSupportPackage(s, post)
+	for _, fileID := range post.FileIds {
+		// attachments whose info can't be read are queued anyway, the worker tries them again
+		fileInfo, err := s.api.File.GetInfo(fileID)
+		if err == nil && !isZipAttachment(fileInfo) {
+			continue
+		}
 
-	if len(supportPackets) == 0 {
-		return
+		err = s.jobs.EnqueueIngestionJob(post.Id, fileID, post.ChannelId)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"post_id": post.Id,
+				"file_id": fileID,
+			}).Error("Failed to queue attachment for ingestion")
+		}
 	}
+}
 
-	err := s.poster.PostMessageToThread(post.Id, &model.Post{
-		ChannelId: post.ChannelId,
-		Message:   "Uploading support packet for " + strings.Join(names, " ,"),
-	})
+// openSupportPacketFile downloads the attachment and returns it if it's a support packet. Nil is
+// returned for attachments that aren't zip archives, or zips that don't contain the packet files.
+// Zips too large to open are refused before downloading them, anything else that fails is worth
+// trying again.
+func openSupportPacketFile(s *customerService, fileID string) (*supportPacketFile, error) {
+	fileInfo, err := s.api.File.GetInfo(fileID)
+	if err != nil {
+		return nil, newRetryableIngestionError(StageDownload, "the attachment couldn't be found", errors.Wrap(err, "failed to get file info"))
+	}
 
+	if !isZipAttachment(fileInfo) {
+		return nil, nil
+	}
+
+	if fileInfo.Size > MaxPacketArchiveSize {
+		return nil, &IngestionError{
+			Stage:  StageDownload,
+			Reason: fmt.Sprintf("the zip is %d MB, larger than the %d MB that can be opened", fileInfo.Size/1024/1024, MaxPacketArchiveSize/1024/1024),
+			Fix:    "Generate a new support packet, or remove the extra files from the zip, then upload it again.",
+			Err:    errors.Wrapf(ErrPacketTooLarge, "archive is larger than %d bytes", MaxPacketArchiveSize),
+		}
+	}
+
+	fileData, err := s.api.File.Get(fileInfo.Id)
 	if err != nil {
-		logrus.WithError(err).Error("Failed in sending reply")
+		return nil, newRetryableIngestionError(StageDownload, "the attachment couldn't be downloaded", errors.Wrap(err, "failed to download file"))
+	}
+
+	readerAt, err := readArchive(fileData)
+	if err != nil {
+		return nil, extractionError(err)
 	}
 
-	err = processSupportPackets(s, supportPackets, post)
+	archive, err := zip.NewReader(readerAt, readerAt.Size())
 	if err != nil {
-		logrus.WithError(err).Error("Failed processing packets")
+		logrus.WithError(err).WithField("file_id", fileID).Debug("Attachment is not a readable zip, skipping.")
+		return nil, nil
+	}
+
+	if !containsSupportPacketFiles(archive) {
+		return nil, nil
 	}
+
+	hash, err := hashArchive(readerAt)
+	if err != nil {
+		return nil, newRetryableIngestionError(StageDownload, "the attachment couldn't be read", err)
+	}
+
+	return &supportPacketFile{
+		info:    fileInfo,
+		archive: archive,
+		data:    readerAt,
+		hash:    hash,
+	}, nil
 }
 
-func postContainsSupportPackage(s *customerService, post *model.Post) ([]*model.FileInfo, []string) {
-	var supportPackets []*model.FileInfo
-	var names []string
+// parsedSupportPacket holds whichever parts of a support packet could be read. Older servers
+// and hand-built packets often only include some of the files, so every part is optional.
+type parsedSupportPacket struct {
+	packet  *model.SupportPacket
+	config  *model.Config
+	plugins *model.PluginsResponse
 
-	for _, id := range post.FileIds {
-		fileCheck, err := s.api.File.GetInfo(id)
-		if err != nil {
-			logrus.WithError(err).Error("Failure checking for support packet.")
-		}
-		supportPackets = append(supportPackets, fileCheck)
-		names = append(names, fileCheck.Name)
+	// metadata is only included by newer servers, it's not reported as missing.
+	metadata *packetMetadata
+
+	// nodes is only set for multi-node packets, the first node is used for the cluster level values.
+	nodes []*supportPacketNode
+
+	// missing lists the packet files that were absent or could not be parsed.
+	missing []string
+
+	// unparseable lists the paths of the files that were present but could not be parsed.
+	unparseable []string
+}
+
+// upload returns the parts of the packet to be stored.
+func (p *parsedSupportPacket) upload() *SupportPacketUpload {
+	upload := &SupportPacketUpload{
+		Packet:  p.packet,
+		Config:  p.config,
+		Plugins: p.plugins,
 	}
 
-	return supportPackets, names
+	for _, node := range p.nodes {
+		upload.Nodes = append(upload.Nodes, node.nodeValues())
+	}
+
+	return upload
 }
 
-func unzipToMemory(zippedBytes io.Reader) ([]*model.FileData, error) {
-	var fileContents []*model.FileData
+// findingInput returns the parts of the packet that the findings rules are evaluated against.
+func (p *parsedSupportPacket) findingInput() *FindingInput {
+	input := &FindingInput{
+		Config: p.config,
+	}
 
-	// Read all data from the io.Reader into a byte slice
-	data, err := io.ReadAll(zippedBytes)
-	if err != nil {
-		return nil, err
+	if p.packet != nil {
+		input.Packet = PacketValuesFromSupportPacket(p.packet)
+		inferPacketValues(p.config, p.plugins).apply(input.Packet, nil)
+	}
+	if p.plugins != nil {
+		input.Plugins = PluginValuesFromPluginsResponse(p.plugins)
+	}
+	for _, node := range p.nodes {
+		input.Nodes = append(input.Nodes, node.nodeValues())
 	}
-	reader := bytes.NewReader(data)
-	zipReader, err := zip.NewReader(reader, int64(len(data)))
 
-	if err != nil {
-		return nil, err
+	return input
+}
+
+// identity returns the identifiers of the server the packet came from.
+func (p *parsedSupportPacket) identity() PacketIdentity {
+	identity := PacketIdentity{
+		SiteURL:    p.siteURL(),
+		LicensedTo: p.licensedTo(),
 	}
 
-	for _, file := range zipReader.File {
-		// Open each file in the zip archive
-		zippedFile, err := file.Open()
-		if err != nil {
-			return nil, err
-		}
+	if p.packet != nil {
+		identity.ClusterID = p.packet.ClusterID
+	}
+	if p.metadata != nil {
+		identity.TelemetryID = p.metadata.ServerID
+		identity.LicenseID = p.metadata.LicenseID
+	}
 
-		defer zippedFile.Close()
+	return identity
+}
 
-		// Read the contents of the file into a byte slice
-		contents, err := io.ReadAll(zippedFile)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Println(filepath.Base(file.Name))
-		// Store the file contents in the map using the file name as the key
-		fileContents = append(fileContents, &model.FileData{
-			Filename: filepath.Base(file.Name),
-			Body:     contents,
-		})
+// siteURL returns the site URL from the config, or an empty string if it's not available.
+func (p *parsedSupportPacket) siteURL() string {
+	if p.config == nil {
+		return ""
+	}
+
+	return stringValue(p.config.ServiceSettings.SiteURL)
+}
+
+// licensedTo returns the license holder from the packet, or an empty string if it's not available.
+func (p *parsedSupportPacket) licensedTo() string {
+	if p.packet == nil {
+		return ""
+	}
+
+	return p.packet.LicenseTo
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
 	}
-	return fileContents, nil
+
+	return *value
 }
 
-func returnMarkdownResponse(packet *model.SupportPacket, config *model.Config, plugins *model.PluginsResponse) string {
-	mdTable := "## Support Packet valuessss\n"
+func boolValue(value *bool) string {
+	if value == nil {
+		return "unknown"
+	}
 
-	mdTable += "| Key | Value |\n| --- | --- |\n"
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Licensed To", packet.LicenseTo)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Active Users", packet.ActiveUsers)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Daily Active Users", packet.DailyActiveUsers)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Monthly Active Users", packet.MonthlyActiveUsers)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Server Arch", packet.ServerArchitecture)
+	return fmt.Sprintf("%t", *value)
+}
 
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Server OS", packet.ServerOS)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Server Version", packet.ServerVersion)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Database Type", packet.DatabaseType)
-	mdTable += fmt.Sprintf("| %s | %v |\n", "Database Version", packet.DatabaseVersion)
+func returnMarkdownResponse(parsed *parsedSupportPacket) string {
+	var mdTable string
 
-	mdTable += "\n"
-	mdTable += "## Config Values\n"
+	if len(parsed.missing) > 0 {
+		mdTable += fmt.Sprintf("**Missing from packet:** `%s`\n\n", strings.Join(parsed.missing, "`, `"))
+	}
 
-	mdTable += "| Key | Value |\n| --- | --- |\n"
-	mdTable += fmt.Sprintf("| %s | %t |\n", "High Availability", *config.ClusterSettings.Enable)
-	mdTable += fmt.Sprintf("| %s | %t |\n", "SAML", *config.SamlSettings.Enable)
-	mdTable += fmt.Sprintf("| %s | %t |\n", "LDAP", *config.LdapSettings.Enable)
-	mdTable += fmt.Sprintf("| %s | %s |\n", "LDAP Groups", *config.LdapSettings.GroupFilter)
-	mdTable += fmt.Sprintf("| %s | %t |\n", "Elasticsearch Search", *config.ElasticsearchSettings.EnableSearching)
-	mdTable += fmt.Sprintf("| %s | %t |\n", "Elasticsearch Autocomplete", *config.ElasticsearchSettings.EnableAutocomplete)
+	if packet := parsed.packet; packet != nil {
+		mdTable += "## Support Packet Values\n"
+
+		mdTable += "| Key | Value |\n| --- | --- |\n"
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Licensed To", packet.LicenseTo)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Active Users", packet.ActiveUsers)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Daily Active Users", packet.DailyActiveUsers)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Monthly Active Users", packet.MonthlyActiveUsers)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Server Arch", packet.ServerArchitecture)
+
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Server OS", packet.ServerOS)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Server Version", packet.ServerVersion)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Database Type", packet.DatabaseType)
+		mdTable += fmt.Sprintf("| %s | %v |\n", "Database Version", packet.DatabaseVersion)
+		mdTable += "\n"
+	}
 
-	mdTable += "\n"
-	mdTable += "## Plugin Info\n"
+	if len(parsed.nodes) > 0 {
+		mdTable += "## Cluster Nodes\n"
 
-	mdTable += "| Plugin Name | Enabled | Version |\n| --- | :---: | --- |\n"
+		mdTable += "| Node | Server Version | Server OS | Server Arch | Database Schema |\n| --- | --- | --- | --- | --- |\n"
+		for _, node := range parsed.nodes {
+			mdTable += fmt.Sprintf("| %s | %s | %s | %s | %s |\n", node.id, node.packet.ServerVersion, node.packet.ServerOS, node.packet.ServerArchitecture, node.packet.DatabaseSchemaVersion)
+		}
+		mdTable += "\n"
 
-	for _, activePlugins := range plugins.Active {
-		mdTable += fmt.Sprintf("| %s | %s | %s |\n", activePlugins.Name, ":white_check_mark:", activePlugins.Version)
+		for _, mismatch := range clusterMismatches(parsed.nodes) {
+			mdTable += fmt.Sprintf(":warning: %s\n", mismatch)
+		}
+		mdTable += "\n"
 	}
-	for _, disabledPlugins := range plugins.Inactive {
-		mdTable += fmt.Sprintf("| %s | %s | %s |\n", disabledPlugins.Name, "", disabledPlugins.Version)
+
+	if config := parsed.config; config != nil {
+		mdTable += "## Config Values\n"
+
+		mdTable += "| Key | Value |\n| --- | --- |\n"
+		mdTable += fmt.Sprintf("| %s | %s |\n", "High Availability", boolValue(config.ClusterSettings.Enable))
+		mdTable += fmt.Sprintf("| %s | %s |\n", "SAML", boolValue(config.SamlSettings.Enable))
+		mdTable += fmt.Sprintf("| %s | %s |\n", "LDAP", boolValue(config.LdapSettings.Enable))
+		mdTable += fmt.Sprintf("| %s | %s |\n", "LDAP Groups", stringValue(config.LdapSettings.GroupFilter))
+		mdTable += fmt.Sprintf("| %s | %s |\n", "Elasticsearch Search", boolValue(config.ElasticsearchSettings.EnableSearching))
+		mdTable += fmt.Sprintf("| %s | %s |\n", "Elasticsearch Autocomplete", boolValue(config.ElasticsearchSettings.EnableAutocomplete))
+		mdTable += "\n"
 	}
 
-	fmt.Println(config)
+	if plugins := parsed.plugins; plugins != nil {
+		mdTable += "## Plugin Info\n"
+
+		mdTable += "| Plugin Name | Enabled | Version |\n| --- | :---: | --- |\n"
+
+		for _, activePlugins := range plugins.Active {
+			mdTable += fmt.Sprintf("| %s | %s | %s |\n", activePlugins.Name, ":white_check_mark:", activePlugins.Version)
+		}
+		for _, disabledPlugins := range plugins.Inactive {
+			mdTable += fmt.Sprintf("| %s | %s | %s |\n", disabledPlugins.Name, "", disabledPlugins.Version)
+		}
+	}
 
 	return mdTable
 }
@@ -156,6 +292,17 @@ func unmarshalPacket(file *model.FileData) (*model.SupportPacket, error) {
 	return packet, nil
 }
 
+func unmarshalMetadata(file *model.FileData) (*packetMetadata, error) {
+	var metadata *packetMetadata
+
+	err := yaml.Unmarshal(file.Body, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
 func unmarshalConfig(file *model.FileData) (*model.Config, error) {
 	var config *model.Config
 
@@ -180,72 +327,344 @@ func unmarshalPlugins(file *model.FileData) (*model.PluginsResponse, error) {
 	return plugins, nil
 }
 
-// Responsible for downloading, reading, and processing the support packet
-func processSupportPackets(s *customerService, packetArray []*model.FileInfo, post *model.Post) error {
-	// looking through all the packets in a post as you can upload more than one.
-	for _, packet := range packetArray {
-		fileData, err := s.api.File.Get(packet.Id)
+// parseSupportPacket unmarshals the extracted files. A file that fails to parse is treated the
+// same as a missing one so the rest of the packet can still be ingested.
+//
+// Files are grouped by the directory they were in. When more than one directory has a
+// support_packet.yaml the packet came from a cluster and each of those directories is a node.
+func parseSupportPacket(files []*model.FileData) *parsedSupportPacket {
+	parsed := &parsedSupportPacket{}
+	directories := make(map[string]*supportPacketNode)
+
+	for _, file := range files {
+		dir := path.Dir(file.Filename)
+		node, ok := directories[dir]
+		if !ok {
+			node = &supportPacketNode{id: path.Base(dir)}
+			directories[dir] = node
+		}
 
-		var packet *model.SupportPacket
-		var config *model.Config
-		var plugins *model.PluginsResponse
+		var err error
+		switch path.Base(file.Filename) {
+		case SupportPacketName:
+			node.packet, err = unmarshalPacket(file)
+		case ConfigFileName:
+			node.config, err = unmarshalConfig(file)
+		case PluginFileName:
+			node.plugins, err = unmarshalPlugins(file)
+		case MetadataFileName:
+			node.metadata, err = unmarshalMetadata(file)
+		}
 
 		if err != nil {
-			return err
+			logrus.WithError(err).WithField("file", file.Filename).Warn("Error parsing support packet file, skipping it.")
+			parsed.unparseable = append(parsed.unparseable, file.Filename)
 		}
+	}
 
-		unzippedFiles, err := unzipToMemory(fileData)
-		if err != nil {
-			logrus.WithError(err).Error("Failure unpacking packet")
-			return err
-		}
-
-		// looking through everything that's in the zipped file to find
-		for _, file := range unzippedFiles {
-			switch file.Filename {
-			case SupportPacketName:
-				data, e := unmarshalPacket(file)
-				packet = data
-				err = e
-			case ConfigFileName:
-				data, e := unmarshalConfig(file)
-				config = data
-				err = e
-			case PluginFileName:
-				data, e := unmarshalPlugins(file)
-				err = e
-				plugins = data
-			}
-
-			if err != nil {
-				logrus.WithError(err).Error("Error parsing support packet.")
-				return err
-			}
-		}
-
-		customerID, err := s.store.GetCustomerID(*config.ServiceSettings.SiteURL, packet.LicenseTo)
+	dirNames := make([]string, 0, len(directories))
+	for dir := range directories {
+		dirNames = append(dirNames, dir)
+	}
+	sort.Strings(dirNames)
 
-		if err != nil {
-			logrus.WithError(err).Error("Error getting customer ID.")
-			return err
+	var nodes []*supportPacketNode
+	for _, dir := range dirNames {
+		if directories[dir].packet != nil {
+			nodes = append(nodes, directories[dir])
 		}
+	}
+	if len(nodes) > 1 {
+		parsed.nodes = nodes
+	}
 
-		err = s.store.UpdateCustomerThroughUpload(customerID, packet, config, plugins)
+	// the nodes are checked first so the cluster level values all come from the same node when possible
+	candidates := nodes
+	for _, dir := range dirNames {
+		candidates = append(candidates, directories[dir])
+	}
+	for _, candidate := range candidates {
+		if parsed.packet == nil {
+			parsed.packet = candidate.packet
+		}
+		if parsed.config == nil {
+			parsed.config = candidate.config
+		}
+		if parsed.plugins == nil {
+			parsed.plugins = candidate.plugins
+		}
+		if parsed.metadata == nil {
+			parsed.metadata = candidate.metadata
+		}
+	}
 
-		if err != nil {
-			logrus.WithError(err).Error("Error updating customer data.")
+	if parsed.packet == nil {
+		parsed.missing = append(parsed.missing, SupportPacketName)
+	}
+	if parsed.config == nil {
+		parsed.missing = append(parsed.missing, ConfigFileName)
+	}
+	if parsed.plugins == nil {
+		parsed.missing = append(parsed.missing, PluginFileName)
+	}
+
+	return parsed
+}
+
+// preparedPacket is a support packet read and evaluated, ready to be stored for a customer.
+type preparedPacket struct {
+	upload  *SupportPacketUpload
+	summary string
+	missing []string
+
+	// duplicate is the snapshot the same packet was already ingested as, nil if it's new. Nothing
+	// else is set when it's a duplicate.
+	duplicate *PacketSnapshot
+}
+
+// preparePacketUpload extracts and parses the support packet and evaluates the findings, the part
+// of ingesting shared by every way a packet can be uploaded. Errors are IngestionErrors.
+func preparePacketUpload(s *customerService, packetFile *supportPacketFile, userID string) (*preparedPacket, error) {
+	unzippedFiles, err := extractSupportPacket(packetFile.archive)
+	if err != nil {
+		return nil, extractionError(err)
+	}
+
+	contentHash := hashPacketContents(unzippedFiles)
+	original, err := s.store.GetSnapshotByHash(packetFile.hash, contentHash)
+	if err == nil {
+		return &preparedPacket{duplicate: &original}, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, newRetryableIngestionError(StageStore, "checking whether it was already ingested failed", err)
+	}
+
+	parsed := parseSupportPacket(unzippedFiles)
+	if parsed.packet == nil && parsed.config == nil && parsed.plugins == nil {
+		return nil, unreadablePacketError(parsed)
+	}
+
+	logs, err := analyzeSupportPacketLogs(packetFile.archive)
+	if err != nil {
+		logrus.WithError(err).WithField("file_id", packetFile.info.Id).Warn("Failed analyzing packet logs, skipping them.")
+	}
+
+	upload := parsed.upload()
+	upload.FileName = packetFile.info.Name
+	upload.UserID = userID
+	upload.ArchiveHash = packetFile.hash
+	upload.ContentHash = contentHash
+	upload.Identity = parsed.identity()
+	upload.Logs = logs
+	upload.Findings = EvaluateFindings(parsed.findingInput(), currentFindingRules(s))
+
+	return &preparedPacket{
+		upload:  upload,
+		summary: returnMarkdownResponse(parsed) + findingsMarkdown(upload.Findings),
+		missing: parsed.missing,
+	}, nil
+}
+
+// requireIdentity returns an error if the packet has nothing to match a customer with.
+func requireIdentity(identity PacketIdentity) error {
+	if identity.SiteURL != "" || identity.LicensedTo != "" {
+		return nil
+	}
+
+	return &IngestionError{
+		Stage:  StageMatch,
+		Reason: "it has neither a site URL nor a license holder to match a customer with",
+		Fix:    fmt.Sprintf("Set the Site URL in the System Console, or include `%s` from a licensed server, then generate a new support packet.", SupportPacketName),
+	}
+}
+
+// processSupportPacket reads the support packet and stores it for the matching customer, or asks
+// which customer it belongs to. Backfilled packets are stored without posting their summary. The
+// returned message is the final status of the upload. Errors are IngestionErrors explaining what
+// went wrong to the uploader.
+func processSupportPacket(s *customerService, packetFile *supportPacketFile, post *model.Post, backfilled bool) (string, error) {
+	fileName := packetFile.info.Name
+
+	prepared, err := preparePacketUpload(s, packetFile, post.UserId)
+	if err != nil {
+		return "", err
+	}
+	if prepared.duplicate != nil {
+		return duplicatePacketMessage(s, fileName, *prepared.duplicate), nil
+	}
+
+	upload := prepared.upload
+	upload.PostID = post.Id
+	upload.ChannelID = post.ChannelId
+	upload.UploadedAt = post.CreateAt
+	upload.Backfilled = backfilled
+	upload.TicketRef = ticketReference(post.Message)
+
+	if err = requireIdentity(upload.Identity); err != nil {
+		return "", err
+	}
+
+	candidates, err := s.store.MatchCustomers(upload.Identity)
+	if err != nil {
+		return "", newRetryableIngestionError(StageMatch, "looking up the matching customers failed", err)
+	}
+
+	// packets sent to the bot directly are never matched automatically, the uploader picks the customer
+	if isBotDirectMessage(s, post.ChannelId) {
+		if err = queueDirectMessageUpload(s, packetFile, upload, prepared.summary, candidates); err != nil {
+			return "", newRetryableIngestionError(StageStore, "saving it until a customer is chosen failed", err)
+		}
+		return fmt.Sprintf(":hourglass: Support packet `%s` is waiting for you to choose the customer it belongs to.", fileName), nil
+	}
+
+	customer, ok := ResolveCustomerMatch(candidates)
+	if !ok {
+		// someone has to choose, the upload waits until they do
+		if err = queuePendingUpload(s, packetFile, upload, prepared.summary, candidates); err != nil {
+			return "", newRetryableIngestionError(StageStore, "saving it until a customer is chosen failed", err)
 		}
+		return fmt.Sprintf(":hourglass: Support packet `%s` is waiting for someone to choose the customer it belongs to.", fileName), nil
+	}
 
-		err = s.poster.PostMessageToThread(post.Id, &model.Post{
-			ChannelId: post.ChannelId,
-			Message:   returnMarkdownResponse(packet, config, plugins),
-		})
+	upload.ArchivePath, upload.ArchiveSize, err = archivePacket(s, customer.ID, packetFile)
+	if err != nil {
+		// the parsed values are still worth keeping without the raw zip
+		logrus.WithError(err).WithField("file_id", packetFile.info.Id).Warn("Failed to archive support packet.")
+	}
 
-		if err != nil {
-			logrus.WithError(err).Error("Error parsing support packet.")
-			return err
+	if _, _, err = completeUpload(s, customer.ID, upload, prepared.summary); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(":white_check_mark: Support packet `%s` was added to **%s**.", fileName, customer.Name), nil
+}
+
+// unreadablePacketError explains why none of the packet files could be used.
+func unreadablePacketError(parsed *parsedSupportPacket) *IngestionError {
+	if len(parsed.unparseable) > 0 {
+		return &IngestionError{
+			Stage:  StageParse,
+			Reason: fmt.Sprintf("`%s` couldn't be parsed", strings.Join(parsed.unparseable, "`, `")),
+			Fix:    "The files may have been edited or truncated. Generate a new support packet from the System Console and upload it without modifying it.",
+		}
+	}
+
+	return &IngestionError{
+		Stage:  StageParse,
+		Reason: fmt.Sprintf("none of `%s`, `%s` or `%s` are in the zip", SupportPacketName, ConfigFileName, PluginFileName),
+		Fix:    "Generate a support packet from the System Console and upload the zip it downloads.",
+	}
+}
+
+// completeUpload stores the upload for the customer and posts the summary, in the thread of the
+// post the packet was uploaded to or the results channel, and to the customer's channel. Uploads
+// that weren't posted, like the ones through the API, get no reply, and backfilled ones aren't
+// announced anywhere since they are old news. Failing to post is only logged, the packet is stored
+// by then. The ID of the snapshot created and the changes since the previous packet, nil for the
+// first one, are returned.
+func completeUpload(s *customerService, customerID string, upload *SupportPacketUpload, summary string) (string, *PacketChanges, error) {
+	// compared against the previous packet of the same server, not of the customer's others
+	if err := resolveUploadEnvironment(s, customerID, upload); err != nil {
+		return "", nil, newRetryableIngestionError(StageMatch, "looking up the customer's environment failed", err)
+	}
+	previousLogs := previousLogEntries(s, customerID, upload.EnvironmentID)
+	changes := diffPacketUpload(loadPreviousPacketValues(s, customerID, upload.EnvironmentID), upload)
+
+	snapshotID, err := s.store.UpdateCustomerThroughUpload(customerID, upload)
+	if err != nil {
+		return "", nil, newRetryableIngestionError(StageStore, "saving the customer data failed", err)
+	}
+
+	if upload.Backfilled {
+		return snapshotID, changes, nil
+	}
+
+	changesMarkdown := packetChangesMarkdown(changes)
+	crossPostPacketSummary(s, customerID, upload, changesMarkdown)
+
+	if upload.PostID == "" {
+		return snapshotID, changes, nil
+	}
+
+	err = postPacketResult(s, upload, &model.Post{
+		Message: summary + changesMarkdown + logSummaryMarkdown(upload.Logs, previousLogs),
+	})
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"customer_id": customerID,
+			"snapshot_id": snapshotID,
+		}).Error("Failed to reply with the packet summary.")
+	}
+
+	return snapshotID, changes, nil
+}
+
+// replyToPacketPost posts a message in the thread of the post the packet was uploaded to.
+func replyToPacketPost(s *customerService, post *model.Post, message string) {
+	err := s.poster.PostMessageToThread(post.Id, &model.Post{
+		ChannelId: post.ChannelId,
+		Message:   message,
+	})
+	if err != nil {
+		logrus.WithError(err).Error("Failed in sending reply")
+	}
+}
+
+// previousLogEntries returns the log entries of the environment's latest snapshot, or nil if there is none.
+func previousLogEntries(s *customerService, customerID string, environmentID string) []LogEntrySummary {
+	latest, ok := latestEnvironmentSnapshot(s, customerID, environmentID)
+	if !ok {
+		return nil
+	}
+
+	entries, err := s.store.GetLogEntries(latest.ID)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get previous log entries.")
+		return nil
+	}
+
+	return entries
+}
+
+// currentFindingRules returns the latest custom findings rules, or nil if there are none or they
+// couldn't be loaded so the built in rules still run.
+func currentFindingRules(s *customerService) []FindingRuleDefinition {
+	ruleSet, err := s.store.GetLatestFindingRuleSet()
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			logrus.WithError(err).Warn("Failed to get custom findings rules.")
 		}
+		return nil
+	}
+
+	return ruleSet.Rules
+}
+
+// duplicatePacketMessage is the reply to a packet that has already been ingested, pointing at
+// the post the original was uploaded to.
+func duplicatePacketMessage(s *customerService, fileName string, original PacketSnapshot) string {
+	customerName := original.CustomerID
+	if customer, err := s.store.GetCustomerByID(original.CustomerID); err == nil {
+		customerName = customer.Name
+	}
+
+	message := fmt.Sprintf("Support packet `%s` has already been ingested for **%s** on %s", fileName, customerName, formatLogTime(original.CreatedAt))
+	if link := postPermalink(s, original.PostID); link != "" {
+		message += fmt.Sprintf(", see the [original upload](%s)", link)
+	}
+
+	return message + ". Nothing was updated."
+}
+
+// postPermalink returns a link to the post, or an empty string if the post or site URL is unknown.
+func postPermalink(s *customerService, postID string) string {
+	if postID == "" {
+		return ""
+	}
+
+	siteURL := s.api.Configuration.GetConfig().ServiceSettings.SiteURL
+	if siteURL == nil || *siteURL == "" {
+		return ""
 	}
 
-	return nil
+	return fmt.Sprintf("%s/_redirect/pl/%s", strings.TrimSuffix(*siteURL, "/"), postID)
 }
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSupportPacket(t *testing.T) {
+	t.Run("packet without config or plugins", func(t *testing.T) {
+		parsed := parseSupportPacket([]*model.FileData{
+			{Filename: SupportPacketName, Body: []byte("license_to: test\nserver_version: 5.0.0")},
+		})
+
+		require.NotNil(t, parsed.packet)
+		require.Nil(t, parsed.config)
+		require.Nil(t, parsed.plugins)
+		require.Equal(t, []string{ConfigFileName, PluginFileName}, parsed.missing)
+		require.Equal(t, "test", parsed.licensedTo())
+		require.Equal(t, "", parsed.siteURL())
+	})
+
+	t.Run("unparseable files are treated as missing", func(t *testing.T) {
+		parsed := parseSupportPacket([]*model.FileData{
+			{Filename: SupportPacketName, Body: []byte("license_to: test")},
+			{Filename: ConfigFileName, Body: []byte("not json")},
+		})
+
+		require.Nil(t, parsed.config)
+		require.Contains(t, parsed.missing, ConfigFileName)
+		require.Equal(t, []string{ConfigFileName}, parsed.unparseable)
+	})
+
+	t.Run("identity from packet, config and metadata", func(t *testing.T) {
+		parsed := parseSupportPacket([]*model.FileData{
+			{Filename: SupportPacketName, Body: []byte("license_to: test\ncluster_id: cluster1")},
+			{Filename: ConfigFileName, Body: []byte(`{"ServiceSettings": {"SiteURL": "https://a.test"}}`)},
+			{Filename: MetadataFileName, Body: []byte("version: 1\nserver_id: server1\nlicense_id: license1")},
+		})
+
+		require.Equal(t, PacketIdentity{
+			SiteURL:     "https://a.test",
+			LicensedTo:  "test",
+			TelemetryID: "server1",
+			LicenseID:   "license1",
+			ClusterID:   "cluster1",
+		}, parsed.identity())
+		require.Equal(t, []string{PluginFileName}, parsed.missing)
+	})
+}
+
+func TestParseMultiNodeSupportPacket(t *testing.T) {
+	parsed := parseSupportPacket([]*model.FileData{
+		{Filename: "node-b/" + SupportPacketName, Body: []byte("license_to: test\nserver_version: 9.2.0")},
+		{Filename: "node-a/" + SupportPacketName, Body: []byte("license_to: test\nserver_version: 9.3.0")},
+		{Filename: "node-a/" + ConfigFileName, Body: []byte(`{"ServiceSettings": {"SiteURL": "https://a.test"}}`)},
+		{Filename: "node-b/" + ConfigFileName, Body: []byte(`{"ServiceSettings": {"SiteURL": "https://b.test"}}`)},
+		{Filename: PluginFileName, Body: []byte(`{"active": [], "inactive": []}`)},
+	})
+
+	require.Len(t, parsed.nodes, 2)
+	require.Equal(t, "node-a", parsed.nodes[0].id)
+	require.Equal(t, "node-b", parsed.nodes[1].id)
+	require.Equal(t, "9.3.0", parsed.packet.ServerVersion)
+	require.Equal(t, "https://a.test", parsed.siteURL())
+	require.NotNil(t, parsed.plugins)
+	require.Empty(t, parsed.missing)
+
+	upload := parsed.upload()
+	require.Len(t, upload.Nodes, 2)
+	require.Equal(t, "node-b", upload.Nodes[1].NodeID)
+	require.Equal(t, "9.2.0", upload.Nodes[1].Version)
+
+	response := returnMarkdownResponse(parsed)
+	require.Contains(t, response, "## Cluster Nodes")
+	require.Contains(t, response, "Nodes are running different versions")
+	require.Contains(t, response, "Config on `node-b` differs from `node-a`: `ServiceSettings.SiteURL`")
+}
+
+func TestParseSingleNodeSupportPacketInDirectory(t *testing.T) {
+	parsed := parseSupportPacket([]*model.FileData{
+		{Filename: "packet/" + SupportPacketName, Body: []byte("license_to: test")},
+		{Filename: "packet/" + ConfigFileName, Body: []byte(`{}`)},
+	})
+
+	require.Empty(t, parsed.nodes)
+	require.NotNil(t, parsed.packet)
+	require.NotNil(t, parsed.config)
+	require.Equal(t, []string{PluginFileName}, parsed.missing)
+}
+
+func TestReturnMarkdownResponse(t *testing.T) {
+	t.Run("config with unset pointers", func(t *testing.T) {
+		response := returnMarkdownResponse(&parsedSupportPacket{
+			config:  &model.Config{},
+			missing: []string{SupportPacketName, PluginFileName},
+		})
+
+		require.Contains(t, response, "| High Availability | unknown |")
+		require.Contains(t, response, "**Missing from packet:** `support_packet.yaml`, `plugins.json`")
+		require.NotContains(t, response, "## Support Packet Values")
+		require.NotContains(t, response, "## Plugin Info")
+	})
+
+	t.Run("full packet", func(t *testing.T) {
+		config := &model.Config{}
+		config.SetDefaults()
+
+		response := returnMarkdownResponse(&parsedSupportPacket{
+			packet:  &model.SupportPacket{LicenseTo: "test"},
+			config:  config,
+			plugins: &model.PluginsResponse{},
+		})
+
+		require.Contains(t, response, "| Licensed To | test |")
+		require.Contains(t, response, "| High Availability | false |")
+		require.NotContains(t, response, "Missing from packet")
+	})
+}
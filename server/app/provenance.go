@@ -0,0 +1,32 @@
+package app
+
+import "regexp"
+
+// ticketURLPattern matches links to a support ticket, like a Zendesk agent URL.
+var ticketURLPattern = regexp.MustCompile(`https?://[^\s<>()]+/tickets/\d+`)
+
+// ticketNumberPattern matches tickets mentioned by number, like "ticket 12345", "ZD #12345" or "ZD-12345".
+var ticketNumberPattern = regexp.MustCompile(`(?i)\b(?:ticket|zd)\s*[:#-]?\s*#?(\d{3,})\b`)
+
+// ticketReference returns the support ticket mentioned in a message, preferring a link over a
+// bare ticket number. Returns an empty string if there is none.
+func ticketReference(message string) string {
+	if link := ticketURLPattern.FindString(message); link != "" {
+		return link
+	}
+
+	if match := ticketNumberPattern.FindStringSubmatch(message); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// withPermalinks fills in the link back to the post each snapshot was uploaded in.
+func withPermalinks(s *customerService, snapshots []PacketSnapshot) []PacketSnapshot {
+	for i := range snapshots {
+		snapshots[i].Permalink = postPermalink(s, snapshots[i].PostID)
+	}
+
+	return snapshots
+}
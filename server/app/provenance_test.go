@@ -0,0 +1,29 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketReference(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"no ticket", "here is the packet", ""},
+		{"zendesk link", "for https://example.zendesk.com/agent/tickets/12345 thanks", "https://example.zendesk.com/agent/tickets/12345"},
+		{"ticket number", "Packet for ticket 12345", "12345"},
+		{"zd hash", "ZD #54321 packet", "54321"},
+		{"zd dash", "zd-54321", "54321"},
+		{"link wins", "ticket 1111 https://example.zendesk.com/agent/tickets/2222", "https://example.zendesk.com/agent/tickets/2222"},
+		{"short numbers ignored", "ticket 12", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, ticketReference(tc.message))
+		})
+	}
+}
@@ -14,6 +14,31 @@ package config
 type Configuration struct {
 	// BotUserID used to post messages.
 	BotUserID string
+
+	// PacketRetentionDays is how long archived support packets are kept, 0 keeps them forever.
+	PacketRetentionDays int
+
+	// PacketRetentionCount is how many archived support packets are kept per customer, 0 keeps all.
+	PacketRetentionCount int
+
+	// IngestionTeams are the comma separated names or IDs of the teams packets are ingested in,
+	// empty allows every team.
+	IngestionTeams string
+
+	// IngestionChannels are the comma separated names or IDs of the channels packets are ingested
+	// in, empty allows every channel of the allowed teams.
+	IngestionChannels string
+
+	// IngestionBlockedChannels are the comma separated names or IDs of channels packets are never
+	// ingested in, even if they are allowed otherwise.
+	IngestionBlockedChannels string
+
+	// IngestionDirectMessages allows ingesting packets sent to the bot in a direct message.
+	IngestionDirectMessages bool
+
+	// ResultsChannelID is the private channel the packet summaries are posted to instead of the
+	// channel the packet was uploaded to, empty replies in the packet's thread.
+	ResultsChannelID string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -26,5 +51,12 @@ func (c *Configuration) Clone() *Configuration {
 func (c *Configuration) serialize() map[string]interface{} {
 	ret := make(map[string]interface{})
 	ret["BotUserID"] = c.BotUserID
+	ret["PacketRetentionDays"] = c.PacketRetentionDays
+	ret["PacketRetentionCount"] = c.PacketRetentionCount
+	ret["IngestionTeams"] = c.IngestionTeams
+	ret["IngestionChannels"] = c.IngestionChannels
+	ret["IngestionBlockedChannels"] = c.IngestionBlockedChannels
+	ret["IngestionDirectMessages"] = c.IngestionDirectMessages
+	ret["ResultsChannelID"] = c.ResultsChannelID
 	return ret
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/api"
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
@@ -30,8 +31,13 @@ type Plugin struct {
 
 	pluginAPI       *pluginapi.Client
 	customerService app.CustomerService
+
+	retentionJob *cluster.Job
 }
 
+// packetRetentionInterval is how often archived support packets are checked against the retention settings.
+const packetRetentionInterval = time.Hour
+
 type StatusRecorder struct {
 	http.ResponseWriter
 	Status int
@@ -89,9 +95,10 @@ func (p *Plugin) OnActivate() error {
 	}
 
 	customerStore := sqlstore.NewCustomerStore(apiClient, sqlStore)
+	ingestionJobStore := sqlstore.NewIngestionJobStore(sqlStore)
 	p.handler = api.NewHandler(pluginAPIClient, p.config)
 
-	p.customerService = app.NewCustomerService(customerStore, p.bot, pluginAPIClient)
+	p.customerService = app.NewCustomerService(customerStore, ingestionJobStore, p.bot, pluginAPIClient, p.config)
 
 	// Migrations use the scheduler, so they have to be run after playbookRunService and scheduler have started
 	mutex, err := cluster.NewMutex(p.API, "CRM_Customers")
@@ -112,9 +119,57 @@ func (p *Plugin) OnActivate() error {
 		p.config,
 	)
 
+	api.NewFindingsHandler(
+		p.handler.APIRouter,
+		p.customerService,
+		pluginAPIClient,
+		p.config,
+	)
+
+	api.NewIngestionHandler(
+		p.handler.APIRouter,
+		p.customerService,
+		pluginAPIClient,
+		p.config,
+	)
+
+	p.customerService.StartIngestionWorker()
+
+	p.retentionJob, err = cluster.Schedule(
+		p.API,
+		"CRM_PacketRetention",
+		cluster.MakeWaitForRoundedInterval(packetRetentionInterval),
+		p.customerService.PurgeExpiredPacketArchives,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule packet retention job")
+	}
+
 	return nil
 }
 
+func (p *Plugin) OnDeactivate() error {
+	if p.retentionJob != nil {
+		if err := p.retentionJob.Close(); err != nil {
+			logrus.WithError(err).Warn("Failed to close packet retention job")
+		}
+	}
+
+	if p.customerService != nil {
+		p.customerService.StopIngestionWorker()
+	}
+
+	return nil
+}
+
+func (p *Plugin) OnConfigurationChange() error {
+	if p.config == nil {
+		return nil
+	}
+
+	return p.config.OnConfigurationChange()
+}
+
 func (p *Plugin) MessageHasBeenPosted(_ *plugin.Context, post *model.Post) {
 	p.customerService.MessageHasBeenPosted(post)
 }
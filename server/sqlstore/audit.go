@@ -22,32 +22,27 @@ func diffPlugins(old, new []app.CustomerPluginValues) (diff.Changelog, error) {
 	return diff.Diff(old, new)
 }
 
-func (s *customerStore) createAuditRow(customerID string, updatedBy string, diff diff.Changelog) (id string, err error) {
+// createAuditRow records a change to the customer made at updatedAt, the time the packet was
+// uploaded for packet updates. updatedBy is the user that made the change, or uploaded the packet
+// for packet updates, and may be empty when it isn't known.
+func (s *customerStore) createAuditRow(e execer, customerID string, updatedBy string, updateType UpdateType, diff diff.Changelog, updatedAt int64) (id string, err error) {
 	if customerID == "" {
 		return "", errors.New("customerID cannot be empty")
 	}
 
-	var updateType UpdateType
-	if updatedBy != "" {
-		updateType = User
-	} else {
-		updateType = Packet
-	}
-
 	changelogJSON, err := json.Marshal(diff)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to marshal changelog")
 	}
 
 	id = model.NewId()
-	lastUpdated := model.GetMillis()
-	_, err = s.store.execBuilder(s.store.db, sq.
+	_, err = s.store.execBuilder(e, sq.
 		Insert(auditTable).
 		SetMap(map[string]interface{}{
 			"ID":         id,
 			"customerId": customerID,
 			"updatedBy":  updatedBy,
-			"updatedAt":  lastUpdated,
+			"updatedAt":  updatedAt,
 			"updateType": updateType,
 			"path":       "",
 			"diff":       string(changelogJSON),
@@ -56,10 +51,11 @@ func (s *customerStore) createAuditRow(customerID string, updatedBy string, diff
 		return "", errors.Wrap(err, "failed to store audit row")
 	}
 
-	_, err = s.store.execBuilder(s.store.db, sq.
+	// older packets, like backfilled ones, don't move the last update back in time
+	_, err = s.store.execBuilder(e, sq.
 		Update(customerTable).
 		SetMap(map[string]interface{}{
-			"lastUpdated": lastUpdated,
+			"lastUpdated": sq.Expr("GREATEST(lastUpdated, ?)", updatedAt),
 		}).
 		Where(sq.Eq{"ID": customerID}),
 	)
@@ -0,0 +1,340 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+const (
+	backfillJobTable  = "crm_backfillJobs"
+	backfillItemTable = "crm_backfillItems"
+)
+
+type sqlBackfillJob struct {
+	app.BackfillJob
+	ChannelIDs json.RawMessage `db:"channelids"`
+}
+
+func newBackfillJobSelect(sqlStore *SQLStore) sq.SelectBuilder {
+	// the progress is counted from the items so it can't drift from them
+	countItems := func(alias string, status app.IngestionJobStatus) string {
+		condition := ""
+		if status != "" {
+			condition = fmt.Sprintf(" AND bi.Status = '%s'", status)
+		}
+		return fmt.Sprintf("(SELECT COUNT(*) FROM %s bi WHERE bi.JobID = bj.ID%s) AS %s", backfillItemTable, condition, alias)
+	}
+
+	return sqlStore.builder.
+		Select(
+			"bj.ID",
+			"bj.CreatedBy",
+			"bj.ChannelIDs",
+			"bj.Since",
+			"bj.Until",
+			"bj.Status",
+			"bj.LastError",
+			"bj.CreateAt",
+			"bj.UpdateAt",
+			"bj.ScanChannel",
+			"bj.ScanCursor",
+			"bj.PostsScanned",
+			countItems("AttachmentsFound", ""),
+			countItems("Ingested", app.IngestionCompleted),
+			countItems("Skipped", app.IngestionSkipped),
+			countItems("Failed", app.IngestionDead),
+		).
+		From(backfillJobTable + " as bj")
+}
+
+func newBackfillItemSelect(sqlStore *SQLStore) sq.SelectBuilder {
+	return sqlStore.builder.
+		Select(
+			"bi.ID",
+			"bi.JobID",
+			"bi.PostID",
+			"bi.FileID",
+			"bi.ChannelID",
+			"bi.PostCreateAt",
+			"bi.Status",
+			"bi.Attempts",
+			"bi.LastError",
+		).
+		From(backfillItemTable + " as bi")
+}
+
+func toBackfillJob(raw sqlBackfillJob) (app.BackfillJob, error) {
+	job := raw.BackfillJob
+	if err := json.Unmarshal(raw.ChannelIDs, &job.ChannelIDs); err != nil {
+		return app.BackfillJob{}, errors.Wrapf(err, "failed to unmarshal channels of backfill job '%s'", job.ID)
+	}
+
+	return job, nil
+}
+
+func (s *ingestionJobStore) CreateBackfillJob(job app.BackfillJob) (string, error) {
+	channelsJSON, err := json.Marshal(job.ChannelIDs)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal backfill channels")
+	}
+
+	id := model.NewId()
+	now := model.GetMillis()
+	_, err = s.store.execBuilder(s.store.db, sq.
+		Insert(backfillJobTable).
+		SetMap(map[string]interface{}{
+			"ID":           id,
+			"CreatedBy":    job.CreatedBy,
+			"ChannelIDs":   string(channelsJSON),
+			"Since":        job.Since,
+			"Until":        job.Until,
+			"Status":       job.Status,
+			"ScanChannel":  0,
+			"ScanCursor":   "",
+			"PostsScanned": 0,
+			"LastError":    "",
+			"CreateAt":     now,
+			"UpdateAt":     now,
+			"LockedUntil":  0,
+		}))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store backfill job")
+	}
+
+	return id, nil
+}
+
+func (s *ingestionJobStore) GetBackfillJobs() ([]app.BackfillJob, error) {
+	var rawJobs []sqlBackfillJob
+	err := s.store.selectBuilder(s.store.db, &rawJobs, s.backfillSelect.OrderBy("bj.CreateAt DESC"))
+	if err != nil {
+		return []app.BackfillJob{}, errors.Wrap(err, "failed to get backfill jobs")
+	}
+
+	jobs := make([]app.BackfillJob, 0, len(rawJobs))
+	for _, rawJob := range rawJobs {
+		job, err := toBackfillJob(rawJob)
+		if err != nil {
+			return []app.BackfillJob{}, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (s *ingestionJobStore) GetBackfillJob(id string) (app.BackfillJob, error) {
+	var rawJob sqlBackfillJob
+	err := s.store.getBuilder(s.store.db, &rawJob, s.backfillSelect.Where(sq.Eq{"bj.ID": id}))
+	if err == sql.ErrNoRows {
+		return app.BackfillJob{}, errors.Wrapf(app.ErrNotFound, "backfill job '%s' does not exist", id)
+	} else if err != nil {
+		return app.BackfillJob{}, errors.Wrapf(err, "failed to get backfill job '%s'", id)
+	}
+
+	return toBackfillJob(rawJob)
+}
+
+func (s *ingestionJobStore) GetBackfillItems(jobID string, status app.IngestionJobStatus) ([]app.BackfillItem, error) {
+	query := s.backfillItemSelect.
+		Where(sq.Eq{"bi.JobID": jobID}).
+		OrderBy("bi.PostCreateAt", "bi.PostID", "bi.FileID")
+	if status != "" {
+		query = query.Where(sq.Eq{"bi.Status": status})
+	}
+
+	var items []app.BackfillItem
+	if err := s.store.selectBuilder(s.store.db, &items, query); err != nil {
+		return []app.BackfillItem{}, errors.Wrapf(err, "failed to get items of backfill job '%s'", jobID)
+	}
+
+	return items, nil
+}
+
+func (s *ingestionJobStore) ClaimBackfillJob(lease time.Duration) (*app.BackfillJob, error) {
+	now := model.GetMillis()
+
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	var rawJob sqlBackfillJob
+	err = s.store.getBuilder(tx, &rawJob, s.backfillSelect.
+		Where(sq.Eq{"bj.Status": []app.BackfillStatus{app.BackfillScanning, app.BackfillIngesting}}).
+		Where(sq.Lt{"bj.LockedUntil": now}).
+		OrderBy("bj.CreateAt").
+		Limit(1).
+		Suffix("FOR UPDATE OF bj SKIP LOCKED"))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get active backfill job")
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Update(backfillJobTable).
+		SetMap(map[string]interface{}{
+			"UpdateAt":    now,
+			"LockedUntil": now + lease.Milliseconds(),
+		}).
+		Where(sq.Eq{"ID": rawJob.ID}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to claim backfill job '%s'", rawJob.ID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "could not commit transaction")
+	}
+
+	job, err := toBackfillJob(rawJob)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (s *ingestionJobStore) SaveBackfillScan(job app.BackfillJob, items []app.BackfillItem) error {
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	for _, item := range items {
+		_, err = s.store.execBuilder(tx, sq.
+			Insert(backfillItemTable).
+			SetMap(map[string]interface{}{
+				"ID":           model.NewId(),
+				"JobID":        job.ID,
+				"PostID":       item.PostID,
+				"FileID":       item.FileID,
+				"ChannelID":    item.ChannelID,
+				"PostCreateAt": item.PostCreateAt,
+				"Status":       app.IngestionPending,
+				"Attempts":     0,
+				"LastError":    "",
+			}).
+			Suffix("ON CONFLICT (JobID, PostID, FileID) DO NOTHING"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to store item of backfill job '%s'", job.ID)
+		}
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Update(backfillJobTable).
+		SetMap(map[string]interface{}{
+			"Status":       job.Status,
+			"ScanChannel":  job.ScanChannel,
+			"ScanCursor":   job.ScanCursor,
+			"PostsScanned": job.PostsScanned,
+			"LastError":    "",
+			"UpdateAt":     model.GetMillis(),
+		}).
+		Where(sq.Eq{"ID": job.ID}).
+		Where(sq.NotEq{"Status": app.BackfillCancelled}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to save scan of backfill job '%s'", job.ID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) GetPendingBackfillItems(jobID string, limit int) ([]app.BackfillItem, error) {
+	var items []app.BackfillItem
+	err := s.store.selectBuilder(s.store.db, &items, s.backfillItemSelect.
+		Where(sq.Eq{"bi.JobID": jobID}).
+		Where(sq.Eq{"bi.Status": app.IngestionPending}).
+		OrderBy("bi.PostCreateAt", "bi.PostID", "bi.FileID").
+		Limit(uint64(limit)))
+	if err != nil {
+		return []app.BackfillItem{}, errors.Wrapf(err, "failed to get pending items of backfill job '%s'", jobID)
+	}
+
+	return items, nil
+}
+
+func (s *ingestionJobStore) FinishBackfillItem(id string, status app.IngestionJobStatus, lastError string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(backfillItemTable).
+		SetMap(map[string]interface{}{
+			"Status":    status,
+			"LastError": lastError,
+		}).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to finish backfill item '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) FailBackfillItem(id string, lastError string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(backfillItemTable).
+		SetMap(map[string]interface{}{
+			"Attempts":  sq.Expr("Attempts + 1"),
+			"LastError": lastError,
+		}).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to record failure of backfill item '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) ReleaseBackfillJob(id string, status app.BackfillStatus, lastError string, retryAt int64) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(backfillJobTable).
+		SetMap(map[string]interface{}{
+			"Status":      status,
+			"LastError":   lastError,
+			"UpdateAt":    model.GetMillis(),
+			"LockedUntil": retryAt,
+		}).
+		Where(sq.Eq{"ID": id}).
+		Where(sq.NotEq{"Status": app.BackfillCancelled}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to release backfill job '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) CancelBackfillJob(id string) error {
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Update(backfillJobTable).
+		SetMap(map[string]interface{}{
+			"Status":   app.BackfillCancelled,
+			"UpdateAt": model.GetMillis(),
+		}).
+		Where(sq.Eq{"ID": id}).
+		Where(sq.Eq{"Status": []app.BackfillStatus{app.BackfillScanning, app.BackfillIngesting}}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to cancel backfill job '%s'", id)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to cancel backfill job '%s'", id)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "no backfill job '%s' that is scanning or ingesting", id)
+	}
+
+	return nil
+}
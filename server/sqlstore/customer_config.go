@@ -14,97 +14,148 @@ func (s *customerStore) GetConfig(customerID string) (model.Config, error) {
 		return model.Config{}, errors.New("ID cannot be empty")
 	}
 
-	tx, err := s.store.db.Beginx()
+	environmentID, err := s.primaryEnvironmentID(s.store.db, customerID)
 	if err != nil {
-		return model.Config{}, errors.Wrap(err, "could not begin transaction")
+		return model.Config{}, err
+	}
+
+	return s.getConfig(s.store.db, environmentID)
+}
+
+// getConfig returns the environment's current config, or an empty config if it has none.
+func (s *customerStore) getConfig(q queryer, environmentID string) (model.Config, error) {
+	if environmentID == "" {
+		return model.Config{}, nil
 	}
-	defer s.store.finalizeTransaction(tx)
+
 	var rawConfig sqlConfig
-	err = s.store.getBuilder(
-		tx,
+	err := s.store.getBuilder(
+		q,
 		&rawConfig,
 		s.configValuesSelect.
-			Where(sq.Eq{"ccv.customerId": customerID}).
+			Where(sq.Eq{"ccv.environmentId": environmentID}).
 			Where(sq.Eq{"ccv.current": true}),
 	)
 
 	if err == sql.ErrNoRows {
 		return model.Config{}, nil
 	} else if err != nil {
-		return model.Config{}, errors.Wrapf(err, "failed to get config data for customer id '%s'", customerID)
+		return model.Config{}, errors.Wrapf(err, "failed to get config data for environment id '%s'", environmentID)
 	}
 
 	var config model.Config
-	err = json.Unmarshal(rawConfig.Config, &config)
-	if err != nil {
+	if err = json.Unmarshal(rawConfig.Config, &config); err != nil {
 		return model.Config{}, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return model.Config{}, errors.Wrap(err, "could not commit transaction")
-	}
-
 	return config, nil
 }
 
-func (s *customerStore) storeConfig(userID string, customerID string, config *model.Config) error {
-	configJSON, err := json.Marshal(config)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal config")
+// getConfigByAudit returns the config stored with the audit row, or an empty config if there is none.
+func (s *customerStore) getConfigByAudit(q queryer, auditID string) (model.Config, error) {
+	if auditID == "" {
+		return model.Config{}, nil
 	}
 
-	existingConfig, err := s.GetConfig(customerID)
+	var rawConfig sqlConfig
+	err := s.store.getBuilder(q, &rawConfig, s.configValuesSelect.Where(sq.Eq{"ccv.auditId": auditID}))
+	if err == sql.ErrNoRows {
+		return model.Config{}, nil
+	} else if err != nil {
+		return model.Config{}, errors.Wrapf(err, "failed to get config data for audit id '%s'", auditID)
+	}
 
-	if err != nil {
-		return errors.Wrap(err, "failed to get existing config")
+	var config model.Config
+	if err = json.Unmarshal(rawConfig.Config, &config); err != nil {
+		return model.Config{}, err
 	}
 
-	diff, err := diffConfig(&existingConfig, config)
+	return config, nil
+}
 
+// storeConfig stores the config as the current one for the revision's environment, or as history
+// for older revisions, returning the ID of the audit row created.
+func (s *customerStore) storeConfig(q queryExecer, userID string, updateType UpdateType, customerID string, config *model.Config, rev revision) (string, error) {
+	configJSON, err := json.Marshal(config)
 	if err != nil {
-		return errors.Wrap(err, "failed to diff config")
+		return "", errors.Wrap(err, "failed to marshal config")
 	}
 
-	auditID, err := s.createAuditRow(customerID, userID, diff)
+	var existingConfig model.Config
+	if rev.history {
+		existingConfig, err = s.getConfigByAudit(q, rev.previousConfig)
+	} else {
+		existingConfig, err = s.getConfig(q, rev.environment)
+	}
 	if err != nil {
-		return errors.Wrap(err, "failed to create audit row")
+		return "", errors.Wrap(err, "failed to get existing config")
 	}
 
-	_, err = s.store.execBuilder(s.store.db, sq.
-		Update(configTable).
-		SetMap(map[string]interface{}{
-			"current": false,
-		}).
-		Where(sq.Eq{"customerId": customerID}))
+	diff, err := diffConfig(&existingConfig, config)
 
 	if err != nil {
-		return errors.Wrap(err, "failed to set old config inactive")
+		return "", errors.Wrap(err, "failed to diff config")
 	}
 
-	// updating site url in the customer table to always keep it up to date
-	_, err = s.store.execBuilder(s.store.db, sq.
-		Update(customerTable).
-		SetMap(map[string]interface{}{
-			"siteURL": config.ServiceSettings.SiteURL,
-		}).
-		Where(sq.Eq{"id": customerID}))
-
+	auditID, err := s.createAuditRow(q, customerID, userID, updateType, diff, rev.at)
 	if err != nil {
-		return errors.Wrap(err, "failed to update siteURL from config change")
+		return "", errors.Wrap(err, "failed to create audit row")
+	}
+
+	// older revisions are only kept for the history
+	if !rev.history {
+		_, err = s.store.execBuilder(q, sq.
+			Update(configTable).
+			SetMap(map[string]interface{}{
+				"current": false,
+			}).
+			Where(sq.Eq{"environmentId": rev.environment}))
+
+		if err != nil {
+			return "", errors.Wrap(err, "failed to set old config inactive")
+		}
+
+		// updating site url of the environment, and of the customer for its primary one, to always keep it up to date
+		if config.ServiceSettings.SiteURL != nil && *config.ServiceSettings.SiteURL != "" {
+			_, err = s.store.execBuilder(q, sq.
+				Update(environmentTable).
+				SetMap(map[string]interface{}{
+					"siteURL": *config.ServiceSettings.SiteURL,
+				}).
+				Where(sq.Eq{"id": rev.environment}))
+
+			if err != nil {
+				return "", errors.Wrap(err, "failed to update environment siteURL from config change")
+			}
+
+			if rev.primary {
+				_, err = s.store.execBuilder(q, sq.
+					Update(customerTable).
+					SetMap(map[string]interface{}{
+						"siteURL": *config.ServiceSettings.SiteURL,
+					}).
+					Where(sq.Eq{"id": customerID}))
+
+				if err != nil {
+					return "", errors.Wrap(err, "failed to update siteURL from config change")
+				}
+			}
+		}
 	}
 
-	_, err = s.store.execBuilder(s.store.db, sq.
+	_, err = s.store.execBuilder(q, sq.
 		Insert(configTable).
 		SetMap(map[string]interface{}{
-			"ID":         model.NewId(),
-			"AuditID":    auditID,
-			"Current":    true,
-			"CustomerId": customerID,
-			"Config":     string(configJSON),
+			"ID":            model.NewId(),
+			"AuditID":       auditID,
+			"Current":       !rev.history,
+			"CustomerId":    customerID,
+			"EnvironmentId": rev.environment,
+			"Config":        string(configJSON),
 		}))
 	if err != nil {
-		return errors.Wrap(err, "failed to store config")
+		return "", errors.Wrap(err, "failed to store config")
 	}
 
-	return nil
+	return auditID, nil
 }
@@ -0,0 +1,85 @@
+package sqlstore
+
+import (
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+func (s *customerStore) GetCustomerIdentifiers(customerID string) ([]app.CustomerIdentifier, error) {
+	identifiers := []app.CustomerIdentifier{}
+	err := s.store.selectBuilder(s.store.db, &identifiers, s.identifierSelect.
+		Where(sq.Eq{"cid.CustomerID": customerID}).
+		OrderBy("cid.Type", "cid.CreatedAt"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get identifiers for customer '%s'", customerID)
+	}
+
+	return identifiers, nil
+}
+
+func (s *customerStore) AddCustomerIdentifier(identifier app.CustomerIdentifier) error {
+	return s.addCustomerIdentifier(s.store.db, identifier)
+}
+
+func (s *customerStore) addCustomerIdentifier(e execer, identifier app.CustomerIdentifier) error {
+	_, err := s.store.execBuilder(e, sq.
+		Insert(identifierTable).
+		SetMap(map[string]interface{}{
+			"CustomerID": identifier.CustomerID,
+			"Type":       identifier.Type,
+			"Value":      identifier.Value,
+			"Source":     identifier.Source,
+			"CreatedAt":  identifier.CreatedAt,
+			"CreatedBy":  identifier.CreatedBy,
+		}).
+		Suffix("ON CONFLICT (CustomerID, Type, Value) DO NOTHING"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to add %s identifier for customer '%s'", identifier.Type, identifier.CustomerID)
+	}
+
+	return nil
+}
+
+// addPacketIdentifiers adds the identifiers of an uploaded packet to the customer's set, so the
+// next packet from the same server matches even if its site URL or license holder changed.
+func (s *customerStore) addPacketIdentifiers(e execer, customerID string, userID string, createdAt int64, identity app.PacketIdentity) error {
+	for identifierType, value := range identity.Identifiers() {
+		err := s.addCustomerIdentifier(e, app.CustomerIdentifier{
+			CustomerID: customerID,
+			Type:       identifierType,
+			Value:      value,
+			Source:     app.IdentifierSourcePacket,
+			CreatedAt:  createdAt,
+			CreatedBy:  userID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *customerStore) RemoveCustomerIdentifier(customerID string, identifierType app.IdentifierType, value string) error {
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Delete(identifierTable).
+		Where(sq.Eq{
+			"CustomerID": customerID,
+			"Type":       identifierType,
+			"Value":      value,
+		}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to remove %s identifier for customer '%s'", identifierType, customerID)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to remove %s identifier for customer '%s'", identifierType, customerID)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "customer '%s' has no %s identifier '%s'", customerID, identifierType, value)
+	}
+
+	return nil
+}
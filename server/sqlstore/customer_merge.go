@@ -0,0 +1,372 @@
+package sqlstore
+
+import (
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+	"github.com/r3labs/diff"
+)
+
+func (s *customerStore) CountCustomerRows(id string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(customerDataTables))
+	for _, data := range customerDataTables {
+		var count int64
+		err := s.store.getBuilder(s.store.db, &count, s.queryBuilder.
+			Select("COUNT(*)").
+			From(data.table).
+			Where(sq.Eq{"CustomerID": id}))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to count %s of customer '%s'", data.name, id)
+		}
+		counts[data.name] = count
+	}
+
+	return counts, nil
+}
+
+func (s *customerStore) MergeCustomers(merged app.Customer, source app.Customer, userID string) (map[string]int64, error) {
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	// both are locked, in the order of their IDs so merges the other way around wait instead
+	// of deadlocking
+	if source.ID < merged.ID {
+		if err = s.lockCustomer(tx, source.ID); err != nil {
+			return nil, err
+		}
+	}
+	var before sqlCustomers
+	err = s.store.getBuilder(tx, &before, s.customerSelect.Where(sq.Eq{"ci.ID": merged.ID}).Suffix("FOR UPDATE"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get customer '%s' before merging", merged.ID)
+	}
+	if source.ID > merged.ID {
+		if err = s.lockCustomer(tx, source.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	moved := make(map[string]int64, len(customerDataTables))
+	for _, data := range customerDataTables {
+		var count int64
+		switch data.table {
+		case identifierTable:
+			count, err = s.mergeIdentifiers(tx, merged.ID, source, userID)
+		case packetPinTable:
+			count, err = s.mergePins(tx, merged.ID, source.ID)
+		case environmentTable:
+			count, err = s.mergeEnvironments(tx, merged.ID, source.ID)
+		default:
+			count, err = s.moveCustomerRows(tx, data.table, merged.ID, source.ID)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to merge %s", data.name)
+		}
+		moved[data.name] = count
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Update(customerTable).
+		SetMap(map[string]interface{}{
+			"name":                    merged.Name,
+			"lastUpdated":             sq.Expr("GREATEST(lastUpdated, ?)", source.LastUpdated),
+			"salesforceId":            merged.SalesforceID,
+			"zendeskId":               merged.ZendeskID,
+			"customerSuccessManager":  merged.CustomerSuccessManager,
+			"accountExecutive":        merged.AccountExecutive,
+			"technicalAccountManager": merged.TechnicalAccountManager,
+			"productManager":          merged.ProductManager,
+			"licensedTo":              merged.LicensedTo,
+			"siteUrl":                 merged.SiteURL,
+			"licenseType":             merged.LicenseType,
+			"customerChannel":         merged.CustomerChannel,
+			"gdriveLink":              merged.GDriveLink,
+			"airGapped":               merged.AirGapped,
+			"airGappedReason":         merged.AirGappedReason,
+			"region":                  merged.Region,
+			"status":                  merged.Status,
+			"companyType":             merged.CompanyType,
+			"codeWord":                merged.CodeWord,
+			"postPacketSummaries":     merged.PostPacketSummaries,
+		}).
+		Where(sq.Eq{"id": merged.ID}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to update merged customer '%s'", merged.ID)
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Delete(customerTable).
+		Where(sq.Eq{"ID": source.ID}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to delete merged customer '%s'", source.ID)
+	}
+
+	if err = s.replacePendingCandidate(tx, source.ID, merged.ID); err != nil {
+		return nil, err
+	}
+
+	changelog, err := diff.Diff(before.Customer, merged)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to diff merged customer")
+	}
+	changelog = append(changelog, diff.Change{
+		Type: string(Merge),
+		Path: []string{"customer"},
+		From: source.ID,
+		To:   merged.ID,
+	})
+	if _, err = s.createAuditRow(tx, merged.ID, userID, Merge, changelog, model.GetMillis()); err != nil {
+		return nil, errors.Wrap(err, "failed to create merge audit row")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "could not commit transaction")
+	}
+
+	return moved, nil
+}
+
+// environmentValueTables hold the rows stored for an environment.
+var environmentValueTables = []string{packetTable, configTable, pluginTable, nodeTable, snapshotTable}
+
+// mergeEnvironments moves the source's environments to the target as secondary ones. Those that
+// are the same server as one of the target's, by MatchEnvironment, are merged into it instead.
+func (s *customerStore) mergeEnvironments(tx *sqlx.Tx, targetID string, sourceID string) (int64, error) {
+	var targetEnvironments, sourceEnvironments []app.Environment
+	if err := s.store.selectBuilder(tx, &targetEnvironments, s.environmentSelect.Where(sq.Eq{"ce.CustomerID": targetID})); err != nil {
+		return 0, err
+	}
+	if err := s.store.selectBuilder(tx, &sourceEnvironments, s.environmentSelect.Where(sq.Eq{"ce.CustomerID": sourceID})); err != nil {
+		return 0, err
+	}
+
+	for _, source := range sourceEnvironments {
+		i, ok := matchEnvironmentIndex(targetEnvironments, app.PacketIdentity{SiteURL: source.SiteURL, TelemetryID: source.TelemetryID})
+		if !ok {
+			_, err := s.store.execBuilder(tx, sq.
+				Update(environmentTable).
+				SetMap(map[string]interface{}{
+					"CustomerID": targetID,
+					"IsPrimary":  false,
+				}).
+				Where(sq.Eq{"ID": source.ID}))
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		target := &targetEnvironments[i]
+		if err := s.mergeEnvironment(tx, target, source); err != nil {
+			return 0, errors.Wrapf(err, "failed to merge environment '%s' into '%s'", source.ID, target.ID)
+		}
+	}
+
+	return int64(len(sourceEnvironments)), nil
+}
+
+func matchEnvironmentIndex(environments []app.Environment, identity app.PacketIdentity) (int, bool) {
+	matched, ok := app.MatchEnvironment(environments, identity)
+	if !ok {
+		return 0, false
+	}
+	for i := range environments {
+		if environments[i].ID == matched.ID {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// mergeEnvironment moves the source environment's rows to the target one, which keeps the newest
+// current values of the two and learns the identifiers it was missing, then deletes the source.
+func (s *customerStore) mergeEnvironment(tx *sqlx.Tx, target *app.Environment, source app.Environment) error {
+	if err := s.mergeCurrentValues(tx, target.ID, source.ID); err != nil {
+		return err
+	}
+
+	for _, table := range environmentValueTables {
+		_, err := s.store.execBuilder(tx, sq.
+			Update(table).
+			SetMap(map[string]interface{}{
+				"EnvironmentID": target.ID,
+			}).
+			Where(sq.Eq{"EnvironmentID": source.ID}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if target.SiteURL == "" {
+		target.SiteURL = source.SiteURL
+	}
+	if target.TelemetryID == "" {
+		target.TelemetryID = source.TelemetryID
+	}
+	_, err := s.store.execBuilder(tx, sq.
+		Update(environmentTable).
+		SetMap(map[string]interface{}{
+			"SiteURL":     target.SiteURL,
+			"TelemetryID": target.TelemetryID,
+		}).
+		Where(sq.Eq{"ID": target.ID}))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Delete(environmentTable).
+		Where(sq.Eq{"ID": source.ID}))
+
+	return err
+}
+
+// mergeCurrentValues keeps the newest current values of the two environments, by when they were
+// stored. The nodes follow the packet they were uploaded with.
+func (s *customerStore) mergeCurrentValues(tx *sqlx.Tx, targetID string, sourceID string) error {
+	for _, tables := range [][]string{{packetTable, nodeTable}, {configTable}, {pluginTable}} {
+		targetAt, err := s.currentValuesAt(tx, tables[0], targetID)
+		if err != nil {
+			return err
+		}
+		sourceAt, err := s.currentValuesAt(tx, tables[0], sourceID)
+		if err != nil {
+			return err
+		}
+
+		outdatedID := sourceID
+		if sourceAt > targetAt {
+			outdatedID = targetID
+		}
+
+		for _, table := range tables {
+			_, err = s.store.execBuilder(tx, sq.
+				Update(table).
+				SetMap(map[string]interface{}{
+					"Current": false,
+				}).
+				Where(sq.Eq{"EnvironmentID": outdatedID}))
+			if err != nil {
+				return errors.Wrapf(err, "failed to replace current values of environment '%s'", outdatedID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// currentValuesAt returns when the environment's current values in the table were stored, zero if
+// there are none.
+func (s *customerStore) currentValuesAt(tx *sqlx.Tx, table string, environmentID string) (int64, error) {
+	var updatedAt int64
+	err := s.store.getBuilder(tx, &updatedAt, s.queryBuilder.
+		Select("COALESCE(MAX(a.UpdatedAt), 0)").
+		From(table+" as v").
+		Join(auditTable+" as a ON a.ID = v.AuditID").
+		Where(sq.Eq{"v.EnvironmentID": environmentID}).
+		Where(sq.Eq{"v.Current": true}))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get current values of environment '%s'", environmentID)
+	}
+
+	return updatedAt, nil
+}
+
+func (s *customerStore) moveCustomerRows(tx *sqlx.Tx, table string, targetID string, sourceID string) (int64, error) {
+	result, err := s.store.execBuilder(tx, sq.
+		Update(table).
+		SetMap(map[string]interface{}{
+			"CustomerID": targetID,
+		}).
+		Where(sq.Eq{"CustomerID": sourceID}))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// mergeIdentifiers adds the source's identifiers to the target's set, along with its site URL and
+// license holder, so packets matching either customer land on the target.
+func (s *customerStore) mergeIdentifiers(tx *sqlx.Tx, targetID string, source app.Customer, userID string) (int64, error) {
+	result, err := s.store.execBuilder(tx, sq.
+		Insert(identifierTable).
+		Columns("CustomerID", "Type", "Value", "Source", "CreatedAt", "CreatedBy").
+		Select(sq.
+			Select().
+			Column("CAST(? AS TEXT)", targetID).
+			Columns("Type", "Value", "Source", "CreatedAt", "CreatedBy").
+			From(identifierTable).
+			Where(sq.Eq{"CustomerID": source.ID})).
+		Suffix("ON CONFLICT (CustomerID, Type, Value) DO NOTHING"))
+	if err != nil {
+		return 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	now := model.GetMillis()
+	for identifierType, value := range map[app.IdentifierType]string{
+		app.IdentifierSiteURL:    source.SiteURL,
+		app.IdentifierLicensedTo: source.LicensedTo,
+	} {
+		if value == "" {
+			continue
+		}
+		err = s.addCustomerIdentifier(tx, app.CustomerIdentifier{
+			CustomerID: targetID,
+			Type:       identifierType,
+			Value:      value,
+			Source:     app.IdentifierSourceMerge,
+			CreatedAt:  now,
+			CreatedBy:  userID,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Delete(identifierTable).
+		Where(sq.Eq{"CustomerID": source.ID}))
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
+
+// mergePins moves the source's pins of the fields the target hasn't pinned.
+func (s *customerStore) mergePins(tx *sqlx.Tx, targetID string, sourceID string) (int64, error) {
+	result, err := s.store.execBuilder(tx, sq.
+		Update(packetPinTable).
+		SetMap(map[string]interface{}{
+			"CustomerID": targetID,
+		}).
+		Where(sq.Eq{"CustomerID": sourceID}).
+		Where(sq.Expr("Field NOT IN (SELECT Field FROM "+packetPinTable+" WHERE CustomerID = ?)", targetID)))
+	if err != nil {
+		return 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Delete(packetPinTable).
+		Where(sq.Eq{"CustomerID": sourceID}))
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+func (s *customerStore) GetNodes(customerID string) ([]app.CustomerNodeValues, error) {
+	if customerID == "" {
+		return []app.CustomerNodeValues{}, errors.New("ID cannot be empty")
+	}
+
+	environmentID, err := s.primaryEnvironmentID(s.store.db, customerID)
+	if err != nil {
+		return []app.CustomerNodeValues{}, err
+	}
+
+	return s.getNodes(environmentID)
+}
+
+// getNodes returns the environment's current nodes.
+func (s *customerStore) getNodes(environmentID string) ([]app.CustomerNodeValues, error) {
+	if environmentID == "" {
+		return []app.CustomerNodeValues{}, nil
+	}
+
+	rawNodes := []app.CustomerNodeValues{}
+	err := s.store.selectBuilder(
+		s.store.db,
+		&rawNodes,
+		s.nodeValuesSelect.
+			Where(sq.Eq{"cnv.environmentId": environmentID}).
+			Where(sq.Eq{"cnv.current": true}),
+	)
+	if err != nil {
+		return []app.CustomerNodeValues{}, errors.Wrapf(err, "failed to get node data for environment id '%s'", environmentID)
+	}
+
+	return rawNodes, nil
+}
+
+// storeNodes replaces the current nodes of the revision's environment. The nodes share the audit row of the
+// packet they were uploaded with. Passing no nodes clears the current nodes, which is what
+// happens when a customer goes from a cluster back to a single server. Nodes of older revisions
+// are only kept for the history.
+func (s *customerStore) storeNodes(e execer, auditID string, customerID string, nodes []app.CustomerNodeValues, rev revision) error {
+	if !rev.history {
+		_, err := s.store.execBuilder(e, sq.
+			Update(nodeTable).
+			SetMap(map[string]interface{}{
+				"current": false,
+			}).
+			Where(sq.Eq{"environmentId": rev.environment}))
+
+		if err != nil {
+			return errors.Wrap(err, "failed to set old node data inactive")
+		}
+	}
+
+	for _, node := range nodes {
+		_, err := s.store.execBuilder(e, sq.
+			Insert(nodeTable).
+			SetMap(map[string]interface{}{
+				"ID":                    model.NewId(),
+				"AuditID":               auditID,
+				"CustomerID":            customerID,
+				"EnvironmentID":         rev.environment,
+				"Current":               !rev.history,
+				"NodeID":                node.NodeID,
+				"Version":               node.Version,
+				"BuildHash":             node.BuildHash,
+				"ServerOS":              node.ServerOS,
+				"ServerArch":            node.ServerArch,
+				"DatabaseVersion":       node.DatabaseVersion,
+				"DatabaseSchemaVersion": node.DatabaseSchemaVersion,
+				"WebsocketConnections":  node.WebsocketConnections,
+				"MasterDBConnections":   node.MasterDBConnections,
+				"ReplicaDBConnections":  node.ReplicaDBConnections,
+			}))
+		if err != nil {
+			return errors.Wrap(err, "failed to store node")
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package sqlstore
 
 import (
 	"database/sql"
+	"encoding/json"
 
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -10,75 +11,153 @@ import (
 )
 
 func (s *customerStore) GetPacket(customerID string) (app.CustomerPacketValues, error) {
+	return s.getPacket(s.store.db, customerID)
+}
+
+// getPacket returns the current packet values of the customer's primary environment, with the
+// pinned ones in place of the values stored from packets.
+func (s *customerStore) getPacket(q queryer, customerID string) (app.CustomerPacketValues, error) {
+	environmentID, err := s.primaryEnvironmentID(q, customerID)
+	if err != nil {
+		return app.CustomerPacketValues{}, err
+	}
+
+	stored, _, err := s.getStoredPacket(q, environmentID)
+	if err != nil {
+		return app.CustomerPacketValues{}, err
+	}
+
+	pins, err := s.getPacketPins(q, customerID)
+	if err != nil {
+		return app.CustomerPacketValues{}, err
+	}
+
+	return app.ApplyPacketPins(stored, pins), nil
+}
+
+func (s *customerStore) GetStoredPacket(customerID string) (app.CustomerPacketValues, app.PacketSources, error) {
 	if customerID == "" {
-		return app.CustomerPacketValues{}, errors.New("ID cannot be empty")
+		return app.CustomerPacketValues{}, nil, errors.New("ID cannot be empty")
 	}
 
-	tx, err := s.store.db.Beginx()
+	environmentID, err := s.primaryEnvironmentID(s.store.db, customerID)
 	if err != nil {
-		return app.CustomerPacketValues{}, errors.Wrap(err, "could not begin transaction")
+		return app.CustomerPacketValues{}, nil, err
+	}
+
+	return s.getStoredPacket(s.store.db, environmentID)
+}
+
+// getStoredPacket returns the environment's current packet values as stored from packets, or
+// empty values if it has none.
+func (s *customerStore) getStoredPacket(q queryer, environmentID string) (app.CustomerPacketValues, app.PacketSources, error) {
+	if environmentID == "" {
+		return app.CustomerPacketValues{}, app.PacketSources{}, nil
 	}
-	defer s.store.finalizeTransaction(tx)
 
 	var rawPacket sqlPacket
-	err = s.store.getBuilder(
-		tx,
+	err := s.store.getBuilder(
+		q,
 		&rawPacket,
 		s.packetValuesSelect.
-			Where(sq.Eq{"cp.customerId": customerID}).
+			Where(sq.Eq{"cp.environmentId": environmentID}).
 			Where(sq.Eq{"cp.current": true}),
 	)
 
 	if err == sql.ErrNoRows {
-		return app.CustomerPacketValues{}, nil
+		return app.CustomerPacketValues{}, app.PacketSources{}, nil
 	} else if err != nil {
-		return app.CustomerPacketValues{}, errors.Wrapf(err, "failed to get packet data for customer id '%s'", customerID)
+		return app.CustomerPacketValues{}, nil, errors.Wrapf(err, "failed to get packet data for environment id '%s'", environmentID)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return app.CustomerPacketValues{}, errors.Wrap(err, "could not commit transaction")
+	return toPacket(rawPacket)
+}
+
+func toPacket(rawPacket sqlPacket) (app.CustomerPacketValues, app.PacketSources, error) {
+	sources := app.PacketSources{}
+	if err := json.Unmarshal(rawPacket.Sources, &sources); err != nil {
+		return app.CustomerPacketValues{}, nil, errors.Wrap(err, "failed to unmarshal packet sources")
 	}
 
-	return rawPacket.CustomerPacketValues, nil
+	return rawPacket.CustomerPacketValues, sources, nil
 }
 
-func (s *customerStore) storePacket(userID string, customerID string, packet *app.CustomerPacketValues) error {
-	existingPacket, err := s.GetPacket(customerID)
+// getPacketByAudit returns the packet stored with the audit row, or empty values if there is none.
+func (s *customerStore) getPacketByAudit(q queryer, auditID string) (app.CustomerPacketValues, app.PacketSources, error) {
+	if auditID == "" {
+		return app.CustomerPacketValues{}, app.PacketSources{}, nil
+	}
 
-	if err != nil {
-		return errors.Wrap(err, "failed to get existing packet")
+	var rawPacket sqlPacket
+	err := s.store.getBuilder(q, &rawPacket, s.packetValuesSelect.Where(sq.Eq{"cp.auditId": auditID}))
+	if err == sql.ErrNoRows {
+		return app.CustomerPacketValues{}, app.PacketSources{}, nil
+	} else if err != nil {
+		return app.CustomerPacketValues{}, nil, errors.Wrapf(err, "failed to get packet data for audit id '%s'", auditID)
 	}
 
-	_, err = s.store.execBuilder(s.store.db, sq.
-		Update(packetTable).
-		SetMap(map[string]interface{}{
-			"current": false,
-		}).
-		Where(sq.Eq{"customerId": customerID}))
+	return toPacket(rawPacket)
+}
+
+// revisionPacket returns the stored packet values the revision replaces, or the ones it follows
+// in the history for older revisions.
+func (s *customerStore) revisionPacket(q queryer, rev revision) (app.CustomerPacketValues, app.PacketSources, error) {
+	if rev.history {
+		return s.getPacketByAudit(q, rev.previousPacket)
+	}
+
+	return s.getStoredPacket(q, rev.environment)
+}
 
+// storePacket stores the packet as the current one for the revision's environment, or as history
+// for older revisions, returning the ID of the audit row created.
+func (s *customerStore) storePacket(q queryExecer, userID string, updateType UpdateType, customerID string, packet *app.CustomerPacketValues, sources app.PacketSources, rev revision) (string, error) {
+	if sources == nil {
+		sources = app.PacketSources{}
+	}
+	sourcesJSON, err := json.Marshal(sources)
 	if err != nil {
-		return errors.Wrap(err, "failed to delete old packet data")
+		return "", errors.Wrap(err, "failed to marshal packet sources")
+	}
+
+	existingPacket, _, err := s.revisionPacket(q, rev)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get existing packet")
+	}
+
+	if !rev.history {
+		_, err = s.store.execBuilder(q, sq.
+			Update(packetTable).
+			SetMap(map[string]interface{}{
+				"current": false,
+			}).
+			Where(sq.Eq{"environmentId": rev.environment}))
+
+		if err != nil {
+			return "", errors.Wrap(err, "failed to delete old packet data")
+		}
 	}
 
 	diff, err := diffPacket(&existingPacket, packet)
 
 	if err != nil {
-		return errors.Wrap(err, "failed to diff packet")
+		return "", errors.Wrap(err, "failed to diff packet")
 	}
 
-	auditID, err := s.createAuditRow(customerID, userID, diff)
+	auditID, err := s.createAuditRow(q, customerID, userID, updateType, diff, rev.at)
 	if err != nil {
-		return errors.Wrap(err, "failed to create audit row")
+		return "", errors.Wrap(err, "failed to create audit row")
 	}
 
 	newID := model.NewId()
-	_, err = s.store.execBuilder(s.store.db, sq.
+	_, err = s.store.execBuilder(q, sq.
 		Insert(packetTable).
 		SetMap(map[string]interface{}{
 			"ID":                    newID,
 			"AuditID":               auditID,
 			"CustomerID":            customerID,
-			"Current":               true,
+			"EnvironmentID":         rev.environment,
+			"Current":               !rev.history,
 			"LicensedTo":            packet.LicensedTo,
 			"Version":               packet.Version,
 			"ServerOS":              packet.ServerOS,
@@ -104,23 +183,26 @@ func (s *customerStore) storePacket(userID string, customerID string, packet *ap
 			"ProductsInUse":         packet.ProductsInUse,
 			"SAMLProvider":          packet.SAMLProvider,
 			"LDAPProvider":          packet.LDAPProvider,
+			"Sources":               string(sourcesJSON),
 		}))
 
 	if err != nil {
-		return errors.Wrap(err, "failed to store packet")
+		return "", errors.Wrap(err, "failed to store packet")
 	}
 
-	// updating licensedTo in the customer table to always keep it up to date
-	_, err = s.store.execBuilder(s.store.db, sq.
-		Update(customerTable).
-		SetMap(map[string]interface{}{
-			"LicensedTo": packet.LicensedTo,
-		}).
-		Where(sq.Eq{"id": customerID}))
-
-	if err != nil {
-		return errors.Wrap(err, "failed to update licensedTo from packet update")
+	// updating licensedTo in the customer table to always keep it up to date with its primary environment
+	if packet.LicensedTo != "" && !rev.history && rev.primary {
+		_, err = s.store.execBuilder(q, sq.
+			Update(customerTable).
+			SetMap(map[string]interface{}{
+				"LicensedTo": packet.LicensedTo,
+			}).
+			Where(sq.Eq{"id": customerID}))
+
+		if err != nil {
+			return "", errors.Wrap(err, "failed to update licensedTo from packet update")
+		}
 	}
 
-	return nil
+	return auditID, nil
 }
@@ -1,8 +1,6 @@
 package sqlstore
 
 import (
-	"database/sql"
-
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
 	"github.com/mattermost/mattermost/server/public/model"
 	sq "github.com/mattermost/squirrel"
@@ -14,79 +12,108 @@ func (s *customerStore) GetPlugins(customerID string) ([]app.CustomerPluginValue
 		return []app.CustomerPluginValues{}, errors.New("ID cannot be empty")
 	}
 
-	tx, err := s.store.db.Beginx()
+	environmentID, err := s.primaryEnvironmentID(s.store.db, customerID)
 	if err != nil {
-		return []app.CustomerPluginValues{}, errors.Wrap(err, "could not begin transaction")
+		return []app.CustomerPluginValues{}, err
 	}
-	defer s.store.finalizeTransaction(tx)
-	var rawPlugins []app.CustomerPluginValues
-	err = s.store.selectBuilder(
-		tx,
+
+	return s.getPlugins(s.store.db, environmentID)
+}
+
+// getPlugins returns the environment's current plugins.
+func (s *customerStore) getPlugins(q queryer, environmentID string) ([]app.CustomerPluginValues, error) {
+	if environmentID == "" {
+		return []app.CustomerPluginValues{}, nil
+	}
+
+	rawPlugins := []app.CustomerPluginValues{}
+	err := s.store.selectBuilder(
+		q,
 		&rawPlugins,
 		s.pluginValuesSelect.
-			Where(sq.Eq{"cpv.customerId": customerID}).
+			Where(sq.Eq{"cpv.environmentId": environmentID}).
 			Where(sq.Eq{"cpv.current": true}),
 	)
+	if err != nil {
+		return []app.CustomerPluginValues{}, errors.Wrapf(err, "failed to get plugin data for environment id '%s'", environmentID)
+	}
+
+	return rawPlugins, nil
+}
 
-	if err == sql.ErrNoRows {
+// getPluginsByAudit returns the plugins stored with the audit row, or none if there is no audit row.
+func (s *customerStore) getPluginsByAudit(q queryer, auditID string) ([]app.CustomerPluginValues, error) {
+	if auditID == "" {
 		return []app.CustomerPluginValues{}, nil
-	} else if err != nil {
-		return []app.CustomerPluginValues{}, errors.Wrapf(err, "failed to get plugin data for customer id '%s'", customerID)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return []app.CustomerPluginValues{}, errors.Wrap(err, "could not commit transaction")
+	var rawPlugins []app.CustomerPluginValues
+	err := s.store.selectBuilder(q, &rawPlugins, s.pluginValuesSelect.Where(sq.Eq{"cpv.auditId": auditID}))
+	if err != nil {
+		return []app.CustomerPluginValues{}, errors.Wrapf(err, "failed to get plugin data for audit id '%s'", auditID)
 	}
 
 	return rawPlugins, nil
 }
 
-func (s *customerStore) storePlugins(userID string, customerID string, plugins []app.CustomerPluginValues) error {
-	_, err := s.store.execBuilder(s.store.db, sq.
-		Update(pluginTable).
-		SetMap(map[string]interface{}{
-			"current": false,
-		}).
-		Where(sq.Eq{"customerId": customerID}))
-
+// storePlugins stores the plugins as the current ones for the revision's environment, or as
+// history for older revisions, returning the ID of the audit row created.
+func (s *customerStore) storePlugins(q queryExecer, userID string, updateType UpdateType, customerID string, plugins []app.CustomerPluginValues, rev revision) (string, error) {
+	var existingPlugins []app.CustomerPluginValues
+	var err error
+	if rev.history {
+		existingPlugins, err = s.getPluginsByAudit(q, rev.previousPlugins)
+	} else {
+		// read before the old rows stop being current, otherwise the diff is against nothing
+		existingPlugins, err = s.getPlugins(q, rev.environment)
+	}
 	if err != nil {
-		return errors.Wrap(err, "failed to delete old plugin data")
+		return "", errors.Wrap(err, "failed to get existing plugins")
 	}
 
-	existingPlugins, err := s.GetPlugins(customerID)
-	if err != nil {
-		return errors.Wrap(err, "failed to get existing plugins")
+	if !rev.history {
+		_, err = s.store.execBuilder(q, sq.
+			Update(pluginTable).
+			SetMap(map[string]interface{}{
+				"current": false,
+			}).
+			Where(sq.Eq{"environmentId": rev.environment}))
+
+		if err != nil {
+			return "", errors.Wrap(err, "failed to delete old plugin data")
+		}
 	}
 
 	diff, err := diffPlugins(existingPlugins, plugins)
 
 	if err != nil {
-		return errors.Wrap(err, "failed to diff plugins")
+		return "", errors.Wrap(err, "failed to diff plugins")
 	}
 
-	auditID, err := s.createAuditRow(customerID, userID, diff)
+	auditID, err := s.createAuditRow(q, customerID, userID, updateType, diff, rev.at)
 	if err != nil {
-		return errors.Wrap(err, "failed to create audit row")
+		return "", errors.Wrap(err, "failed to create audit row")
 	}
 
 	for _, plugin := range plugins {
-		_, err := s.store.execBuilder(s.store.db, sq.
+		_, err := s.store.execBuilder(q, sq.
 			Insert(pluginTable).
 			SetMap(map[string]interface{}{
-				"ID":          model.NewId(),
-				"AuditID":     auditID,
-				"CustomerID":  customerID,
-				"Current":     true,
-				"PluginID":    plugin.PluginID,
-				"Version":     plugin.Version,
-				"IsActive":    plugin.IsActive,
-				"Name":        plugin.Name,
-				"HomePageURL": plugin.HomePageURL,
+				"ID":            model.NewId(),
+				"AuditID":       auditID,
+				"CustomerID":    customerID,
+				"EnvironmentID": rev.environment,
+				"Current":       !rev.history,
+				"PluginID":      plugin.PluginID,
+				"Version":       plugin.Version,
+				"IsActive":      plugin.IsActive,
+				"Name":          plugin.Name,
+				"HomePageURL":   plugin.HomePageURL,
 			}))
 		if err != nil {
-			return errors.Wrap(err, "failed to store plugin")
+			return "", errors.Wrap(err, "failed to store plugin")
 		}
 	}
 
-	return nil
+	return auditID, nil
 }
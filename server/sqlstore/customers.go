@@ -7,6 +7,7 @@ import (
 	"math"
 
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/jmoiron/sqlx"
 	"github.com/mattermost/mattermost/server/public/model"
 	sq "github.com/mattermost/squirrel"
 	"github.com/pkg/errors"
@@ -21,6 +22,13 @@ type customerStore struct {
 	packetValuesSelect sq.SelectBuilder
 	configValuesSelect sq.SelectBuilder
 	pluginValuesSelect sq.SelectBuilder
+	nodeValuesSelect   sq.SelectBuilder
+	snapshotSelect     sq.SelectBuilder
+	logEntriesSelect   sq.SelectBuilder
+	findingsSelect     sq.SelectBuilder
+	findingRulesSelect sq.SelectBuilder
+	identifierSelect   sq.SelectBuilder
+	environmentSelect  sq.SelectBuilder
 }
 
 type sqlCustomers struct {
@@ -29,6 +37,7 @@ type sqlCustomers struct {
 
 type sqlPacket struct {
 	app.CustomerPacketValues
+	Sources json.RawMessage `db:"sources"`
 }
 
 type sqlConfig struct {
@@ -36,11 +45,18 @@ type sqlConfig struct {
 }
 
 const (
-	customerTable = "crm_customers"
-	packetTable   = "crm_packetValues"
-	configTable   = "crm_configValues"
-	pluginTable   = "crm_pluginValues"
-	auditTable    = "crm_audit"
+	customerTable    = "crm_customers"
+	packetTable      = "crm_packetValues"
+	configTable      = "crm_configValues"
+	pluginTable      = "crm_pluginValues"
+	auditTable       = "crm_audit"
+	nodeTable        = "crm_nodeValues"
+	snapshotTable    = "crm_snapshots"
+	logTable         = "crm_logEntries"
+	findingTable     = "crm_findings"
+	ruleSetTable     = "crm_findingRules"
+	identifierTable  = "crm_customerIdentifiers"
+	environmentTable = "crm_environments"
 )
 
 type UpdateType string
@@ -48,9 +64,14 @@ type UpdateType string
 const (
 	Packet UpdateType = "packet"
 	User   UpdateType = "user"
+	Merge  UpdateType = "merge"
 )
 
 func applyCustomerFilterOptionsSort(builder sq.SelectBuilder, options app.CustomerFilterOptions) (sq.SelectBuilder, error) {
+	if !options.IncludeArchived {
+		builder = builder.Where(sq.Eq{"ci.archivedAt": 0})
+	}
+
 	var searchTerm string
 	if options.SearchTerm != "" {
 		searchTerm = "%" + options.SearchTerm + "%"
@@ -139,6 +160,9 @@ func NewCustomerStore(pluginAPI PluginAPIClient, sqlStore *SQLStore) app.Custome
 			"ci.Status",
 			"ci.CompanyType",
 			"ci.CodeWord",
+			"ci.PostPacketSummaries",
+			"ci.ArchivedAt",
+			"ci.ArchivedBy",
 		).
 		From(customerTable + " as ci")
 
@@ -169,6 +193,7 @@ func NewCustomerStore(pluginAPI PluginAPIClient, sqlStore *SQLStore) app.Custome
 			"cp.ProductsInUse",
 			"cp.SAMLProvider",
 			"cp.LDAPProvider",
+			"cp.Sources",
 		).
 		From(packetTable + " as cp")
 
@@ -186,6 +211,102 @@ func NewCustomerStore(pluginAPI PluginAPIClient, sqlStore *SQLStore) app.Custome
 		).
 		From(pluginTable + " as cpv")
 
+	nodeValuesSelect := sqlStore.builder.
+		Select(
+			"cnv.NodeID",
+			"cnv.Version",
+			"cnv.BuildHash",
+			"cnv.ServerOS",
+			"cnv.ServerArch",
+			"cnv.DatabaseVersion",
+			"cnv.DatabaseSchemaVersion",
+			"cnv.WebsocketConnections",
+			"cnv.MasterDBConnections",
+			"cnv.ReplicaDBConnections",
+		).
+		From(nodeTable + " as cnv").
+		OrderBy("cnv.NodeID")
+
+	snapshotSelect := sqlStore.builder.
+		Select(
+			"cs.ID",
+			"cs.CustomerID",
+			"cs.EnvironmentID",
+			"cs.CreatedAt",
+			"cs.FileName",
+			"cs.PacketAuditID",
+			"cs.ConfigAuditID",
+			"cs.PluginsAuditID",
+			"cs.UserID",
+			"cs.PostID",
+			"cs.ChannelID",
+			"cs.TicketRef",
+			"cs.ArchiveHash",
+			"cs.ContentHash",
+			"cs.ArchivePath",
+			"cs.ArchiveSize",
+		).
+		From(snapshotTable + " as cs")
+
+	logEntriesSelect := sqlStore.builder.
+		Select(
+			"cle.Level",
+			"cle.Source",
+			"cle.Message",
+			"cle.Caller",
+			"cle.Example",
+			"cle.Count",
+			"cle.FirstSeen",
+			"cle.LastSeen",
+			"cle.Timeline",
+		).
+		From(logTable + " as cle")
+
+	findingsSelect := sqlStore.builder.
+		Select(
+			"cf.RuleID",
+			"cf.Severity",
+			"cf.Title",
+			"cf.Remediation",
+		).
+		From(findingTable + " as cf")
+
+	findingRulesSelect := sqlStore.builder.
+		Select(
+			"cfr.ID",
+			"cfr.Version",
+			"cfr.CreatedAt",
+			"cfr.CreatedBy",
+			"cfr.Rules",
+		).
+		From(ruleSetTable + " as cfr")
+
+	identifierSelect := sqlStore.builder.
+		Select(
+			"cid.CustomerID",
+			"cid.Type",
+			"cid.Value",
+			"cid.Source",
+			"cid.CreatedAt",
+			"cid.CreatedBy",
+		).
+		From(identifierTable + " as cid")
+
+	environmentSelect := sqlStore.builder.
+		Select(
+			"ce.ID",
+			"ce.CustomerID",
+			"ce.Name",
+			"ce.Type",
+			"ce.SiteURL",
+			"ce.TelemetryID",
+			"ce.IsPrimary",
+			"ce.CreatedAt",
+			"ce.CreatedBy",
+		).
+		From(environmentTable+" as ce").
+		OrderBy("ce.IsPrimary DESC", "ce.CreatedAt", "ce.ID")
+
 	return &customerStore{
 		pluginAPI:          pluginAPI,
 		store:              sqlStore,
@@ -194,6 +315,13 @@ func NewCustomerStore(pluginAPI PluginAPIClient, sqlStore *SQLStore) app.Custome
 		packetValuesSelect: packetValuesSelect,
 		configValuesSelect: configValuesSelect,
 		pluginValuesSelect: pluginValuesSelect,
+		nodeValuesSelect:   nodeValuesSelect,
+		snapshotSelect:     snapshotSelect,
+		logEntriesSelect:   logEntriesSelect,
+		findingsSelect:     findingsSelect,
+		findingRulesSelect: findingRulesSelect,
+		identifierSelect:   identifierSelect,
+		environmentSelect:  environmentSelect,
 	}
 }
 
@@ -208,6 +336,10 @@ func (s *customerStore) GetCustomers(opts app.CustomerFilterOptions) (app.GetCus
 		Select("COUNT(*)").
 		From(customerTable)
 
+	if !opts.IncludeArchived {
+		queryForTotal = queryForTotal.Where(sq.Eq{"archivedAt": 0})
+	}
+
 	if opts.SearchTerm != "" {
 		queryForTotal = queryForTotal.Where(sq.Or{
 			sq.ILike{"name": "%" + opts.SearchTerm + "%"},
@@ -270,123 +402,313 @@ func (s *customerStore) GetCustomerByID(id string) (app.FullCustomerInfo, error)
 
 	customer.Customer = rawCustomers.Customer
 
-	config, err := s.GetConfig(customer.ID)
+	environments, err := s.GetEnvironments(id)
 	if err != nil {
 		return app.FullCustomerInfo{}, err
 	}
 
-	customer.Config = config
-
-	plugins, err := s.GetPlugins(customer.ID)
-	if err != nil {
-		return app.FullCustomerInfo{}, err
+	var primary app.EnvironmentInfo
+	primarySources := app.PacketSources{}
+	customer.Environments = make([]app.EnvironmentInfo, 0, len(environments))
+	for _, environment := range environments {
+		info, sources, err := s.environmentInfo(environment)
+		if err != nil {
+			return app.FullCustomerInfo{}, err
+		}
+		if environment.Primary {
+			primary, primarySources = info, sources
+		}
+		customer.Environments = append(customer.Environments, info)
 	}
-	customer.Plugins = plugins
 
-	packet, err := s.GetPacket(id)
+	// the customer's own values are its primary environment's, with the pins in place
+	pins, err := s.getPacketPins(s.store.db, id)
 	if err != nil {
 		return app.FullCustomerInfo{}, err
 	}
 
-	customer.PacketValues = packet
+	customer.PacketValues = app.ApplyPacketPins(primary.PacketValues, pins)
+	customer.PacketFields = app.NewPacketFields(primary.PacketValues, primarySources, pins)
+	customer.Config = primary.Config
+	customer.Plugins = primary.Plugins
+	customer.Nodes = primary.Nodes
 
 	return customer, nil
 }
 
-func (s *customerStore) createCustomer(siteURL string, licensedTo string) (string, error) {
+// lockCustomer locks the customer's row until the transaction ends, or returns ErrNotFound.
+func (s *customerStore) lockCustomer(tx *sqlx.Tx, id string) error {
+	var locked string
+	err := s.store.getBuilder(tx, &locked, s.queryBuilder.
+		Select("ID").
+		From(customerTable).
+		Where(sq.Eq{"ID": id}).
+		Suffix("FOR UPDATE"))
+	if err == sql.ErrNoRows {
+		return errors.Wrapf(app.ErrNotFound, "customer does not exist for id '%s'", id)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to lock customer '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *customerStore) CreateCustomer(customer app.Customer) (string, error) {
 	newID := model.NewId()
 
-	_, err := s.store.execBuilder(s.store.db, sq.
+	name := customer.Name
+	if name == "" {
+		name = customer.LicensedTo
+	}
+	if name == "" {
+		name = customer.SiteURL
+	}
+
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return "", errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	_, err = s.store.execBuilder(tx, sq.
 		Insert(customerTable).
-		// TODO - Should this use some kind of app.customer struct?
-		// the one that I have now pulls the other attributes that should not be stored here.
 		SetMap(map[string]interface{}{
 			"ID":                      newID,
-			"Name":                    licensedTo,
+			"Name":                    name,
 			"LastUpdated":             model.GetMillis(),
-			"SalesforceId":            "",
-			"ZendeskId":               "",
-			"CustomerSuccessManager":  "",
-			"AccountExecutive":        "",
-			"TechnicalAccountManager": "",
-			"ProductManager":          "",
-			"LicensedTo":              licensedTo,
-			"SiteUrl":                 siteURL,
-			"LicenseType":             "",
-			"CustomerChannel":         "",
-			"GDriveLink":              "",
-			"AirGapped":               false,
-			"AirGappedReason":         "",
-			"Region":                  "",
-			"Status":                  "",
-			"CompanyType":             "",
+			"SalesforceId":            customer.SalesforceID,
+			"ZendeskId":               customer.ZendeskID,
+			"CustomerSuccessManager":  customer.CustomerSuccessManager,
+			"AccountExecutive":        customer.AccountExecutive,
+			"TechnicalAccountManager": customer.TechnicalAccountManager,
+			"ProductManager":          customer.ProductManager,
+			"LicensedTo":              customer.LicensedTo,
+			"SiteUrl":                 customer.SiteURL,
+			"LicenseType":             customer.LicenseType,
+			"CustomerChannel":         customer.CustomerChannel,
+			"GDriveLink":              customer.GDriveLink,
+			"AirGapped":               customer.AirGapped,
+			"AirGappedReason":         customer.AirGappedReason,
+			"Region":                  customer.Region,
+			"Status":                  customer.Status,
+			"CompanyType":             customer.CompanyType,
+			"CodeWord":                customer.CodeWord,
+			"PostPacketSummaries":     customer.PostPacketSummaries,
 		}))
 	if err != nil {
 		return "", errors.Wrap(err, "failed to store new customer")
 	}
 
+	_, err = s.createEnvironment(tx, app.Environment{
+		CustomerID: newID,
+		Name:       "Production",
+		Type:       app.EnvironmentProduction,
+		SiteURL:    customer.SiteURL,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store environment of new customer")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "could not commit transaction")
+	}
+
 	return newID, nil
 }
-func (s *customerStore) GetCustomerID(siteURL string, licensedTo string) (id string, err error) {
-	if siteURL == "" || licensedTo == "" {
-		return "", errors.New("must include siteURL or Licensedto")
+
+func (s *customerStore) SetCustomerArchived(id string, userID string, archivedAt int64) error {
+	archivedBy := userID
+	if archivedAt == 0 {
+		archivedBy = ""
 	}
 
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Update(customerTable).
+		SetMap(map[string]interface{}{
+			"ArchivedAt": archivedAt,
+			"ArchivedBy": archivedBy,
+		}).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to archive customer '%s'", id)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to archive customer '%s'", id)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "customer does not exist for id '%s'", id)
+	}
+
+	return nil
+}
+
+// customerDataTables hold the rows stored for a customer, deleted or merged along with it, by the
+// name they're counted under.
+var customerDataTables = []struct {
+	name  string
+	table string
+}{
+	{"packets", packetTable},
+	{"configs", configTable},
+	{"plugins", pluginTable},
+	{"nodes", nodeTable},
+	{"audit", auditTable},
+	{"snapshots", snapshotTable},
+	{"logEntries", logTable},
+	{"findings", findingTable},
+	{"identifiers", identifierTable},
+	{"pins", packetPinTable},
+	{"environments", environmentTable},
+}
+
+func (s *customerStore) DeleteCustomer(id string) error {
 	tx, err := s.store.db.Beginx()
 	if err != nil {
-		return "", errors.Wrap(err, "could not begin transaction")
+		return errors.Wrap(err, "could not begin transaction")
 	}
 	defer s.store.finalizeTransaction(tx)
 
-	query := s.queryBuilder.
-		Select("*").
-		From(customerTable).
-		Where(sq.Or{
-			sq.Eq{"siteUrl": siteURL},
-			sq.Eq{"licensedTo": licensedTo},
-		})
+	result, err := s.store.execBuilder(tx, sq.
+		Delete(customerTable).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete customer '%s'", id)
+	}
 
-	var rawCustomers []sqlCustomers
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete customer '%s'", id)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "customer does not exist for id '%s'", id)
+	}
 
-	err = s.store.selectBuilder(tx, &rawCustomers, query)
+	for _, data := range customerDataTables {
+		_, err = s.store.execBuilder(tx, sq.
+			Delete(data.table).
+			Where(sq.Eq{"CustomerID": id}))
+		if err != nil {
+			return errors.Wrapf(err, "failed to delete %s of customer '%s'", data.name, id)
+		}
+	}
+
+	if err = s.replacePendingCandidate(tx, id, ""); err != nil {
+		return err
+	}
 
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+
+	return nil
+}
+
+func (s *customerStore) MatchCustomers(identity app.PacketIdentity) ([]app.CustomerMatch, error) {
+	identifiers := identity.Identifiers()
+	if len(identifiers) == 0 {
+		return nil, errors.New("must include at least one identifier")
+	}
+
+	// only match on the identifiers that were provided, otherwise an empty
+	// value would match every customer missing that field.
+	knownBy := sq.Or{}
+	for identifierType, value := range identifiers {
+		knownBy = append(knownBy, sq.Eq{"Type": identifierType, "Value": value})
+	}
+	// built with question placeholders, they're numbered once it's part of the outer query
+	knownBySQL, knownByArgs, err := sq.
+		Select("CustomerID").
+		From(identifierTable).
+		Where(knownBy).
+		ToSql()
 	if err != nil {
-		return "", errors.Wrapf(err, "failed find customer with siteURL: '%s' and licensedTo: '%s'", siteURL, licensedTo)
+		return nil, errors.Wrap(err, "failed to build identifier query")
 	}
 
+	matchOn := sq.Or{sq.Expr("ci.ID IN ("+knownBySQL+")", knownByArgs...)}
+	if identity.SiteURL != "" {
+		matchOn = append(matchOn,
+			sq.Eq{"ci.SiteUrl": identity.SiteURL},
+			sq.Expr("ci.ID IN (SELECT CustomerID FROM "+environmentTable+" WHERE SiteURL = ?)", identity.SiteURL),
+		)
+	}
+	if identity.LicensedTo != "" {
+		matchOn = append(matchOn, sq.Eq{"ci.LicensedTo": identity.LicensedTo})
+	}
+
+	var rawCustomers []sqlCustomers
+	err = s.store.selectBuilder(s.store.db, &rawCustomers, s.customerSelect.Where(matchOn))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to match customers with siteURL: '%s' and licensedTo: '%s'", identity.SiteURL, identity.LicensedTo)
+	}
 	if len(rawCustomers) == 0 {
-		return s.createCustomer(siteURL, licensedTo)
+		return []app.CustomerMatch{}, nil
 	}
 
-	if err = tx.Commit(); err != nil {
-		return "", errors.Wrap(err, "could not commit transaction")
+	customerIDs := make([]string, 0, len(rawCustomers))
+	for _, rawCustomer := range rawCustomers {
+		customerIDs = append(customerIDs, rawCustomer.ID)
 	}
 
-	if len(rawCustomers) == 1 {
-		return rawCustomers[0].ID, nil
+	var known []app.CustomerIdentifier
+	err = s.store.selectBuilder(s.store.db, &known, s.identifierSelect.Where(sq.Eq{"cid.CustomerID": customerIDs}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get identifiers of matching customers")
 	}
 
-	var matchingLicense []app.Customer
-	var matchingBoth []app.Customer
+	// the site URLs of every environment are known ones too, even those entered by users
+	var environments []app.Environment
+	err = s.store.selectBuilder(s.store.db, &environments, s.environmentSelect.
+		Where(sq.Eq{"ce.CustomerID": customerIDs}).
+		Where(sq.NotEq{"ce.SiteURL": ""}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get environments of matching customers")
+	}
+	for _, environment := range environments {
+		known = append(known, app.CustomerIdentifier{
+			CustomerID: environment.CustomerID,
+			Type:       app.IdentifierSiteURL,
+			Value:      environment.SiteURL,
+		})
+	}
 
-	for _, customer := range rawCustomers {
-		if customer.LicensedTo == licensedTo && customer.SiteURL == siteURL {
-			matchingBoth = append(matchingBoth, customer.Customer)
-		}
+	knownByCustomer := make(map[string][]app.CustomerIdentifier)
+	for _, identifier := range known {
+		knownByCustomer[identifier.CustomerID] = append(knownByCustomer[identifier.CustomerID], identifier)
+	}
 
-		if customer.LicensedTo == licensedTo {
-			matchingLicense = append(matchingLicense, customer.Customer)
-		}
+	matches := make([]app.CustomerMatch, 0, len(rawCustomers))
+	for _, rawCustomer := range rawCustomers {
+		matches = append(matches, app.ScoreCustomerMatch(rawCustomer.Customer, knownByCustomer[rawCustomer.ID], identity))
+	}
+	app.SortCustomerMatches(matches)
+
+	return matches, nil
+}
+
+func (s *customerStore) GetCustomerID(siteURL string, licensedTo string) (id string, err error) {
+	if siteURL == "" && licensedTo == "" {
+		return "", errors.New("must include siteURL or Licensedto")
+	}
+
+	identity := app.PacketIdentity{SiteURL: siteURL, LicensedTo: licensedTo}
+	matches, err := s.MatchCustomers(identity)
+	if err != nil {
+		return "", err
 	}
 
-	if len(matchingBoth) > 1 || len(matchingLicense) > 1 {
-		// Need to evaluate this logic.
-		return s.createCustomer(siteURL, licensedTo)
-	} else if len(matchingBoth) == 1 || len(matchingLicense) == 1 {
-		return matchingBoth[0].ID, nil
+	if len(matches) == 0 {
+		return s.CreateCustomer(app.Customer{SiteURL: siteURL, LicensedTo: licensedTo})
 	}
 
-	return "", errors.Wrapf(err, "No customer found with siteURL: '%s' and licensedTo: '%s'", siteURL, licensedTo)
+	customer, ok := app.ResolveCustomerMatch(matches)
+	if !ok {
+		return "", errors.Wrapf(app.ErrAmbiguousCustomer, "%d customers match siteURL: '%s' and licensedTo: '%s'", len(matches), siteURL, licensedTo)
+	}
+
+	return customer.ID, nil
 }
 
 func (s *customerStore) UpdateCustomer(customer app.Customer) error {
@@ -412,6 +734,7 @@ func (s *customerStore) UpdateCustomer(customer app.Customer) error {
 			"region":                  customer.Region,
 			"status":                  customer.Status,
 			"companyType":             customer.CompanyType,
+			"postPacketSummaries":     customer.PostPacketSummaries,
 		}).
 		Where(sq.Eq{"id": customer.ID}))
 
@@ -427,26 +750,56 @@ func (s *customerStore) UpdateCustomerData(customerID string, userID string, pac
 		return errors.New("must include at least one of packet, config, or plugins")
 	}
 
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	primary, err := s.primaryEnvironment(tx, customerID)
+	if err != nil {
+		return err
+	}
+	// locked before the customer, in the same order as uploads
+	if err = s.lockEnvironment(tx, primary.ID); err != nil {
+		return err
+	}
+
+	rev := currentRevision(primary)
 	if packet != nil {
-		err := s.storePacket(userID, customerID, packet)
+		// edited values are pinned so the next packet doesn't replace them
+		current, err := s.getPacket(tx, customerID)
+		if err != nil {
+			return errors.Wrap(err, "failed to get current packet")
+		}
+		changed, err := app.ChangedPacketFields(current, app.PacketValueFields(*packet))
 		if err != nil {
-			return errors.Wrap(err, "failed to store packet")
+			return err
+		}
+		if len(changed) > 0 {
+			if err = s.pinPacketFields(tx, customerID, userID, changed, 0); err != nil {
+				return errors.Wrap(err, "failed to pin packet values")
+			}
 		}
 	}
 
 	if config != nil {
-		err := s.storeConfig(userID, customerID, config)
+		_, err = s.storeConfig(tx, userID, User, customerID, config, rev)
 		if err != nil {
 			return errors.Wrap(err, "failed to store config")
 		}
 	}
 
 	if plugins != nil {
-		err := s.storePlugins(userID, customerID, plugins)
+		_, err = s.storePlugins(tx, userID, User, customerID, plugins, rev)
 		if err != nil {
 			return errors.Wrap(err, "failed to store plugins")
 		}
 	}
 
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+
 	return nil
 }
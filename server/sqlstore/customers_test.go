@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
@@ -109,42 +110,216 @@ func TestGetCustomerID(t *testing.T) {
 		}
 	})
 
-	t.Run("creates new due to too many exact matches", func(t *testing.T) {
+	t.Run("ambiguous due to too many exact matches", func(t *testing.T) {
 		_, err := db.Exec(`INSERT INTO crm_customers (id, name, LicenseType, siteurl, licensedto, lastupdated) VALUES ($1, $2, $3, $4, $5, $6)`, "2", "test", "cloud", "www.1.com", "1", 0)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		ID, err := customerStore.GetCustomerID("www.1.com", "1")
+		_, err = customerStore.GetCustomerID("www.1.com", "1")
+		if !errors.Is(err, app.ErrAmbiguousCustomer) {
+			t.Fatal("expected an ambiguous match", err)
+		}
+
+		candidates, err := customerStore.MatchCustomers(app.PacketIdentity{SiteURL: "www.1.com", LicensedTo: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 2 {
+			t.Fatal("expected both customers as candidates", candidates)
+		}
+	})
+
+	t.Run("matches on siteurl alone", func(t *testing.T) {
+		_, err := db.Exec(`INSERT INTO crm_customers (id, name, LicenseType, siteurl, licensedto, lastupdated) VALUES ($1, $2, $3, $4, $5, $6)`, "3", "test", "cloud", "www.3.com", "3", 0)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if ID == "1" || ID == "2" {
+		ID, err := customerStore.GetCustomerID("www.3.com", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ID != "3" {
 			t.Fatal("customer id does not match")
 		}
+	})
 
-		if len(ID) != 26 {
-			t.Fatal("does not appear to be a valid id")
+	t.Run("matches on licensedto alone", func(t *testing.T) {
+		ID, err := customerStore.GetCustomerID("", "3")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ID != "3" {
+			t.Fatal("customer id does not match")
 		}
 	})
 
 	t.Run("siteurl was changed licensedto remains", func(t *testing.T) {
-		ID, err := customerStore.GetCustomerID("www.2.com", "1")
+		ID, err := customerStore.GetCustomerID("www.new3.com", "3")
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if ID == "1" || ID == "2" {
+		if ID != "3" {
 			t.Fatal("customer id does not match")
 		}
+	})
 
-		if len(ID) != 26 {
-			t.Fatal("does not appear to be a valid id")
+	t.Run("siteurl was changed licensedto is shared", func(t *testing.T) {
+		_, err := customerStore.GetCustomerID("www.2.com", "1")
+		if !errors.Is(err, app.ErrAmbiguousCustomer) {
+			t.Fatal("expected an ambiguous match", err)
 		}
 	})
 }
 
+func TestCustomerIdentifiers(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.CreateCustomer(app.Customer{SiteURL: "www.old.com", LicensedTo: "Old Name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		Packet: &model.SupportPacket{LicenseTo: "Old Name"},
+		Identity: app.PacketIdentity{
+			SiteURL:     "www.old.com",
+			LicensedTo:  "Old Name",
+			TelemetryID: "server1",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = customerStore.AddCustomerIdentifier(app.CustomerIdentifier{
+		CustomerID: customerID,
+		Type:       app.IdentifierSiteURL,
+		Value:      "www.new.com",
+		Source:     app.IdentifierSourceAdmin,
+		CreatedBy:  "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identifiers, err := customerStore.GetCustomerIdentifiers(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identifiers) != 4 {
+		t.Fatal("expected the packet and admin identifiers", identifiers)
+	}
+
+	t.Run("moved and renamed customer matches by telemetry id", func(t *testing.T) {
+		matches, err := customerStore.MatchCustomers(app.PacketIdentity{SiteURL: "www.other.com", LicensedTo: "New Name", TelemetryID: "server1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 {
+			t.Fatal("expected a single match", matches)
+		}
+		assertEqual(t, customerID, matches[0].ID, "matched customer")
+		assertEqual(t, []app.IdentifierType{app.IdentifierTelemetryID}, matches[0].Matched, "matched identifiers")
+	})
+
+	t.Run("matches an identifier added by an admin", func(t *testing.T) {
+		ID, err := customerStore.GetCustomerID("www.new.com", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, customerID, ID, "matched customer")
+	})
+
+	t.Run("remove identifier", func(t *testing.T) {
+		err := customerStore.RemoveCustomerIdentifier(customerID, app.IdentifierSiteURL, "www.new.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = customerStore.RemoveCustomerIdentifier(customerID, app.IdentifierSiteURL, "www.new.com")
+		if !errors.Is(err, app.ErrNotFound) {
+			t.Fatal("expected the identifier to be gone", err)
+		}
+	})
+}
+
+func TestPendingUploads(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	upload := &app.SupportPacketUpload{
+		FileName:    "packet.zip",
+		PostID:      "post1",
+		ArchiveHash: "hash1",
+		Packet:      &model.SupportPacket{LicenseTo: "test"},
+	}
+
+	id, err := customerStore.CreatePendingUpload(app.PendingUpload{
+		CandidateIDs: []string{"1", "2"},
+		Summary:      "summary",
+		Upload:       upload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = customerStore.SetPendingUploadPrompt(id, "prompt1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a retry of the same packet finds it
+	pending, err := customerStore.GetPendingUploadForPacket("post1", "hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, id, pending.ID, "pending upload of the packet")
+	_, err = customerStore.GetPendingUploadForPacket("post2", "hash1")
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected no pending upload for another post", err)
+	}
+
+	pending, err = customerStore.ClaimPendingUpload(id, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "prompt1", pending.PromptPostID, "prompt post")
+	assertEqual(t, []string{"1", "2"}, pending.CandidateIDs, "candidates")
+	assertEqual(t, "summary", pending.Summary, "summary")
+	assertEqual(t, upload, pending.Upload, "upload")
+
+	_, err = customerStore.ClaimPendingUpload(id, "user2")
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected the pending upload to be claimed only once", err)
+	}
+
+	// a failed attempt releases it for the next one
+	err = customerStore.ReleasePendingUpload(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = customerStore.ClaimPendingUpload(id, "user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, upload, pending.Upload, "upload")
+
+	err = customerStore.DeletePendingUpload(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = customerStore.GetPendingUpload(id)
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected the stored pending upload to be deleted", err)
+	}
+}
+
 func TestGetCustomers(t *testing.T) {
 	db := setupTestDB(t)
 	customerStore := setupCustomerStore(t, db)
@@ -286,7 +461,11 @@ func TestStoreCustomerData(t *testing.T) {
 			TotalPosts: packet.TotalPosts,
 		}
 
-		err = customerStore.UpdateCustomerThroughUpload(customerID, packet, config, plugins)
+		_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+			Packet:  packet,
+			Config:  config,
+			Plugins: plugins,
+		})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -308,6 +487,435 @@ func TestStoreCustomerData(t *testing.T) {
 	})
 }
 
+func TestStoreClusterNodes(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.cluster.com", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("stores a node per cluster member", func(t *testing.T) {
+		nodes := []app.CustomerNodeValues{
+			{NodeID: "node-a", Version: "9.3.0"},
+			{NodeID: "node-b", Version: "9.2.0"},
+		}
+
+		_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+			Packet: &model.SupportPacket{LicenseTo: "cluster", ServerVersion: "9.3.0"},
+			Nodes:  nodes,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		customerInfo, err := customerStore.GetCustomerByID(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEqual(t, nodes, customerInfo.Nodes, "node data")
+		assertEqual(t, "9.3.0", customerInfo.PacketValues.Version, "cluster version")
+	})
+
+	t.Run("single node packet clears the old nodes", func(t *testing.T) {
+		_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+			Packet: &model.SupportPacket{LicenseTo: "cluster", ServerVersion: "9.3.0"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes, err := customerStore.GetNodes(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(nodes) != 0 {
+			t.Fatal("expected no current nodes", nodes)
+		}
+	})
+}
+
+func TestStoreSnapshotLogs(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.logs.com", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []app.LogEntrySummary{
+		{
+			Level:     app.LogLevelError,
+			Source:    "mattermost.log",
+			Message:   "Unable to get channel id=<id>",
+			Caller:    "app/channel.go:12",
+			Example:   "Unable to get channel id=8ohp3n1yx3dm5rmxbzd3nsibqo",
+			Count:     3,
+			FirstSeen: 1000,
+			LastSeen:  2000,
+			Timeline:  map[int64]int{0: 3},
+		},
+	}
+
+	snapshotID, err := customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName: "packet.zip",
+		Packet:   &model.SupportPacket{LicenseTo: "logs"},
+		Logs:     &app.LogAnalysis{Entries: entries},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := customerStore.GetSnapshots(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 1 || snapshots[0].ID != snapshotID {
+		t.Fatal("snapshot was not stored", snapshots)
+	}
+	assertEqual(t, "packet.zip", snapshots[0].FileName, "snapshot file name")
+
+	storedEntries, err := customerStore.GetLogEntries(snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, entries, storedEntries, "log entries")
+}
+
+func TestGetSnapshotByHash(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.hash.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.GetSnapshotByHash("archive1", "content1")
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected no snapshot", err)
+	}
+
+	snapshotID, err := customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName:    "packet.zip",
+		PostID:      "post1",
+		ArchiveHash: "archive1",
+		ContentHash: "content1",
+		Packet:      &model.SupportPacket{LicenseTo: "hash"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("same archive", func(t *testing.T) {
+		snapshot, err := customerStore.GetSnapshotByHash("archive1", "content2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, snapshotID, snapshot.ID, "snapshot id")
+		assertEqual(t, "post1", snapshot.PostID, "post id")
+	})
+
+	t.Run("re-zipped contents", func(t *testing.T) {
+		snapshot, err := customerStore.GetSnapshotByHash("archive2", "content1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, snapshotID, snapshot.ID, "snapshot id")
+	})
+}
+
+func TestSnapshotArchives(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.archive.com", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotID, err := customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName:    "packet.zip",
+		ArchivePath: "plugins/customers/packets/archive.zip",
+		ArchiveSize: 1024,
+		Packet:      &model.SupportPacket{LicenseTo: "archive"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := customerStore.GetSnapshot(snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "plugins/customers/packets/archive.zip", snapshot.ArchivePath, "archive path")
+	assertEqual(t, int64(1024), snapshot.ArchiveSize, "archive size")
+
+	archived, err := customerStore.GetArchivedSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(archived), "archived snapshots")
+
+	if err = customerStore.ClearSnapshotArchive(snapshotID); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, err = customerStore.GetArchivedSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 0, len(archived), "archived snapshots after clearing")
+
+	_, err = customerStore.GetSnapshot("missing")
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected missing snapshot to not be found", err)
+	}
+}
+
+func TestSnapshotProvenance(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.provenance.com", "provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName:  "packet.zip",
+		UserID:    "user1",
+		PostID:    "post1",
+		ChannelID: "channel1",
+		TicketRef: "12345",
+		Packet:    &model.SupportPacket{LicenseTo: "provenance"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := customerStore.GetSnapshots(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatal("expected a single snapshot", snapshots)
+	}
+	assertEqual(t, "user1", snapshots[0].UserID, "user id")
+	assertEqual(t, "post1", snapshots[0].PostID, "post id")
+	assertEqual(t, "channel1", snapshots[0].ChannelID, "channel id")
+	assertEqual(t, "12345", snapshots[0].TicketRef, "ticket reference")
+}
+
+func TestInferredPacketValues(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.inferred.com", "inferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = customerStore.UpdateCustomerData(customerID, "user1", &app.CustomerPacketValues{
+		HostingType:  "azure",
+		SAMLProvider: "Shibboleth",
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &model.Config{}
+	config.MetricsSettings.Enable = model.NewBool(true)
+	config.SamlSettings.Enable = model.NewBool(false)
+
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName: "packet.zip",
+		Packet:   &model.SupportPacket{LicenseTo: "inferred"},
+		Config:   config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := customerStore.GetPacket(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, packet.Metrics, "metrics")
+	assertEqual(t, "Prometheus", packet.MetricService, "metric service")
+	assertEqual(t, "azure", packet.HostingType, "manual hosting type")
+	assertEqual(t, "Shibboleth", packet.SAMLProvider, "pinned saml provider")
+
+	stored, sources, err := customerStore.GetStoredPacket(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "", stored.SAMLProvider, "contradicted saml provider")
+	assertEqual(t, app.PacketSourceInferred, sources.Source("samlProvider"), "saml provider source")
+	assertEqual(t, app.PacketSourcePacket, sources.Source("version"), "version source")
+}
+
+func TestPacketFieldPins(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.pins.com", "pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = customerStore.PinPacketFields(customerID, "user1", map[string]json.RawMessage{
+		"deploymentType": json.RawMessage(`"kubernetes"`),
+		"version":        json.RawMessage(`"8.0.0"`),
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an expired pin is ignored
+	err = customerStore.PinPacketFields(customerID, "user1", map[string]json.RawMessage{
+		"hostingType": json.RawMessage(`"azure"`),
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName: "packet.zip",
+		Packet:   &model.SupportPacket{LicenseTo: "pins", ServerVersion: "9.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := customerStore.GetPacket(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "kubernetes", packet.DeploymentType, "pinned deployment type")
+	assertEqual(t, "8.0.0", packet.Version, "pinned version")
+	assertEqual(t, "", packet.HostingType, "expired hosting type")
+
+	customer, err := customerStore.GetCustomerByID(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "8.0.0", customer.PacketValues.Version, "effective version")
+	for _, field := range customer.PacketFields {
+		if field.Field != "version" {
+			continue
+		}
+		assertEqual(t, app.PacketSourceManual, field.Source, "version source")
+		assertEqual(t, "9.0.0", field.PacketValue, "packet version")
+		assertEqual(t, true, field.Conflict, "version conflict")
+	}
+
+	if err = customerStore.UnpinPacketField(customerID, "user1", "version"); err != nil {
+		t.Fatal(err)
+	}
+	err = customerStore.UnpinPacketField(customerID, "user1", "version")
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected not found unpinning twice", err)
+	}
+
+	packet, err = customerStore.GetPacket(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "9.0.0", packet.Version, "unpinned version")
+}
+
+func TestBackfilledSnapshotOrder(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.backfill.com", "backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName:   "new.zip",
+		UploadedAt: 3000,
+		Packet:     &model.SupportPacket{LicenseTo: "backfill", ServerVersion: "9.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a packet posted before the one already ingested, like the ones found by a backfill
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName:   "old.zip",
+		UploadedAt: 1000,
+		Packet:     &model.SupportPacket{LicenseTo: "backfill", ServerVersion: "7.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := customerStore.GetSnapshots(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatal("expected two snapshots", snapshots)
+	}
+	assertEqual(t, "new.zip", snapshots[0].FileName, "newest snapshot")
+	assertEqual(t, int64(3000), snapshots[0].CreatedAt, "newest snapshot time")
+	assertEqual(t, "old.zip", snapshots[1].FileName, "oldest snapshot")
+	assertEqual(t, int64(1000), snapshots[1].CreatedAt, "oldest snapshot time")
+
+	packet, err := customerStore.GetPacket(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "9.0.0", packet.Version, "current version")
+
+	customer, err := customerStore.GetCustomerByID(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3000), customer.LastUpdated, "last updated")
+}
+
+func TestStoreSnapshotFindings(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.GetCustomerID("www.findings.com", "findings")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := []app.Finding{
+		{
+			RuleID:      "ha-single-node",
+			Severity:    app.SeverityWarning,
+			Title:       "High availability is enabled but the packet only contains a single node",
+			Remediation: "Check the other nodes.",
+		},
+	}
+
+	snapshotID, err := customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName: "packet.zip",
+		Packet:   &model.SupportPacket{LicenseTo: "findings"},
+		Findings: findings,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedFindings, err := customerStore.GetFindings(snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, findings, storedFindings, "findings")
+}
+
 func TestUpdateCustomer(t *testing.T) {
 	db := setupTestDB(t)
 	customerStore := setupCustomerStore(t, db)
@@ -356,3 +964,413 @@ func assertEqual(t *testing.T, expected, actual interface{}, name string) {
 		t.Fatalf("Incorrect %s. Expected %v got %v", name, expected, actual)
 	}
 }
+
+func TestFindingRuleSets(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	_, err := customerStore.GetLatestFindingRuleSet()
+	if !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected no rules to be found", err)
+	}
+
+	rules := []app.FindingRuleDefinition{
+		{
+			ID:       "mysql",
+			Field:    "config.SqlSettings.DriverName",
+			Operator: app.OperatorEquals,
+			Value:    "mysql",
+			Severity: app.SeverityInfo,
+			Message:  "Customer is on MySQL",
+		},
+	}
+
+	first, err := customerStore.CreateFindingRuleSet("user1", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, first.Version, "first version")
+
+	second, err := customerStore.CreateFindingRuleSet("user2", rules[:0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, second.Version, "second version")
+
+	latest, err := customerStore.GetLatestFindingRuleSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, second.ID, latest.ID, "latest rule set")
+
+	ruleSets, err := customerStore.GetFindingRuleSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ruleSets) != 2 {
+		t.Fatal("expected both versions", ruleSets)
+	}
+	assertEqual(t, rules, ruleSets[1].Rules, "stored rules")
+}
+
+func TestArchiveAndDeleteCustomer(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.CreateCustomer(app.Customer{Name: "Archived", SiteURL: "https://archived.com", LicenseType: app.Enterprise})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keptID, err := customerStore.CreateCustomer(app.Customer{Name: "Kept", SiteURL: "https://kept.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+		FileName: "packet.zip",
+		Packet:   &model.SupportPacket{LicenseTo: "Archived", ServerVersion: "9.0.0"},
+		Config:   &model.Config{},
+		Plugins:  &model.PluginsResponse{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = customerStore.SetCustomerArchived(customerID, "user1", 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := customerStore.GetCustomers(app.CustomerFilterOptions{PerPage: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, result.TotalCount, "listed customers")
+	assertEqual(t, keptID, result.Customers[0].ID, "listed customer")
+
+	result, err = customerStore.GetCustomers(app.CustomerFilterOptions{PerPage: 100, IncludeArchived: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, result.TotalCount, "customers including archived")
+
+	// the history is kept while archived
+	customer, err := customerStore.GetCustomerByID(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(1000), customer.ArchivedAt, "archived at")
+	assertEqual(t, "user1", customer.ArchivedBy, "archived by")
+	assertEqual(t, "9.0.0", customer.PacketValues.Version, "archived packet")
+
+	pendingID, err := customerStore.CreatePendingUpload(app.PendingUpload{
+		CandidateIDs: []string{customerID, keptID},
+		Upload:       &app.SupportPacketUpload{FileName: "pending.zip"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = customerStore.DeleteCustomer(customerID); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := customerStore.GetPendingUpload(pendingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []string{keptID}, pending.CandidateIDs, "pending candidates")
+	if _, err = customerStore.GetCustomerByID(customerID); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected deleted customer to be gone", err)
+	}
+	if err = customerStore.DeleteCustomer(customerID); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected not found deleting twice", err)
+	}
+
+	for _, table := range []string{packetTable, configTable, pluginTable, auditTable, snapshotTable} {
+		var count int
+		if err = db.Get(&count, "SELECT COUNT(*) FROM "+table+" WHERE CustomerID = $1", customerID); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, 0, count, table+" rows left")
+	}
+}
+
+func TestMergeCustomers(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	targetID, err := customerStore.CreateCustomer(app.Customer{Name: "Target", SiteURL: "https://target.com", LicensedTo: "Target"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceID, err := customerStore.CreateCustomer(app.Customer{Name: "Source", SiteURL: "https://source.com", LicensedTo: "Source"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(targetID, &app.SupportPacketUpload{
+		FileName:   "old.zip",
+		UploadedAt: 1000,
+		Packet:     &model.SupportPacket{LicenseTo: "Target", ServerVersion: "8.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = customerStore.UpdateCustomerThroughUpload(sourceID, &app.SupportPacketUpload{
+		FileName:   "new.zip",
+		UploadedAt: 2000,
+		Packet:     &model.SupportPacket{LicenseTo: "Source", ServerVersion: "9.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := customerStore.CountCustomerRows(sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(1), counts["packets"], "source packets")
+	assertEqual(t, int64(1), counts["snapshots"], "source snapshots")
+
+	target, err := customerStore.GetCustomerByID(targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := customerStore.GetCustomerByID(sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingID, err := customerStore.CreatePendingUpload(app.PendingUpload{
+		CandidateIDs: []string{sourceID, targetID},
+		Upload:       &app.SupportPacketUpload{FileName: "pending.zip"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := customerStore.MergeCustomers(target.Customer, source.Customer, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(1), moved["packets"], "moved packets")
+	assertEqual(t, int64(1), moved["snapshots"], "moved snapshots")
+	assertEqual(t, int64(1), moved["environments"], "moved environments")
+
+	if _, err = customerStore.GetCustomerByID(sourceID); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected the source to be deleted", err)
+	}
+
+	// the pending upload offers the target in place of the source
+	pending, err := customerStore.GetPendingUpload(pendingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []string{targetID}, pending.CandidateIDs, "pending candidates")
+
+	// the source's server is kept as a secondary environment with its own current values
+	merged, err := customerStore.GetCustomerByID(targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "8.0.0", merged.PacketValues.Version, "current version")
+	assertEqual(t, 2, len(merged.Environments), "merged environments")
+	assertEqual(t, "https://source.com", merged.Environments[1].SiteURL, "secondary environment")
+	assertEqual(t, false, merged.Environments[1].Primary, "secondary environment primary")
+	assertEqual(t, "9.0.0", merged.Environments[1].PacketValues.Version, "secondary environment version")
+
+	snapshots, err := customerStore.GetSnapshots(targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, len(snapshots), "merged snapshots")
+
+	// packets from the source's server now land on the target
+	customerID, err := customerStore.GetCustomerID("https://source.com", "Source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, targetID, customerID, "matched customer")
+}
+
+func TestMergeSameServerEnvironments(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	targetID, err := customerStore.CreateCustomer(app.Customer{Name: "Target", SiteURL: "https://chat.acme.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceID, err := customerStore.CreateCustomer(app.Customer{Name: "Duplicate", LicensedTo: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = customerStore.UpdateCustomerThroughUpload(targetID, &app.SupportPacketUpload{
+		FileName:   "old.zip",
+		UploadedAt: 1000,
+		Identity:   app.PacketIdentity{SiteURL: "https://chat.acme.com", TelemetryID: "server1"},
+		Packet:     &model.SupportPacket{ServerVersion: "8.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = customerStore.UpdateCustomerThroughUpload(sourceID, &app.SupportPacketUpload{
+		FileName:   "new.zip",
+		UploadedAt: 2000,
+		Identity:   app.PacketIdentity{SiteURL: "https://chat.acme.com", TelemetryID: "server1"},
+		Packet:     &model.SupportPacket{ServerVersion: "9.0.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := customerStore.GetCustomerByID(targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := customerStore.GetCustomerByID(sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = customerStore.MergeCustomers(target.Customer, source.Customer, "user1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// both were the same server, the newest packet of the two is its current one
+	merged, err := customerStore.GetCustomerByID(targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(merged.Environments), "merged environments")
+	assertEqual(t, "9.0.0", merged.PacketValues.Version, "current version")
+
+	snapshots, err := customerStore.GetSnapshots(targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, len(snapshots), "merged snapshots")
+	for _, snapshot := range snapshots {
+		assertEqual(t, merged.Environments[0].ID, snapshot.EnvironmentID, "snapshot environment")
+	}
+}
+
+func TestEnvironments(t *testing.T) {
+	db := setupTestDB(t)
+	customerStore := setupCustomerStore(t, db)
+
+	customerID, err := customerStore.CreateCustomer(app.Customer{Name: "Acme", SiteURL: "https://chat.acme.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(fileName string, uploadedAt int64, identity app.PacketIdentity, version string) {
+		t.Helper()
+		_, err := customerStore.UpdateCustomerThroughUpload(customerID, &app.SupportPacketUpload{
+			FileName:   fileName,
+			UploadedAt: uploadedAt,
+			Identity:   identity,
+			Packet:     &model.SupportPacket{ServerVersion: version},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("packets of each server keep their own current values", func(t *testing.T) {
+		upload("production.zip", 1000, app.PacketIdentity{SiteURL: "https://chat.acme.com", TelemetryID: "production"}, "9.0.0")
+		upload("staging.zip", 2000, app.PacketIdentity{SiteURL: "https://chat.staging.acme.com", TelemetryID: "staging"}, "9.1.0")
+
+		customer, err := customerStore.GetCustomerByID(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "9.0.0", customer.PacketValues.Version, "customer version")
+		assertEqual(t, 2, len(customer.Environments), "environments")
+
+		production := customer.Environments[0]
+		assertEqual(t, true, production.Primary, "production primary")
+		assertEqual(t, "production", production.TelemetryID, "learned telemetry ID")
+		assertEqual(t, "9.0.0", production.PacketValues.Version, "production version")
+
+		staging := customer.Environments[1]
+		assertEqual(t, app.EnvironmentStaging, staging.Type, "staging type")
+		assertEqual(t, "chat.staging.acme.com", staging.Name, "staging name")
+		assertEqual(t, "9.1.0", staging.PacketValues.Version, "staging version")
+	})
+
+	t.Run("the telemetry ID matches a server that moved", func(t *testing.T) {
+		upload("moved.zip", 3000, app.PacketIdentity{SiteURL: "https://mattermost.acme.com", TelemetryID: "production"}, "9.2.0")
+
+		environments, err := customerStore.GetEnvironments(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, 2, len(environments), "environments")
+
+		packet, err := customerStore.GetPacket(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "9.2.0", packet.Version, "customer version")
+	})
+
+	t.Run("older packets are ordered within their environment", func(t *testing.T) {
+		upload("old-staging.zip", 1500, app.PacketIdentity{TelemetryID: "staging"}, "8.0.0")
+
+		environments, err := customerStore.GetEnvironments(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := customerStore.GetEnvironmentInfo(environments[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "9.1.0", info.PacketValues.Version, "staging version")
+	})
+
+	t.Run("making another environment primary", func(t *testing.T) {
+		environments, err := customerStore.GetEnvironments(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		staging := environments[1]
+		staging.Primary = true
+		if err = customerStore.UpdateEnvironment(staging); err != nil {
+			t.Fatal(err)
+		}
+
+		customer, err := customerStore.GetCustomerByID(customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, staging.ID, customer.Environments[0].ID, "primary environment")
+		assertEqual(t, false, customer.Environments[1].Primary, "former primary")
+		assertEqual(t, "9.1.0", customer.PacketValues.Version, "customer version")
+		assertEqual(t, "https://chat.staging.acme.com", customer.SiteURL, "customer site URL")
+	})
+
+	t.Run("packets match the site URL of environments entered by users", func(t *testing.T) {
+		_, err := customerStore.CreateEnvironment(app.Environment{
+			CustomerID: customerID,
+			Name:       "DR",
+			Type:       app.EnvironmentDisasterRecovery,
+			SiteURL:    "https://dr.acme.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		matches, err := customerStore.MatchCustomers(app.PacketIdentity{SiteURL: "https://dr.acme.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, 1, len(matches), "matches")
+		assertEqual(t, customerID, matches[0].ID, "matched customer")
+
+		environment, err := customerStore.ResolveEnvironment(customerID, "user1", app.PacketIdentity{SiteURL: "https://dr.acme.com/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "DR", environment.Name, "resolved environment")
+	})
+}
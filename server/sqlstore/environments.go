@@ -0,0 +1,269 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+func (s *customerStore) GetEnvironments(customerID string) ([]app.Environment, error) {
+	return s.getEnvironments(s.store.db, customerID)
+}
+
+func (s *customerStore) getEnvironments(q queryer, customerID string) ([]app.Environment, error) {
+	environments := []app.Environment{}
+	err := s.store.selectBuilder(q, &environments, s.environmentSelect.Where(sq.Eq{"ce.CustomerID": customerID}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get environments for customer '%s'", customerID)
+	}
+
+	return environments, nil
+}
+
+func (s *customerStore) GetEnvironment(id string) (app.Environment, error) {
+	return s.getEnvironment(s.store.db, id)
+}
+
+func (s *customerStore) getEnvironment(q queryer, id string) (app.Environment, error) {
+	var environment app.Environment
+	err := s.store.getBuilder(q, &environment, s.environmentSelect.Where(sq.Eq{"ce.ID": id}))
+	if err == sql.ErrNoRows {
+		return app.Environment{}, errors.Wrapf(app.ErrNotFound, "environment does not exist for id '%s'", id)
+	} else if err != nil {
+		return app.Environment{}, errors.Wrapf(err, "failed to get environment by id '%s'", id)
+	}
+
+	return environment, nil
+}
+
+// lockEnvironment locks the environment's row until the transaction ends.
+func (s *customerStore) lockEnvironment(tx *sqlx.Tx, id string) error {
+	var locked string
+	err := s.store.getBuilder(tx, &locked, s.queryBuilder.
+		Select("ID").
+		From(environmentTable).
+		Where(sq.Eq{"ID": id}).
+		Suffix("FOR UPDATE"))
+	if err == sql.ErrNoRows {
+		return errors.Wrapf(app.ErrNotFound, "environment does not exist for id '%s'", id)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to lock environment '%s'", id)
+	}
+
+	return nil
+}
+
+// primaryEnvironment returns the customer's primary environment, or ErrNotFound.
+func (s *customerStore) primaryEnvironment(q queryer, customerID string) (app.Environment, error) {
+	var environment app.Environment
+	err := s.store.getBuilder(q, &environment, s.environmentSelect.
+		Where(sq.Eq{"ce.CustomerID": customerID}).
+		Where(sq.Eq{"ce.IsPrimary": true}).
+		Limit(1))
+	if err == sql.ErrNoRows {
+		return app.Environment{}, errors.Wrapf(app.ErrNotFound, "customer '%s' has no primary environment", customerID)
+	} else if err != nil {
+		return app.Environment{}, errors.Wrapf(err, "failed to get primary environment of customer '%s'", customerID)
+	}
+
+	return environment, nil
+}
+
+// primaryEnvironmentID returns the ID of the customer's primary environment, empty if it has none.
+func (s *customerStore) primaryEnvironmentID(q queryer, customerID string) (string, error) {
+	environment, err := s.primaryEnvironment(q, customerID)
+	if errors.Is(err, app.ErrNotFound) {
+		return "", nil
+	}
+
+	return environment.ID, err
+}
+
+func (s *customerStore) CreateEnvironment(environment app.Environment) (string, error) {
+	return s.createEnvironment(s.store.db, environment)
+}
+
+func (s *customerStore) createEnvironment(q queryExecer, environment app.Environment) (string, error) {
+	var count int
+	err := s.store.getBuilder(q, &count, s.queryBuilder.
+		Select("COUNT(*)").
+		From(environmentTable).
+		Where(sq.Eq{"CustomerID": environment.CustomerID}))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to count environments of customer '%s'", environment.CustomerID)
+	}
+
+	environment.ID = model.NewId()
+	if environment.CreatedAt == 0 {
+		environment.CreatedAt = model.GetMillis()
+	}
+	// the first environment is the primary one, later ones are made primary by UpdateEnvironment
+	environment.Primary = count == 0
+
+	_, err = s.store.execBuilder(q, sq.
+		Insert(environmentTable).
+		SetMap(map[string]interface{}{
+			"ID":          environment.ID,
+			"CustomerID":  environment.CustomerID,
+			"Name":        environment.Name,
+			"Type":        environment.Type,
+			"SiteURL":     environment.SiteURL,
+			"TelemetryID": environment.TelemetryID,
+			"IsPrimary":   environment.Primary,
+			"CreatedAt":   environment.CreatedAt,
+			"CreatedBy":   environment.CreatedBy,
+		}))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to store environment of customer '%s'", environment.CustomerID)
+	}
+
+	return environment.ID, nil
+}
+
+func (s *customerStore) UpdateEnvironment(environment app.Environment) error {
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	if environment.Primary {
+		_, err = s.store.execBuilder(tx, sq.
+			Update(environmentTable).
+			SetMap(map[string]interface{}{
+				"IsPrimary": false,
+			}).
+			Where(sq.Eq{"CustomerID": environment.CustomerID}))
+		if err != nil {
+			return errors.Wrapf(err, "failed to make the environments of customer '%s' secondary", environment.CustomerID)
+		}
+
+		// the customer's site URL is its primary environment's
+		if environment.SiteURL != "" {
+			_, err = s.store.execBuilder(tx, sq.
+				Update(customerTable).
+				SetMap(map[string]interface{}{
+					"SiteURL": environment.SiteURL,
+				}).
+				Where(sq.Eq{"ID": environment.CustomerID}))
+			if err != nil {
+				return errors.Wrapf(err, "failed to update site URL of customer '%s'", environment.CustomerID)
+			}
+		}
+	}
+
+	result, err := s.store.execBuilder(tx, sq.
+		Update(environmentTable).
+		SetMap(map[string]interface{}{
+			"Name":      environment.Name,
+			"Type":      environment.Type,
+			"SiteURL":   environment.SiteURL,
+			"IsPrimary": sq.Expr("IsPrimary OR ?", environment.Primary),
+		}).
+		Where(sq.Eq{"ID": environment.ID}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to update environment '%s'", environment.ID)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to update environment '%s'", environment.ID)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "environment does not exist for id '%s'", environment.ID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+
+	return nil
+}
+
+func (s *customerStore) ResolveEnvironment(customerID string, userID string, identity app.PacketIdentity) (app.Environment, error) {
+	return s.resolveEnvironment(s.store.db, customerID, userID, identity)
+}
+
+func (s *customerStore) resolveEnvironment(q queryExecer, customerID string, userID string, identity app.PacketIdentity) (app.Environment, error) {
+	environments, err := s.getEnvironments(q, customerID)
+	if err != nil {
+		return app.Environment{}, err
+	}
+
+	environment, ok := app.MatchEnvironment(environments, identity)
+	if !ok {
+		environment = app.NewPacketEnvironment(customerID, environments, identity)
+		environment.CreatedBy = userID
+		environment.ID, err = s.createEnvironment(q, environment)
+		if err != nil {
+			return app.Environment{}, err
+		}
+
+		return s.getEnvironment(q, environment.ID)
+	}
+
+	// learn what the environment was missing so its next packets match it directly
+	learned := map[string]interface{}{}
+	if environment.TelemetryID == "" && identity.TelemetryID != "" {
+		environment.TelemetryID = identity.TelemetryID
+		learned["TelemetryID"] = identity.TelemetryID
+	}
+	if environment.SiteURL == "" && identity.SiteURL != "" {
+		environment.SiteURL = identity.SiteURL
+		learned["SiteURL"] = identity.SiteURL
+	}
+	if len(learned) > 0 {
+		_, err = s.store.execBuilder(q, sq.
+			Update(environmentTable).
+			SetMap(learned).
+			Where(sq.Eq{"ID": environment.ID}))
+		if err != nil {
+			return app.Environment{}, errors.Wrapf(err, "failed to update identifiers of environment '%s'", environment.ID)
+		}
+	}
+
+	return environment, nil
+}
+
+func (s *customerStore) GetEnvironmentInfo(environmentID string) (app.EnvironmentInfo, error) {
+	environment, err := s.GetEnvironment(environmentID)
+	if err != nil {
+		return app.EnvironmentInfo{}, err
+	}
+
+	info, _, err := s.environmentInfo(environment)
+	return info, err
+}
+
+// environmentInfo returns the environment's current values, along with the sources of its packet values.
+func (s *customerStore) environmentInfo(environment app.Environment) (app.EnvironmentInfo, app.PacketSources, error) {
+	info := app.EnvironmentInfo{Environment: environment}
+
+	var sources app.PacketSources
+	var err error
+	info.PacketValues, sources, err = s.getStoredPacket(s.store.db, environment.ID)
+	if err != nil {
+		return app.EnvironmentInfo{}, nil, err
+	}
+
+	info.Config, err = s.getConfig(s.store.db, environment.ID)
+	if err != nil {
+		return app.EnvironmentInfo{}, nil, err
+	}
+
+	info.Plugins, err = s.getPlugins(s.store.db, environment.ID)
+	if err != nil {
+		return app.EnvironmentInfo{}, nil, err
+	}
+
+	info.Nodes, err = s.getNodes(environment.ID)
+	if err != nil {
+		return app.EnvironmentInfo{}, nil, err
+	}
+
+	return info, sources, nil
+}
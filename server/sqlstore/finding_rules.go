@@ -0,0 +1,112 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+type sqlFindingRuleSet struct {
+	app.FindingRuleSet
+	Rules json.RawMessage `db:"rules"`
+}
+
+func (r sqlFindingRuleSet) toRuleSet() (app.FindingRuleSet, error) {
+	ruleSet := r.FindingRuleSet
+	if err := json.Unmarshal(r.Rules, &ruleSet.Rules); err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "failed to unmarshal findings rules")
+	}
+
+	return ruleSet, nil
+}
+
+func (s *customerStore) GetFindingRuleSets() ([]app.FindingRuleSet, error) {
+	var rawRuleSets []sqlFindingRuleSet
+	err := s.store.selectBuilder(
+		s.store.db,
+		&rawRuleSets,
+		s.findingRulesSelect.OrderBy("cfr.version DESC"),
+	)
+	if err != nil {
+		return []app.FindingRuleSet{}, errors.Wrap(err, "failed to get findings rules")
+	}
+
+	ruleSets := make([]app.FindingRuleSet, 0, len(rawRuleSets))
+	for _, rawRuleSet := range rawRuleSets {
+		ruleSet, err := rawRuleSet.toRuleSet()
+		if err != nil {
+			return []app.FindingRuleSet{}, err
+		}
+		ruleSets = append(ruleSets, ruleSet)
+	}
+
+	return ruleSets, nil
+}
+
+func (s *customerStore) GetLatestFindingRuleSet() (app.FindingRuleSet, error) {
+	var rawRuleSet sqlFindingRuleSet
+	err := s.store.getBuilder(
+		s.store.db,
+		&rawRuleSet,
+		s.findingRulesSelect.OrderBy("cfr.version DESC").Limit(1),
+	)
+	if err == sql.ErrNoRows {
+		return app.FindingRuleSet{}, errors.Wrap(app.ErrNotFound, "no findings rules have been uploaded")
+	} else if err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "failed to get latest findings rules")
+	}
+
+	return rawRuleSet.toRuleSet()
+}
+
+func (s *customerStore) CreateFindingRuleSet(userID string, rules []app.FindingRuleDefinition) (app.FindingRuleSet, error) {
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "failed to marshal findings rules")
+	}
+
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	var latestVersion int
+	err = s.store.getBuilder(tx, &latestVersion, sq.
+		Select("COALESCE(MAX(Version), 0)").
+		From(ruleSetTable))
+	if err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "failed to get latest findings rules version")
+	}
+
+	ruleSet := app.FindingRuleSet{
+		ID:        model.NewId(),
+		Version:   latestVersion + 1,
+		CreatedAt: model.GetMillis(),
+		CreatedBy: userID,
+		Rules:     rules,
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Insert(ruleSetTable).
+		SetMap(map[string]interface{}{
+			"ID":        ruleSet.ID,
+			"Version":   ruleSet.Version,
+			"CreatedAt": ruleSet.CreatedAt,
+			"CreatedBy": ruleSet.CreatedBy,
+			"Rules":     string(rulesJSON),
+		}))
+	if err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "failed to store findings rules")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return app.FindingRuleSet{}, errors.Wrap(err, "could not commit transaction")
+	}
+
+	return ruleSet, nil
+}
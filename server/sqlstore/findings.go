@@ -0,0 +1,49 @@
+package sqlstore
+
+import (
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+func (s *customerStore) GetFindings(snapshotID string) ([]app.Finding, error) {
+	if snapshotID == "" {
+		return []app.Finding{}, errors.New("ID cannot be empty")
+	}
+
+	var findings []app.Finding
+	err := s.store.selectBuilder(
+		s.store.db,
+		&findings,
+		s.findingsSelect.
+			Where(sq.Eq{"cf.snapshotId": snapshotID}).
+			OrderBy("cf.ruleId"),
+	)
+	if err != nil {
+		return []app.Finding{}, errors.Wrapf(err, "failed to get findings for snapshot id '%s'", snapshotID)
+	}
+
+	return findings, nil
+}
+
+func (s *customerStore) storeFindings(e execer, snapshotID string, customerID string, findings []app.Finding) error {
+	for _, finding := range findings {
+		_, err := s.store.execBuilder(e, sq.
+			Insert(findingTable).
+			SetMap(map[string]interface{}{
+				"ID":          model.NewId(),
+				"SnapshotID":  snapshotID,
+				"CustomerID":  customerID,
+				"RuleID":      finding.RuleID,
+				"Severity":    finding.Severity,
+				"Title":       finding.Title,
+				"Remediation": finding.Remediation,
+			}))
+		if err != nil {
+			return errors.Wrap(err, "failed to store finding")
+		}
+	}
+
+	return nil
+}
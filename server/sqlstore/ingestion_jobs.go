@@ -0,0 +1,249 @@
+package sqlstore
+
+import (
+	"time"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+const ingestionJobTable = "crm_ingestionJobs"
+
+// ingestionJobStore holds the information needed to fulfill the methods in the store interface.
+type ingestionJobStore struct {
+	store              *SQLStore
+	jobSelect          sq.SelectBuilder
+	backfillSelect     sq.SelectBuilder
+	backfillItemSelect sq.SelectBuilder
+}
+
+// NewIngestionJobStore creates a new store for the ingestion queue.
+func NewIngestionJobStore(sqlStore *SQLStore) app.IngestionJobStore {
+	jobSelect := sqlStore.builder.
+		Select(
+			"ij.ID",
+			"ij.PostID",
+			"ij.FileID",
+			"ij.ChannelID",
+			"ij.Status",
+			"ij.Attempts",
+			"ij.LastError",
+			"ij.CreateAt",
+			"ij.UpdateAt",
+			"ij.NextAttemptAt",
+			"ij.LockedUntil",
+			"ij.StatusPostID",
+		).
+		From(ingestionJobTable + " as ij")
+
+	return &ingestionJobStore{
+		store:              sqlStore,
+		jobSelect:          jobSelect,
+		backfillSelect:     newBackfillJobSelect(sqlStore),
+		backfillItemSelect: newBackfillItemSelect(sqlStore),
+	}
+}
+
+func (s *ingestionJobStore) EnqueueIngestionJob(postID, fileID, channelID string) error {
+	now := model.GetMillis()
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Insert(ingestionJobTable).
+		SetMap(map[string]interface{}{
+			"ID":            model.NewId(),
+			"PostID":        postID,
+			"FileID":        fileID,
+			"ChannelID":     channelID,
+			"Status":        app.IngestionPending,
+			"Attempts":      0,
+			"LastError":     "",
+			"CreateAt":      now,
+			"UpdateAt":      now,
+			"NextAttemptAt": now,
+			"LockedUntil":   0,
+			"StatusPostID":  "",
+		}).
+		Suffix("ON CONFLICT (PostID, FileID) DO NOTHING"))
+	if err != nil {
+		return errors.Wrap(err, "failed to enqueue ingestion job")
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) ClaimIngestionJobs(limit int, lease time.Duration) ([]app.IngestionJob, error) {
+	now := model.GetMillis()
+
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	// jobs left processing past their lease belong to a worker that went away
+	var jobs []app.IngestionJob
+	err = s.store.selectBuilder(tx, &jobs, s.jobSelect.
+		Where(sq.Or{
+			sq.And{
+				sq.Eq{"ij.Status": app.IngestionPending},
+				sq.LtOrEq{"ij.NextAttemptAt": now},
+			},
+			sq.And{
+				sq.Eq{"ij.Status": app.IngestionProcessing},
+				sq.Lt{"ij.LockedUntil": now},
+			},
+		}).
+		OrderBy("ij.NextAttemptAt").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get due ingestion jobs")
+	}
+
+	if len(jobs) == 0 {
+		return jobs, nil
+	}
+
+	ids := make([]string, 0, len(jobs))
+	for i := range jobs {
+		jobs[i].Status = app.IngestionProcessing
+		jobs[i].UpdateAt = now
+		jobs[i].LockedUntil = now + lease.Milliseconds()
+		ids = append(ids, jobs[i].ID)
+	}
+
+	_, err = s.store.execBuilder(tx, sq.
+		Update(ingestionJobTable).
+		SetMap(map[string]interface{}{
+			"Status":      app.IngestionProcessing,
+			"UpdateAt":    now,
+			"LockedUntil": now + lease.Milliseconds(),
+		}).
+		Where(sq.Eq{"ID": ids}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to claim ingestion jobs")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "could not commit transaction")
+	}
+
+	return jobs, nil
+}
+
+func (s *ingestionJobStore) FinishIngestionJob(id string, lockedUntil int64, status app.IngestionJobStatus) error {
+	return s.releaseIngestionJob(id, lockedUntil, map[string]interface{}{
+		"Status":      status,
+		"UpdateAt":    model.GetMillis(),
+		"LockedUntil": 0,
+		"LastError":   "",
+	})
+}
+
+func (s *ingestionJobStore) FailIngestionJob(id string, lockedUntil int64, lastError string, nextAttemptAt int64, dead bool) error {
+	status := app.IngestionPending
+	if dead {
+		status = app.IngestionDead
+	}
+
+	return s.releaseIngestionJob(id, lockedUntil, map[string]interface{}{
+		"Status":        status,
+		"Attempts":      sq.Expr("Attempts + 1"),
+		"LastError":     lastError,
+		"UpdateAt":      model.GetMillis(),
+		"NextAttemptAt": nextAttemptAt,
+		"LockedUntil":   0,
+	})
+}
+
+// releaseIngestionJob updates a job that is being processed, as long as it's still held by the
+// claim with the given lease.
+func (s *ingestionJobStore) releaseIngestionJob(id string, lockedUntil int64, values map[string]interface{}) error {
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Update(ingestionJobTable).
+		SetMap(values).
+		Where(sq.Eq{
+			"ID":          id,
+			"Status":      app.IngestionProcessing,
+			"LockedUntil": lockedUntil,
+		}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to update ingestion job '%s'", id)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to update ingestion job '%s'", id)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "ingestion job '%s' is no longer held by the claim", id)
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) SetIngestionJobStatusPost(id string, postID string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(ingestionJobTable).
+		Set("StatusPostID", postID).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to set status post of ingestion job '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) GetIngestionJobs(status app.IngestionJobStatus) ([]app.IngestionJob, error) {
+	query := s.jobSelect.OrderBy("ij.CreateAt DESC")
+	if status != "" {
+		query = query.Where(sq.Eq{"ij.Status": status})
+	}
+
+	var jobs []app.IngestionJob
+	if err := s.store.selectBuilder(s.store.db, &jobs, query); err != nil {
+		return []app.IngestionJob{}, errors.Wrap(err, "failed to get ingestion jobs")
+	}
+
+	return jobs, nil
+}
+
+func (s *ingestionJobStore) RetryIngestionJob(id string) error {
+	now := model.GetMillis()
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Update(ingestionJobTable).
+		SetMap(map[string]interface{}{
+			"Status":        app.IngestionPending,
+			"Attempts":      0,
+			"UpdateAt":      now,
+			"NextAttemptAt": now,
+			"LockedUntil":   0,
+		}).
+		Where(sq.Eq{"ID": id}).
+		Where(sq.NotEq{"Status": app.IngestionProcessing}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to retry ingestion job '%s'", id)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to retry ingestion job '%s'", id)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "no ingestion job '%s' that isn't processing", id)
+	}
+
+	return nil
+}
+
+func (s *ingestionJobStore) PurgeIngestionJobs(status app.IngestionJobStatus) (int64, error) {
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Delete(ingestionJobTable).
+		Where(sq.Eq{"Status": status}))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to purge '%s' ingestion jobs", status)
+	}
+
+	return result.RowsAffected()
+}
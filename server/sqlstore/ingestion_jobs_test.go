@@ -0,0 +1,202 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+)
+
+func TestIngestionJobQueue(t *testing.T) {
+	db := setupTestDB(t)
+	jobStore := NewIngestionJobStore(setupSQLStore(t, db))
+
+	postID := model.NewId()
+	if err := jobStore.EnqueueIngestionJob(postID, "file1", "channel1"); err != nil {
+		t.Fatal(err)
+	}
+	// the hook can fire more than once for the same post
+	if err := jobStore.EnqueueIngestionJob(postID, "file1", "channel1"); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := jobStore.GetIngestionJobs(app.IngestionPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Fatal("expected a single pending job", pending)
+	}
+
+	claimed, err := jobStore.ClaimIngestionJobs(5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].Status != app.IngestionProcessing {
+		t.Fatal("expected the job to be claimed", claimed)
+	}
+
+	claimedAgain, err := jobStore.ClaimIngestionJobs(5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 0, len(claimedAgain), "jobs claimed twice")
+
+	if err = jobStore.RetryIngestionJob(claimed[0].ID); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected processing jobs to not be retried", err)
+	}
+
+	if err = jobStore.SetIngestionJobStatusPost(claimed[0].ID, "statuspost"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a claim whose lease ran out can't overwrite the outcome of the next one
+	if err = jobStore.FinishIngestionJob(claimed[0].ID, claimed[0].LockedUntil-1, app.IngestionCompleted); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected an expired lease to be rejected", err)
+	}
+
+	if err = jobStore.FailIngestionJob(claimed[0].ID, claimed[0].LockedUntil, "download failed", model.GetMillis()+time.Hour.Milliseconds(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, err := jobStore.GetIngestionJobs(app.IngestionDead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatal("expected the job to be dead", dead)
+	}
+	assertEqual(t, 1, dead[0].Attempts, "attempts")
+	assertEqual(t, "download failed", dead[0].LastError, "last error")
+	assertEqual(t, "statuspost", dead[0].StatusPostID, "status post")
+
+	if err = jobStore.RetryIngestionJob(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err = jobStore.ClaimIngestionJobs(5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatal("expected the retried job to be claimed", claimed)
+	}
+
+	if err = jobStore.FinishIngestionJob(claimed[0].ID, claimed[0].LockedUntil, app.IngestionCompleted); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := jobStore.PurgeIngestionJobs(app.IngestionCompleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(1), purged, "purged jobs")
+}
+
+func TestBackfillJobs(t *testing.T) {
+	db := setupTestDB(t)
+	jobStore := NewIngestionJobStore(setupSQLStore(t, db))
+
+	jobID, err := jobStore.CreateBackfillJob(app.BackfillJob{
+		CreatedBy:  "admin",
+		ChannelIDs: []string{"channel1", "channel2"},
+		Since:      1000,
+		Status:     app.BackfillScanning,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := jobStore.ClaimBackfillJob(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.ID != jobID {
+		t.Fatal("expected the backfill job to be claimed", job)
+	}
+	assertEqual(t, []string{"channel1", "channel2"}, job.ChannelIDs, "channels")
+
+	claimedAgain, err := jobStore.ClaimBackfillJob(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimedAgain != nil {
+		t.Fatal("expected the backfill job to not be claimed twice", claimedAgain)
+	}
+
+	job.ScanChannel = 2
+	job.PostsScanned = 3
+	job.Status = app.BackfillIngesting
+	items := []app.BackfillItem{
+		{PostID: "post2", FileID: "file2", ChannelID: "channel2", PostCreateAt: 2000},
+		{PostID: "post1", FileID: "file1", ChannelID: "channel1", PostCreateAt: 1500},
+	}
+	if err = jobStore.SaveBackfillScan(*job, items); err != nil {
+		t.Fatal(err)
+	}
+	// a resumed scan may find the same attachments again
+	if err = jobStore.SaveBackfillScan(*job, items[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := jobStore.GetPendingBackfillItems(jobID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatal("expected two pending items", pending)
+	}
+	assertEqual(t, "post1", pending[0].PostID, "oldest post first")
+
+	if err = jobStore.FailBackfillItem(pending[0].ID, "download failed"); err != nil {
+		t.Fatal(err)
+	}
+	if err = jobStore.FinishBackfillItem(pending[0].ID, app.IngestionCompleted, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = jobStore.FinishBackfillItem(pending[1].ID, app.IngestionDead, "not a packet"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = jobStore.ReleaseBackfillJob(jobID, app.BackfillCompleted, "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = jobStore.ClaimBackfillJob(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job != nil {
+		t.Fatal("expected completed jobs to not be claimed", job)
+	}
+
+	completed, err := jobStore.GetBackfillJob(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, app.BackfillCompleted, completed.Status, "status")
+	assertEqual(t, 3, completed.PostsScanned, "posts scanned")
+	assertEqual(t, 2, completed.AttachmentsFound, "attachments found")
+	assertEqual(t, 1, completed.Ingested, "ingested")
+	assertEqual(t, 1, completed.Failed, "failed")
+
+	dead, err := jobStore.GetBackfillItems(jobID, app.IngestionDead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatal("expected a single failed item", dead)
+	}
+	assertEqual(t, "not a packet", dead[0].LastError, "last error")
+
+	if err = jobStore.CancelBackfillJob(jobID); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected completed jobs to not be cancelled", err)
+	}
+
+	if _, err = jobStore.GetBackfillJob("missing"); !errors.Is(err, app.ErrNotFound) {
+		t.Fatal("expected missing backfill job to not be found", err)
+	}
+}
@@ -0,0 +1,75 @@
+package sqlstore
+
+import (
+	"encoding/json"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+type sqlLogEntry struct {
+	app.LogEntrySummary
+	Timeline json.RawMessage `db:"timeline"`
+}
+
+func (s *customerStore) GetLogEntries(snapshotID string) ([]app.LogEntrySummary, error) {
+	if snapshotID == "" {
+		return []app.LogEntrySummary{}, errors.New("ID cannot be empty")
+	}
+
+	var rawEntries []sqlLogEntry
+	err := s.store.selectBuilder(
+		s.store.db,
+		&rawEntries,
+		s.logEntriesSelect.
+			Where(sq.Eq{"cle.snapshotId": snapshotID}).
+			OrderBy("cle.count DESC", "cle.message"),
+	)
+	if err != nil {
+		return []app.LogEntrySummary{}, errors.Wrapf(err, "failed to get log entries for snapshot id '%s'", snapshotID)
+	}
+
+	entries := make([]app.LogEntrySummary, 0, len(rawEntries))
+	for _, rawEntry := range rawEntries {
+		entry := rawEntry.LogEntrySummary
+		if err = json.Unmarshal(rawEntry.Timeline, &entry.Timeline); err != nil {
+			return []app.LogEntrySummary{}, errors.Wrap(err, "failed to unmarshal log entry timeline")
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (s *customerStore) storeLogEntries(e execer, snapshotID string, customerID string, entries []app.LogEntrySummary) error {
+	for _, entry := range entries {
+		timelineJSON, err := json.Marshal(entry.Timeline)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal log entry timeline")
+		}
+
+		_, err = s.store.execBuilder(e, sq.
+			Insert(logTable).
+			SetMap(map[string]interface{}{
+				"ID":         model.NewId(),
+				"SnapshotID": snapshotID,
+				"CustomerID": customerID,
+				"Level":      entry.Level,
+				"Source":     entry.Source,
+				"Message":    entry.Message,
+				"Caller":     entry.Caller,
+				"Example":    entry.Example,
+				"Count":      entry.Count,
+				"FirstSeen":  entry.FirstSeen,
+				"LastSeen":   entry.LastSeen,
+				"Timeline":   string(timelineJSON),
+			}))
+		if err != nil {
+			return errors.Wrap(err, "failed to store log entry")
+		}
+	}
+
+	return nil
+}
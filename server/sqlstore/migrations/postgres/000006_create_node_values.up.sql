@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS crm_nodeValues (
+	ID TEXT NOT NULL PRIMARY KEY,
+	AuditID TEXT NOT NULL,
+	CustomerID TEXT NOT NULL,
+	Current BOOLEAN NOT NULL,
+	NodeID TEXT NOT NULL,
+	Version TEXT NOT NULL,
+	BuildHash TEXT NOT NULL,
+	ServerOS TEXT NOT NULL,
+	ServerArch TEXT NOT NULL,
+	DatabaseVersion TEXT NOT NULL,
+	DatabaseSchemaVersion TEXT NOT NULL,
+	WebsocketConnections INTEGER NOT NULL,
+	MasterDBConnections INTEGER NOT NULL,
+	ReplicaDBConnections INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_nodevalues_customerid ON crm_nodeValues (CustomerID, Current);
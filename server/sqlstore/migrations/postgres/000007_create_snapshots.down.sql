@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS crm_logEntries;
+DROP TABLE IF EXISTS crm_snapshots;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS crm_snapshots (
+	ID TEXT NOT NULL PRIMARY KEY,
+	CustomerID TEXT NOT NULL,
+	CreatedAt BIGINT NOT NULL,
+	FileName TEXT NOT NULL,
+	PacketAuditID TEXT NOT NULL,
+	ConfigAuditID TEXT NOT NULL,
+	PluginsAuditID TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_snapshots_customerid ON crm_snapshots (CustomerID, CreatedAt);
+
+CREATE TABLE IF NOT EXISTS crm_logEntries (
+	ID TEXT NOT NULL PRIMARY KEY,
+	SnapshotID TEXT NOT NULL,
+	CustomerID TEXT NOT NULL,
+	Level TEXT NOT NULL,
+	Source TEXT NOT NULL,
+	Message TEXT NOT NULL,
+	Caller TEXT NOT NULL,
+	Example TEXT NOT NULL,
+	Count INTEGER NOT NULL,
+	FirstSeen BIGINT NOT NULL,
+	LastSeen BIGINT NOT NULL,
+	Timeline JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_logentries_snapshotid ON crm_logEntries (SnapshotID);
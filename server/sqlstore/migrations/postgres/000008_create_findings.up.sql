@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS crm_findings (
+	ID TEXT NOT NULL PRIMARY KEY,
+	SnapshotID TEXT NOT NULL,
+	CustomerID TEXT NOT NULL,
+	RuleID TEXT NOT NULL,
+	Severity TEXT NOT NULL,
+	Title TEXT NOT NULL,
+	Remediation TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_findings_snapshotid ON crm_findings (SnapshotID);
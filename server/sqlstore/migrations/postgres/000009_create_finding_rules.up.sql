@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS crm_findingRules (
+	ID TEXT NOT NULL PRIMARY KEY,
+	Version INTEGER NOT NULL UNIQUE,
+	CreatedAt BIGINT NOT NULL,
+	CreatedBy TEXT NOT NULL,
+	Rules TEXT NOT NULL
+);
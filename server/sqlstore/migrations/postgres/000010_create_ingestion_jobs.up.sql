@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS crm_ingestionJobs (
+	ID TEXT NOT NULL PRIMARY KEY,
+	PostID TEXT NOT NULL,
+	FileID TEXT NOT NULL,
+	ChannelID TEXT NOT NULL,
+	Status TEXT NOT NULL,
+	Attempts INTEGER NOT NULL DEFAULT 0,
+	LastError TEXT NOT NULL DEFAULT '',
+	CreateAt BIGINT NOT NULL,
+	UpdateAt BIGINT NOT NULL,
+	NextAttemptAt BIGINT NOT NULL,
+	LockedUntil BIGINT NOT NULL DEFAULT 0,
+	UNIQUE (PostID, FileID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_ingestionjobs_status_nextattemptat ON crm_ingestionJobs (Status, NextAttemptAt);
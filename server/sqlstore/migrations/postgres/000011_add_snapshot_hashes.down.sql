@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_crm_snapshots_contenthash;
+DROP INDEX IF EXISTS idx_crm_snapshots_archivehash;
+
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS ContentHash;
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS ArchiveHash;
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS PostID;
@@ -0,0 +1,6 @@
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS PostID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS ArchiveHash TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS ContentHash TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_crm_snapshots_archivehash ON crm_snapshots (ArchiveHash);
+CREATE INDEX IF NOT EXISTS idx_crm_snapshots_contenthash ON crm_snapshots (ContentHash);
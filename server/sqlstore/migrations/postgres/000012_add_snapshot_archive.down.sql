@@ -0,0 +1,2 @@
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS ArchiveSize;
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS ArchivePath;
@@ -0,0 +1,2 @@
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS ArchivePath TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS ArchiveSize BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS TicketRef;
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS ChannelID;
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS UserID;
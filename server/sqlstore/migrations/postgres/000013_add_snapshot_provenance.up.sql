@@ -0,0 +1,3 @@
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS UserID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS ChannelID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS TicketRef TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS crm_pendingUploads (
+	ID TEXT NOT NULL PRIMARY KEY,
+	CreatedAt BIGINT NOT NULL,
+	PromptPostID TEXT NOT NULL,
+	CandidateIDs JSONB NOT NULL,
+	Summary TEXT NOT NULL,
+	Upload JSONB NOT NULL
+);
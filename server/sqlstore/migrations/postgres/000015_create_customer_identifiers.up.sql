@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS crm_customerIdentifiers (
+	CustomerID TEXT NOT NULL,
+	Type TEXT NOT NULL,
+	Value TEXT NOT NULL,
+	Source TEXT NOT NULL,
+	CreatedAt BIGINT NOT NULL,
+	CreatedBy TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (CustomerID, Type, Value)
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_customeridentifiers_type_value ON crm_customerIdentifiers (Type, Value);
+
+INSERT INTO crm_customerIdentifiers (CustomerID, Type, Value, Source, CreatedAt)
+	SELECT ID, 'site_url', SiteURL, 'packet', LastUpdated FROM crm_customers WHERE SiteURL <> ''
+	ON CONFLICT DO NOTHING;
+
+INSERT INTO crm_customerIdentifiers (CustomerID, Type, Value, Source, CreatedAt)
+	SELECT ID, 'licensed_to', LicensedTo, 'packet', LastUpdated FROM crm_customers WHERE LicensedTo <> ''
+	ON CONFLICT DO NOTHING;
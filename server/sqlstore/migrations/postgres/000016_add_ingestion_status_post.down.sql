@@ -0,0 +1 @@
+ALTER TABLE crm_ingestionJobs DROP COLUMN IF EXISTS StatusPostID;
@@ -0,0 +1 @@
+ALTER TABLE crm_ingestionJobs ADD COLUMN IF NOT EXISTS StatusPostID TEXT NOT NULL DEFAULT '';
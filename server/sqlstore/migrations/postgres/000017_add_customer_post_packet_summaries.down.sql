@@ -0,0 +1 @@
+ALTER TABLE crm_customers DROP COLUMN IF EXISTS PostPacketSummaries;
@@ -0,0 +1 @@
+ALTER TABLE crm_customers ADD COLUMN IF NOT EXISTS PostPacketSummaries BOOLEAN NOT NULL DEFAULT FALSE;
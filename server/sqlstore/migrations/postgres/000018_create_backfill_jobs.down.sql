@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS crm_backfillItems;
+DROP TABLE IF EXISTS crm_backfillJobs;
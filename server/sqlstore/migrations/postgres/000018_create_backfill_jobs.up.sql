@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS crm_backfillJobs (
+	ID TEXT NOT NULL PRIMARY KEY,
+	CreatedBy TEXT NOT NULL,
+	ChannelIDs JSONB NOT NULL,
+	Since BIGINT NOT NULL,
+	Until BIGINT NOT NULL,
+	Status TEXT NOT NULL,
+	ScanChannel INTEGER NOT NULL DEFAULT 0,
+	ScanCursor TEXT NOT NULL DEFAULT '',
+	PostsScanned INTEGER NOT NULL DEFAULT 0,
+	LastError TEXT NOT NULL DEFAULT '',
+	CreateAt BIGINT NOT NULL,
+	UpdateAt BIGINT NOT NULL,
+	LockedUntil BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS crm_backfillItems (
+	ID TEXT NOT NULL PRIMARY KEY,
+	JobID TEXT NOT NULL,
+	PostID TEXT NOT NULL,
+	FileID TEXT NOT NULL,
+	ChannelID TEXT NOT NULL,
+	PostCreateAt BIGINT NOT NULL,
+	Status TEXT NOT NULL,
+	Attempts INTEGER NOT NULL DEFAULT 0,
+	LastError TEXT NOT NULL DEFAULT '',
+	UNIQUE (JobID, PostID, FileID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_backfillitems_jobid_status_postcreateat ON crm_backfillItems (JobID, Status, PostCreateAt);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS crm_packetFieldPins;
+ALTER TABLE crm_packetValues DROP COLUMN IF EXISTS Sources;
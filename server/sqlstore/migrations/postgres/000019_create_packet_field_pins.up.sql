@@ -0,0 +1,11 @@
+ALTER TABLE crm_packetValues ADD COLUMN IF NOT EXISTS Sources JSONB NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS crm_packetFieldPins (
+	CustomerID TEXT NOT NULL,
+	Field TEXT NOT NULL,
+	Value JSONB NOT NULL,
+	PinnedBy TEXT NOT NULL,
+	PinnedAt BIGINT NOT NULL,
+	ExpiresAt BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (CustomerID, Field)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE crm_customers DROP COLUMN IF EXISTS ArchivedBy;
+ALTER TABLE crm_customers DROP COLUMN IF EXISTS ArchivedAt;
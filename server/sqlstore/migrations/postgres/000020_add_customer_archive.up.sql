@@ -0,0 +1,2 @@
+ALTER TABLE crm_customers ADD COLUMN IF NOT EXISTS ArchivedAt BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE crm_customers ADD COLUMN IF NOT EXISTS ArchivedBy TEXT NOT NULL DEFAULT '';
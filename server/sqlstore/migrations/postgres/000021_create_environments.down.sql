@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_crm_snapshots_environmentid;
+DROP INDEX IF EXISTS idx_crm_nodevalues_environmentid;
+DROP INDEX IF EXISTS idx_crm_pluginvalues_environmentid;
+DROP INDEX IF EXISTS idx_crm_configvalues_environmentid;
+DROP INDEX IF EXISTS idx_crm_packetvalues_environmentid;
+
+ALTER TABLE crm_snapshots DROP COLUMN IF EXISTS EnvironmentID;
+ALTER TABLE crm_nodeValues DROP COLUMN IF EXISTS EnvironmentID;
+ALTER TABLE crm_pluginValues DROP COLUMN IF EXISTS EnvironmentID;
+ALTER TABLE crm_configValues DROP COLUMN IF EXISTS EnvironmentID;
+ALTER TABLE crm_packetValues DROP COLUMN IF EXISTS EnvironmentID;
+
+DROP TABLE IF EXISTS crm_environments;
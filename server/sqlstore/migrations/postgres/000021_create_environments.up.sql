@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS crm_environments (
+	ID TEXT NOT NULL PRIMARY KEY,
+	CustomerID TEXT NOT NULL,
+	Name TEXT NOT NULL,
+	Type TEXT NOT NULL,
+	SiteURL TEXT NOT NULL DEFAULT '',
+	TelemetryID TEXT NOT NULL DEFAULT '',
+	IsPrimary BOOLEAN NOT NULL DEFAULT FALSE,
+	CreatedAt BIGINT NOT NULL,
+	CreatedBy TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_environments_customerid ON crm_environments (CustomerID);
+
+ALTER TABLE crm_packetValues ADD COLUMN IF NOT EXISTS EnvironmentID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_configValues ADD COLUMN IF NOT EXISTS EnvironmentID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_pluginValues ADD COLUMN IF NOT EXISTS EnvironmentID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_nodeValues ADD COLUMN IF NOT EXISTS EnvironmentID TEXT NOT NULL DEFAULT '';
+ALTER TABLE crm_snapshots ADD COLUMN IF NOT EXISTS EnvironmentID TEXT NOT NULL DEFAULT '';
+
+-- every existing customer gets a primary production environment holding everything stored so far
+INSERT INTO crm_environments (ID, CustomerID, Name, Type, SiteURL, IsPrimary, CreatedAt)
+	SELECT SUBSTR(MD5(ID || 'environment'), 1, 26), ID, 'Production', 'production', SiteURL, TRUE, LastUpdated FROM crm_customers
+	ON CONFLICT DO NOTHING;
+
+UPDATE crm_packetValues SET EnvironmentID = SUBSTR(MD5(CustomerID || 'environment'), 1, 26) WHERE EnvironmentID = '';
+UPDATE crm_configValues SET EnvironmentID = SUBSTR(MD5(CustomerID || 'environment'), 1, 26) WHERE EnvironmentID = '';
+UPDATE crm_pluginValues SET EnvironmentID = SUBSTR(MD5(CustomerID || 'environment'), 1, 26) WHERE EnvironmentID = '';
+UPDATE crm_nodeValues SET EnvironmentID = SUBSTR(MD5(CustomerID || 'environment'), 1, 26) WHERE EnvironmentID = '';
+UPDATE crm_snapshots SET EnvironmentID = SUBSTR(MD5(CustomerID || 'environment'), 1, 26) WHERE EnvironmentID = '';
+
+CREATE INDEX IF NOT EXISTS idx_crm_packetvalues_environmentid ON crm_packetValues (EnvironmentID, Current);
+CREATE INDEX IF NOT EXISTS idx_crm_configvalues_environmentid ON crm_configValues (EnvironmentID, Current);
+CREATE INDEX IF NOT EXISTS idx_crm_pluginvalues_environmentid ON crm_pluginValues (EnvironmentID, Current);
+CREATE INDEX IF NOT EXISTS idx_crm_nodevalues_environmentid ON crm_nodeValues (EnvironmentID, Current);
+CREATE INDEX IF NOT EXISTS idx_crm_snapshots_environmentid ON crm_snapshots (EnvironmentID, CreatedAt);
@@ -0,0 +1,2 @@
+ALTER TABLE crm_pendingUploads DROP COLUMN IF EXISTS ClaimedBy;
+ALTER TABLE crm_pendingUploads DROP COLUMN IF EXISTS ClaimedAt;
@@ -0,0 +1,2 @@
+ALTER TABLE crm_pendingUploads ADD COLUMN IF NOT EXISTS ClaimedAt BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE crm_pendingUploads ADD COLUMN IF NOT EXISTS ClaimedBy TEXT NOT NULL DEFAULT '';
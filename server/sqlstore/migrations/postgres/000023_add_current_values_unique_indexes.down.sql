@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_crm_pluginvalues_environmentid_pluginid_current;
+DROP INDEX IF EXISTS idx_crm_configvalues_environmentid_current;
+DROP INDEX IF EXISTS idx_crm_packetvalues_environmentid_current;
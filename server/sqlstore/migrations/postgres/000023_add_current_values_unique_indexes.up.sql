@@ -0,0 +1,25 @@
+-- only the newest current values of an environment stay current, before the indexes enforce it
+UPDATE crm_packetValues SET Current = FALSE WHERE Current AND ID NOT IN (
+	SELECT DISTINCT ON (v.EnvironmentID) v.ID FROM crm_packetValues v
+	LEFT JOIN crm_audit a ON a.ID = v.AuditID
+	WHERE v.Current
+	ORDER BY v.EnvironmentID, a.UpdatedAt DESC NULLS LAST
+);
+UPDATE crm_configValues SET Current = FALSE WHERE Current AND ID NOT IN (
+	SELECT DISTINCT ON (v.EnvironmentID) v.ID FROM crm_configValues v
+	LEFT JOIN crm_audit a ON a.ID = v.AuditID
+	WHERE v.Current
+	ORDER BY v.EnvironmentID, a.UpdatedAt DESC NULLS LAST
+);
+UPDATE crm_pluginValues SET Current = FALSE WHERE Current AND ID NOT IN (
+	SELECT DISTINCT ON (v.EnvironmentID, v.PluginID) v.ID FROM crm_pluginValues v
+	LEFT JOIN crm_audit a ON a.ID = v.AuditID
+	WHERE v.Current
+	ORDER BY v.EnvironmentID, v.PluginID, a.UpdatedAt DESC NULLS LAST
+);
+
+-- an environment has one current packet and config, and one current row per plugin. Its current
+-- nodes are a row per node of the cluster, so they are left out.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_crm_packetvalues_environmentid_current ON crm_packetValues (EnvironmentID) WHERE Current;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_crm_configvalues_environmentid_current ON crm_configValues (EnvironmentID) WHERE Current;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_crm_pluginvalues_environmentid_pluginid_current ON crm_pluginValues (EnvironmentID, PluginID) WHERE Current;
@@ -0,0 +1,168 @@
+package sqlstore
+
+import (
+	"encoding/json"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+const packetPinTable = "crm_packetFieldPins"
+
+// getPacketPins returns the customer's pins that haven't expired.
+func (s *customerStore) getPacketPins(q queryer, customerID string) ([]app.PacketFieldPin, error) {
+	var pins []app.PacketFieldPin
+	err := s.store.selectBuilder(q, &pins, s.queryBuilder.
+		Select(
+			"pfp.Field",
+			"pfp.Value",
+			"pfp.PinnedBy",
+			"pfp.PinnedAt",
+			"pfp.ExpiresAt",
+		).
+		From(packetPinTable+" as pfp").
+		Where(sq.Eq{"pfp.CustomerID": customerID}).
+		Where(sq.Or{sq.Eq{"pfp.ExpiresAt": 0}, sq.Gt{"pfp.ExpiresAt": model.GetMillis()}}).
+		OrderBy("pfp.Field"))
+	if err != nil {
+		return []app.PacketFieldPin{}, errors.Wrapf(err, "failed to get packet pins for customer id '%s'", customerID)
+	}
+
+	return pins, nil
+}
+
+func (s *customerStore) GetPacketFields(customerID string) ([]app.PacketField, error) {
+	stored, sources, err := s.GetStoredPacket(customerID)
+	if err != nil {
+		return []app.PacketField{}, err
+	}
+
+	pins, err := s.getPacketPins(s.store.db, customerID)
+	if err != nil {
+		return []app.PacketField{}, err
+	}
+
+	return app.NewPacketFields(stored, sources, pins), nil
+}
+
+func (s *customerStore) PinPacketFields(customerID string, userID string, fields map[string]json.RawMessage, expiresAt int64) error {
+	if customerID == "" {
+		return errors.New("customerID cannot be empty")
+	}
+
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	if err = s.pinPacketFields(tx, customerID, userID, fields, expiresAt); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+
+	return nil
+}
+
+// pinPacketFields pins the fields and audits the change in the transaction. The customer is
+// locked first, so the audited values before and after are those of this change only.
+func (s *customerStore) pinPacketFields(tx *sqlx.Tx, customerID string, userID string, fields map[string]json.RawMessage, expiresAt int64) error {
+	if err := s.lockCustomer(tx, customerID); err != nil {
+		return err
+	}
+
+	before, err := s.getPacket(tx, customerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get packet before pinning")
+	}
+
+	now := model.GetMillis()
+	for field, value := range fields {
+		_, err = s.store.execBuilder(tx, sq.
+			Insert(packetPinTable).
+			SetMap(map[string]interface{}{
+				"CustomerID": customerID,
+				"Field":      field,
+				"Value":      string(value),
+				"PinnedBy":   userID,
+				"PinnedAt":   now,
+				"ExpiresAt":  expiresAt,
+			}).
+			Suffix("ON CONFLICT (CustomerID, Field) DO UPDATE SET Value = EXCLUDED.Value, PinnedBy = EXCLUDED.PinnedBy, PinnedAt = EXCLUDED.PinnedAt, ExpiresAt = EXCLUDED.ExpiresAt"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to pin packet field '%s'", field)
+		}
+	}
+
+	return s.auditPinChange(tx, customerID, userID, before, now)
+}
+
+func (s *customerStore) UnpinPacketField(customerID string, userID string, field string) error {
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	if err = s.lockCustomer(tx, customerID); err != nil {
+		return err
+	}
+
+	before, err := s.getPacket(tx, customerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get packet before unpinning")
+	}
+
+	result, err := s.store.execBuilder(tx, sq.
+		Delete(packetPinTable).
+		Where(sq.Eq{"CustomerID": customerID, "Field": field}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to unpin packet field '%s'", field)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "failed to unpin packet field '%s'", field)
+	}
+	if rows == 0 {
+		return errors.Wrapf(app.ErrNotFound, "packet field '%s' is not pinned for customer '%s'", field, customerID)
+	}
+
+	if err = s.auditPinChange(tx, customerID, userID, before, model.GetMillis()); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+
+	return nil
+}
+
+// auditPinChange records how the pins changed the customer's effective packet values.
+func (s *customerStore) auditPinChange(tx *sqlx.Tx, customerID string, userID string, before app.CustomerPacketValues, at int64) error {
+	after, err := s.getPacket(tx, customerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get packet after pinning")
+	}
+
+	diff, err := diffPacket(&before, &after)
+	if err != nil {
+		return errors.Wrap(err, "failed to diff packet")
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+
+	if _, err = s.createAuditRow(tx, customerID, userID, User, diff, at); err != nil {
+		return errors.Wrap(err, "failed to create audit row")
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+const pendingUploadTable = "crm_pendingUploads"
+
+type sqlPendingUpload struct {
+	ID           string          `db:"id"`
+	CreatedAt    int64           `db:"createdat"`
+	PromptPostID string          `db:"promptpostid"`
+	CandidateIDs json.RawMessage `db:"candidateids"`
+	Summary      string          `db:"summary"`
+	Upload       json.RawMessage `db:"upload"`
+}
+
+func (s *customerStore) CreatePendingUpload(pending app.PendingUpload) (string, error) {
+	if pending.Upload == nil {
+		return "", errors.New("upload cannot be empty")
+	}
+
+	candidatesJSON, err := json.Marshal(pending.CandidateIDs)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal candidate ids")
+	}
+
+	uploadJSON, err := json.Marshal(pending.Upload)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal pending upload")
+	}
+
+	pending.ID = model.NewId()
+	if pending.CreatedAt == 0 {
+		pending.CreatedAt = model.GetMillis()
+	}
+
+	_, err = s.store.execBuilder(s.store.db, sq.
+		Insert(pendingUploadTable).
+		SetMap(map[string]interface{}{
+			"ID":           pending.ID,
+			"CreatedAt":    pending.CreatedAt,
+			"PromptPostID": pending.PromptPostID,
+			"CandidateIDs": string(candidatesJSON),
+			"Summary":      pending.Summary,
+			"Upload":       string(uploadJSON),
+		}))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store pending upload")
+	}
+
+	return pending.ID, nil
+}
+
+func (s *customerStore) SetPendingUploadPrompt(id string, promptPostID string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(pendingUploadTable).
+		Set("PromptPostID", promptPostID).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to set prompt of pending upload '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *customerStore) GetPendingUpload(id string) (app.PendingUpload, error) {
+	return s.getPendingUpload(s.store.db, id)
+}
+
+func (s *customerStore) GetPendingUploadForPacket(postID string, archiveHash string) (app.PendingUpload, error) {
+	var id string
+	err := s.store.getBuilder(s.store.db, &id, s.queryBuilder.
+		Select("ID").
+		From(pendingUploadTable).
+		Where(sq.Expr("Upload->>'PostID' = ?", postID)).
+		Where(sq.Expr("Upload->>'ArchiveHash' = ?", archiveHash)).
+		OrderBy("CreatedAt").
+		Limit(1))
+	if err == sql.ErrNoRows {
+		return app.PendingUpload{}, errors.Wrapf(app.ErrNotFound, "no pending upload of archive '%s' in post '%s'", archiveHash, postID)
+	} else if err != nil {
+		return app.PendingUpload{}, errors.Wrapf(err, "failed to get pending upload of archive '%s' in post '%s'", archiveHash, postID)
+	}
+
+	return s.getPendingUpload(s.store.db, id)
+}
+
+// ClaimPendingUpload marks the pending upload as being stored by the user and returns it, so only
+// one choice is ever applied. The row stays until the upload is stored, so a failed attempt can
+// release it for another.
+func (s *customerStore) ClaimPendingUpload(id string, userID string) (app.PendingUpload, error) {
+	result, err := s.store.execBuilder(s.store.db, sq.
+		Update(pendingUploadTable).
+		SetMap(map[string]interface{}{
+			"ClaimedAt": model.GetMillis(),
+			"ClaimedBy": userID,
+		}).
+		Where(sq.Eq{"ID": id}).
+		Where(sq.Eq{"ClaimedAt": 0}))
+	if err != nil {
+		return app.PendingUpload{}, errors.Wrapf(err, "failed to claim pending upload '%s'", id)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return app.PendingUpload{}, errors.Wrapf(err, "failed to claim pending upload '%s'", id)
+	}
+	if rows == 0 {
+		return app.PendingUpload{}, errors.Wrapf(app.ErrNotFound, "pending upload '%s' does not exist or was already claimed", id)
+	}
+
+	return s.getPendingUpload(s.store.db, id)
+}
+
+func (s *customerStore) ReleasePendingUpload(id string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(pendingUploadTable).
+		SetMap(map[string]interface{}{
+			"ClaimedAt": 0,
+			"ClaimedBy": "",
+		}).
+		Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to release pending upload '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *customerStore) DeletePendingUpload(id string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.Delete(pendingUploadTable).Where(sq.Eq{"ID": id}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete pending upload '%s'", id)
+	}
+
+	return nil
+}
+
+func (s *customerStore) getPendingUpload(q sqlx.Queryer, id string) (app.PendingUpload, error) {
+	query := s.queryBuilder.
+		Select("ID", "CreatedAt", "PromptPostID", "CandidateIDs", "Summary", "Upload").
+		From(pendingUploadTable).
+		Where(sq.Eq{"ID": id})
+
+	var raw sqlPendingUpload
+	err := s.store.getBuilder(q, &raw, query)
+	if err == sql.ErrNoRows {
+		return app.PendingUpload{}, errors.Wrapf(app.ErrNotFound, "pending upload does not exist for id '%s'", id)
+	} else if err != nil {
+		return app.PendingUpload{}, errors.Wrapf(err, "failed to get pending upload '%s'", id)
+	}
+
+	pending := app.PendingUpload{
+		ID:           raw.ID,
+		CreatedAt:    raw.CreatedAt,
+		PromptPostID: raw.PromptPostID,
+		Summary:      raw.Summary,
+	}
+	if err = json.Unmarshal(raw.CandidateIDs, &pending.CandidateIDs); err != nil {
+		return app.PendingUpload{}, errors.Wrap(err, "failed to unmarshal candidate ids")
+	}
+	if err = json.Unmarshal(raw.Upload, &pending.Upload); err != nil {
+		return app.PendingUpload{}, errors.Wrap(err, "failed to unmarshal pending upload")
+	}
+
+	return pending, nil
+}
+
+// replacePendingCandidate swaps the customer for the replacement in the candidates of every
+// pending upload offering it, or drops it when replacementID is empty, so a customer that was
+// deleted or merged away can't be chosen for them anymore.
+func (s *customerStore) replacePendingCandidate(q queryExecer, customerID string, replacementID string) error {
+	customerJSON, err := json.Marshal([]string{customerID})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal candidate id")
+	}
+
+	var rawPending []sqlPendingUpload
+	err = s.store.selectBuilder(q, &rawPending, s.queryBuilder.
+		Select("ID", "CandidateIDs").
+		From(pendingUploadTable).
+		Where(sq.Expr("CandidateIDs @> ?::jsonb", string(customerJSON))).
+		Suffix("FOR UPDATE"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get pending uploads offering customer '%s'", customerID)
+	}
+
+	for _, raw := range rawPending {
+		var candidateIDs []string
+		if err = json.Unmarshal(raw.CandidateIDs, &candidateIDs); err != nil {
+			return errors.Wrap(err, "failed to unmarshal candidate ids")
+		}
+
+		replaced := make([]string, 0, len(candidateIDs))
+		seen := make(map[string]bool)
+		for _, candidateID := range candidateIDs {
+			if candidateID == customerID {
+				candidateID = replacementID
+			}
+			if candidateID == "" || seen[candidateID] {
+				continue
+			}
+			seen[candidateID] = true
+			replaced = append(replaced, candidateID)
+		}
+
+		var candidatesJSON []byte
+		candidatesJSON, err = json.Marshal(replaced)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal candidate ids")
+		}
+
+		_, err = s.store.execBuilder(q, sq.
+			Update(pendingUploadTable).
+			Set("CandidateIDs", string(candidatesJSON)).
+			Where(sq.Eq{"ID": raw.ID}))
+		if err != nil {
+			return errors.Wrapf(err, "failed to update candidates of pending upload '%s'", raw.ID)
+		}
+	}
+
+	return nil
+}
@@ -2,92 +2,207 @@ package sqlstore
 
 import (
 	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/jmoiron/sqlx"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 )
 
-func (s *customerStore) rawPacketToPacket(rawPacket *model.SupportPacket) *app.CustomerPacketValues {
-	var packet app.CustomerPacketValues
-
-	packet.LicensedTo = rawPacket.LicenseTo
-	packet.Version = rawPacket.ServerVersion
-	packet.ServerOS = rawPacket.ServerOS
-	packet.ServerArch = rawPacket.ServerArchitecture
-	packet.DatabaseType = rawPacket.DatabaseType
-	packet.DatabaseVersion = rawPacket.DatabaseVersion
-	packet.DatabaseSchemaVersion = rawPacket.DatabaseSchemaVersion
-	packet.FileDriver = rawPacket.FileDriver
-	packet.ActiveUsers = rawPacket.ActiveUsers
-	packet.DailyActiveUsers = rawPacket.DailyActiveUsers
-	packet.MonthlyActiveUsers = rawPacket.MonthlyActiveUsers
-	packet.InactiveUserCount = rawPacket.InactiveUserCount
-	packet.LicenseSupportedUsers = rawPacket.LicenseSupportedUsers
-	packet.TotalPosts = rawPacket.TotalPosts
-	packet.TotalChannels = rawPacket.TotalChannels
-	packet.TotalTeams = rawPacket.TotalTeams
-	packet.LDAPProvider = rawPacket.LdapVendorName
-	packet.ElasticServerVersion = rawPacket.ElasticServerVersion
-	return &packet
+// revision is when stored values were uploaded, the environment they're stored for and whether
+// they replace its current ones.
+type revision struct {
+	at int64
+
+	// environment is the ID of the environment, primary is set if the customer's own site URL and
+	// license holder follow its values.
+	environment string
+	primary     bool
+
+	// history is set for packets older than the customer's newest one, like the ones found by a
+	// backfill. Their values are kept without replacing the current ones, and are diffed against
+	// the values uploaded before them, the audit rows of which are set when there are any.
+	history         bool
+	previousPacket  string
+	previousConfig  string
+	previousPlugins string
+}
+
+// currentRevision is for values that replace the environment's current ones as of now, like the
+// ones edited by users.
+func currentRevision(environment app.Environment) revision {
+	return revision{
+		at:          model.GetMillis(),
+		environment: environment.ID,
+		primary:     environment.Primary,
+	}
 }
 
-func (s *customerStore) rawPluginstoPlugins(rawPlugins *model.PluginsResponse) []app.CustomerPluginValues {
-	var parsedPlugins []app.CustomerPluginValues
+// uploadRevision returns the revision of a packet uploaded for the environment at the given time,
+// or now if it's zero. It's ordered against the environment's own packets only.
+func (s *customerStore) uploadRevision(q queryer, environment app.Environment, uploadedAt int64) (revision, error) {
+	rev := currentRevision(environment)
+	if uploadedAt == 0 {
+		return rev, nil
+	}
+
+	// newest first
+	snapshots, err := s.getEnvironmentSnapshots(q, environment.ID)
+	if err != nil {
+		return revision{}, errors.Wrap(err, "failed to get snapshots to order the upload")
+	}
 
-	for _, plugin := range rawPlugins.Active {
-		parsedPlugins = append(parsedPlugins, app.CustomerPluginValues{
-			PluginID:    plugin.Id,
-			Version:     plugin.Version,
-			IsActive:    true,
-			Name:        plugin.Name,
-			HomePageURL: plugin.HomepageURL,
-		})
+	rev.at = uploadedAt
+	if len(snapshots) == 0 || snapshots[0].CreatedAt <= uploadedAt {
+		return rev, nil
 	}
 
-	for _, plugin := range rawPlugins.Inactive {
-		parsedPlugins = append(parsedPlugins, app.CustomerPluginValues{
-			PluginID:    plugin.Id,
-			Version:     plugin.Version,
-			IsActive:    false,
-			Name:        plugin.Name,
-			HomePageURL: plugin.HomepageURL,
-		})
+	rev.history = true
+	for _, snapshot := range snapshots {
+		if snapshot.CreatedAt > uploadedAt {
+			continue
+		}
+		if rev.previousPacket == "" {
+			rev.previousPacket = snapshot.PacketAuditID
+		}
+		if rev.previousConfig == "" {
+			rev.previousConfig = snapshot.ConfigAuditID
+		}
+		if rev.previousPlugins == "" {
+			rev.previousPlugins = snapshot.PluginsAuditID
+		}
 	}
 
-	return parsedPlugins
+	return rev, nil
 }
 
-func (s *customerStore) UpdateCustomerThroughUpload(customerID string, packet *model.SupportPacket, config *model.Config, plugins *model.PluginsResponse) error {
+func (s *customerStore) UpdateCustomerThroughUpload(customerID string, upload *app.SupportPacketUpload) (string, error) {
 	if customerID == "" {
-		return errors.New("customerID cannot be empty")
+		return "", errors.New("customerID cannot be empty")
 	}
 
-	if packet == nil && config == nil && plugins == nil {
-		return errors.New("must include at least one of packet, config, or plugins")
+	if upload == nil || (upload.Packet == nil && upload.Config == nil && upload.Plugins == nil) {
+		return "", errors.New("must include at least one of packet, config, or plugins")
 	}
 
-	if packet != nil {
-		rawPacket := s.rawPacketToPacket(packet)
+	// a retried upload either finds everything stored or nothing
+	tx, err := s.store.db.Beginx()
+	if err != nil {
+		return "", errors.Wrap(err, "could not begin transaction")
+	}
+	defer s.store.finalizeTransaction(tx)
+
+	environment, err := s.uploadEnvironment(tx, customerID, upload)
+	if err != nil {
+		return "", err
+	}
 
-		err := s.storePacket("", customerID, rawPacket)
+	rev, err := s.uploadRevision(tx, environment, upload.UploadedAt)
+	if err != nil {
+		return "", err
+	}
+
+	snapshot := app.PacketSnapshot{
+		CustomerID:    customerID,
+		EnvironmentID: environment.ID,
+		CreatedAt:     rev.at,
+		FileName:      upload.FileName,
+		UserID:        upload.UserID,
+		PostID:        upload.PostID,
+		ChannelID:     upload.ChannelID,
+		TicketRef:     upload.TicketRef,
+		ArchiveHash:   upload.ArchiveHash,
+		ContentHash:   upload.ContentHash,
+		ArchivePath:   upload.ArchivePath,
+		ArchiveSize:   upload.ArchiveSize,
+	}
+
+	if upload.Packet != nil {
+		// values the packet has no evidence for are kept from the ones it follows
+		previousPacket, previousSources, err := s.revisionPacket(tx, rev)
 		if err != nil {
-			return errors.Wrap(err, "failed to store packet")
+			return "", errors.Wrap(err, "failed to get existing packet")
+		}
+		rawPacket := app.PacketValuesFromUpload(upload, &previousPacket)
+		sources := app.PacketSourcesFromUpload(upload, previousSources)
+
+		auditID, err := s.storePacket(tx, upload.UserID, Packet, customerID, rawPacket, sources, rev)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to store packet")
+		}
+		snapshot.PacketAuditID = auditID
+
+		err = s.storeNodes(tx, auditID, customerID, upload.Nodes, rev)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to store nodes")
 		}
 	}
 
-	if config != nil {
-		err := s.storeConfig("", customerID, config)
+	if upload.Config != nil {
+		auditID, err := s.storeConfig(tx, upload.UserID, Packet, customerID, upload.Config, rev)
 		if err != nil {
-			return errors.Wrap(err, "failed to store config")
+			return "", errors.Wrap(err, "failed to store config")
 		}
+		snapshot.ConfigAuditID = auditID
 	}
 
-	if plugins != nil {
-		rawPlugins := s.rawPluginstoPlugins(plugins)
-		err := s.storePlugins("", customerID, rawPlugins)
+	if upload.Plugins != nil {
+		rawPlugins := app.PluginValuesFromPluginsResponse(upload.Plugins)
+		auditID, err := s.storePlugins(tx, upload.UserID, Packet, customerID, rawPlugins, rev)
 		if err != nil {
-			return errors.Wrap(err, "failed to store plugins")
+			return "", errors.Wrap(err, "failed to store plugins")
 		}
+		snapshot.PluginsAuditID = auditID
+	}
+
+	snapshotID, err := s.createSnapshot(tx, snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	if upload.Logs != nil {
+		err = s.storeLogEntries(tx, snapshotID, customerID, upload.Logs.Entries)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to store log entries")
+		}
+	}
+
+	err = s.storeFindings(tx, snapshotID, customerID, upload.Findings)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store findings")
+	}
+
+	err = s.addPacketIdentifiers(tx, customerID, upload.UserID, rev.at, upload.Identity)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store identifiers")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "could not commit transaction")
+	}
+
+	return snapshotID, nil
+}
+
+// uploadEnvironment returns the customer's environment the upload was resolved to, or resolves it
+// now if it wasn't. The environment is locked until the transaction ends, so uploads for the same
+// server are stored one after the other.
+func (s *customerStore) uploadEnvironment(tx *sqlx.Tx, customerID string, upload *app.SupportPacketUpload) (app.Environment, error) {
+	var environment app.Environment
+	var err error
+	if upload.EnvironmentID == "" {
+		environment, err = s.resolveEnvironment(tx, customerID, upload.UserID, upload.Identity)
+	} else {
+		environment, err = s.getEnvironment(tx, upload.EnvironmentID)
+	}
+	if err != nil {
+		return app.Environment{}, err
+	}
+	if environment.CustomerID != customerID {
+		return app.Environment{}, errors.Wrapf(app.ErrNotFound, "customer '%s' has no environment '%s'", customerID, upload.EnvironmentID)
+	}
+
+	if err = s.lockEnvironment(tx, environment.ID); err != nil {
+		return app.Environment{}, err
 	}
 
-	return nil
+	return environment, nil
 }
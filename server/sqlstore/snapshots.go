@@ -0,0 +1,158 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"github.com/coltoneshaw/mattermost-plugin-customers/server/app"
+	"github.com/mattermost/mattermost/server/public/model"
+	sq "github.com/mattermost/squirrel"
+	"github.com/pkg/errors"
+)
+
+func (s *customerStore) GetSnapshots(customerID string) ([]app.PacketSnapshot, error) {
+	if customerID == "" {
+		return []app.PacketSnapshot{}, errors.New("ID cannot be empty")
+	}
+
+	var snapshots []app.PacketSnapshot
+	err := s.store.selectBuilder(
+		s.store.db,
+		&snapshots,
+		s.snapshotSelect.
+			Where(sq.Eq{"cs.customerId": customerID}).
+			OrderBy("cs.createdAt DESC"),
+	)
+	if err != nil {
+		return []app.PacketSnapshot{}, errors.Wrapf(err, "failed to get snapshots for customer id '%s'", customerID)
+	}
+
+	return snapshots, nil
+}
+
+// getEnvironmentSnapshots returns the packets uploaded for the environment, newest first.
+func (s *customerStore) getEnvironmentSnapshots(q queryer, environmentID string) ([]app.PacketSnapshot, error) {
+	var snapshots []app.PacketSnapshot
+	err := s.store.selectBuilder(
+		q,
+		&snapshots,
+		s.snapshotSelect.
+			Where(sq.Eq{"cs.environmentId": environmentID}).
+			OrderBy("cs.createdAt DESC"),
+	)
+	if err != nil {
+		return []app.PacketSnapshot{}, errors.Wrapf(err, "failed to get snapshots for environment id '%s'", environmentID)
+	}
+
+	return snapshots, nil
+}
+
+func (s *customerStore) GetSnapshot(snapshotID string) (app.PacketSnapshot, error) {
+	if snapshotID == "" {
+		return app.PacketSnapshot{}, errors.New("ID cannot be empty")
+	}
+
+	var snapshot app.PacketSnapshot
+	err := s.store.getBuilder(s.store.db, &snapshot, s.snapshotSelect.Where(sq.Eq{"cs.ID": snapshotID}))
+	if err == sql.ErrNoRows {
+		return app.PacketSnapshot{}, errors.Wrapf(app.ErrNotFound, "snapshot does not exist for id '%s'", snapshotID)
+	} else if err != nil {
+		return app.PacketSnapshot{}, errors.Wrapf(err, "failed to get snapshot by id '%s'", snapshotID)
+	}
+
+	return snapshot, nil
+}
+
+func (s *customerStore) GetArchivedSnapshots() ([]app.PacketSnapshot, error) {
+	var snapshots []app.PacketSnapshot
+	err := s.store.selectBuilder(
+		s.store.db,
+		&snapshots,
+		s.snapshotSelect.
+			Where(sq.NotEq{"cs.archivePath": ""}).
+			OrderBy("cs.customerId", "cs.createdAt DESC"),
+	)
+	if err != nil {
+		return []app.PacketSnapshot{}, errors.Wrap(err, "failed to get archived snapshots")
+	}
+
+	return snapshots, nil
+}
+
+func (s *customerStore) ClearSnapshotArchive(snapshotID string) error {
+	_, err := s.store.execBuilder(s.store.db, sq.
+		Update(snapshotTable).
+		SetMap(map[string]interface{}{
+			"ArchivePath": "",
+			"ArchiveSize": 0,
+		}).
+		Where(sq.Eq{"ID": snapshotID}))
+	if err != nil {
+		return errors.Wrapf(err, "failed to clear archive of snapshot '%s'", snapshotID)
+	}
+
+	return nil
+}
+
+func (s *customerStore) GetSnapshotByHash(archiveHash string, contentHash string) (app.PacketSnapshot, error) {
+	hashes := sq.Or{}
+	if archiveHash != "" {
+		hashes = append(hashes, sq.Eq{"cs.archiveHash": archiveHash})
+	}
+	if contentHash != "" {
+		hashes = append(hashes, sq.Eq{"cs.contentHash": contentHash})
+	}
+	if len(hashes) == 0 {
+		return app.PacketSnapshot{}, errors.New("a hash must be provided")
+	}
+
+	var snapshot app.PacketSnapshot
+	err := s.store.getBuilder(
+		s.store.db,
+		&snapshot,
+		s.snapshotSelect.
+			Where(hashes).
+			OrderBy("cs.createdAt").
+			Limit(1),
+	)
+	if err == sql.ErrNoRows {
+		return app.PacketSnapshot{}, errors.Wrap(app.ErrNotFound, "no snapshot with a matching hash")
+	} else if err != nil {
+		return app.PacketSnapshot{}, errors.Wrap(err, "failed to get snapshot by hash")
+	}
+
+	return snapshot, nil
+}
+
+// createSnapshot records an uploaded packet, linking the audit rows created while storing it.
+func (s *customerStore) createSnapshot(e execer, snapshot app.PacketSnapshot) (string, error) {
+	snapshot.ID = model.NewId()
+	if snapshot.CreatedAt == 0 {
+		snapshot.CreatedAt = model.GetMillis()
+	}
+
+	_, err := s.store.execBuilder(e, sq.
+		Insert(snapshotTable).
+		SetMap(map[string]interface{}{
+			"ID":             snapshot.ID,
+			"CustomerID":     snapshot.CustomerID,
+			"EnvironmentID":  snapshot.EnvironmentID,
+			"CreatedAt":      snapshot.CreatedAt,
+			"FileName":       snapshot.FileName,
+			"PacketAuditID":  snapshot.PacketAuditID,
+			"ConfigAuditID":  snapshot.ConfigAuditID,
+			"PluginsAuditID": snapshot.PluginsAuditID,
+			"UserID":         snapshot.UserID,
+			"PostID":         snapshot.PostID,
+			"ChannelID":      snapshot.ChannelID,
+			"TicketRef":      snapshot.TicketRef,
+			"ArchiveHash":    snapshot.ArchiveHash,
+			"ContentHash":    snapshot.ContentHash,
+			"ArchivePath":    snapshot.ArchivePath,
+			"ArchiveSize":    snapshot.ArchiveSize,
+		}))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store snapshot")
+	}
+
+	return snapshot.ID, nil
+}
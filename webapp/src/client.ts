@@ -2,7 +2,7 @@ import {Client4, ClientError} from '@mattermost/client';
 import {Options, ClientResponse} from '@mattermost/types/client4';
 
 import {pluginId} from './manifest';
-import {Customer, CustomerConfigValues, CustomerFilterOptions, CustomerPacketValues, CustomerPluginValues, FullCustomerInfo, GetCustomerResult} from './types/customers';
+import {Customer, CustomerConfigValues, CustomerFilterOptions, CustomerPacketValues, CustomerPluginValues, Environment, FullCustomerInfo, GetCustomerResult, MergeOptions, MergeResult} from './types/customers';
 
 let siteURL = '';
 let basePath = '';
@@ -84,6 +84,38 @@ export function clientFetchCustomers(opts?: CustomerFilterOptions) {
     return doGet<GetCustomerResult>(`${apiUrl}/customers${params ? `?${params}` : ''}`);
 }
 
+export function createCustomer(customer: Partial<Customer>) {
+    return doPost<FullCustomerInfo>(`${apiUrl}/customers`, JSON.stringify(customer));
+}
+
+export function archiveCustomer(customerID: string) {
+    return doPost(`${apiUrl}/customers/${customerID}/archive`);
+}
+
+export function restoreCustomer(customerID: string) {
+    return doPost(`${apiUrl}/customers/${customerID}/restore`);
+}
+
+export function mergeCustomer(customerID: string, opts: MergeOptions) {
+    return doPost<MergeResult>(`${apiUrl}/customers/${customerID}/merge`, JSON.stringify(opts));
+}
+
+export function deleteCustomer(customerID: string) {
+    return doFetchWithResponse(`${apiUrl}/customers/${customerID}`, {method: 'DELETE'});
+}
+
+export function fetchEnvironments(customerID: string) {
+    return doGet<Environment[]>(`${apiUrl}/customers/${customerID}/environments`);
+}
+
+export function createEnvironment(customerID: string, environment: Partial<Environment>) {
+    return doPost<Environment>(`${apiUrl}/customers/${customerID}/environments`, JSON.stringify(environment));
+}
+
+export function updateEnvironment(customerID: string, environmentID: string, environment: Partial<Environment>) {
+    return doPut<Environment>(`${apiUrl}/customers/${customerID}/environments/${environmentID}`, environment);
+}
+
 export function updateCustomer(customerID: string, customer: Partial<Customer>) {
     return doPut<FullCustomerInfo>(`${apiUrl}/customers/${customerID}`, customer);
 }
@@ -92,8 +124,13 @@ export function updateCustomerConfig(customerID: string, config: Partial<Custome
     return doPut<FullCustomerInfo>(`${apiUrl}/customers/${customerID}/config`, config);
 }
 
-export function updateCustomerPacket(customerID: string, packet: Partial<CustomerPacketValues>) {
-    return doPut<FullCustomerInfo>(`${apiUrl}/customers/${customerID}/packet`, packet);
+export function updateCustomerPacket(customerID: string, packet: Partial<CustomerPacketValues>, expiresAt?: number) {
+    const query = expiresAt ? `?expiresAt=${expiresAt}` : '';
+    return doPut<FullCustomerInfo>(`${apiUrl}/customers/${customerID}/packet${query}`, packet);
+}
+
+export function unpinPacketField(customerID: string, field: keyof CustomerPacketValues) {
+    return doFetchWithResponse(`${apiUrl}/customers/${customerID}/packet/fields/${field}`, {method: 'DELETE'});
 }
 
 export function updateCustomerPlugins(customerID: string, plugins: Partial<CustomerPluginValues>[]) {
@@ -1,6 +1,6 @@
 import React, {useEffect} from 'react';
 
-import {TextInput} from '@mantine/core';
+import {Checkbox, TextInput} from '@mantine/core';
 
 import {UseFormReturnType, useForm} from '@mantine/form';
 
@@ -87,6 +87,7 @@ const CustomerInfoProfile = ({
             productManager: '',
             region: '',
             status: '',
+            postPacketSummaries: false,
         },
     });
 
@@ -164,6 +165,10 @@ const CustomerInfoProfile = ({
                     label={'Customer PS Channel'}
                     formKey={'customerChannel'}
                 />
+                <Checkbox
+                    label={'Post support packet summaries to the customer channel'}
+                    {...getInputProps('postPacketSummaries', {type: 'checkbox'})}
+                />
                 <FormTextInput
                     getInputProps={getInputProps}
                     label={'Salesforce ID'}
@@ -32,6 +32,9 @@ export type Customer = {
     status: string;
     companyType: string;
     codeWord: string;
+    postPacketSummaries: boolean;
+    archivedAt: number;
+    archivedBy: string;
 }
 
 export type CustomerPacketValues = {
@@ -72,10 +75,66 @@ export type CustomerPluginValues = {
 
 export type CustomerConfigValues = AdminConfig;
 
+export type PacketFieldSource = 'packet' | 'inferred' | 'manual';
+
+export type PacketFieldPin = {
+    field: keyof CustomerPacketValues;
+    value: string | number | boolean;
+    pinnedBy: string;
+    pinnedAt: number;
+    expiresAt: number;
+}
+
+export type PacketField = {
+    field: keyof CustomerPacketValues;
+    value: string | number | boolean;
+    packetValue: string | number | boolean;
+    source: PacketFieldSource;
+    pin: PacketFieldPin | null;
+    conflict: boolean;
+}
+
+export type CustomerNodeValues = {
+    nodeID: string;
+    version: string;
+    buildHash: string;
+    serverOS: string;
+    serverArch: string;
+    databaseVersion: string;
+    databaseSchemaVersion: string;
+    websocketConnections: number;
+    masterDBConnections: number;
+    replicaDBConnections: number;
+}
+
+export type EnvironmentType = 'production' | 'staging' | 'development' | 'dr' | 'other';
+
+export type Environment = {
+    id: string;
+    customerID: string;
+    name: string;
+    type: EnvironmentType;
+    siteURL: string;
+    telemetryID: string;
+    primary: boolean;
+    createdAt: number;
+    createdBy: string;
+}
+
+export type EnvironmentInfo = Environment & {
+    packet: CustomerPacketValues;
+    config: AdminConfig;
+    plugins: CustomerPluginValues[];
+    nodes: CustomerNodeValues[];
+}
+
 export type FullCustomerInfo = Customer & {
     packet: CustomerPacketValues;
+    packetFields: PacketField[];
     config: AdminConfig;
-    plugins: CustomerPluginValues[]
+    plugins: CustomerPluginValues[];
+    nodes: CustomerNodeValues[];
+    environments: EnvironmentInfo[];
 }
 
 export type GetCustomerResult = {
@@ -110,4 +169,28 @@ export type CustomerFilterOptions = {
     page: string;
     perPage: string;
     searchTerm: string;
+    includeArchived?: string;
+}
+
+export type MergeStrategy = 'target' | 'source' | 'newest';
+
+export type MergeOptions = {
+    sourceID: string;
+    strategy?: MergeStrategy;
+    fields?: Partial<Record<keyof Customer, MergeStrategy>>;
+    dryRun?: boolean;
+}
+
+export type MergeConflict = {
+    field: keyof Customer;
+    targetValue: string | boolean;
+    sourceValue: string | boolean;
+    kept: MergeStrategy;
+}
+
+export type MergeResult = {
+    dryRun: boolean;
+    customer: Customer;
+    conflicts: MergeConflict[];
+    movedRows: Record<string, number>;
 }